@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audits the daemon resources",
+	Long:  `Audits the daemon resources.`,
+}
+
+// auditIdleCmd represents the audit idle command
+var auditIdleCmd = &cobra.Command{
+	Use:   "idle",
+	Short: "Lists the resources unused for longer than a threshold",
+	Long: `Lists the stopped containers, unmounted volumes, networks without endpoints
+and images no container uses, whose last use is older than the threshold, with
+their estimated reclaimable size and the commands removing them.
+
+The daemon doesn't track uses: the last one is the container stop time, the
+image last tag time or the resource creation time. Only the docker-runner
+resources are audited, unless --all is set.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		threshold, err := docker.ParseIdleThreshold(auditIdleThreshold)
+		if err != nil {
+			panic(err)
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		idle, err := c.IdleResources(ctx, docker.IdleOptions{Threshold: threshold, All: auditIdleAll})
+		if err != nil {
+			panic(err)
+		}
+		if len(idle) == 0 {
+			fmt.Println("No idle resources")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "KIND\tID\tNAME\tLAST USED\tSIZE\tREASON")
+		now := time.Now()
+		var total int64
+		for _, r := range idle {
+			size := "-"
+			if r.Size > 0 {
+				size = units.HumanSize(float64(r.Size))
+				total += r.Size
+			}
+			lastUsed := units.HumanDuration(now.Sub(r.LastUsed)) + " ago"
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Kind, r.ID, r.Name, lastUsed, size, r.Reason)
+		}
+		_ = w.Flush()
+		fmt.Printf("%d idle resources, about %s reclaimable. To remove them:\n", len(idle), units.HumanSize(float64(total)))
+		for _, line := range docker.IdleCleanupCommands(idle) {
+			fmt.Println("  " + line)
+		}
+	},
+}
+
+var (
+	auditIdleThreshold string
+	auditIdleAll       bool
+)
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditIdleCmd)
+
+	auditIdleCmd.Flags().StringVar(&auditIdleThreshold, "threshold", "14d", "Time a resource has to be unused for (e.g. 14d, 36h)")
+	auditIdleCmd.Flags().BoolVar(&auditIdleAll, "all", false, "Audits all the resources, not only the docker-runner ones")
+}
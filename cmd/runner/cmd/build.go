@@ -2,6 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
 	"github.com/eldius/docker-runner/internal/docker"
 
 	"github.com/spf13/cobra"
@@ -9,23 +17,263 @@ import (
 
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
-	Use:   "build",
+	Use:   "build [SRC]",
 	Short: "Builds the image to test",
-	Long:  `Builds the image to test.`,
-	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Long: `Builds the image to test, from a folder or a git repository URL.
+
+Repositories over SSH (git@host:org/repo.git#ref:subdir) are cloned locally
+using the SSH agent, others are fetched by the daemon.
+
+With --dockerfile-stdin or --dockerfile-inline the Dockerfile is built without
+any context (COPY and ADD of context files fail before building), and the
+image gets a generated tag.`,
+	Args:        cobra.MatchAll(cobra.RangeArgs(0, 1), cobra.OnlyValidArgs),
+	Annotations: mutating,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		c, err := docker.NewClient()
+		dockerfile, err := inlineDockerfile()
+		if err != nil {
+			panic(err)
+		}
+		var src string
+		if len(args) > 0 {
+			src = args[0]
+		}
+		if src == "" && dockerfile == nil && buildReplayStream == "" {
+			panic(errors.New("a source folder is required without an inline Dockerfile"))
+		}
+		if buildAlsoFinal && buildTarget == "" {
+			panic(errors.New("--also-final requires --target"))
+		}
+		output, err := docker.ParseBuildOutputMode(buildOutput)
+		if err != nil {
+			panic(err)
+		}
+		hostKeyChecking, err := docker.ParseHostKeyChecking(buildGitHostKeyChecking)
+		if err != nil {
+			panic(err)
+		}
+		var outputs []types.ImageBuildOutput
+		for _, o := range buildOutputs {
+			out, err := docker.ParseOutput(o)
+			if err != nil {
+				panic(err)
+			}
+			outputs = append(outputs, out)
+		}
+		buildArgs, err := parseBuildArgs(buildBuildArgs)
+		if err != nil {
+			panic(err)
+		}
+		labels, err := parseKeyValues(buildLabels)
+		if err != nil {
+			panic(err)
+		}
+		ciMetadata, err := docker.ParseCIMetadataMode(buildCIMetadata)
+		if err != nil {
+			panic(err)
+		}
+		var fileArgs []map[string]*string
+		for _, f := range buildBuildArgFiles {
+			args, err := docker.ReadBuildArgFile(f)
+			if err != nil {
+				panic(err)
+			}
+			fileArgs = append(fileArgs, args)
+		}
+		buildArgs = docker.MergeBuildArgs(append(fileArgs, buildArgs)...)
+		var historyDir string
+		if !buildNoStore {
+			historyDir, err = docker.DefaultHistoryDir()
+			if err != nil {
+				panic(err)
+			}
+		}
+		var resultCacheDir string
+		if buildResultCache {
+			resultCacheDir, err = docker.DefaultResultCacheDir()
+			if err != nil {
+				panic(err)
+			}
+		}
+		if buildReplayStream != "" {
+			res, err := docker.ReplayBuildStream(buildReplayStream, docker.BuildOptions{
+				Output:             output,
+				OutputContextLines: buildOutputContextLines,
+				Tail:               buildTail,
+				WarningPatterns:    buildWarningPatterns,
+				FailOnWarn:         buildFailOnWarn,
+			})
+			if err != nil {
+				panic(err)
+			}
+			if buildSVG != "" {
+				if err := writeTimingsSVG(buildSVG, res.Steps); err != nil {
+					panic(err)
+				}
+			}
+			return
+		}
+		c, err := newClient()
 		if err != nil {
 			panic(err)
 		}
-		err = c.Build(ctx, args[0])
+		res, err := c.Build(ctx, src, docker.BuildOptions{
+			ContextSubdir:      buildContextSubdir,
+			ExplainIgnore:      buildExplainIgnore,
+			Output:             output,
+			OutputContextLines: buildOutputContextLines,
+			Tail:               buildTail,
+			WarningPatterns:    buildWarningPatterns,
+			FailOnWarn:         buildFailOnWarn,
+			BuildKit:           buildBuildKit || len(outputs) > 0 || buildInlineCache,
+			Outputs:            outputs,
+			InlineCache:        buildInlineCache,
+			Platform:           buildPlatform,
+			Emulation: docker.EmulationOptions{
+				Probe: buildProbeEmulation,
+				Setup: buildSetupBinfmt,
+			},
+			GitSSH: docker.GitSSHOptions{
+				KnownHostsFile:  buildGitKnownHosts,
+				HostKeyChecking: hostKeyChecking,
+			},
+			BuildArgs:               buildArgs,
+			Tag:                     buildImageTag,
+			AlsoTags:                buildAlsoTags,
+			Labels:                  labels,
+			CIMetadata:              ciMetadata,
+			PrintOptions:            buildPrintOptions,
+			PrintResolvedDockerfile: buildPrintResolvedDockerfile,
+			NormalizeDockerfile:     buildNormalizeDockerfile,
+			PullBaseImages:          buildPull || buildQuietPull,
+			QuietPull:               buildQuietPull,
+			RequireHealthcheck:      buildRequireHealthcheck,
+			StrictSecrets:           buildStrictSecrets,
+			Override: docker.CommandOverride{
+				Entrypoint: buildEntrypoint,
+				Cmd:        buildCmdOverride,
+			},
+			Dockerfile:        dockerfile,
+			Compress:          buildCompress,
+			CompressionLevel:  buildCompressionLevel,
+			Heartbeat:         buildHeartbeat,
+			MaxContextFiles:   buildMaxContextFiles,
+			CPUShares:         buildCPUShares,
+			CleanFailedBuilds: buildCleanFailedBuilds,
+			KeepImages:        buildKeepImages,
+			RecordStream:      buildRecordStream,
+			PostBuildHook:     buildPostBuildHook,
+			ResultCacheDir:    resultCacheDir,
+			Target:            buildTarget,
+			AlsoFinal:         buildAlsoFinal,
+			Ephemeral:         buildEphemeral,
+			HistoryDir:        historyDir,
+			HistoryKeep:       buildHistoryKeep,
+		})
 		if err != nil {
 			panic(err)
 		}
+		if !buildEphemeral {
+			if res.TargetTag != "" {
+				fmt.Println("Target image:", res.TargetTag)
+			}
+			fmt.Println("Image:", strings.Join(append([]string{res.Tag}, res.AlsoTags...), ", "))
+		}
+		if buildSVG != "" {
+			if err := writeTimingsSVG(buildSVG, res.Steps); err != nil {
+				panic(err)
+			}
+		}
 	},
 }
 
+var (
+	buildContextSubdir           string
+	buildExplainIgnore           bool
+	buildOutput                  string
+	buildOutputContextLines      int
+	buildTail                    int
+	buildWarningPatterns         []string
+	buildFailOnWarn              bool
+	buildBuildKit                bool
+	buildOutputs                 []string
+	buildGitKnownHosts           string
+	buildGitHostKeyChecking      string
+	buildPlatform                string
+	buildProbeEmulation          bool
+	buildSetupBinfmt             bool
+	buildBuildArgs               []string
+	buildLabels                  []string
+	buildImageTag                string
+	buildAlsoTags                []string
+	buildCIMetadata              string
+	buildBuildArgFiles           []string
+	buildPrintOptions            bool
+	buildPrintResolvedDockerfile bool
+	buildNormalizeDockerfile     bool
+	buildPull                    bool
+	buildQuietPull               bool
+	buildRequireHealthcheck      bool
+	buildStrictSecrets           bool
+	buildEntrypoint              string
+	buildCmdOverride             string
+	buildDockerfileStdin         bool
+	buildDockerfileInline        string
+	buildCompress                bool
+	buildCompressionLevel        int
+	buildHeartbeat               time.Duration
+	buildSVG                     string
+	buildMaxContextFiles         int
+	buildPostBuildHook           string
+	buildResultCache             bool
+	buildNoStore                 bool
+	buildTarget                  string
+	buildAlsoFinal               bool
+	buildInlineCache             bool
+	buildEphemeral               bool
+	buildCPUShares               int64
+	buildCleanFailedBuilds       bool
+	buildKeepImages              bool
+	buildRecordStream            string
+	buildReplayStream            string
+	buildHistoryKeep             int
+)
+
+// writeTimingsSVG saves the step timings chart to path
+func writeTimingsSVG(path string, steps []docker.BuildStep) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := docker.WriteTimingsSVG(f, steps); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// inlineDockerfile returns the Dockerfile given with --dockerfile-stdin
+// or --dockerfile-inline (where \n stands for a line break), or nil
+func inlineDockerfile() ([]byte, error) {
+	switch {
+	case buildDockerfileStdin && buildDockerfileInline != "":
+		return nil, errors.New("--dockerfile-stdin and --dockerfile-inline are exclusive")
+	case buildDockerfileStdin:
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, errors.New("empty Dockerfile read from stdin")
+		}
+		return b, nil
+	case buildDockerfileInline != "":
+		return []byte(strings.ReplaceAll(buildDockerfileInline, `\n`, "\n")), nil
+	}
+	return nil, nil
+}
+
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
@@ -38,4 +286,55 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// buildCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	buildCmd.Flags().StringVar(&buildContextSubdir, "context-subdir", "", "Folder (relative to the source folder) used as the build context root")
+	buildCmd.Flags().BoolVar(&buildExplainIgnore, "explain-ignore", false, "Logs whether each context file was included or excluded, and the .dockerignore pattern that decided it")
+	buildCmd.Flags().StringVar(&buildOutput, "build-output", string(docker.BuildOutputFull), "Build output mode (full, failures-only or errors+warnings)")
+	buildCmd.Flags().IntVar(&buildOutputContextLines, "build-output-context", docker.DefaultOutputContextLines, "Lines of the step before the failing one printed in the failures-only and errors+warnings modes")
+	buildCmd.Flags().IntVar(&buildTail, "tail", docker.DefaultBuildTail, "Last build output lines printed again when the build fails (negative for none)")
+	buildCmd.Flags().StringArrayVar(&buildWarningPatterns, "warning-pattern", nil, "Regular expression of the lines treated as warnings (repeatable)")
+	buildCmd.Flags().BoolVar(&buildFailOnWarn, "fail-on-warn", false, "Fails the build if it produced any warning")
+	buildCmd.Flags().BoolVar(&buildBuildKit, "buildkit", false, "Builds the image with BuildKit")
+	buildCmd.Flags().StringArrayVar(&buildOutputs, "output", nil, "BuildKit output (e.g. type=registry to push without loading the image locally, implies --buildkit)")
+	buildCmd.Flags().BoolVar(&buildInlineCache, "inline-cache", false, "Embeds the cache metadata in the image (BUILDKIT_INLINE_CACHE=1), so a pushed image can serve as cache source later (implies --buildkit)")
+	buildCmd.Flags().StringVar(&buildGitKnownHosts, "git-known-hosts", "", "Known hosts file used to clone git contexts over SSH")
+	buildCmd.Flags().StringVar(&buildGitHostKeyChecking, "git-host-key-checking", string(docker.HostKeyCheckingYes), "SSH host key checking for git contexts (yes, accept-new or no)")
+	buildCmd.Flags().StringVar(&buildPlatform, "platform", "", "Platform to build for (e.g. linux/arm64)")
+	buildCmd.Flags().BoolVar(&buildProbeEmulation, "probe-emulation", false, "Runs a tiny container for the target platform to confirm emulation works when no binfmt handler is found")
+	buildCmd.Flags().BoolVar(&buildSetupBinfmt, "setup-binfmt", false, "Registers the qemu binfmt handlers when the platform differs from the daemon one")
+	buildCmd.Flags().StringArrayVar(&buildBuildArgs, "build-arg", nil, "Build arg (KEY=VALUE, or KEY to take it from the environment, repeatable)")
+	buildCmd.Flags().StringVar(&buildImageTag, "tag", "", "Tag of the built image, a bare tag (e.g. 1.2.3) naming the default eldius/test-image repository")
+	buildCmd.Flags().StringArrayVar(&buildAlsoTags, "also-tag", nil, "Extra tag of the built image, applied without rebuilding, a bare tag (e.g. latest) taking the --tag repository (repeatable)")
+	buildCmd.Flags().StringArrayVar(&buildLabels, "label", nil, "Label (key=value) set on the built image (repeatable)")
+	buildCmd.Flags().StringVar(&buildCIMetadata, "ci-metadata", string(docker.CIMetadataOff), "Adds the GitHub Actions, GitLab CI or Jenkins metadata as labels and declared build args when detected (auto or off)")
+	buildCmd.Flags().StringArrayVar(&buildBuildArgFiles, "build-arg-file", nil, "Dotenv-like file of build args, overridden by --build-arg (repeatable, later files take precedence)")
+	buildCmd.Flags().BoolVar(&buildPrintOptions, "print-options", false, "Prints the effective build settings, including the Dockerfile ARG defaults, before building")
+	buildCmd.Flags().BoolVar(&buildPull, "pull", false, "Pulls the Dockerfile base images before building, even if they're local (skips the build result cache)")
+	buildCmd.Flags().BoolVar(&buildQuietPull, "quiet-pull", false, "Pulls the base images as --pull does without printing the pull progress, keeping the build output")
+	buildCmd.Flags().BoolVar(&buildNormalizeDockerfile, "normalize-dockerfile", false, "Strips the Dockerfile UTF-8 BOM and converts its CRLF line endings for the build, instead of warning about them")
+	buildCmd.Flags().BoolVar(&buildPrintResolvedDockerfile, "print-resolved-dockerfile", false, "Prints the Dockerfile with the ARG defaults, build args and ENV values substituted where known before building")
+	buildCmd.Flags().BoolVar(&buildRequireHealthcheck, "require-healthcheck", false, "Fails the build if the image defines no HEALTHCHECK")
+	buildCmd.Flags().BoolVar(&buildStrictSecrets, "strict-secrets", false, "Fails the build if the image history or env embeds a sensitive build arg or a known token, instead of warning")
+	buildCmd.Flags().StringVar(&buildEntrypoint, "entrypoint", "", "Replaces the built image entrypoint, in JSON ([\"/app\"]) or shell form (adds a layer)")
+	buildCmd.Flags().StringVar(&buildCmdOverride, "cmd", "", "Replaces the built image command, in JSON ([\"--flag\"]) or shell form (adds a layer)")
+	buildCmd.Flags().BoolVar(&buildDockerfileStdin, "dockerfile-stdin", false, "Builds the Dockerfile read from stdin, without context")
+	buildCmd.Flags().StringVar(&buildDockerfileInline, "dockerfile-inline", "", "Builds the given Dockerfile content (\\n for line breaks), without context")
+	buildCmd.Flags().BoolVar(&buildCompress, "compress", false, "Compresses the build context with gzip")
+	buildCmd.Flags().IntVar(&buildCompressionLevel, "compression-level", 0, "Gzip level of --compress, from 1 (fastest) to 9 (smallest), balanced if not set")
+	buildCmd.Flags().DurationVar(&buildHeartbeat, "heartbeat", 0, "Prints a \"still building...\" line when the build output stays silent for this long (e.g. 30s)")
+	buildCmd.Flags().BoolVar(&buildCleanFailedBuilds, "clean-failed-builds", true, "Removes the intermediate containers and untagged step images a failed classic builder build leaves")
+	buildCmd.Flags().BoolVar(&buildKeepImages, "keep-images", false, "Keeps the intermediate containers and step images of a failed build for inspection (same as --clean-failed-builds=false)")
+	buildCmd.Flags().StringVar(&buildRecordStream, "record-stream", "", "Writes the raw daemon build stream to this file, for --replay-stream")
+	buildCmd.Flags().StringVar(&buildReplayStream, "replay-stream", "", "Replays a --record-stream file through the build output handling, without a daemon nor source folder")
+	_ = buildCmd.Flags().MarkHidden("record-stream")
+	_ = buildCmd.Flags().MarkHidden("replay-stream")
+	buildCmd.Flags().Int64Var(&buildCPUShares, "cpu-shares", 0, "Relative CPU weight of the build containers (the daemon default is 1024)")
+	buildCmd.Flags().IntVar(&buildMaxContextFiles, "max-context-files", 0, "Aborts the build if the context holds more files than this (0 means no limit)")
+	buildCmd.Flags().StringVar(&buildTarget, "target", "", "Dockerfile stage to build, tagged eldius/test-image:<target>")
+	buildCmd.Flags().BoolVar(&buildAlsoFinal, "also-final", false, "Also builds the final stage after the --target one, reusing its cache, so both images are tagged")
+	buildCmd.Flags().BoolVar(&buildEphemeral, "ephemeral", false, "Tags the image with a random docker-runner-ephemeral tag and removes it once the build and --post-build-hook are done, even on failure")
+	buildCmd.Flags().BoolVar(&buildNoStore, "no-store", false, "Doesn't record the build in the history (see build history)")
+	buildCmd.Flags().IntVar(&buildHistoryKeep, "history-keep", docker.DefaultHistoryKeep, "Number of builds kept in the history, the oldest ones and their logs are pruned")
+	buildCmd.Flags().BoolVar(&buildResultCache, "result-cache", false, "Reuse the image of a previous build with the same context content and settings, if it still exists, without building")
+	buildCmd.Flags().StringVar(&buildPostBuildHook, "post-build-hook", "", "Command run after a successful build, with the image ID and tags in DR_IMAGE_ID and DR_IMAGE_TAGS (fails the build if it fails)")
+	buildCmd.Flags().StringVar(&buildSVG, "svg", "", "Saves an SVG chart of the step durations to this file")
 }
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows the runner configuration",
+	Long:  `Shows the runner configuration.`,
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Shows the effective Docker client configuration",
+	Long: `Shows the Docker host, TLS settings, Docker context and negotiated API
+version the runner connects with, with credentials redacted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		cfg, err := c.Config(ctx)
+		if err != nil {
+			slog.With("error", err.Error()).Warn("DaemonUnreachable")
+		}
+		apiVersion := cfg.APIVersion
+		if apiVersion == "" {
+			apiVersion = "unknown (daemon unreachable)"
+		}
+		fmt.Println("Docker host:", cfg.Host)
+		fmt.Println("Docker context:", cfg.Context)
+		fmt.Println("Docker config file:", cfg.ConfigFile)
+		fmt.Println("TLS verify:", cfg.TLSVerify)
+		if cfg.CertPath != "" {
+			fmt.Println("TLS cert path:", cfg.CertPath)
+		}
+		fmt.Println("API version:", apiVersion)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
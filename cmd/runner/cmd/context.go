@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// contextCmd represents the context command
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Inspects build contexts",
+	Long:  `Inspects build contexts.`,
+}
+
+// contextAnalyzeCmd represents the context analyze command
+var contextAnalyzeCmd = &cobra.Command{
+	Use:   "analyze [PATH]",
+	Short: "Shows what a build context holds and suggests .dockerignore lines",
+	Long: `Walks the build context applying its .dockerignore rules, shows the included
+bytes by top-level entry and by extension, and suggests .dockerignore lines
+for the well-known junk (VCS folders, dependency caches, build outputs, media
+files) the Dockerfile COPY and ADD instructions never reference.
+
+With --write the suggestions are appended to the .dockerignore file, after
+a confirmation for each of them (all of them with --yes).`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := "."
+		if len(args) > 0 {
+			src = args[0]
+		}
+		a, err := docker.AnalyzeContext(src, contextAnalyzeSubdir)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Context %s: %d files, %s\n", a.Root, a.Files, units.HumanSize(float64(a.Bytes)))
+		printSizeGroups("By top-level entry:", a.ByDir)
+		printSizeGroups("By extension:", a.ByExt)
+		if len(a.Suggestions) == 0 {
+			fmt.Println("No .dockerignore suggestions")
+			return
+		}
+		fmt.Println("Suggested .dockerignore lines:")
+		for _, s := range a.Suggestions {
+			fmt.Printf("  %-24s saves %8s (%d files, %s)\n", s.Pattern, units.HumanSize(float64(s.Bytes)), s.Files, s.Reason)
+		}
+		if !contextAnalyzeWrite {
+			return
+		}
+
+		var accepted []string
+		in := bufio.NewReader(os.Stdin)
+		for _, s := range a.Suggestions {
+			if !contextAnalyzeYes && !confirm(in, fmt.Sprintf("Add %s to .dockerignore?", s.Pattern)) {
+				continue
+			}
+			accepted = append(accepted, s.Pattern)
+		}
+		if len(accepted) == 0 {
+			return
+		}
+		if err := docker.AppendIgnorePatterns(a.Root, accepted); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Added %d lines to .dockerignore\n", len(accepted))
+	},
+}
+
+var (
+	contextAnalyzeSubdir string
+	contextAnalyzeWrite  bool
+	contextAnalyzeYes    bool
+)
+
+// contextAnalyzeTopGroups is the number of size groups printed
+const contextAnalyzeTopGroups = 10
+
+func printSizeGroups(title string, groups []docker.SizeGroup) {
+	fmt.Println(title)
+	for i, g := range groups {
+		if i == contextAnalyzeTopGroups {
+			fmt.Printf("  ... %d more\n", len(groups)-i)
+			break
+		}
+		fmt.Printf("  %-24s %8s (%d files)\n", g.Name, units.HumanSize(float64(g.Bytes)), g.Files)
+	}
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no
+func confirm(in *bufio.Reader, question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, _ := in.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(contextAnalyzeCmd)
+
+	contextAnalyzeCmd.Flags().StringVar(&contextAnalyzeSubdir, "context-subdir", "", "Folder, relative to PATH, used as the build context root")
+	contextAnalyzeCmd.Flags().BoolVar(&contextAnalyzeWrite, "write", false, "Appends the accepted suggestions to the .dockerignore file")
+	contextAnalyzeCmd.Flags().BoolVar(&contextAnalyzeYes, "yes", false, "Accepts all the suggestions without asking (with --write)")
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// estimateCmd represents the estimate command
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [SRC]",
+	Short: "Estimates the size of the image a build produces",
+	Long: `Gives a ballpark of the size of the image built from SRC (the current folder
+by default): the base image size of the final (or --target) stage plus the
+build context size, a rough proxy of what the stages add.
+
+The base image size is the one of the local image. When it isn't local, the
+registry is only asked whether it exists, as it doesn't tell the layer sizes,
+unless --pull pulls it.`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		src := "."
+		if len(args) > 0 {
+			src = args[0]
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		e, err := c.EstimateImageSize(ctx, src, docker.EstimateOptions{
+			ContextSubdir: estimateContextSubdir,
+			Target:        estimateTarget,
+			Pull:          estimatePull,
+		})
+		if err != nil {
+			panic(err)
+		}
+		base := units.HumanSize(float64(e.BaseSize))
+		switch {
+		case e.BaseKnown:
+		case e.BaseRemote:
+			base = "unknown (not local, use --pull)"
+		default:
+			base = "unknown (unresolved build args)"
+		}
+		fmt.Printf("Base image:  %s, %s\n", e.Base, base)
+		fmt.Printf("Context:     %d files, %s\n", e.ContextFiles, units.HumanSize(float64(e.ContextBytes)))
+		if !e.BaseKnown {
+			fmt.Printf("Estimate:    more than %s\n", units.HumanSize(float64(e.Total())))
+			return
+		}
+		fmt.Printf("Estimate:    ~%s\n", units.HumanSize(float64(e.Total())))
+	},
+}
+
+var (
+	estimateContextSubdir string
+	estimateTarget        string
+	estimatePull          bool
+)
+
+func init() {
+	rootCmd.AddCommand(estimateCmd)
+
+	estimateCmd.Flags().StringVar(&estimateContextSubdir, "context-subdir", "", "Folder, relative to SRC, used as the build context root")
+	estimateCmd.Flags().StringVar(&estimateTarget, "target", "", "Stage estimated (the last one by default)")
+	estimateCmd.Flags().BoolVar(&estimatePull, "pull", false, "Pulls the base image when it isn't local, to know its size")
+}
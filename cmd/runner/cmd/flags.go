@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of the environment variables providing the
+// flag defaults (DOCKER_RUNNER_PLATFORM for --platform)
+const envPrefix = "DOCKER_RUNNER_"
+
+// flagEnvName returns the environment variable of the flag
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvDefaults sets the flags not given on the command line from
+// their DOCKER_RUNNER_ environment variable, if set. A repeatable flag
+// gets the variable value as a single occurrence.
+func applyEnvDefaults(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		v, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := cmd.Flags().Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("invalid %s value %q: %w", flagEnvName(f.Name), v, setErr)
+		}
+	})
+	return err
+}
+
+// parseKeyValues parses key=value flag values
+func parseKeyValues(values []string) (map[string]string, error) {
+	res := make(map[string]string, len(values))
+	for _, v := range values {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid key=value pair: %q", v)
+		}
+		res[k] = val
+	}
+	return res, nil
+}
+
+// parseBuildArgs parses KEY=VALUE build args. As with docker build, a
+// KEY alone takes its value from the environment (and is left unset if
+// the variable doesn't exist).
+func parseBuildArgs(values []string) (map[string]*string, error) {
+	res := make(map[string]*string, len(values))
+	for _, v := range values {
+		k, val, ok := strings.Cut(v, "=")
+		if k == "" {
+			return nil, fmt.Errorf("invalid build arg: %q", v)
+		}
+		if !ok {
+			env, found := os.LookupEnv(k)
+			if !found {
+				res[k] = nil
+				continue
+			}
+			val = env
+		}
+		res[k] = &val
+	}
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestFlagEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"platform":      "DOCKER_RUNNER_PLATFORM",
+		"pull-policy":   "DOCKER_RUNNER_PULL_POLICY",
+		"max-ctx-files": "DOCKER_RUNNER_MAX_CTX_FILES",
+	} {
+		if got := flagEnvName(name); got != want {
+			t.Errorf("flagEnvName(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestApplyEnvDefaults(t *testing.T) {
+	t.Setenv("DOCKER_RUNNER_PLATFORM", "linux/arm64")
+	t.Setenv("DOCKER_RUNNER_PULL", "true")
+	t.Setenv("DOCKER_RUNNER_TAG", "from-env")
+	t.Setenv("DOCKER_RUNNER_LABEL", "team=core")
+
+	cmd := &cobra.Command{Use: "build"}
+	platform := cmd.Flags().String("platform", "", "")
+	pull := cmd.Flags().Bool("pull", false, "")
+	tag := cmd.Flags().String("tag", "", "")
+	labels := cmd.Flags().StringArray("label", nil, "")
+	target := cmd.Flags().String("target", "final", "")
+	if err := cmd.Flags().Parse([]string{"--tag", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnvDefaults(cmd); err != nil {
+		t.Fatalf("applyEnvDefaults: %v", err)
+	}
+	if *platform != "linux/arm64" || !*pull {
+		t.Errorf("platform = %q, pull = %v, want the env values", *platform, *pull)
+	}
+	if *tag != "from-flag" {
+		t.Errorf("tag = %q, want the command line value over the env one", *tag)
+	}
+	if !reflect.DeepEqual(*labels, []string{"team=core"}) {
+		t.Errorf("labels = %v, want the env value as one occurrence", *labels)
+	}
+	if *target != "final" {
+		t.Errorf("target = %q, want the flag default without env", *target)
+	}
+}
+
+func TestApplyEnvDefaultsInvalid(t *testing.T) {
+	t.Setenv("DOCKER_RUNNER_MAX_CONTEXT_FILES", "many")
+	cmd := &cobra.Command{Use: "build"}
+	cmd.Flags().Int("max-context-files", 0, "")
+	err := applyEnvDefaults(cmd)
+	if err == nil || !strings.Contains(err.Error(), "DOCKER_RUNNER_MAX_CONTEXT_FILES") {
+		t.Errorf("err = %v, want the invalid env variable", err)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// buildHistoryCmd represents the build history command
+var buildHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Lists the past builds",
+	Long:  `Lists the builds recorded in the history, the most recent first.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := docker.DefaultHistoryDir()
+		if err != nil {
+			panic(err)
+		}
+		records, err := docker.ReadBuildHistory(dir)
+		if err != nil {
+			panic(err)
+		}
+		var context string
+		if buildHistoryContext != "" {
+			if context, err = filepath.Abs(buildHistoryContext); err != nil {
+				panic(err)
+			}
+		}
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Time.After(records[j].Time)
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tTIME\tCONTEXT\tDURATION\tCACHED\tRESULT")
+		for _, r := range records {
+			if (context != "" && r.Context != context) || (buildHistoryFailed && !r.Failed()) {
+				continue
+			}
+			result := r.ImageID
+			if r.Failed() {
+				result = "failed (" + r.ErrorClass + ")"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%s\n", r.ID, r.Time.Format(time.DateTime), r.Context, r.Duration, r.CachedSteps, r.Steps, result)
+		}
+		_ = w.Flush()
+	},
+}
+
+// buildShowCmd represents the build show command
+var buildShowCmd = &cobra.Command{
+	Use:   "show ID",
+	Short: "Shows a past build",
+	Long:  `Shows the details and the stored output of a build recorded in the history (an ID prefix is enough).`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := docker.DefaultHistoryDir()
+		if err != nil {
+			panic(err)
+		}
+		r, err := docker.FindBuild(dir, args[0])
+		if err != nil {
+			panic(err)
+		}
+		field := func(name string, v any) {
+			fmt.Printf("%-19s %v\n", name+":", v)
+		}
+		field("ID", r.ID)
+		field("Time", r.Time.Format(time.DateTime))
+		field("Context", r.Context)
+		field("Context digest", r.ContextDigest)
+		field("Dockerfile digest", r.DockerfileDigest)
+		field("Tags", r.Tags)
+		field("Image", r.ImageID)
+		field("Duration", r.Duration)
+		field("Cache hits", fmt.Sprintf("%d/%d (%.0f%%)", r.CachedSteps, r.Steps, r.CacheHitRatio()*100))
+		if len(r.BuildArgs) > 0 {
+			names := make([]string, 0, len(r.BuildArgs))
+			for k := range r.BuildArgs {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			fmt.Println("Build args:")
+			for _, k := range names {
+				fmt.Printf("  %s=%s\n", k, r.BuildArgs[k])
+			}
+		}
+		if r.Failed() {
+			fmt.Printf("Error (%s): %s\n", r.ErrorClass, r.Error)
+		}
+		fmt.Println("Output:")
+		if err := docker.WriteBuildLog(dir, r.ID, os.Stdout); err != nil {
+			panic(err)
+		}
+	},
+}
+
+var (
+	buildHistoryContext string
+	buildHistoryFailed  bool
+)
+
+func init() {
+	buildCmd.AddCommand(buildHistoryCmd)
+	buildCmd.AddCommand(buildShowCmd)
+
+	buildHistoryCmd.Flags().StringVar(&buildHistoryContext, "context", "", "Only lists the builds of this context folder")
+	buildHistoryCmd.Flags().BoolVar(&buildHistoryFailed, "failed", false, "Only lists the failed builds")
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/eldius/docker-runner/internal/docker"
+	"github.com/eldius/docker-runner/internal/sarif"
+
+	"github.com/spf13/cobra"
+)
+
+// imageCmd represents the image command
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manages images",
+	Long:  `Manages images.`,
+}
+
+// imagePromoteCmd represents the image promote command
+var imagePromoteCmd = &cobra.Command{
+	Use:   "promote SRC DST",
+	Short: "Promotes an image to another reference without rebuilding",
+	Long: `Pulls the source image (if not local), checks its required labels, tags it
+as the destination, pushes it and verifies the pushed digest is the same as
+the source one.
+
+With --local the destination is only tagged, not pushed. With --untag-source
+the source tag is removed after the destination is in place, so an
+interrupted promotion never leaves the image without a tag.`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		labels, err := parseKeyValues(imagePromoteRequireLabels)
+		if err != nil {
+			panic(err)
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		res, err := c.Promote(ctx, args[0], args[1], docker.PromoteOptions{
+			RequireLabels: labels,
+			Local:         imagePromoteLocal,
+			UntagSource:   imagePromoteUntagSource,
+		})
+		if !imagePromoteLocal {
+			fmt.Println("Source digest:", res.SourceDigest)
+			fmt.Println("Pushed digest:", res.PushedDigest)
+		}
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+// imageSummaryCmd represents the image summary command
+var imageSummaryCmd = &cobra.Command{
+	Use:   "summary REF",
+	Short: "Summarizes the runtime settings of a local image",
+	Long: `Inspects a local image and prints its exposed ports, environment, working
+directory, user, entrypoint and command.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		if imageSummaryOutput != "text" && imageSummaryOutput != "json" {
+			panic(fmt.Errorf("invalid output %q (expected text or json)", imageSummaryOutput))
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		s, err := c.ImageSummary(ctx, args[0])
+		if err != nil {
+			panic(err)
+		}
+		if imageSummaryOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(s); err != nil {
+				panic(err)
+			}
+			return
+		}
+		s.Write(os.Stdout)
+	},
+}
+
+// imageCheckSecretsCmd represents the image check-secrets command
+var imageCheckSecretsCmd = &cobra.Command{
+	Use:   "check-secrets IMAGE",
+	Short: "Looks for secrets embedded in a local image",
+	Long: `Scans the history, config env and layer text files of a local image for
+known token formats (AWS access keys, GitHub tokens, private key headers),
+failing if any is found.
+
+With --sarif the findings are also written as a SARIF 2.1.0 report, located
+by image and layer; --sarif-append adds the run to an existing report.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		findings, err := c.ScanImageSecrets(ctx, args[0], docker.SecretScanOptions{
+			Files:       true,
+			MaxFileSize: imageCheckSecretsMaxFileSize,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if imageCheckSecretsSARIF != "" {
+			if err := sarif.WriteFile(imageCheckSecretsSARIF, imageCheckSecretsSARIFAppend, docker.SecretsSARIF(args[0], findings)); err != nil {
+				panic(err)
+			}
+		}
+		if len(findings) == 0 {
+			fmt.Println("No secrets found")
+			return
+		}
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		panic(fmt.Errorf("%w: %d found in %s", docker.SecretLeakErr, len(findings), args[0]))
+	},
+}
+
+// imageCommandCmd represents the image command command
+var imageCommandCmd = &cobra.Command{
+	Use:   "command IMAGE [-- ARGS...]",
+	Short: "Previews the process a container of a local image runs",
+	Long: `Merges the entrypoint and command of a local image with the --entrypoint and
+args overrides as the daemon does, without creating anything, and prints the
+resulting process with notes on the surprising cases: args replacing the
+image command, an entrypoint override dropping it, shell form entrypoints
+ignoring the args and exec form commands not expanding env vars.
+
+--entrypoint takes a single executable, as docker run --entrypoint does, and
+--entrypoint "" clears the image entrypoint.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		if imageCommandOutput != "text" && imageCommandOutput != "json" {
+			panic(fmt.Errorf("invalid output %q (expected text or json)", imageCommandOutput))
+		}
+		var entrypoint []string
+		if cmd.Flags().Changed("entrypoint") {
+			entrypoint = []string{imageCommandEntrypoint}
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		e, err := c.EffectiveCommand(ctx, args[0], entrypoint, args[1:])
+		if err != nil {
+			panic(err)
+		}
+		if imageCommandOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(e); err != nil {
+				panic(err)
+			}
+			return
+		}
+		e.Write(os.Stdout)
+	},
+}
+
+var (
+	imageSummaryOutput string
+
+	imageCommandEntrypoint string
+	imageCommandOutput     string
+
+	imageCheckSecretsMaxFileSize int64
+	imageCheckSecretsSARIF       string
+	imageCheckSecretsSARIFAppend bool
+
+	imagePromoteRequireLabels []string
+	imagePromoteLocal         bool
+	imagePromoteUntagSource   bool
+)
+
+func init() {
+	rootCmd.AddCommand(imageCmd)
+	imageCmd.AddCommand(imagePromoteCmd)
+	imageCmd.AddCommand(imageSummaryCmd)
+	imageCmd.AddCommand(imageCheckSecretsCmd)
+	imageCmd.AddCommand(imageCommandCmd)
+
+	imageCommandCmd.Flags().StringVar(&imageCommandEntrypoint, "entrypoint", "", "Entrypoint override, a single executable (\"\" clears the image one)")
+	imageCommandCmd.Flags().StringVar(&imageCommandOutput, "output", "text", "Output format (text or json)")
+
+	imageCheckSecretsCmd.Flags().Int64Var(&imageCheckSecretsMaxFileSize, "max-file-size", docker.DefaultSecretMaxFileSize, "Size in bytes of the biggest layer file scanned")
+	imageCheckSecretsCmd.Flags().StringVar(&imageCheckSecretsSARIF, "sarif", "", "Also writes the findings as a SARIF report to this file")
+	imageCheckSecretsCmd.Flags().BoolVar(&imageCheckSecretsSARIFAppend, "sarif-append", false, "Adds the findings run to the existing --sarif report instead of replacing it")
+
+	imageSummaryCmd.Flags().StringVar(&imageSummaryOutput, "output", "text", "Output format (text or json)")
+
+	imagePromoteCmd.Flags().StringArrayVar(&imagePromoteRequireLabels, "require-label", nil, "Label (key=value) the source image must have (repeatable)")
+	imagePromoteCmd.Flags().BoolVar(&imagePromoteLocal, "local", false, "Only tags the destination, without pushing it")
+	imagePromoteCmd.Flags().BoolVar(&imagePromoteUntagSource, "untag-source", false, "Removes the source tag once the destination is in place")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile CONTAINER",
+	Short: "Profiles the resource usage of a running container",
+	Long: `Samples the CPU, memory, network and block I/O usage of a running container
+until it stops, or Ctrl-C is hit, and prints the summary of the samples.
+
+With --follow each sample is printed as it's collected (JSON lines with
+--output json). A slow terminal never stalls the sampling: the samples it
+can't keep up with are only dropped from the live output, and counted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if profileOutput != "text" && profileOutput != "json" {
+			panic(fmt.Errorf("invalid output format %q (expected text or json)", profileOutput))
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		var opts docker.ProfileOptions
+		if profileFollow {
+			opts.OnSample = printSample
+			if profileOutput == "text" {
+				fmt.Printf("%-12s %7s %21s %10s %10s %10s %10s\n", "TIME", "CPU %", "MEM USED / LIMIT", "NET RX", "NET TX", "BLK READ", "BLK WRITE")
+			}
+		}
+		summary, err := c.Profile(ctx, args[0], opts)
+		if err != nil {
+			panic(err)
+		}
+		if profileOutput == "json" {
+			b, err := json.Marshal(map[string]docker.ProfileSummary{"summary": summary})
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(b))
+			return
+		}
+		fmt.Printf("Container %s: %d samples over %s\n", summary.Container, summary.Samples, summary.Duration.Round(time.Millisecond))
+		fmt.Printf("  CPU:       %.2f%% avg, %.2f%% max\n", summary.CPUAvg, summary.CPUMax)
+		fmt.Printf("  Memory:    %s max / %s\n", units.BytesSize(float64(summary.MemMax)), units.BytesSize(float64(summary.MemLimit)))
+		fmt.Printf("  Network:   %s received, %s sent\n", units.HumanSize(float64(summary.NetRx)), units.HumanSize(float64(summary.NetTx)))
+		fmt.Printf("  Block I/O: %s read, %s written\n", units.HumanSize(float64(summary.BlkRead)), units.HumanSize(float64(summary.BlkWrite)))
+		if summary.Dropped > 0 {
+			fmt.Printf("  %d samples dropped from the live output\n", summary.Dropped)
+		}
+	},
+}
+
+// printSample prints a sample as a fixed width line or a JSON line
+func printSample(s docker.StatsSample) {
+	if profileOutput == "json" {
+		b, err := json.Marshal(s)
+		if err == nil {
+			fmt.Println(string(b))
+		}
+		return
+	}
+	mem := units.BytesSize(float64(s.MemUsed)) + " / " + units.BytesSize(float64(s.MemLimit))
+	fmt.Printf("%-12s %7.2f %21s %10s %10s %10s %10s\n", s.Time.Local().Format("15:04:05.000"), s.CPUPercent, mem,
+		units.HumanSize(float64(s.NetRx)), units.HumanSize(float64(s.NetTx)),
+		units.HumanSize(float64(s.BlkRead)), units.HumanSize(float64(s.BlkWrite)))
+}
+
+var (
+	profileFollow bool
+	profileOutput string
+)
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+
+	profileCmd.Flags().BoolVar(&profileFollow, "follow", false, "Prints each sample as it's collected")
+	profileCmd.Flags().StringVar(&profileOutput, "output", "text", "Output format (text or json, one JSON line per sample and one for the summary)")
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 
+	"github.com/eldius/docker-runner/internal/docker"
+
 	"github.com/spf13/cobra"
 )
 
@@ -15,6 +18,12 @@ var rootCmd = &cobra.Command{
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 		slog.SetDefault(logger)
+		if err := applyEnvDefaults(cmd); err != nil {
+			panic(err)
+		}
+		if rootReadOnly && cmd.Annotations[mutatingAnnotation] == "true" {
+			panic(fmt.Errorf("%w: %s", docker.ReadOnlyViolationErr, cmd.CommandPath()))
+		}
 	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
@@ -30,10 +39,54 @@ func Execute() {
 	}
 }
 
+// mutatingAnnotation marks the commands that change the daemon state,
+// refused in read-only mode
+const mutatingAnnotation = "docker-runner/mutating"
+
+// mutating is the annotations of the commands that change the daemon
+// state
+var mutating = map[string]string{mutatingAnnotation: "true"}
+
 var (
-	rootDebugEnabled bool
+	rootReadOnly               bool
+	rootDebugEnabled           bool
+	rootMaxConcurrentUploads   int
+	rootMaxConcurrentDownloads int
+	rootMirrors                []string
+	rootNoMirror               bool
+	rootHost                   string
+	rootUserAgent              string
 )
 
+// newClient builds the Docker client with the global flags settings
+func newClient() (*docker.Client, error) {
+	opts := []docker.ClientOption{
+		docker.WithMaxConcurrentUploads(rootMaxConcurrentUploads),
+		docker.WithMaxConcurrentDownloads(rootMaxConcurrentDownloads),
+	}
+	if rootReadOnly {
+		opts = append(opts, docker.WithReadOnly())
+	}
+	if rootHost != "" {
+		opts = append(opts, docker.WithHost(rootHost))
+	}
+	if rootUserAgent != "" {
+		opts = append(opts, docker.WithUserAgent(rootUserAgent))
+	}
+	if !rootNoMirror {
+		mirrors := make(map[string]string)
+		for _, m := range rootMirrors {
+			registry, prefix, err := docker.ParseMirror(m)
+			if err != nil {
+				return nil, err
+			}
+			mirrors[registry] = prefix
+		}
+		opts = append(opts, docker.WithMirrors(mirrors))
+	}
+	return docker.NewClient(opts...)
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -45,4 +98,11 @@ func init() {
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.PersistentFlags().BoolVar(&rootDebugEnabled, "debug", false, "Enables debug output")
+	rootCmd.PersistentFlags().StringVarP(&rootHost, "host", "H", "", "Daemon address for this command (unix:// or tcp:// URL), instead of DOCKER_HOST or the Docker context")
+	rootCmd.PersistentFlags().StringVar(&rootUserAgent, "user-agent", "", "User-Agent of the daemon requests, forwarded to the registries on pulls and pushes (default "+docker.DefaultUserAgent()+")")
+	rootCmd.PersistentFlags().BoolVar(&rootReadOnly, "read-only", false, "Refuses any call that changes the daemon state (create, start, build, remove, push...)")
+	rootCmd.PersistentFlags().IntVar(&rootMaxConcurrentUploads, "max-concurrent-uploads", 0, "Maximum number of image pushes at the same time (0 means no limit, layers concurrency is a daemon setting)")
+	rootCmd.PersistentFlags().IntVar(&rootMaxConcurrentDownloads, "max-concurrent-downloads", 0, "Maximum number of image pulls at the same time (0 means no limit, layers concurrency is a daemon setting)")
+	rootCmd.PersistentFlags().StringArrayVar(&rootMirrors, "mirror", nil, "Registry mirror the images are pulled from (REGISTRY=PREFIX, e.g. docker.io=mirror.corp/dockerhub, repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&rootNoMirror, "no-mirror", false, "Ignores the registry mirrors, pulling from the original registries")
 }
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/eldius/docker-runner/internal/docker"
+	"github.com/eldius/docker-runner/internal/notify"
+
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:         "run IMAGE [CMD...]",
+	Short:       "Runs a container from the image",
+	Long:        `Runs a container from the image, printing its output and exiting with its exit code.`,
+	Args:        cobra.MinimumNArgs(1),
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		pull, err := docker.ParsePullPolicy(runPull)
+		if err != nil {
+			panic(err)
+		}
+		rotate, err := docker.ParseCaptureSize(runCaptureRotate)
+		if err != nil {
+			panic(err)
+		}
+		var overlays []docker.Overlay
+		for _, o := range runOverlays {
+			ov, err := docker.ParseOverlay(o)
+			if err != nil {
+				panic(err)
+			}
+			overlays = append(overlays, ov)
+		}
+		var inits []docker.InitContainer
+		for _, i := range runInits {
+			ic, err := docker.ParseInitContainer(i)
+			if err != nil {
+				panic(err)
+			}
+			inits = append(inits, ic)
+		}
+		var network, netns string
+		if runNetwork != "" {
+			if network, err = docker.ParseExternalNetwork(runNetwork); err != nil {
+				panic(err)
+			}
+		}
+		if runNetNS != "" {
+			if netns, err = docker.ParseNetNS(runNetNS); err != nil {
+				panic(err)
+			}
+		}
+		var tlsSpec docker.TLSSpec
+		if runGenTLS != "" {
+			if tlsSpec, err = docker.ParseTLSSpec(runGenTLS); err != nil {
+				panic(err)
+			}
+		}
+		labels, err := parseKeyValues(runLabels)
+		if err != nil {
+			panic(err)
+		}
+		teardown, err := docker.ParseTeardownPolicy(runTeardown)
+		if err != nil {
+			panic(err)
+		}
+		memory, err := docker.ParseMemoryLimit(runMemory)
+		if err != nil {
+			panic(err)
+		}
+		cpus, err := docker.ParseCPUs(runCPUs)
+		if err != nil {
+			panic(err)
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		var onEvent func(docker.RunEvent)
+		var notifier *notify.Notifier
+		if runNotifyURL != "" {
+			notifier = notify.NewNotifier(runNotifyURL, runNotifySecret)
+			onEvent = runEventNotifier(notifier, notify.NewRunID())
+		}
+		code, err := c.Run(ctx, args[0], docker.RunOptions{
+			Name:     runName,
+			Cmd:      args[1:],
+			Labels:   labels,
+			Pull:     pull,
+			Keep:     runKeep,
+			Teardown: teardown,
+			Platform: runPlatform,
+			Emulation: docker.EmulationOptions{
+				Probe: runProbeEmulation,
+				Setup: runSetupBinfmt,
+			},
+			RequireNative: runRequireNative,
+			Capture: docker.CaptureOptions{
+				Dir:        runCaptureDir,
+				RotateSize: rotate,
+				Keep:       runCaptureKeep,
+			},
+			OnEvent:       onEvent,
+			Overlays:      overlays,
+			OverlayExport: runOverlayExport,
+			Init:          inits,
+			Shared:        runShared,
+			Network:       network,
+			NetworkAlias:  runNetworkAlias,
+			NetNS:         netns,
+			Resources: docker.ResourceLimits{
+				Memory:     memory,
+				NanoCPUs:   cpus,
+				PidsLimit:  runPidsLimit,
+				CPUShares:  runCPUShares,
+				CpusetCpus: runCpusetCpus,
+				CpusetMems: runCpusetMems,
+			},
+			Wait: docker.Probe{
+				Cmd:      runWaitCmd,
+				Interval: runWaitInterval,
+				Timeout:  runWaitTimeout,
+			},
+			Assert: docker.Probe{
+				Cmd:     runAssertCmd,
+				Timeout: runAssertTimeout,
+			},
+			VersionProbes: runProbeVersions,
+			TLS:           tlsSpec,
+			TLSCAFile:     runGenTLSCA,
+		})
+		if notifier != nil {
+			notifier.Wait()
+		}
+		if err != nil {
+			panic(err)
+		}
+		os.Exit(code)
+	},
+}
+
+var (
+	runName   string
+	runLabels []string
+	runPull   string
+	runKeep   bool
+
+	runTeardown string
+
+	runPlatform       string
+	runProbeEmulation bool
+	runSetupBinfmt    bool
+	runRequireNative  bool
+
+	runCaptureDir    string
+	runCaptureRotate string
+	runCaptureKeep   int
+
+	runNotifyURL    string
+	runNotifySecret string
+
+	runOverlays      []string
+	runOverlayExport string
+
+	runInits  []string
+	runShared []string
+
+	runNetwork      string
+	runNetworkAlias string
+	runNetNS        string
+
+	runMemory     string
+	runCPUs       string
+	runPidsLimit  int64
+	runCPUShares  int64
+	runCpusetCpus string
+	runCpusetMems string
+
+	runWaitCmd       string
+	runWaitInterval  time.Duration
+	runWaitTimeout   time.Duration
+	runAssertCmd     string
+	runAssertTimeout time.Duration
+	runProbeVersions []string
+	runGenTLS        string
+	runGenTLSCA      string
+)
+
+// runEventNotifier posts the run events to the notifier webhook
+func runEventNotifier(n *notify.Notifier, runID string) func(docker.RunEvent) {
+	var started time.Time
+	return func(e docker.RunEvent) {
+		p := notify.Payload{
+			RunID:     runID,
+			Container: e.ContainerName,
+			Image:     e.Image,
+			Timestamp: e.Time,
+		}
+		switch e.Type {
+		case docker.RunEventStart:
+			started = e.Time
+			p.Event = notify.EventStart
+			p.StartedAt = &started
+		case docker.RunEventReady:
+			p.Event = notify.EventReady
+			p.StartedAt = &started
+		case docker.RunEventExit:
+			code := e.ExitCode
+			finished := e.Time
+			p.Event = notify.EventExit
+			p.StartedAt = &started
+			p.FinishedAt = &finished
+			if e.Err != nil {
+				p.Error = e.Err.Error()
+			} else {
+				p.ExitCode = &code
+			}
+			if a := e.Assertion; a != nil {
+				p.Assertion = &notify.Assertion{Passed: a.Passed, Message: a.Message}
+			}
+		}
+		n.Notify(p)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().StringVar(&runName, "name", "", "Container name")
+	runCmd.Flags().StringArrayVar(&runLabels, "label", nil, "Label (key=value) set on the container (repeatable)")
+	runCmd.Flags().StringVar(&runPull, "pull", string(docker.PullMissing), "When to pull the image (never, missing or always)")
+	runCmd.Flags().BoolVar(&runKeep, "keep", false, "Keeps the container after it exits (same as --teardown never)")
+	runCmd.Flags().StringVar(&runTeardown, "teardown", string(docker.TeardownAlways), "When the container and its volumes are removed (always, on-success or never), printing the commands to inspect the kept ones")
+	runCmd.Flags().StringVar(&runPlatform, "platform", "", "Platform of the image to run (e.g. linux/arm64)")
+	runCmd.Flags().BoolVar(&runProbeEmulation, "probe-emulation", false, "Runs a tiny container for the target platform to confirm emulation works when no binfmt handler is found")
+	runCmd.Flags().BoolVar(&runSetupBinfmt, "setup-binfmt", false, "Registers the qemu binfmt handlers when the platform differs from the daemon one")
+	runCmd.Flags().BoolVar(&runRequireNative, "require-native", false, "Fails if the image platform differs from the daemon one, instead of warning it runs under emulation")
+	runCmd.Flags().StringVar(&runCaptureDir, "capture-dir", "", "Folder where the container stdout and stderr are saved (<container>.stdout.log and <container>.stderr.log)")
+	runCmd.Flags().StringVar(&runCaptureRotate, "capture-rotate", "", "Size after which the capture files are rotated (e.g. 50m)")
+	runCmd.Flags().IntVar(&runCaptureKeep, "capture-keep", 5, "Number of rotated capture files kept")
+	runCmd.Flags().StringVar(&runNotifyURL, "notify-url", "", "Webhook URL receiving a JSON POST when the container starts, is ready (--wait-cmd passed) and exits")
+	runCmd.Flags().StringArrayVar(&runOverlays, "overlay", nil, "Host folder the container gets a writable copy of, discarded on exit (SRC:/container/path, repeatable)")
+	runCmd.Flags().StringVar(&runOverlayExport, "overlay-export", "", "Folder where the files changed in the overlays are written on exit")
+	runCmd.Flags().StringArrayVar(&runInits, "init", nil, "One-shot container run to completion before the main one (IMAGE:CMD, or IMAGE:TAG:CMD, repeatable, run in order)")
+	runCmd.Flags().StringArrayVar(&runShared, "shared", nil, "Container path of an anonymous volume shared by the init containers and the main one (repeatable)")
+	runCmd.Flags().StringVar(&runNetwork, "network", "", "Existing network the container joins (external:NAME, e.g. of a compose project), never removed on teardown")
+	runCmd.Flags().StringVar(&runNetworkAlias, "network-alias", "", "Container alias in the --network one (the container name by default)")
+	runCmd.Flags().StringVar(&runNetNS, "netns", "", "Running container whose network namespace is shared, so both see the same localhost (container:NAME)")
+	runCmd.Flags().StringVar(&runMemory, "memory", "", "Memory limit of the container (e.g. 512m, 1g)")
+	runCmd.Flags().StringVar(&runCPUs, "cpus", "", "Number of CPUs the container can use (e.g. 1.5)")
+	runCmd.Flags().Int64Var(&runPidsLimit, "pids-limit", 0, "Maximum number of processes of the container (0 means no limit)")
+	runCmd.Flags().Int64Var(&runCPUShares, "cpu-shares", 0, "Relative CPU weight of the container (the daemon default is 1024)")
+	runCmd.Flags().StringVar(&runCpusetCpus, "cpuset-cpus", "", "CPUs the container is pinned to (e.g. 0-3,5), checked against the daemon host CPUs")
+	runCmd.Flags().StringVar(&runCpusetMems, "cpuset-mems", "", "NUMA memory nodes the container allocates from (e.g. 0)")
+	runCmd.Flags().StringVar(&runWaitCmd, "wait-cmd", "", "Host command run until the container is ready: exit 0 is ready, 1 not yet, others fail the run (RUNNER_CONTAINER_ID, RUNNER_CONTAINER_NAME, RUNNER_CONTAINER_IP and RUNNER_PORT_<port> are set)")
+	runCmd.Flags().DurationVar(&runWaitInterval, "wait-interval", time.Second, "Wait between the --wait-cmd attempts")
+	runCmd.Flags().DurationVar(&runWaitTimeout, "wait-timeout", time.Minute, "Time after which the container not ready fails the run")
+	runCmd.Flags().StringVar(&runAssertCmd, "assert-cmd", "", "Host command run once the container is ready, then the container is stopped: exit 0 passes, 1 fails the run, others are errors (same env as --wait-cmd)")
+	runCmd.Flags().DurationVar(&runAssertTimeout, "assert-timeout", time.Minute, "Time after which --assert-cmd is killed and fails the run")
+	runCmd.Flags().StringArrayVar(&runProbeVersions, "probe-version", nil, "Command exec'ed in the container once ready, without shell, whose first output line is printed as a runtime version, \"unavailable\" if missing or failing (e.g. \"node --version\", repeatable)")
+	runCmd.Flags().StringVar(&runGenTLS, "gen-tls", "", "Generates a throwaway CA and certificate copied to the container (e.g. cn=myservice.local,san=localhost,san=127.0.0.1,dir=/run/runner-tls), the CA path is in RUNNER_TLS_CA for the probes")
+	runCmd.Flags().StringVar(&runGenTLSCA, "gen-tls-ca", "", "Host file the --gen-tls CA certificate is written to, for host test clients")
+	runCmd.Flags().StringVar(&runNotifySecret, "notify-secret", "", "Secret used to sign the webhook payloads (HMAC-SHA256 in the X-Runner-Signature header)")
+}
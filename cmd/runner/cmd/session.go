@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionCmd represents the session command
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manages persistent shell sessions",
+	Long:  `Manages the persistent shell containers created with shell --session.`,
+}
+
+// sessionLsCmd represents the session ls command
+var sessionLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists the shell sessions",
+	Long:  `Lists the shell sessions, with their state and last use on this host.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		sessions, err := c.Sessions(ctx)
+		if err != nil {
+			panic(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "NAME\tCONTAINER\tIMAGE\tSTATE\tLAST USED\tIDLE TIMEOUT")
+		now := time.Now()
+		for _, s := range sessions {
+			lastUsed, timeout := "unknown", "none"
+			if !s.LastUsed.IsZero() {
+				lastUsed = now.Sub(s.LastUsed).Round(time.Second).String() + " ago"
+			}
+			if s.IdleTimeout > 0 {
+				timeout = s.IdleTimeout.String()
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.ContainerID[:12], s.Image, s.State, lastUsed, timeout)
+		}
+		_ = w.Flush()
+	},
+}
+
+// sessionRmCmd represents the session rm command
+var sessionRmCmd = &cobra.Command{
+	Use:   "rm [NAME...]",
+	Short: "Removes shell sessions",
+	Long: `Removes the named shell sessions, with their container filesystem, or with
+--idle the ones unused for longer than their --idle-timeout.`,
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && !sessionRmIdle {
+			panic(errors.New("session names or --idle are required"))
+		}
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		for _, name := range args {
+			if err := c.RemoveSession(ctx, name); err != nil {
+				panic(err)
+			}
+			fmt.Println("Removed", name)
+		}
+		if sessionRmIdle {
+			removed, err := c.RemoveIdleSessions(ctx)
+			for _, name := range removed {
+				fmt.Println("Removed", name)
+			}
+			if err != nil {
+				panic(err)
+			}
+		}
+	},
+}
+
+var sessionRmIdle bool
+
+func init() {
+	rootCmd.AddCommand(sessionCmd)
+	sessionCmd.AddCommand(sessionLsCmd)
+	sessionCmd.AddCommand(sessionRmCmd)
+
+	sessionRmCmd.Flags().BoolVar(&sessionRmIdle, "idle", false, "Removes the sessions unused for longer than their idle timeout")
+}
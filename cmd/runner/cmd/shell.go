@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:   "shell IMAGE|--attach CONTAINER [-- SHELL_ARGS...]",
+	Short: "Starts a debug shell in a throwaway container",
+	Long: `Starts a throwaway container from the image running a shell (/bin/bash or /bin/sh),
+with the entrypoint disabled and the current folder mounted read-only at /src.
+The container is removed on exit.
+
+With --session NAME the container persists and the shell runs inside it, so
+the next shell --session NAME reattaches to the same filesystem, e.g. after a
+dropped connection. See session ls and session rm.
+
+With --attach CONTAINER the shell runs in an existing container instead, e.g.
+one run --teardown kept: exec'ed in it while running, else in a throwaway
+container from a commit of its filesystem.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if shellAttach != "" {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		wd, err := os.Getwd()
+		if err != nil {
+			panic(err)
+		}
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		if shellAttach != "" {
+			code, err := c.AttachShell(ctx, shellAttach, docker.ShellOptions{
+				SourceDir: wd,
+				Root:      shellRoot,
+				Network:   shellNetwork,
+				Args:      args,
+			})
+			if err != nil {
+				panic(err)
+			}
+			os.Exit(code)
+		}
+		code, err := c.Shell(ctx, args[0], docker.ShellOptions{
+			SourceDir:   wd,
+			Root:        shellRoot,
+			Network:     shellNetwork,
+			Args:        args[1:],
+			Session:     shellSession,
+			IdleTimeout: shellIdleTimeout,
+		})
+		if err != nil {
+			panic(err)
+		}
+		os.Exit(code)
+	},
+}
+
+var (
+	shellRoot        bool
+	shellNetwork     string
+	shellSession     string
+	shellIdleTimeout time.Duration
+	shellAttach      string
+)
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+
+	shellCmd.Flags().BoolVar(&shellRoot, "root", false, "Runs the shell as root (user 0), even if the image sets USER")
+	shellCmd.Flags().StringVar(&shellNetwork, "network", "", "Network mode of the container (e.g. host)")
+	shellCmd.Flags().StringVar(&shellSession, "session", "", "Runs the shell in the named persistent container, reattached to by the next shell of the session")
+	shellCmd.Flags().StringVar(&shellAttach, "attach", "", "Runs the shell in the existing container instead of one from an image (a stopped one is committed to a throwaway image first)")
+	shellCmd.Flags().DurationVar(&shellIdleTimeout, "idle-timeout", 0, "Idle time after which session rm --idle removes a new session (never if 0)")
+}
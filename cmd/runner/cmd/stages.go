@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// stagesCmd represents the stages command
+var stagesCmd = &cobra.Command{
+	Use:   "stages [SRC]",
+	Short: "Lists the stages of a Dockerfile",
+	Long: `Lists the stages of the Dockerfile of SRC (the current folder by default),
+with their name (or index when unnamed), base image or stage and FROM line,
+the names being the build --target values.`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := "."
+		if len(args) > 0 {
+			src = args[0]
+		}
+		stages, err := docker.ReadStages(src, stagesContextSubdir)
+		if err != nil {
+			panic(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "STAGE\tBASE\tPLATFORM\tLINE")
+		for _, s := range stages {
+			name := s.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(s.Index)
+			}
+			base := s.Base
+			if s.BaseStage {
+				base += " (stage)"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", name, base, s.Platform, s.Line)
+		}
+		_ = w.Flush()
+	},
+}
+
+var (
+	stagesContextSubdir string
+)
+
+func init() {
+	rootCmd.AddCommand(stagesCmd)
+
+	stagesCmd.Flags().StringVar(&stagesContextSubdir, "context-subdir", "", "Folder, relative to SRC, holding the Dockerfile")
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eldius/docker-runner/internal/docker"
+
+	"github.com/spf13/cobra"
+)
+
+// validateRefCmd represents the validate-ref command
+var validateRefCmd = &cobra.Command{
+	Use:   "validate-ref REF...",
+	Short: "Checks the syntax of image references",
+	Long: `Parses each image reference as the daemon would, without contacting any
+registry, and prints whether it's valid, with its normalized form, or why
+it isn't. Exits with 1 if any reference is invalid.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		invalid := 0
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, ref := range args {
+			v := docker.ValidateRef(ref)
+			if v.Valid {
+				_, _ = fmt.Fprintf(w, "valid\t%s\t%s\n", v.Ref, v.Normalized)
+				continue
+			}
+			invalid++
+			_, _ = fmt.Fprintf(w, "invalid\t%s\t%s\n", v.Ref, v.Reason)
+		}
+		_ = w.Flush()
+		if invalid > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateRefCmd)
+}
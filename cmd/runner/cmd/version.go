@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Shows the Docker daemon version information",
+	Long: `Shows the Docker daemon version information, including the default build
+platform (the one builds without --platform target) and the features its
+API supports. Along with config show, it is the daemon diagnostics, there
+is no separate doctor command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		version, apiVersion, err := c.ServerVersion(ctx)
+		if err != nil {
+			panic(err)
+		}
+		platform, err := c.DefaultPlatform(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Server version:", version)
+		fmt.Println("Server API version:", apiVersion)
+		caps, err := c.Capabilities(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Default platform:", platform)
+		fmt.Println("Capabilities:")
+		for _, cp := range caps {
+			supported := "yes"
+			if !cp.Supported {
+				supported = "no"
+			}
+			fmt.Printf("  %-20s API >= %-5s %s\n", cp.Feature, cp.MinAPI, supported)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
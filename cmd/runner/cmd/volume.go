@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eldius/docker-runner/internal/docker"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spf13/cobra"
+)
+
+// volumeCmd represents the volume command
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manages test data volumes",
+	Long:  `Manages test data volumes.`,
+}
+
+// volumeBuildCmd represents the volume build command
+var volumeBuildCmd = &cobra.Command{
+	Use:   "build NAME SRC",
+	Short: "Builds a volume from a folder",
+	Long: `Creates a volume populated with the content of a folder, packed the same
+way build contexts are, and prints its content digest.`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		dgst, err := c.BuildVolume(ctx, args[0], args[1], docker.VolumeBuildOptions{
+			IgnoreFile: volumeIgnoreFile,
+			Replace:    volumeReplace,
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(dgst)
+	},
+}
+
+// volumeVerifyCmd represents the volume verify command
+var volumeVerifyCmd = &cobra.Command{
+	Use:         "verify NAME DIGEST",
+	Short:       "Verifies a volume content digest",
+	Long:        `Verifies the content of a volume matches the digest printed by volume build.`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: mutating,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		c, err := newClient()
+		if err != nil {
+			panic(err)
+		}
+		if err := c.VerifyVolume(ctx, args[0], digest.Digest(args[1])); err != nil {
+			panic(err)
+		}
+		fmt.Println("ok")
+	},
+}
+
+var (
+	volumeIgnoreFile string
+	volumeReplace    bool
+)
+
+func init() {
+	rootCmd.AddCommand(volumeCmd)
+	volumeCmd.AddCommand(volumeBuildCmd)
+	volumeCmd.AddCommand(volumeVerifyCmd)
+
+	volumeBuildCmd.Flags().StringVar(&volumeIgnoreFile, "ignore-file", "", "Ignore file (.dockerignore syntax), relative to the source folder")
+	volumeBuildCmd.Flags().BoolVar(&volumeReplace, "replace", false, "Recreates the volume if it already exists")
+}
@@ -0,0 +1,259 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ContextAnalyzeErr = errors.New("failed to analyze build context")
+)
+
+// junkDirs are the folders rarely needed by a build, with the reason
+var junkDirs = map[string]string{
+	".git":          "VCS folder",
+	".hg":           "VCS folder",
+	".svn":          "VCS folder",
+	"node_modules":  "dependency cache",
+	".venv":         "dependency cache",
+	"venv":          "dependency cache",
+	"__pycache__":   "dependency cache",
+	".gradle":       "dependency cache",
+	".cache":        "dependency cache",
+	".pytest_cache": "dependency cache",
+	"target":        "build output",
+	"dist":          "build output",
+	"build":         "build output",
+	"coverage":      "build output",
+	".idea":         "editor settings",
+	".vscode":       "editor settings",
+}
+
+// junkExts are the file extensions rarely needed by a build
+var junkExts = map[string]string{
+	".mp4":   "media file",
+	".mov":   "media file",
+	".avi":   "media file",
+	".mkv":   "media file",
+	".mp3":   "media file",
+	".wav":   "media file",
+	".psd":   "media file",
+	".log":   "log file",
+	".swp":   "editor file",
+	".tmp":   "temporary file",
+	".zip":   "archive",
+	".tgz":   "archive",
+	".iso":   "disk image",
+	".dmg":   "disk image",
+	".pyc":   "compiled file",
+	".class": "compiled file",
+}
+
+// SizeGroup is the size of a group of context files
+type SizeGroup struct {
+	Name  string
+	Files int
+	Bytes int64
+}
+
+// IgnoreSuggestion is a .dockerignore line the context would benefit
+// from, with the bytes it would save
+type IgnoreSuggestion struct {
+	Pattern string
+	Reason  string
+	Files   int
+	Bytes   int64
+}
+
+// ContextAnalysis describes what a build context holds
+type ContextAnalysis struct {
+	// Root is the context root folder
+	Root  string
+	Files int
+	Bytes int64
+	// ByDir are the included bytes by top-level entry, biggest first
+	ByDir []SizeGroup
+	// ByExt are the included bytes by file extension, biggest first
+	ByExt []SizeGroup
+	// Suggestions are the ignore lines for the well-known junk the
+	// Dockerfile COPY and ADD instructions never reference, biggest
+	// first
+	Suggestions []IgnoreSuggestion
+}
+
+// AnalyzeContext walks the build context of src (and subdir), applying
+// its ignore rules, and suggests ignore lines for the junk it holds
+func AnalyzeContext(src, subdir string) (ContextAnalysis, error) {
+	root, err := contextRoot(src, subdir)
+	if err != nil {
+		return ContextAnalysis{}, err
+	}
+	fsys := contextFS(root)
+	entries, err := contextEntries(fsys, buildContextTarOptions(BuildOptions{}))
+	if err != nil {
+		return ContextAnalysis{}, fmt.Errorf("%w: %w", ContextAnalyzeErr, err)
+	}
+	var sources []contextSource
+	if df, err := readDockerfile(fsys); err == nil {
+		sources = df.ContextSources()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return ContextAnalysis{}, fmt.Errorf("%w: %w", ContextAnalyzeErr, err)
+	}
+
+	a := ContextAnalysis{Root: root}
+	dirs := map[string]*SizeGroup{}
+	exts := map[string]*SizeGroup{}
+	suggestions := map[string]*IgnoreSuggestion{}
+	add := func(groups map[string]*SizeGroup, key string, size int64) {
+		g, ok := groups[key]
+		if !ok {
+			g = &SizeGroup{Name: key}
+			groups[key] = g
+		}
+		g.Files++
+		g.Bytes += size
+	}
+	suggest := func(pattern, reason string, size int64) {
+		s, ok := suggestions[pattern]
+		if !ok {
+			s = &IgnoreSuggestion{Pattern: pattern, Reason: reason}
+			suggestions[pattern] = s
+		}
+		s.Files++
+		s.Bytes += size
+	}
+
+	for name, d := range entries {
+		if d.IsDir() {
+			continue
+		}
+		i, err := d.Info()
+		if err != nil {
+			return ContextAnalysis{}, fmt.Errorf("%w: %w", ContextAnalyzeErr, err)
+		}
+		size := i.Size()
+		a.Files++
+		a.Bytes += size
+		top, _, _ := strings.Cut(name, "/")
+		add(dirs, top, size)
+		ext := strings.ToLower(path.Ext(name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		add(exts, ext, size)
+
+		if dir, reason, ok := junkDir(name); ok {
+			if !referenced(sources, dir) {
+				suggest(junkDirPattern(dir), reason, size)
+			}
+			continue
+		}
+		if reason, ok := junkExts[strings.ToLower(path.Ext(name))]; ok && !referenced(sources, name) {
+			suggest("**/*"+path.Ext(name), reason, size)
+		}
+	}
+
+	a.ByDir = sortedGroups(dirs)
+	a.ByExt = sortedGroups(exts)
+	for _, s := range suggestions {
+		a.Suggestions = append(a.Suggestions, *s)
+	}
+	sort.Slice(a.Suggestions, func(i, j int) bool {
+		if a.Suggestions[i].Bytes != a.Suggestions[j].Bytes {
+			return a.Suggestions[i].Bytes > a.Suggestions[j].Bytes
+		}
+		return a.Suggestions[i].Pattern < a.Suggestions[j].Pattern
+	})
+	return a, nil
+}
+
+// junkDir returns the outermost junk folder holding the file name
+func junkDir(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if reason, ok := junkDirs[p]; ok {
+			return strings.Join(parts[:i+1], "/"), reason, true
+		}
+	}
+	return "", "", false
+}
+
+// junkDirPattern is the ignore line of a junk folder: itself at the
+// root, any folder of its name otherwise
+func junkDirPattern(dir string) string {
+	if !strings.Contains(dir, "/") {
+		return dir
+	}
+	return "**/" + path.Base(dir)
+}
+
+// referenced tells if a COPY/ADD source names the context entry (or a
+// folder or glob holding it). The whole context (.) doesn't count, as
+// the suggestions are for what it copies without need.
+func referenced(sources []contextSource, name string) bool {
+	for _, s := range sources {
+		src := path.Clean(strings.TrimPrefix(s.Source, "/"))
+		if src == "." || strings.Contains(src, "$") {
+			continue
+		}
+		if src == name || strings.HasPrefix(name, src+"/") || strings.HasPrefix(src, name+"/") {
+			return true
+		}
+		if ok, _ := path.Match(src, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(src, path.Dir(name)); ok && strings.ContainsAny(src, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedGroups(groups map[string]*SizeGroup) []SizeGroup {
+	res := make([]SizeGroup, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, *g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes != res[j].Bytes {
+			return res[i].Bytes > res[j].Bytes
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// AppendIgnorePatterns appends the patterns to the .dockerignore file
+// of the context root, creating it if needed
+func AppendIgnorePatterns(root string, patterns []string) error {
+	p := filepath.Join(root, dockerignoreFile)
+	existing, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	var b strings.Builder
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		b.WriteString("\n")
+	}
+	for _, pt := range patterns {
+		b.WriteString(pt + "\n")
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	return nil
+}
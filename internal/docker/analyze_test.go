@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeContext(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		dockerfileName:            "FROM node:20\nCOPY package.json src/ ./\nCOPY assets/intro.mp4 /static/\n",
+		dockerignoreFile:          "*.log\n",
+		"package.json":            "{}",
+		"src/index.js":            strings.Repeat("j", 100),
+		"node_modules/a/index.js": strings.Repeat("n", 400),
+		"node_modules/b/index.js": strings.Repeat("n", 100),
+		"web/node_modules/x.js":   strings.Repeat("w", 50),
+		".git/HEAD":               "ref: refs/heads/main\n",
+		"assets/intro.mp4":        strings.Repeat("v", 1000),
+		"assets/promo.mp4":        strings.Repeat("v", 300),
+		"debug.log":               strings.Repeat("l", 5000),
+	})
+	a, err := AnalyzeContext(src, "")
+	if err != nil {
+		t.Fatalf("AnalyzeContext: %v", err)
+	}
+	// debug.log is already ignored
+	if a.Files != 10 {
+		t.Errorf("Files = %d, want the 10 included files", a.Files)
+	}
+	if len(a.ByDir) == 0 || a.ByDir[0].Name != "assets" || a.ByDir[0].Files != 2 || a.ByDir[0].Bytes != 1300 {
+		t.Errorf("ByDir = %+v, want assets first", a.ByDir)
+	}
+	if len(a.ByExt) == 0 || a.ByExt[0].Name != ".mp4" {
+		t.Errorf("ByExt = %+v, want .mp4 first", a.ByExt)
+	}
+	// intro.mp4 is copied, src and package.json aren't junk
+	want := []IgnoreSuggestion{
+		{Pattern: "node_modules", Reason: "dependency cache", Files: 2, Bytes: 500},
+		{Pattern: "**/*.mp4", Reason: "media file", Files: 1, Bytes: 300},
+		{Pattern: "**/node_modules", Reason: "dependency cache", Files: 1, Bytes: 50},
+		{Pattern: ".git", Reason: "VCS folder", Files: 1, Bytes: 21},
+	}
+	if !reflect.DeepEqual(a.Suggestions, want) {
+		t.Errorf("Suggestions = %+v, want %+v", a.Suggestions, want)
+	}
+}
+
+func TestReferenced(t *testing.T) {
+	sources := []contextSource{{Source: "./config"}, {Source: "/assets/*.png"}, {Source: "."}, {Source: "$SRC"}}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "config", want: true},
+		{name: "config/app.yml", want: true},
+		{name: "assets/logo.png", want: true},
+		{name: "assets/logo.jpg", want: false},
+		{name: "main.go", want: false},
+	}
+	for _, tt := range tests {
+		if got := referenced(sources, tt.name); got != tt.want {
+			t.Errorf("referenced(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAppendIgnorePatterns(t *testing.T) {
+	root := t.TempDir()
+	if err := AppendIgnorePatterns(root, []string{"node_modules"}); err != nil {
+		t.Fatalf("AppendIgnorePatterns: %v", err)
+	}
+	path := filepath.Join(root, dockerignoreFile)
+	if b, err := os.ReadFile(path); err != nil || string(b) != "node_modules\n" {
+		t.Fatalf("created %s = %q, %v, want the pattern", dockerignoreFile, b, err)
+	}
+	// an existing file without a final newline
+	if err := os.WriteFile(path, []byte("*.log"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendIgnorePatterns(root, []string{".git", "**/*.mp4"}); err != nil {
+		t.Fatalf("AppendIgnorePatterns: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "*.log\n.git\n**/*.mp4\n"; string(b) != want {
+		t.Errorf("%s = %q, want %q", dockerignoreFile, b, want)
+	}
+}
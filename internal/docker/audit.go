@@ -0,0 +1,250 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+var (
+	InvalidThresholdErr = errors.New("invalid idle threshold")
+	AuditErr            = errors.New("failed to audit resources")
+)
+
+// IdleKind is the kind of an idle resource
+type IdleKind string
+
+const (
+	IdleContainer IdleKind = "container"
+	IdleVolume    IdleKind = "volume"
+	IdleNetwork   IdleKind = "network"
+	IdleImage     IdleKind = "image"
+)
+
+// idleKindOrder lists the containers first, as they hold the others
+var idleKindOrder = map[IdleKind]int{IdleContainer: 0, IdleVolume: 1, IdleNetwork: 2, IdleImage: 3}
+
+// predefinedNetworks can't be removed
+var predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// IdleOptions defines what an idle audit looks for
+type IdleOptions struct {
+	// Threshold is the time a resource has to be unused for
+	Threshold time.Duration
+	// All audits every resource, not only the docker-runner ones
+	All bool
+}
+
+// IdleResource is a resource unused for longer than the threshold
+type IdleResource struct {
+	Kind IdleKind
+	ID   string
+	Name string
+	// LastUsed is the last use the daemon knows of (stop, tag or
+	// creation time)
+	LastUsed time.Time
+	// Size is the estimated reclaimable size (0 if unknown)
+	Size int64
+	// Reason tells why it's taken for idle
+	Reason string
+}
+
+// ParseIdleThreshold parses a Go duration, or a number of days (14d)
+func ParseIdleThreshold(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: %q (e.g. 14d or 36h)", InvalidThresholdErr, s)
+	}
+	return d, nil
+}
+
+// IdleResources lists the stopped containers, unmounted volumes,
+// networks without endpoints and images no container uses, whose last
+// use is older than the threshold, sorted by kind and age. The daemon
+// doesn't track uses, so the last one is the container stop time, the
+// image last tag time or the resource creation time.
+func (c Client) IdleResources(ctx context.Context, opts IdleOptions) ([]IdleResource, error) {
+	du, err := c.d.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", AuditErr, err)
+	}
+	before := time.Now().Add(-opts.Threshold)
+	var res []IdleResource
+	used := map[string]bool{}
+	for _, ctr := range du.Containers {
+		used[ctr.ImageID] = true
+		if ctr.State == "running" || ctr.State == "paused" || ctr.State == "restarting" {
+			continue
+		}
+		if !opts.All && ctr.Labels[ManagedLabel] != "true" {
+			continue
+		}
+		lastUsed := time.Unix(ctr.Created, 0)
+		if inspect, err := c.d.ContainerInspect(ctx, ctr.ID); err == nil && inspect.State != nil {
+			if t, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt); err == nil && t.After(lastUsed) {
+				lastUsed = t
+			}
+		}
+		if lastUsed.After(before) {
+			continue
+		}
+		res = append(res, IdleResource{
+			Kind:     IdleContainer,
+			ID:       ctr.ID[:12],
+			Name:     strings.TrimPrefix(firstOr(ctr.Names, ""), "/"),
+			LastUsed: lastUsed,
+			Size:     ctr.SizeRw,
+			Reason:   ctr.State,
+		})
+	}
+	for _, v := range du.Volumes {
+		if v.UsageData != nil && v.UsageData.RefCount > 0 {
+			continue
+		}
+		if !opts.All && v.Labels[ManagedLabel] != "true" {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, v.CreatedAt)
+		if err != nil || created.After(before) {
+			continue
+		}
+		r := IdleResource{Kind: IdleVolume, ID: v.Name, Name: v.Name, LastUsed: created, Reason: "not mounted"}
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			r.Size = v.UsageData.Size
+		}
+		res = append(res, r)
+	}
+	networks, err := c.idleNetworks(ctx, opts, before)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, networks...)
+	for _, img := range du.Images {
+		if img.Containers > 0 || used[img.ID] {
+			continue
+		}
+		if !opts.All && !isRunnerImage(img.RepoTags) {
+			continue
+		}
+		lastUsed := time.Unix(img.Created, 0)
+		if inspect, _, err := c.d.ImageInspectWithRaw(ctx, img.ID); err == nil && inspect.Metadata.LastTagTime.After(lastUsed) {
+			lastUsed = inspect.Metadata.LastTagTime
+		}
+		if lastUsed.After(before) {
+			continue
+		}
+		// the layers shared with other images aren't reclaimed
+		size := img.Size
+		if img.SharedSize > 0 {
+			size -= img.SharedSize
+		}
+		res = append(res, IdleResource{
+			Kind:     IdleImage,
+			ID:       strings.TrimPrefix(img.ID, "sha256:")[:12],
+			Name:     firstOr(img.RepoTags, "<none>"),
+			LastUsed: lastUsed,
+			Size:     size,
+			Reason:   "not used by any container",
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return idleKindOrder[res[i].Kind] < idleKindOrder[res[j].Kind]
+		}
+		return res[i].LastUsed.Before(res[j].LastUsed)
+	})
+	return res, nil
+}
+
+// idleNetworks lists the user networks without endpoints created before
+// the time
+func (c Client) idleNetworks(ctx context.Context, opts IdleOptions, before time.Time) ([]IdleResource, error) {
+	args := filters.NewArgs()
+	if !opts.All {
+		args.Add("label", ManagedLabel+"=true")
+	}
+	list, err := c.d.NetworkList(ctx, types.NetworkListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", AuditErr, err)
+	}
+	var res []IdleResource
+	for _, n := range list {
+		if predefinedNetworks[n.Name] || n.Created.After(before) {
+			continue
+		}
+		// the list doesn't tell the endpoints
+		inspect, err := c.d.NetworkInspect(ctx, n.ID, types.NetworkInspectOptions{})
+		if err != nil || len(inspect.Containers) > 0 {
+			continue
+		}
+		res = append(res, IdleResource{
+			Kind:     IdleNetwork,
+			ID:       n.ID[:12],
+			Name:     n.Name,
+			LastUsed: n.Created,
+			Reason:   "no endpoints",
+		})
+	}
+	return res, nil
+}
+
+// isRunnerImage tells if an image is only tagged in the repositories
+// of the inline and ephemeral builds
+func isRunnerImage(tags []string) bool {
+	if len(tags) == 0 {
+		return false
+	}
+	for _, t := range tags {
+		repo, _, _ := strings.Cut(t, ":")
+		if repo != inlineTagRepo && repo != ephemeralTagRepo {
+			return false
+		}
+	}
+	return true
+}
+
+func firstOr(values []string, fallback string) string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return values[0]
+}
+
+// IdleCleanupCommands returns the docker commands removing the idle
+// resources, containers first as they hold the others
+func IdleCleanupCommands(resources []IdleResource) []string {
+	byKind := map[IdleKind][]string{}
+	for _, r := range resources {
+		byKind[r.Kind] = append(byKind[r.Kind], r.ID)
+	}
+	var res []string
+	for _, k := range []struct {
+		kind IdleKind
+		cmd  string
+	}{
+		{IdleContainer, "docker rm"},
+		{IdleVolume, "docker volume rm"},
+		{IdleNetwork, "docker network rm"},
+		{IdleImage, "docker image rm"},
+	} {
+		if ids := byKind[k.kind]; len(ids) > 0 {
+			res = append(res, k.cmd+" "+strings.Join(ids, " "))
+		}
+	}
+	return res
+}
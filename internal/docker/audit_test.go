@@ -0,0 +1,288 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// auditAPI fakes the daemon calls of an idle audit
+type auditAPI struct {
+	client.APIClient
+	du types.DiskUsage
+	// finished is the container stop time, by id
+	finished map[string]time.Time
+	// tagged is the image last tag time, by id
+	tagged   map[string]time.Time
+	networks []types.NetworkResource
+	// endpoints is the number of containers connected, by network id
+	endpoints map[string]int
+	filters   filters.Args
+	duErr     error
+}
+
+func (f *auditAPI) DiskUsage(_ context.Context, _ types.DiskUsageOptions) (types.DiskUsage, error) {
+	return f.du, f.duErr
+}
+
+func (f *auditAPI) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	state := &types.ContainerState{FinishedAt: "0001-01-01T00:00:00Z"}
+	if t, ok := f.finished[id]; ok {
+		state.FinishedAt = t.Format(time.RFC3339Nano)
+	}
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id, State: state}}, nil
+}
+
+func (f *auditAPI) ImageInspectWithRaw(_ context.Context, id string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: id, Metadata: image.Metadata{LastTagTime: f.tagged[id]}}, nil, nil
+}
+
+func (f *auditAPI) NetworkList(_ context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	f.filters = options.Filters
+	return f.networks, nil
+}
+
+func (f *auditAPI) NetworkInspect(_ context.Context, id string, _ types.NetworkInspectOptions) (types.NetworkResource, error) {
+	res := types.NetworkResource{ID: id, Containers: map[string]types.EndpointResource{}}
+	for i := 0; i < f.endpoints[id]; i++ {
+		res.Containers[string(rune('a'+i))] = types.EndpointResource{}
+	}
+	return res, nil
+}
+
+func TestParseIdleThreshold(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "14d", want: 14 * 24 * time.Hour},
+		{in: "36h", want: 36 * time.Hour},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "0d", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "1.5d", wantErr: true},
+		{in: "two weeks", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseIdleThreshold(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidThresholdErr) {
+					t.Errorf("err = %v, want %v", err, InvalidThresholdErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseIdleThreshold: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseIdleThreshold = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testAuditAPI returns a daemon with a mix of idle, recent and used
+// resources, some of them created by docker-runner
+func testAuditAPI(now time.Time) *auditAPI {
+	old := now.Add(-30 * 24 * time.Hour)
+	older := now.Add(-60 * 24 * time.Hour)
+	recent := now.Add(-time.Hour)
+	managed := map[string]string{ManagedLabel: "true"}
+	return &auditAPI{
+		du: types.DiskUsage{
+			Containers: []*types.Container{
+				{ID: "c1aaaaaaaaaaaaaa", Names: []string{"/old-run"}, ImageID: "sha256:i1used000000000", State: "exited", Created: older.Unix(), SizeRw: 10, Labels: managed},
+				{ID: "c2aaaaaaaaaaaaaa", Names: []string{"/stopped-lately"}, State: "exited", Created: older.Unix(), Labels: managed},
+				{ID: "c3aaaaaaaaaaaaaa", Names: []string{"/serving"}, State: "running", Created: older.Unix(), Labels: managed},
+				{ID: "c4aaaaaaaaaaaaaa", Names: []string{"/foreign"}, State: "exited", Created: older.Unix()},
+				{ID: "c5aaaaaaaaaaaaaa", Names: []string{"/oldest-run"}, State: "created", Created: older.Add(-time.Hour).Unix(), Labels: managed},
+			},
+			Volumes: []*volume.Volume{
+				{Name: "cache", CreatedAt: old.Format(time.RFC3339), Labels: managed, UsageData: &volume.UsageData{Size: 100}},
+				{Name: "mounted", CreatedAt: old.Format(time.RFC3339), Labels: managed, UsageData: &volume.UsageData{RefCount: 1}},
+				{Name: "fresh", CreatedAt: recent.Format(time.RFC3339), Labels: managed},
+				{Name: "foreign", CreatedAt: old.Format(time.RFC3339), UsageData: &volume.UsageData{Size: -1}},
+			},
+			Images: []*image.Summary{
+				{ID: "sha256:i1used000000000", RepoTags: []string{inlineTagRepo + ":a"}, Created: older.Unix()},
+				{ID: "sha256:i2idle000000000", RepoTags: []string{inlineTagRepo + ":b"}, Created: older.Unix(), Size: 300, SharedSize: 100},
+				{ID: "sha256:i3retag00000000", RepoTags: []string{ephemeralTagRepo + ":c"}, Created: older.Unix()},
+				{ID: "sha256:i4mine000000000", RepoTags: []string{"app:1"}, Created: older.Unix(), Size: 50},
+				{ID: "sha256:i5dangling00000", Created: old.Unix(), Size: 20, SharedSize: -1},
+				{ID: "sha256:i6counted000000", RepoTags: []string{inlineTagRepo + ":d"}, Created: older.Unix(), Containers: 1},
+			},
+		},
+		finished: map[string]time.Time{
+			"c1aaaaaaaaaaaaaa": old,
+			"c2aaaaaaaaaaaaaa": recent,
+		},
+		tagged: map[string]time.Time{"sha256:i3retag00000000": recent},
+		networks: []types.NetworkResource{
+			{ID: "n1aaaaaaaaaaaaaa", Name: "runner-net", Created: old},
+			{ID: "n2aaaaaaaaaaaaaa", Name: "busy-net", Created: old},
+			{ID: "n3aaaaaaaaaaaaaa", Name: "bridge", Created: older},
+			{ID: "n4aaaaaaaaaaaaaa", Name: "new-net", Created: recent},
+		},
+		endpoints: map[string]int{"n2aaaaaaaaaaaaaa": 2},
+	}
+}
+
+func idleNames(res []IdleResource) []string {
+	var names []string
+	for _, r := range res {
+		names = append(names, string(r.Kind)+" "+r.Name)
+	}
+	return names
+}
+
+func TestIdleResources(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		opts IdleOptions
+		want []string
+	}{
+		{
+			name: "managed",
+			opts: IdleOptions{Threshold: 7 * 24 * time.Hour},
+			want: []string{
+				"container oldest-run",
+				"container old-run",
+				"volume cache",
+				"network runner-net",
+				"image " + inlineTagRepo + ":b",
+			},
+		},
+		{
+			name: "all",
+			opts: IdleOptions{Threshold: 7 * 24 * time.Hour, All: true},
+			want: []string{
+				"container oldest-run",
+				"container foreign",
+				"container old-run",
+				"volume cache",
+				"volume foreign",
+				"network runner-net",
+				"image " + inlineTagRepo + ":b",
+				"image app:1",
+				"image <none>",
+			},
+		},
+		{
+			name: "long threshold",
+			opts: IdleOptions{Threshold: 45 * 24 * time.Hour, All: true},
+			want: []string{
+				"container oldest-run",
+				"container foreign",
+				"image " + inlineTagRepo + ":b",
+				"image app:1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := testAuditAPI(now)
+			res, err := newTestClient(api).IdleResources(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("IdleResources: %v", err)
+			}
+			if got := idleNames(res); !equalStrings(got, tt.want) {
+				t.Errorf("IdleResources =\n%v\nwant\n%v", got, tt.want)
+			}
+			if got := api.filters.Get("label"); tt.opts.All != (len(got) == 0) {
+				t.Errorf("network label filter = %v with All %v", got, tt.opts.All)
+			}
+		})
+	}
+}
+
+func TestIdleResourcesDetails(t *testing.T) {
+	now := time.Now()
+	api := testAuditAPI(now)
+	res, err := newTestClient(api).IdleResources(context.Background(), IdleOptions{Threshold: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("IdleResources: %v", err)
+	}
+	byName := map[string]IdleResource{}
+	for _, r := range res {
+		byName[r.Name] = r
+	}
+	old := now.Add(-30 * 24 * time.Hour)
+	tests := []struct {
+		name string
+		want IdleResource
+	}{
+		{name: "old-run", want: IdleResource{Kind: IdleContainer, ID: "c1aaaaaaaaaa", Name: "old-run", Size: 10, Reason: "exited"}},
+		{name: "cache", want: IdleResource{Kind: IdleVolume, ID: "cache", Name: "cache", Size: 100, Reason: "not mounted"}},
+		{name: "runner-net", want: IdleResource{Kind: IdleNetwork, ID: "n1aaaaaaaaaa", Name: "runner-net", Reason: "no endpoints"}},
+		{name: inlineTagRepo + ":b", want: IdleResource{Kind: IdleImage, ID: "i2idle000000", Name: inlineTagRepo + ":b", Size: 200, Reason: "not used by any container"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := byName[tt.name]
+			if !ok {
+				t.Fatalf("%s not reported idle", tt.name)
+			}
+			if tt.want.Kind != IdleImage {
+				// the stop or creation time, to the second for the volumes
+				if d := got.LastUsed.Sub(old); d > time.Second || d < -time.Second {
+					t.Errorf("LastUsed = %v, want %v", got.LastUsed, old)
+				}
+			}
+			got.LastUsed = time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resource = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdleResourcesError(t *testing.T) {
+	api := &auditAPI{duErr: errors.New("daemon down")}
+	if _, err := newTestClient(api).IdleResources(context.Background(), IdleOptions{Threshold: time.Hour}); !errors.Is(err, AuditErr) {
+		t.Errorf("err = %v, want %v", err, AuditErr)
+	}
+}
+
+func TestIsRunnerImage(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{tags: nil},
+		{tags: []string{inlineTagRepo + ":a"}, want: true},
+		{tags: []string{inlineTagRepo + ":a", ephemeralTagRepo + ":b"}, want: true},
+		{tags: []string{inlineTagRepo + ":a", "app:1"}},
+		{tags: []string{"app:1"}},
+	}
+	for _, tt := range tests {
+		if got := isRunnerImage(tt.tags); got != tt.want {
+			t.Errorf("isRunnerImage(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestIdleCleanupCommands(t *testing.T) {
+	got := IdleCleanupCommands([]IdleResource{
+		{Kind: IdleImage, ID: "i1"},
+		{Kind: IdleContainer, ID: "c1"},
+		{Kind: IdleVolume, ID: "cache"},
+		{Kind: IdleContainer, ID: "c2"},
+	})
+	want := []string{"docker rm c1 c2", "docker volume rm cache", "docker image rm i1"}
+	if !equalStrings(got, want) {
+		t.Errorf("IdleCleanupCommands = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types/registry"
+)
+
+var (
+	RegistryAuthErr = errors.New("failed to resolve registry credentials")
+)
+
+const (
+	// dockerHubDomain is the default registry domain of image names
+	dockerHubDomain = "docker.io"
+	// dockerHubAuthKey is the key of the Docker Hub credentials in the
+	// Docker CLI config file
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+)
+
+// registryAuth returns the encoded credentials (from the Docker CLI
+// config file and its credential helpers) of the registry the image
+// reference belongs to, as expected by the pull and push API calls
+func registryAuth(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", RegistryAuthErr, err)
+	}
+	host := reference.Domain(named)
+	if host == dockerHubDomain {
+		host = dockerHubAuthKey
+	}
+
+	cfg := config.LoadDefaultConfigFile(io.Discard)
+	auth, err := cfg.GetAuthConfig(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", RegistryAuthErr, err)
+	}
+
+	encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", RegistryAuthErr, err)
+	}
+	return encoded, nil
+}
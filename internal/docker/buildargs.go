@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	BuildArgFileErr = errors.New("failed to read build arg file")
+	UndefinedVarErr = errors.New("undefined variable")
+)
+
+// ReadBuildArgFile reads a dotenv-like build arg file: KEY=VALUE lines,
+// with optional export prefix, # comments and single or double quoted
+// values. $VAR, ${VAR} and ${VAR:-default} are expanded from the host
+// environment (except in single quotes), failing for undefined vars
+// without a default. A KEY alone is left unset.
+func ReadBuildArgFile(path string) (map[string]*string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", BuildArgFileErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	args := make(map[string]*string)
+	s := bufio.NewScanner(f)
+	line := 0
+	for s.Scan() {
+		line++
+		k, v, err := parseBuildArgLine(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s:%d: %w", BuildArgFileErr, path, line, err)
+		}
+		if k != "" {
+			args[k] = v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", BuildArgFileErr, err)
+	}
+	return args, nil
+}
+
+// parseBuildArgLine parses a build arg file line, returning an empty
+// key for blank and comment lines
+func parseBuildArgLine(l string) (string, *string, error) {
+	l = strings.TrimSpace(strings.TrimSuffix(l, "\r"))
+	if l == "" || strings.HasPrefix(l, "#") {
+		return "", nil, nil
+	}
+	l = strings.TrimSpace(strings.TrimPrefix(l, "export "))
+
+	k, raw, ok := strings.Cut(l, "=")
+	k = strings.TrimSpace(k)
+	if k == "" || strings.ContainsAny(k, " \t") {
+		return "", nil, fmt.Errorf("invalid line %q", l)
+	}
+	if !ok {
+		return k, nil, nil
+	}
+	raw = strings.TrimSpace(raw)
+
+	var v string
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", nil, fmt.Errorf("unterminated quote in %q", l)
+		}
+		return k, strPtr(raw[1 : end+1]), nil
+	case strings.HasPrefix(raw, `"`):
+		unquoted, err := unquoteDouble(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w in %q", err, l)
+		}
+		v = unquoted
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = strings.TrimSpace(raw[:i])
+		}
+		v = raw
+	}
+
+	expanded, err := expandHostVars(v)
+	if err != nil {
+		return "", nil, err
+	}
+	return k, &expanded, nil
+}
+
+// unquoteDouble returns the content of a double quoted value, handling
+// the \n, \t, \" and \\ escapes
+func unquoteDouble(raw string) (string, error) {
+	var b strings.Builder
+	for i := 1; i < len(raw); i++ {
+		switch ch := raw[i]; ch {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if i+1 >= len(raw) {
+				return "", errors.New("unterminated quote")
+			}
+			i++
+			switch raw[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(raw[i])
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", errors.New("unterminated quote")
+}
+
+// expandHostVars expands $VAR, ${VAR} and ${VAR:-default} from the host
+// environment
+func expandHostVars(s string) (string, error) {
+	var missing []string
+	res := os.Expand(s, func(name string) string {
+		name, def, hasDef := strings.Cut(name, ":-")
+		if v, ok := os.LookupEnv(name); ok && (v != "" || !hasDef) {
+			return v
+		}
+		if hasDef {
+			return def
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s", UndefinedVarErr, strings.Join(missing, ", "))
+	}
+	return res, nil
+}
+
+// MergeBuildArgs merges build arg sources, from the lowest to the
+// highest precedence. An unset arg doesn't replace a set one.
+func MergeBuildArgs(sources ...map[string]*string) map[string]*string {
+	res := make(map[string]*string)
+	for _, src := range sources {
+		for k, v := range src {
+			if v == nil && res[k] != nil {
+				continue
+			}
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,136 @@
+package docker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseBuildArgLine(t *testing.T) {
+	t.Setenv("RUNNER_TEST_HOST", "example.com")
+	t.Setenv("RUNNER_TEST_EMPTY", "")
+	tests := []struct {
+		line  string
+		key   string
+		value string
+		unset bool
+	}{
+		{line: "", key: ""},
+		{line: "   ", key: ""},
+		{line: "# a comment", key: ""},
+		{line: "VERSION=1.0", key: "VERSION", value: "1.0"},
+		{line: "  VERSION = 1.0  ", key: "VERSION", value: "1.0"},
+		{line: "export VERSION=1.0", key: "VERSION", value: "1.0"},
+		{line: "VERSION=1.0\r", key: "VERSION", value: "1.0"},
+		{line: "VERSION=1.0 # trailing comment", key: "VERSION", value: "1.0"},
+		{line: "COLOR=#fff", key: "COLOR", value: "#fff"},
+		{line: "EMPTY=", key: "EMPTY", value: ""},
+		{line: "TOKEN", key: "TOKEN", unset: true},
+		{line: `GREETING="hello # world"`, key: "GREETING", value: "hello # world"},
+		{line: `ESCAPED="a\tb\n\"c\"\\"`, key: "ESCAPED", value: "a\tb\n\"c\"\\"},
+		{line: "RAW='$RUNNER_TEST_HOST \\n'", key: "RAW", value: `$RUNNER_TEST_HOST \n`},
+		{line: "URL=https://$RUNNER_TEST_HOST/api", key: "URL", value: "https://example.com/api"},
+		{line: `URL="https://${RUNNER_TEST_HOST}/api"`, key: "URL", value: "https://example.com/api"},
+		{line: "PORT=${RUNNER_TEST_PORT:-8080}", key: "PORT", value: "8080"},
+		{line: "HOST=${RUNNER_TEST_HOST:-localhost}", key: "HOST", value: "example.com"},
+		{line: "HOST=${RUNNER_TEST_EMPTY:-localhost}", key: "HOST", value: "localhost"},
+		{line: "HOST=${RUNNER_TEST_EMPTY}", key: "HOST", value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			k, v, err := parseBuildArgLine(tt.line)
+			if err != nil {
+				t.Fatalf("parseBuildArgLine: %v", err)
+			}
+			if k != tt.key {
+				t.Fatalf("key = %q, want %q", k, tt.key)
+			}
+			if k == "" {
+				return
+			}
+			switch {
+			case tt.unset && v != nil:
+				t.Errorf("value = %q, want unset", *v)
+			case !tt.unset && v == nil:
+				t.Errorf("value unset, want %q", tt.value)
+			case !tt.unset && *v != tt.value:
+				t.Errorf("value = %q, want %q", *v, tt.value)
+			}
+		})
+	}
+}
+
+func TestParseBuildArgLineErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{line: "=value"},
+		{line: "MY KEY=value"},
+		{line: `OPEN="unterminated`},
+		{line: "OPEN='unterminated"},
+		{line: `OPEN="trailing\`},
+		{line: "URL=https://$RUNNER_TEST_UNDEFINED/api", want: UndefinedVarErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, _, err := parseBuildArgLine(tt.line)
+			if err == nil {
+				t.Fatal("err = nil, want an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBuildArgFile(t *testing.T) {
+	t.Setenv("RUNNER_TEST_REGISTRY", "registry.local")
+	path := filepath.Join(t.TempDir(), "build.env")
+	content := "# build args\n\nexport REGISTRY=$RUNNER_TEST_REGISTRY\nVERSION='1.0'\nTOKEN\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args, err := ReadBuildArgFile(path)
+	if err != nil {
+		t.Fatalf("ReadBuildArgFile: %v", err)
+	}
+	want := map[string]string{"REGISTRY": "registry.local", "VERSION": "1.0", "TOKEN": "<nil>"}
+	if got := derefArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("args = %v, want %v", got, want)
+	}
+}
+
+func TestReadBuildArgFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "build.env")
+	if err := os.WriteFile(path, []byte("VERSION=1.0\nBAD LINE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing.env")},
+		{name: "invalid line", path: path},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadBuildArgFile(tt.path); !errors.Is(err, BuildArgFileErr) {
+				t.Errorf("err = %v, want %v", err, BuildArgFileErr)
+			}
+		})
+	}
+}
+
+func TestMergeBuildArgs(t *testing.T) {
+	file := map[string]*string{"VERSION": strPtr("1.0"), "DEBUG": strPtr("false"), "TOKEN": nil}
+	flags := map[string]*string{"VERSION": strPtr("2.0"), "DEBUG": nil}
+	want := map[string]string{"VERSION": "2.0", "DEBUG": "false", "TOKEN": "<nil>"}
+	if got := derefArgs(MergeBuildArgs(file, flags)); !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeBuildArgs = %v, want %v", got, want)
+	}
+}
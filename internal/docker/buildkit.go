@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+)
+
+var (
+	BuildKitSessionErr = errors.New("failed to start BuildKit session")
+	InvalidOutputErr   = errors.New("invalid build output spec")
+)
+
+const (
+	// OutputTypeRegistry pushes the result straight to the registry,
+	// without loading it in the local image store
+	OutputTypeRegistry = "registry"
+	// OutputTypeImage is the BuildKit image exporter
+	OutputTypeImage = "image"
+	// InlineCacheArg is the build arg making BuildKit embed the cache
+	// metadata in the image config, so a later build can use the pushed
+	// image as cache source
+	InlineCacheArg = "BUILDKIT_INLINE_CACHE"
+)
+
+// ParseOutput parses a BuildKit output spec, like
+// "type=registry" or "type=image,push=true,name=repo/app:tag"
+func ParseOutput(spec string) (types.ImageBuildOutput, error) {
+	out := types.ImageBuildOutput{Attrs: map[string]string{}}
+	for _, field := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || k == "" {
+			return out, fmt.Errorf("%w: %q is not a key=value pair", InvalidOutputErr, field)
+		}
+		if k == "type" {
+			out.Type = v
+			continue
+		}
+		out.Attrs[k] = v
+	}
+
+	switch out.Type {
+	case OutputTypeRegistry:
+		out.Type = OutputTypeImage
+		out.Attrs["push"] = "true"
+	case OutputTypeImage:
+	case "":
+		return out, fmt.Errorf("%w: missing type in %q", InvalidOutputErr, spec)
+	default:
+		return out, fmt.Errorf("%w: unsupported type %q (supported types: %s, %s)", InvalidOutputErr, out.Type, OutputTypeRegistry, OutputTypeImage)
+	}
+	return out, nil
+}
+
+// withInlineCache returns a copy of the build args enabling the inline
+// cache export
+func withInlineCache(args map[string]*string) map[string]*string {
+	return MergeBuildArgs(args, map[string]*string{InlineCacheArg: strPtr("1")})
+}
+
+// startSession starts a BuildKit session serving the registry
+// credentials from the Docker CLI config file, the same ones
+// `docker push` would use, with a shared key derived from the context
+// key
+func (c Client) startSession(ctx context.Context, contextKey string) (*session.Session, error) {
+	key := sha256.Sum256([]byte(contextKey))
+	s, err := session.NewSession(ctx, "docker-runner", hex.EncodeToString(key[:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", BuildKitSessionErr, err)
+	}
+	s.Allow(authprovider.NewDockerAuthProvider(config.LoadDefaultConfigFile(io.Discard)))
+
+	dialSession := func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		return c.d.DialHijack(ctx, "/session", proto, meta)
+	}
+	go func() {
+		_ = s.Run(ctx, dialSession)
+	}()
+	return s, nil
+}
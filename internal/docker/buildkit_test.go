@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		spec    string
+		typ     string
+		attrs   map[string]string
+		wantErr bool
+	}{
+		{spec: "type=registry", typ: OutputTypeImage, attrs: map[string]string{"push": "true"}},
+		{spec: "type=registry,name=registry.local/app:1", typ: OutputTypeImage, attrs: map[string]string{"push": "true", "name": "registry.local/app:1"}},
+		// registry always pushes
+		{spec: "type=registry,push=false", typ: OutputTypeImage, attrs: map[string]string{"push": "true"}},
+		{spec: "type=image, push=true ,name=repo/app:tag", typ: OutputTypeImage, attrs: map[string]string{"push": "true", "name": "repo/app:tag"}},
+		{spec: "type=image", typ: OutputTypeImage, attrs: map[string]string{}},
+		{spec: "name=repo/app", wantErr: true},
+		{spec: "type=tar,dest=out.tar", wantErr: true},
+		{spec: "type=registry,push", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseOutput(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidOutputErr) {
+					t.Errorf("ParseOutput err = %v, want %v", err, InvalidOutputErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOutput: %v", err)
+			}
+			if got.Type != tt.typ || len(got.Attrs) != len(tt.attrs) {
+				t.Fatalf("ParseOutput = %+v, want type %s and %v", got, tt.typ, tt.attrs)
+			}
+			for k, v := range tt.attrs {
+				if got.Attrs[k] != v {
+					t.Errorf("attr %s = %q, want %q", k, got.Attrs[k], v)
+				}
+			}
+		})
+	}
+}
+
+// buildkitAPI fakes the BuildKit builds, sending the session dial
+// headers to dials. With dials set, the builds wait for the session
+// as the daemon does, keeping its headers in session.
+type buildkitAPI struct {
+	*buildAPI
+	dials   chan map[string][]string
+	session map[string][]string
+}
+
+func (f *buildkitAPI) ImageBuild(ctx context.Context, buildContext io.Reader, opts types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	if f.dials != nil {
+		select {
+		case f.session = <-f.dials:
+		case <-time.After(5 * time.Second):
+			return types.ImageBuildResponse{}, errors.New("the session wasn't dialed")
+		}
+	}
+	return f.buildAPI.ImageBuild(ctx, buildContext, opts)
+}
+
+func (f *buildkitAPI) DialHijack(_ context.Context, _, _ string, meta map[string][]string) (net.Conn, error) {
+	if f.dials != nil {
+		f.dials <- meta
+	}
+	conn, daemon := net.Pipe()
+	_ = daemon.Close()
+	return conn, nil
+}
+
+func TestWithInlineCache(t *testing.T) {
+	args := map[string]*string{"VERSION": strPtr("1.0")}
+	got := withInlineCache(args)
+	want := map[string]string{"VERSION": "1.0", InlineCacheArg: "1"}
+	if !reflect.DeepEqual(derefArgs(got), want) {
+		t.Errorf("withInlineCache = %v, want %v", derefArgs(got), want)
+	}
+	if _, ok := args[InlineCacheArg]; ok {
+		t.Errorf("withInlineCache changed the build args given")
+	}
+}
+
+func TestBuildInlineCache(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	tests := []struct {
+		name     string
+		buildKit bool
+		wantErr  bool
+	}{
+		{name: "buildkit", buildKit: true},
+		{name: "classic builder", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildkitAPI{buildAPI: &buildAPI{}}
+			_, err := newTestClient(api).build(context.Background(), fsContextName, fsys, BuildOptions{
+				InlineCache: true,
+				BuildKit:    tt.buildKit,
+			}, io.Discard, nil)
+			if tt.wantErr {
+				if !errors.Is(err, ImageBuildErr) || api.builds != 0 {
+					t.Errorf("err = %v after %d builds, want %v before building", err, api.builds, ImageBuildErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			opts := api.lastBuild(t).Options
+			if v := opts.BuildArgs[InlineCacheArg]; v == nil || *v != "1" || opts.Version != types.BuilderBuildKit {
+				t.Errorf("build args = %v, version %q, want %s=1 with BuildKit", derefArgs(opts.BuildArgs), opts.Version, InlineCacheArg)
+			}
+		})
+	}
+}
+
+// chdir changes the working directory for the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+func TestBuildKitSessionKey(t *testing.T) {
+	dockerfile := map[string]string{dockerfileName: "FROM alpine:3.19\n", "sub/" + dockerfileName: "FROM alpine:3.19\n"}
+	dirA, dirB := t.TempDir(), t.TempDir()
+	writeTree(t, dirA, dockerfile)
+	writeTree(t, dirB, dockerfile)
+	tests := []struct {
+		name   string
+		dir    string
+		subdir string
+		want   string
+	}{
+		{name: "first folder", dir: dirA, want: dirA},
+		{name: "second folder", dir: dirB, want: dirB},
+		{name: "subdir", dir: dirA, subdir: "sub", want: filepath.Join(dirA, "sub")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, tt.dir)
+			api := &buildkitAPI{buildAPI: &buildAPI{}, dials: make(chan map[string][]string, 1)}
+			_, err := newTestClient(api).build(context.Background(), ".", nil, BuildOptions{BuildKit: true, ContextSubdir: tt.subdir}, io.Discard, nil)
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			sum := sha256.Sum256([]byte(tt.want))
+			if got := api.session["X-Docker-Expose-Session-Sharedkey"]; !equalStrings(got, []string{hex.EncodeToString(sum[:])}) {
+				t.Errorf("shared key = %v, want the one of %s", got, tt.want)
+			}
+		})
+	}
+}
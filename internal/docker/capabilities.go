@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/docker/docker/api/types/versions"
+)
+
+var (
+	FeatureUnsupportedErr = errors.New("feature not supported by the daemon")
+)
+
+// Feature is a daemon capability some options depend on
+type Feature string
+
+const (
+	// FeatureBuildPlatform is the platform of a build
+	FeatureBuildPlatform Feature = "build-platform"
+	// FeatureBuildKit is building with BuildKit (through a session)
+	FeatureBuildKit Feature = "buildkit"
+	// FeatureBuildOutputs are the BuildKit exporters of a build
+	FeatureBuildOutputs Feature = "build-outputs"
+	// FeaturePlatformOnCreate is the platform of a created container
+	FeaturePlatformOnCreate Feature = "platform-on-create"
+	// FeatureHostGateway is the host-gateway extra host value
+	FeatureHostGateway Feature = "host-gateway"
+)
+
+// featureMinAPI are the minimum daemon API versions of each feature
+var featureMinAPI = map[Feature]string{
+	FeatureBuildPlatform:    "1.38",
+	FeatureBuildKit:         "1.39",
+	FeatureBuildOutputs:     "1.40",
+	FeaturePlatformOnCreate: "1.41",
+	FeatureHostGateway:      "1.41",
+}
+
+// Capability tells whether the daemon supports a feature
+type Capability struct {
+	Feature   Feature
+	MinAPI    string
+	Supported bool
+}
+
+// capabilityCache holds the negotiated API version, shared by the
+// copies of a Client. Only a successful negotiation is kept, a failed
+// one is attempted again on the next call.
+type capabilityCache struct {
+	mu  sync.Mutex
+	api string
+}
+
+// apiVersion returns the API version negotiated with the daemon
+func (c Client) apiVersion(ctx context.Context) (string, error) {
+	if c.caps == nil {
+		return c.d.ClientVersion(), nil
+	}
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+	if c.caps.api != "" {
+		return c.caps.api, nil
+	}
+	ping, err := c.d.Ping(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", DaemonInfoErr, err)
+	}
+	c.d.NegotiateAPIVersionPing(ping)
+	c.caps.api = c.d.ClientVersion()
+	return c.caps.api, nil
+}
+
+// Supports tells if the daemon API is recent enough for the feature
+func (c Client) Supports(ctx context.Context, f Feature) bool {
+	return c.Require(ctx, f) == nil
+}
+
+// Require fails if the daemon API is older than the one the feature
+// needs (requires Docker API >= 1.41, daemon has 1.39)
+func (c Client) Require(ctx context.Context, f Feature) error {
+	min, ok := featureMinAPI[f]
+	if !ok {
+		return fmt.Errorf("%w: unknown feature %s", FeatureUnsupportedErr, f)
+	}
+	api, err := c.apiVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if versions.LessThan(api, min) {
+		return fmt.Errorf("%w: %s requires Docker API >= %s, daemon has %s", FeatureUnsupportedErr, f, min, api)
+	}
+	return nil
+}
+
+// degrade logs that an optional feature is skipped as the daemon
+// doesn't support it, returning false in that case
+func (c Client) degrade(ctx context.Context, f Feature) bool {
+	if err := c.Require(ctx, f); err != nil {
+		slog.With("feature", f, "error", err.Error()).Warn("FeatureUnsupported")
+		return false
+	}
+	return true
+}
+
+// Capabilities returns the support matrix of the daemon, sorted by
+// feature name
+func (c Client) Capabilities(ctx context.Context) ([]Capability, error) {
+	if _, err := c.apiVersion(ctx); err != nil {
+		return nil, err
+	}
+	res := make([]Capability, 0, len(featureMinAPI))
+	for f, min := range featureMinAPI {
+		res = append(res, Capability{Feature: f, MinAPI: min, Supported: c.Supports(ctx, f)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Feature < res[j].Feature
+	})
+	return res, nil
+}
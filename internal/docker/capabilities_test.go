@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// pingAPI fakes a daemon of the api version, failing the first pings
+// with the errs
+type pingAPI struct {
+	client.APIClient
+	api     string
+	errs    []error
+	pings   int
+	version string
+}
+
+func (f *pingAPI) Ping(ctx context.Context) (types.Ping, error) {
+	f.pings++
+	if err := ctx.Err(); err != nil {
+		return types.Ping{}, err
+	}
+	if f.pings <= len(f.errs) {
+		return types.Ping{}, f.errs[f.pings-1]
+	}
+	return types.Ping{APIVersion: f.api}, nil
+}
+
+func (f *pingAPI) NegotiateAPIVersionPing(p types.Ping) {
+	f.version = p.APIVersion
+}
+
+func (f *pingAPI) ClientVersion() string {
+	return f.version
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     string
+		feature Feature
+		wantErr error
+	}{
+		{name: "supported", api: "1.43", feature: FeatureHostGateway},
+		{name: "same version", api: "1.41", feature: FeatureHostGateway},
+		{name: "older daemon", api: "1.39", feature: FeatureHostGateway, wantErr: FeatureUnsupportedErr},
+		{name: "unknown feature", api: "1.43", feature: Feature("teleport"), wantErr: FeatureUnsupportedErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(&pingAPI{api: tt.api})
+			err := c.Require(context.Background(), tt.feature)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Require = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIVersionCached(t *testing.T) {
+	api := &pingAPI{api: "1.43"}
+	c := newTestClient(api)
+	// the copies of a client share the negotiated version
+	for _, cc := range []Client{c, c, c} {
+		if err := cc.Require(context.Background(), FeatureBuildKit); err != nil {
+			t.Fatalf("Require = %v, want nil", err)
+		}
+	}
+	if api.pings != 1 {
+		t.Errorf("pings = %d, want 1", api.pings)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	caps, err := newTestClient(&pingAPI{api: "1.40"}).Capabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Capabilities: %v", err)
+	}
+	want := []Capability{
+		{Feature: FeatureBuildOutputs, MinAPI: "1.40", Supported: true},
+		{Feature: FeatureBuildPlatform, MinAPI: "1.38", Supported: true},
+		{Feature: FeatureBuildKit, MinAPI: "1.39", Supported: true},
+		{Feature: FeatureHostGateway, MinAPI: "1.41"},
+		{Feature: FeaturePlatformOnCreate, MinAPI: "1.41"},
+	}
+	if len(caps) != len(want) {
+		t.Fatalf("Capabilities = %+v, want %+v", caps, want)
+	}
+	for i := range want {
+		if caps[i] != want[i] {
+			t.Errorf("capability %d = %+v, want %+v", i, caps[i], want[i])
+		}
+	}
+}
+
+func TestAPIVersionRetriesAfterFailure(t *testing.T) {
+	api := &pingAPI{api: "1.43", errs: []error{errors.New("connection refused")}}
+	c := newTestClient(api)
+	ctx := context.Background()
+
+	if err := c.Require(ctx, FeatureBuildKit); !errors.Is(err, DaemonInfoErr) {
+		t.Fatalf("Require = %v, want %v", err, DaemonInfoErr)
+	}
+	if err := c.Require(ctx, FeatureBuildKit); err != nil {
+		t.Fatalf("Require after a transient failure = %v, want nil", err)
+	}
+	if err := c.Require(ctx, FeatureBuildOutputs); err != nil {
+		t.Fatalf("Require = %v, want nil", err)
+	}
+	if api.pings != 2 {
+		t.Errorf("pings = %d, want 2 (the negotiated version is cached)", api.pings)
+	}
+}
+
+func TestAPIVersionRetriesAfterCancel(t *testing.T) {
+	api := &pingAPI{api: "1.43"}
+	c := newTestClient(api)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if c.Supports(ctx, FeatureBuildKit) {
+		t.Fatal("Supports with a cancelled context = true, want false")
+	}
+	if !c.Supports(context.Background(), FeatureBuildKit) {
+		t.Error("Supports after a cancelled context = false, want true")
+	}
+}
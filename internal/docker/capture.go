@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/go-units"
+)
+
+var (
+	OutputCaptureErr      = errors.New("failed to capture container output")
+	InvalidCaptureSizeErr = errors.New("invalid capture rotation size")
+)
+
+// CaptureOptions defines where the container output is saved
+type CaptureOptions struct {
+	// Dir is the folder of the <container>.stdout.log and
+	// <container>.stderr.log files (no capture if empty)
+	Dir string
+	// RotateSize is the file size, in bytes, after which it's rotated
+	// (no rotation if zero)
+	RotateSize int64
+	// Keep is the number of rotated files kept (<file>.1 is the newest)
+	Keep int
+}
+
+// ParseCaptureSize parses a rotation size as 50m, 1g or 512k
+func ParseCaptureSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(s)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: %q", InvalidCaptureSizeErr, s)
+	}
+	return size, nil
+}
+
+// rotatingFile is a log file rotated when it reaches a size. It's
+// safe to use from multiple goroutines.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	max  int64
+	keep int
+	f    *os.File
+	size int64
+}
+
+func newRotatingFile(path string, max int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, max: max, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	i, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	r.f = f
+	r.size = i.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p doesn't fit.
+// A single write bigger than the limit goes whole to a fresh file.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.max > 0 && r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	return n, nil
+}
+
+// rotate syncs and closes the current file, shifts the old ones
+// (<file>.1 to <file>.2 and so on, dropping the ones over keep) and
+// opens a new file
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+
+	if r.keep <= 0 {
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+		}
+		return r.open()
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		old := fmt.Sprintf("%s.%d", r.path, i)
+		if err := os.Rename(old, fmt.Sprintf("%s.%d", r.path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	return r.open()
+}
+
+// Close syncs and closes the file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.f.Sync(); err != nil {
+		_ = r.f.Close()
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	return nil
+}
+
+// outputCapture holds the stdout and stderr files of a container
+type outputCapture struct {
+	Stdout *rotatingFile
+	Stderr *rotatingFile
+}
+
+// newOutputCapture opens the capture files of the named container
+func newOutputCapture(name string, opts CaptureOptions) (*outputCapture, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%w: %w", OutputCaptureErr, err)
+	}
+	stdout, err := newRotatingFile(filepath.Join(opts.Dir, name+".stdout.log"), opts.RotateSize, opts.Keep)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := newRotatingFile(filepath.Join(opts.Dir, name+".stderr.log"), opts.RotateSize, opts.Keep)
+	if err != nil {
+		_ = stdout.Close()
+		return nil, err
+	}
+	return &outputCapture{Stdout: stdout, Stderr: stderr}, nil
+}
+
+// Close closes both files
+func (o *outputCapture) Close() error {
+	return errors.Join(o.Stdout.Close(), o.Stderr.Close())
+}
@@ -0,0 +1,235 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readCaptureFiles returns the content of the files in dir, by name
+func readCaptureFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]string{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[e.Name()] = string(b)
+	}
+	return res
+}
+
+func fileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseCaptureSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "512k", want: 512 << 10},
+		{in: "50m", want: 50 << 20},
+		{in: "1g", want: 1 << 30},
+		{in: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCaptureSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseCaptureSize(%q) = %d, %v, want %d (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if tt.wantErr && !errors.Is(err, InvalidCaptureSizeErr) {
+			t.Errorf("ParseCaptureSize(%q) err = %v, want %v", tt.in, err, InvalidCaptureSizeErr)
+		}
+	}
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.stdout.log")
+	r, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("newRotatingFile: %v", err)
+	}
+	for _, w := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		if _, err := r.Write([]byte(w)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// 7 writes of 5 bytes, 2 per file: the 2 oldest files are dropped
+	files := readCaptureFiles(t, dir)
+	want := map[string]string{
+		"app.stdout.log":   "gggg\n",
+		"app.stdout.log.1": "eeee\nffff\n",
+		"app.stdout.log.2": "cccc\ndddd\n",
+	}
+	if !equalStrings(fileNames(files), fileNames(want)) {
+		t.Fatalf("files = %v, want %v", fileNames(files), fileNames(want))
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("%s = %q, want %q", name, files[name], content)
+		}
+	}
+}
+
+func TestRotatingFileKeep(t *testing.T) {
+	tests := []struct {
+		name string
+		keep int
+		want []string
+	}{
+		{name: "no rotated files kept", keep: 0, want: []string{"app.log"}},
+		{name: "one kept", keep: 1, want: []string{"app.log", "app.log.1"}},
+		{name: "three kept", keep: 3, want: []string{"app.log", "app.log.1", "app.log.2", "app.log.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := newRotatingFile(filepath.Join(dir, "app.log"), 4, tt.keep)
+			if err != nil {
+				t.Fatalf("newRotatingFile: %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				if _, err := r.Write([]byte{'0' + byte(i), '\n', '.', '\n'}); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			files := readCaptureFiles(t, dir)
+			if got := fileNames(files); !equalStrings(got, tt.want) {
+				t.Fatalf("files = %v, want %v", got, tt.want)
+			}
+			// the newest write is in the current file, older ones in
+			// .1, .2 and so on
+			for i, name := range tt.want {
+				if want := string(rune('9'-i)) + "\n.\n"; files[name] != want {
+					t.Errorf("%s = %q, want %q", name, files[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileBigWrite(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRotatingFile(filepath.Join(dir, "app.log"), 4, 1)
+	if err != nil {
+		t.Fatalf("newRotatingFile: %v", err)
+	}
+	for _, w := range []string{"ab", "0123456789"} {
+		if _, err := r.Write([]byte(w)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	_ = r.Close()
+	files := readCaptureFiles(t, dir)
+	if files["app.log"] != "0123456789" || files["app.log.1"] != "ab" {
+		t.Errorf("files = %q, want the big write whole in a fresh file", files)
+	}
+}
+
+func TestRotatingFileConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRotatingFile(filepath.Join(dir, "app.log"), 1<<10, 100)
+	if err != nil {
+		t.Fatalf("newRotatingFile: %v", err)
+	}
+	const writers, lines = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			line := []byte(strings.Repeat(string(rune('a'+w)), 15) + "\n")
+			for i := 0; i < lines; i++ {
+				if _, err := r.Write(line); err != nil {
+					t.Errorf("Write: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	counts := map[string]int{}
+	var total int
+	for name, content := range readCaptureFiles(t, dir) {
+		if len(content) > 1<<10 {
+			t.Errorf("%s is %d bytes, over the rotation size", name, len(content))
+		}
+		for _, l := range strings.SplitAfter(content, "\n") {
+			if l == "" {
+				continue
+			}
+			if len(l) != 16 || strings.Count(l, l[:1]) != 15 {
+				t.Fatalf("%s has the interleaved line %q", name, l)
+			}
+			counts[l[:1]]++
+			total++
+		}
+	}
+	if total != writers*lines {
+		t.Errorf("lines = %d, want %d", total, writers*lines)
+	}
+	for w := 0; w < writers; w++ {
+		if c := counts[string(rune('a'+w))]; c != lines {
+			t.Errorf("writer %d lines = %d, want %d", w, c, lines)
+		}
+	}
+}
+
+func TestNewOutputCapture(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	capture, err := newOutputCapture("web", CaptureOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("newOutputCapture: %v", err)
+	}
+	_, _ = capture.Stdout.Write([]byte("listening on :8080\n"))
+	_, _ = capture.Stderr.Write([]byte("warning: debug mode\n"))
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	files := readCaptureFiles(t, dir)
+	if files["web.stdout.log"] != "listening on :8080\n" || files["web.stderr.log"] != "warning: debug mode\n" {
+		t.Errorf("files = %q, want one per stream", files)
+	}
+
+	// a new capture appends to the existing files
+	capture, err = newOutputCapture("web", CaptureOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("newOutputCapture: %v", err)
+	}
+	_, _ = capture.Stdout.Write([]byte("restarted\n"))
+	_ = capture.Close()
+	b, _ := os.ReadFile(filepath.Join(dir, "web.stdout.log"))
+	if !bytes.Equal(b, []byte("listening on :8080\nrestarted\n")) {
+		t.Errorf("web.stdout.log = %q, want both runs", b)
+	}
+}
@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	InvalidCIMetadataModeErr = errors.New("invalid CI metadata mode")
+)
+
+// CIMetadataMode tells if the CI metadata is added to builds
+type CIMetadataMode string
+
+const (
+	CIMetadataOff  CIMetadataMode = "off"
+	CIMetadataAuto CIMetadataMode = "auto"
+)
+
+const (
+	ciLabelPrefix = "docker-runner.ci."
+	// maxCILabelValue is the length CI values are truncated to
+	maxCILabelValue = 512
+)
+
+// ParseCIMetadataMode parses a CI metadata mode (off if empty)
+func ParseCIMetadataMode(s string) (CIMetadataMode, error) {
+	switch m := CIMetadataMode(s); m {
+	case "":
+		return CIMetadataOff, nil
+	case CIMetadataOff, CIMetadataAuto:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: %q (expected auto or off)", InvalidCIMetadataModeErr, s)
+}
+
+// ciValues are the values a CI provider exposes about the build
+type ciValues struct {
+	Commit      string
+	Branch      string
+	PipelineURL string
+	JobID       string
+	Actor       string
+}
+
+// ciProvider detects a CI environment and reads its values
+type ciProvider struct {
+	Name   string
+	Detect func(getenv func(string) string) bool
+	Values func(getenv func(string) string) ciValues
+}
+
+// ciProviders are the known CI environments, in detection order
+var ciProviders = []ciProvider{
+	{
+		Name: "github-actions",
+		Detect: func(getenv func(string) string) bool {
+			return getenv("GITHUB_ACTIONS") == "true"
+		},
+		Values: func(getenv func(string) string) ciValues {
+			v := ciValues{
+				Commit: getenv("GITHUB_SHA"),
+				Branch: getenv("GITHUB_HEAD_REF"),
+				JobID:  getenv("GITHUB_JOB"),
+				Actor:  getenv("GITHUB_ACTOR"),
+			}
+			// the head ref is only set for pull requests
+			if v.Branch == "" {
+				v.Branch = getenv("GITHUB_REF_NAME")
+			}
+			if server, repo, run := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"); server != "" && repo != "" && run != "" {
+				v.PipelineURL = server + "/" + repo + "/actions/runs/" + run
+			}
+			return v
+		},
+	},
+	{
+		Name: "gitlab-ci",
+		Detect: func(getenv func(string) string) bool {
+			return getenv("GITLAB_CI") == "true"
+		},
+		Values: func(getenv func(string) string) ciValues {
+			return ciValues{
+				Commit:      getenv("CI_COMMIT_SHA"),
+				Branch:      getenv("CI_COMMIT_REF_NAME"),
+				PipelineURL: getenv("CI_PIPELINE_URL"),
+				JobID:       getenv("CI_JOB_ID"),
+				Actor:       getenv("GITLAB_USER_LOGIN"),
+			}
+		},
+	},
+	{
+		Name: "jenkins",
+		Detect: func(getenv func(string) string) bool {
+			return getenv("JENKINS_URL") != ""
+		},
+		Values: func(getenv func(string) string) ciValues {
+			v := ciValues{
+				Commit:      getenv("GIT_COMMIT"),
+				Branch:      getenv("BRANCH_NAME"),
+				PipelineURL: getenv("BUILD_URL"),
+				JobID:       getenv("BUILD_TAG"),
+				// set by the build user vars plugin only
+				Actor: getenv("BUILD_USER_ID"),
+			}
+			if v.Branch == "" {
+				v.Branch = strings.TrimPrefix(getenv("GIT_BRANCH"), "origin/")
+			}
+			return v
+		},
+	},
+}
+
+// CIMetadata is the CI metadata added to a build
+type CIMetadata struct {
+	// Provider is the detected CI environment
+	Provider string `json:"provider"`
+	// Labels are the image labels added
+	Labels map[string]string `json:"labels,omitempty"`
+	// BuildArgs are the build args set, the ones the Dockerfile
+	// declares without being given
+	BuildArgs map[string]string `json:"build_args,omitempty"`
+	// args are the build args available
+	args map[string]string
+}
+
+// detectCI returns the metadata of the first CI environment detected
+// (none if nil), sanitized, before being applied
+func detectCI(getenv func(string) string) *CIMetadata {
+	for _, p := range ciProviders {
+		if !p.Detect(getenv) {
+			continue
+		}
+		v := p.Values(getenv)
+		m := &CIMetadata{Provider: p.Name, Labels: map[string]string{}, args: map[string]string{}}
+		for _, f := range []struct {
+			label, arg, value string
+		}{
+			{"provider", "CI_PROVIDER", p.Name},
+			{"commit", "CI_COMMIT_SHA", v.Commit},
+			{"branch", "CI_COMMIT_BRANCH", v.Branch},
+			{"pipeline-url", "CI_PIPELINE_URL", v.PipelineURL},
+			{"job-id", "CI_JOB_ID", v.JobID},
+			{"actor", "CI_ACTOR", v.Actor},
+		} {
+			if value := sanitizeLabelValue(f.value); value != "" {
+				m.Labels[ciLabelPrefix+f.label] = value
+				m.args[f.arg] = value
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// sanitizeLabelValue drops the control characters and surrounding
+// spaces of a value, truncated to maxCILabelValue bytes
+func sanitizeLabelValue(s string) string {
+	s = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s))
+	if len(s) <= maxCILabelValue {
+		return s
+	}
+	s = s[:maxCILabelValue]
+	// don't cut a multi-byte rune
+	return strings.ToValidUTF8(s, "")
+}
+
+// applyLabels adds the CI labels not given explicitly, keeping only the
+// added ones in the metadata
+func (m *CIMetadata) applyLabels(labels map[string]string) map[string]string {
+	res := make(map[string]string, len(labels)+len(m.Labels))
+	for k, v := range m.Labels {
+		if _, ok := labels[k]; ok {
+			delete(m.Labels, k)
+			continue
+		}
+		res[k] = v
+	}
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+// applyBuildArgs sets the CI build args the Dockerfile declares (in
+// defaults) and not given explicitly, recording them in the metadata.
+// Undeclared ones are left out so the builder doesn't warn about
+// unconsumed args.
+func (m *CIMetadata) applyBuildArgs(args map[string]*string, defaults map[string]*string) map[string]*string {
+	res := make(map[string]*string, len(args)+len(m.args))
+	for k, v := range args {
+		res[k] = v
+	}
+	m.BuildArgs = map[string]string{}
+	for k, v := range m.args {
+		_, declared := defaults[k]
+		if _, given := args[k]; given || !declared {
+			continue
+		}
+		value := v
+		res[k] = &value
+		m.BuildArgs[k] = v
+	}
+	return res
+}
+
+// String tells the provider and the added labels and build args
+func (m *CIMetadata) String() string {
+	keys := func(values map[string]string) string {
+		res := make([]string, 0, len(values))
+		for k := range values {
+			res = append(res, k)
+		}
+		sort.Strings(res)
+		if len(res) == 0 {
+			return "none"
+		}
+		return strings.Join(res, ", ")
+	}
+	return fmt.Sprintf("%s (labels: %s; build args: %s)", m.Provider, keys(m.Labels), keys(m.BuildArgs))
+}
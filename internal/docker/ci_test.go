@@ -0,0 +1,269 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// envOf returns a getenv reading the values
+func envOf(values map[string]string) func(string) string {
+	return func(k string) string {
+		return values[k]
+	}
+}
+
+func TestParseCIMetadataMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CIMetadataMode
+		wantErr bool
+	}{
+		{in: "", want: CIMetadataOff},
+		{in: "off", want: CIMetadataOff},
+		{in: "auto", want: CIMetadataAuto},
+		{in: "on", wantErr: true},
+		{in: "AUTO", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCIMetadataMode(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidCIMetadataModeErr) {
+					t.Errorf("err = %v, want %v", err, InvalidCIMetadataModeErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseCIMetadataMode = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectCI(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{name: "none", env: map[string]string{"CI": "true", "GITHUB_ACTIONS": "false"}},
+		{
+			name: "github actions",
+			env: map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_SHA":        "abc123",
+				"GITHUB_REF_NAME":   "main",
+				"GITHUB_JOB":        "build",
+				"GITHUB_ACTOR":      "octocat",
+				"GITHUB_SERVER_URL": "https://github.com",
+				"GITHUB_REPOSITORY": "org/repo",
+				"GITHUB_RUN_ID":     "42",
+			},
+			want: map[string]string{
+				"provider":     "github-actions",
+				"commit":       "abc123",
+				"branch":       "main",
+				"pipeline-url": "https://github.com/org/repo/actions/runs/42",
+				"job-id":       "build",
+				"actor":        "octocat",
+			},
+		},
+		{
+			name: "github pull request",
+			env: map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_HEAD_REF":   "feature",
+				"GITHUB_REF_NAME":   "7/merge",
+				"GITHUB_REPOSITORY": "org/repo",
+			},
+			want: map[string]string{"provider": "github-actions", "branch": "feature"},
+		},
+		{
+			name: "gitlab ci",
+			env: map[string]string{
+				"GITLAB_CI":          "true",
+				"CI_COMMIT_SHA":      "def456",
+				"CI_COMMIT_REF_NAME": "develop",
+				"CI_PIPELINE_URL":    "https://gitlab.com/org/repo/-/pipelines/7",
+				"CI_JOB_ID":          "99",
+				"GITLAB_USER_LOGIN":  "dev",
+			},
+			want: map[string]string{
+				"provider":     "gitlab-ci",
+				"commit":       "def456",
+				"branch":       "develop",
+				"pipeline-url": "https://gitlab.com/org/repo/-/pipelines/7",
+				"job-id":       "99",
+				"actor":        "dev",
+			},
+		},
+		{
+			name: "jenkins",
+			env: map[string]string{
+				"JENKINS_URL": "https://jenkins.local/",
+				"GIT_COMMIT":  "0123abc",
+				"GIT_BRANCH":  "origin/release",
+				"BUILD_URL":   "https://jenkins.local/job/app/3/",
+				"BUILD_TAG":   "jenkins-app-3",
+			},
+			want: map[string]string{
+				"provider":     "jenkins",
+				"commit":       "0123abc",
+				"branch":       "release",
+				"pipeline-url": "https://jenkins.local/job/app/3/",
+				"job-id":       "jenkins-app-3",
+			},
+		},
+		{
+			name: "first detected wins",
+			env:  map[string]string{"GITHUB_ACTIONS": "true", "GITLAB_CI": "true", "CI_COMMIT_SHA": "def456"},
+			want: map[string]string{"provider": "github-actions"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := detectCI(envOf(tt.env))
+			if tt.want == nil {
+				if m != nil {
+					t.Errorf("detectCI = %v, want none", m)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatal("detectCI = nil, want a provider")
+			}
+			want := map[string]string{}
+			for k, v := range tt.want {
+				want[ciLabelPrefix+k] = v
+			}
+			if !reflect.DeepEqual(m.Labels, want) {
+				t.Errorf("Labels = %v, want %v", m.Labels, want)
+			}
+			if m.Provider != tt.want["provider"] || m.args["CI_PROVIDER"] != m.Provider {
+				t.Errorf("Provider = %q, CI_PROVIDER = %q, want %q", m.Provider, m.args["CI_PROVIDER"], tt.want["provider"])
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "main", want: "main"},
+		{name: "spaces", in: "  main \n", want: "main"},
+		{name: "control characters", in: "ma\x1b[31min\x00", want: "ma[31min"},
+		{name: "truncated", in: strings.Repeat("a", maxCILabelValue+10), want: strings.Repeat("a", maxCILabelValue)},
+		{name: "multi-byte rune cut", in: strings.Repeat("a", maxCILabelValue-1) + "é", want: strings.Repeat("a", maxCILabelValue-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeLabelValue(tt.in); got != tt.want {
+				t.Errorf("sanitizeLabelValue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCIMetadataApply(t *testing.T) {
+	m := detectCI(envOf(map[string]string{"GITLAB_CI": "true", "CI_COMMIT_SHA": "def456", "CI_JOB_ID": "99"}))
+	labels := m.applyLabels(map[string]string{ciLabelPrefix + "commit": "given", "team": "core"})
+	wantLabels := map[string]string{
+		ciLabelPrefix + "provider": "gitlab-ci",
+		ciLabelPrefix + "commit":   "given",
+		ciLabelPrefix + "job-id":   "99",
+		"team":                     "core",
+	}
+	if !reflect.DeepEqual(labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", labels, wantLabels)
+	}
+	if _, ok := m.Labels[ciLabelPrefix+"commit"]; ok {
+		t.Errorf("metadata labels = %v, want the given commit left out", m.Labels)
+	}
+
+	args := m.applyBuildArgs(
+		map[string]*string{"CI_JOB_ID": strPtr("given"), "VERSION": strPtr("1.0")},
+		map[string]*string{"CI_COMMIT_SHA": nil, "CI_JOB_ID": nil, "VERSION": nil},
+	)
+	wantArgs := map[string]string{"CI_COMMIT_SHA": "def456", "CI_JOB_ID": "given", "VERSION": "1.0"}
+	if got := derefArgs(args); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("build args = %v, want %v", got, wantArgs)
+	}
+	if want := map[string]string{"CI_COMMIT_SHA": "def456"}; !reflect.DeepEqual(m.BuildArgs, want) {
+		t.Errorf("metadata build args = %v, want %v", m.BuildArgs, want)
+	}
+	want := "gitlab-ci (labels: docker-runner.ci.job-id, docker-runner.ci.provider; build args: CI_COMMIT_SHA)"
+	if got := m.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestBuildCIMetadata(t *testing.T) {
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_SHA", "abc123")
+	t.Setenv("GITHUB_HEAD_REF", "")
+	t.Setenv("GITHUB_REF_NAME", "main")
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nARG CI_COMMIT_SHA\nARG CI_COMMIT_BRANCH\n")}}
+	tests := []struct {
+		name       string
+		mode       CIMetadataMode
+		wantLabels map[string]string
+		wantArgs   map[string]string
+	}{
+		{name: "off", mode: CIMetadataOff, wantLabels: map[string]string{}, wantArgs: map[string]string{}},
+		{
+			name: "auto",
+			mode: CIMetadataAuto,
+			wantLabels: map[string]string{
+				ciLabelPrefix + "provider": "github-actions",
+				ciLabelPrefix + "commit":   "abc123",
+				ciLabelPrefix + "branch":   "main",
+			},
+			wantArgs: map[string]string{"CI_COMMIT_SHA": "abc123", "CI_COMMIT_BRANCH": "main"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			res, err := newTestClient(api).BuildFS(context.Background(), fsys, BuildOptions{CIMetadata: tt.mode})
+			if err != nil {
+				t.Fatalf("BuildFS: %v", err)
+			}
+			opts := api.lastBuild(t).Options
+			for k, v := range tt.wantLabels {
+				if opts.Labels[k] != v {
+					t.Errorf("label %s = %q, want %q", k, opts.Labels[k], v)
+				}
+			}
+			for k := range opts.Labels {
+				if _, ok := tt.wantLabels[k]; !ok && strings.HasPrefix(k, ciLabelPrefix) {
+					t.Errorf("unexpected label %s", k)
+				}
+			}
+			for k, v := range tt.wantArgs {
+				if got := opts.BuildArgs[k]; got == nil || *got != v {
+					t.Errorf("build arg %s = %v, want %q", k, got, v)
+				}
+			}
+			if _, ok := opts.BuildArgs["CI_PROVIDER"]; ok {
+				t.Errorf("undeclared CI_PROVIDER build arg was set")
+			}
+			if (res.CIMetadata != nil) != (tt.mode == CIMetadataAuto) {
+				t.Errorf("CIMetadata = %v with mode %s", res.CIMetadata, tt.mode)
+			}
+		})
+	}
+}
+
+func TestBuildInvalidCIMetadataMode(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	if _, err := newTestClient(&buildAPI{}).BuildFS(context.Background(), fsys, BuildOptions{CIMetadata: "always"}); !errors.Is(err, InvalidCIMetadataModeErr) {
+		t.Errorf("err = %v, want %v", err, InvalidCIMetadataModeErr)
+	}
+}
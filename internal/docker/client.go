@@ -1,18 +1,23 @@
 package docker
 
 import (
-	"archive/tar"
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
 )
 
 var (
@@ -24,13 +29,109 @@ var (
 	ContextFilesReadErr   = errors.New("failed to add file to build request")
 )
 
+// buildTag is the tag of the built images
+const buildTag = "eldius/test-image"
+
+const (
+	// inlineTagRepo is the repository of the inline Dockerfile builds
+	inlineTagRepo = "docker-runner-inline"
+	// ephemeralTagRepo is the repository of the ephemeral builds
+	ephemeralTagRepo = "docker-runner-ephemeral"
+)
+
 type Client struct {
-	d *client.Client
+	d client.APIClient
+	// uploads and downloads bound the pushes and pulls running at the
+	// same time (nil means no limit)
+	uploads   chan struct{}
+	downloads chan struct{}
+	caps      *capabilityCache
+	// mirrors are the repository prefixes the images of each registry
+	// are pulled from
+	mirrors map[string]string
+	// host is the daemon address (DOCKER_HOST or the Docker context if
+	// empty) and readOnly guards the API client, both applied by
+	// NewClient
+	host     string
+	readOnly bool
+	// config is the client configuration NewClient resolved
+	config ClientConfig
+	// userAgent is the User-Agent of the daemon requests
+	// (DefaultUserAgent if empty)
+	userAgent string
+}
+
+// ClientOption customizes the Client built by NewClient
+type ClientOption func(*Client)
+
+// WithMaxConcurrentUploads limits the number of pushes the client runs
+// at the same time. The per layer concurrency of each push is set by
+// the daemon (max-concurrent-uploads in its config).
+func WithMaxConcurrentUploads(n int) ClientOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.uploads = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithMaxConcurrentDownloads limits the number of pulls the client runs
+// at the same time. The per layer concurrency of each pull is set by
+// the daemon (max-concurrent-downloads in its config).
+func WithMaxConcurrentDownloads(n int) ClientOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.downloads = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithHost targets the daemon at host (unix:///var/run/docker.sock,
+// tcp://host:2376...) instead of the DOCKER_HOST one, for this client
+// only
+func WithHost(host string) ClientOption {
+	return func(c *Client) {
+		c.host = host
+	}
+}
+
+// WithUserAgent sets the User-Agent of the daemon requests, which the
+// daemon passes on to the registries on pulls and pushes
+func WithUserAgent(ua string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// DefaultUserAgent is the runner name and module version
+// (docker-runner/v1.2.3, or docker-runner/dev for local builds)
+func DefaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "docker-runner/" + version
 }
 
 // NewClient builds the Docker Client
-func NewClient() (*Client, error) {
-	apiClient, err := client.NewClientWithOpts(client.WithHostFromEnv(), client.WithAPIVersionNegotiation())
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		caps: &capabilityCache{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	cfg, endpointOpts, err := resolveClientConfig(c.host, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ClientBuildErr, err)
+	}
+	c.config = cfg
+	ua := c.userAgent
+	if ua == "" {
+		ua = DefaultUserAgent()
+	}
+	apiClient, err := client.NewClientWithOpts(append(endpointOpts, client.WithUserAgent(ua), client.WithAPIVersionNegotiation())...)
 	if err != nil {
 		err := fmt.Errorf("%w: %w", ClientBuildErr, err)
 		return nil, err
@@ -38,152 +139,767 @@ func NewClient() (*Client, error) {
 
 	fmt.Println("Client API version:", apiClient.ClientVersion())
 
-	return &Client{
-		d: apiClient,
-	}, nil
+	c.d = apiClient
+	if c.readOnly {
+		c.d = readOnlyClient{APIClient: c.d}
+	}
+	return c, nil
 }
 
-func (c Client) Build(ctx context.Context, src string) error {
-	fmt.Sprintln("Building image...")
-
-	dockerFileReader, err := buildRequestReaderWithAllFiles(src)
-	if err != nil {
-		err = fmt.Errorf("%w: %w", ImageBuildErr, err)
-		return err
+// acquire takes a slot of the semaphore, returning the function that
+// releases it
+func acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
 	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	//dockerFileReader, err := buildRequestReaderWithDockerfile(src)
-	//if err != nil {
-	//	err = fmt.Errorf("%w: %w", ImageBuildErr, err)
-	//	return err
-	//}
+// BuildOptions holds the optional settings for a build
+type BuildOptions struct {
+	// ContextSubdir is a folder, relative to the source folder, used
+	// as the build context root
+	ContextSubdir string
+	// IgnorePatterns are .dockerignore patterns applied after the ones
+	// of the .dockerignore file of the context root (if any)
+	IgnorePatterns []string
+	// ExplainIgnore logs the .dockerignore decision about each entry
+	// of the context
+	ExplainIgnore bool
+	// Output is how the build stream is printed (full by default)
+	Output BuildOutputMode
+	// OutputContextLines is the number of lines of the step before a
+	// failing one printed in the non full modes
+	// (DefaultOutputContextLines if 0)
+	OutputContextLines int
+	// Tail is the number of last stream lines printed again when the
+	// build fails (DefaultBuildTail if 0, none if negative)
+	Tail int
+	// WarningPatterns are the regular expressions of the lines printed
+	// in the errors+warnings mode (DefaultWarningPatterns if empty)
+	WarningPatterns []string
+	// FailOnWarn makes the build fail if it produced any warning
+	FailOnWarn bool
+	// BuildKit builds the image with BuildKit instead of the classic
+	// builder
+	BuildKit bool
+	// Outputs are the BuildKit exporters (see ParseOutput)
+	Outputs []types.ImageBuildOutput
+	// InlineCache embeds the BuildKit cache metadata in the built image
+	// (BUILDKIT_INLINE_CACHE build arg), requires BuildKit
+	InlineCache bool
+	// GitSSH holds the SSH settings for git contexts cloned locally
+	GitSSH GitSSHOptions
+	// Platform is the os/arch[/variant] to build for
+	Platform string
+	// Emulation defines how cross-platform support is checked
+	Emulation EmulationOptions
+	// BuildArgs are the Dockerfile ARG values (nil to leave one unset)
+	BuildArgs map[string]*string
+	// PrintOptions prints the effective build settings, including the
+	// Dockerfile ARG defaults, before building
+	PrintOptions bool
+	// PrintResolvedDockerfile prints the Dockerfile with the ARG and ENV
+	// references known before building replaced, before building
+	PrintResolvedDockerfile bool
+	// RequireHealthcheck fails the build if the image defines no
+	// HEALTHCHECK
+	RequireHealthcheck bool
+	// StrictSecrets fails the build if the image history or env embeds
+	// a sensitive build arg or a known token format, instead of
+	// warning about it
+	StrictSecrets bool
+	// Override replaces the built image entrypoint and/or command,
+	// committing an extra (empty) layer
+	Override CommandOverride
+	// Dockerfile is an inline Dockerfile, built without any context
+	// (the src folder is ignored)
+	Dockerfile []byte
+	// Tag is the reference the image is tagged as, a bare tag naming
+	// the default repository (eldius/test-image, with the target stage
+	// as tag, if empty)
+	Tag string
+	// AlsoTags are more tags applied to the built image, without
+	// rebuilding it, a bare tag taking the repository of Tag
+	AlsoTags []string
+	// Labels are set on the built image
+	Labels map[string]string
+	// CIMetadata adds the CI environment metadata, when one is detected,
+	// as labels and declared build args not given explicitly
+	// (CIMetadataOff if empty)
+	CIMetadata CIMetadataMode
+	// PullBaseImages pulls the Dockerfile base images before building,
+	// even if they're local, and skips the build result cache
+	PullBaseImages bool
+	// QuietPull hides the PullBaseImages pull progress, printing one
+	// line per base image instead
+	QuietPull bool
+	// NormalizeDockerfile strips the UTF-8 BOM of the Dockerfile and
+	// converts its CRLF line endings before building, instead of only
+	// warning about them
+	NormalizeDockerfile bool
+	// Compress gzips the context sent to the daemon
+	Compress bool
+	// CompressionLevel is the gzip level, from 1 (fastest) to 9
+	// (smallest), balanced if zero
+	CompressionLevel int
+	// RecordStream is the file the raw daemon build stream is written
+	// to, for ReplayBuildStream (none if empty)
+	RecordStream string
+	// CleanFailedBuilds removes, when a classic builder build fails, the
+	// step containers it left and the untagged step images it created
+	CleanFailedBuilds bool
+	// KeepImages keeps the step containers and images of a failed
+	// build for inspection, overriding CleanFailedBuilds
+	KeepImages bool
+	// CPUShares is the relative CPU weight of the build containers
+	// (the daemon default, 1024, if zero)
+	CPUShares int64
+	// MaxContextFiles aborts the build if the context holds more files
+	// (no limit if zero)
+	MaxContextFiles int
+	// ResultCacheDir is the folder of the build result cache, mapping
+	// the context digest and build settings to the built image, so a
+	// repeated build reuses it without building (disabled if empty)
+	ResultCacheDir string
+	// Target is the Dockerfile stage built (the last one if empty),
+	// tagged <tag>:<target>
+	Target string
+	// AlsoFinal builds the last stage too after the Target one, reusing
+	// its cache, so both images are tagged
+	AlsoFinal bool
+	// Ephemeral tags the image with a random docker-runner-ephemeral
+	// tag, removed once the build and its PostBuildHook are done, even
+	// on failure (the result cache isn't used)
+	Ephemeral bool
+	// keepEphemeral leaves the Ephemeral image removal to the caller
+	keepEphemeral bool
+	// HistoryDir is the folder of the build history, recording the
+	// metadata and output of each build (disabled if empty)
+	HistoryDir string
+	// HistoryKeep is the number of builds kept in the history
+	// (DefaultHistoryKeep if 0)
+	HistoryKeep int
+	// PostBuildHook is a command line run through /bin/sh -c after a
+	// successful build, with the image ID and tags in the DR_IMAGE_ID
+	// and DR_IMAGE_TAGS env vars. The build fails if it fails.
+	PostBuildHook string
+	// Heartbeat is the silence interval after which a "still
+	// building..." line is printed (disabled if zero)
+	Heartbeat time.Duration
+}
 
-	response, err := c.d.ImageBuild(
-		ctx,
-		dockerFileReader,
-		types.ImageBuildOptions{
-			Tags: []string{"eldius/test-image"},
-		},
-	)
+// BuildResult holds what a build produced and the settings it used
+type BuildResult struct {
+	// Tag is the tag of the built image (generated for inline
+	// Dockerfiles)
+	Tag string
+	// AlsoTags are the extra tags of the built image
+	AlsoTags []string
+	// ImageID is the built image ID (empty if the daemon didn't report
+	// it, e.g. for images exported only to a registry)
+	ImageID string
+	// BuildArgs are the effective ARG values: the given build args
+	// and, for the rest, the Dockerfile defaults
+	BuildArgs map[string]string
+	// Steps are the executed Dockerfile steps, with their durations
+	Steps []BuildStep
+	// TargetTag and TargetImageID are the Target stage image of an
+	// AlsoFinal build
+	TargetTag     string
+	TargetImageID string
+	// CIMetadata is the CI metadata added (nil if none)
+	CIMetadata *CIMetadata
+}
+
+// Build builds the image from the src folder, or from a git
+// repository URL (git@host:org/repo.git#ref:subdir)
+func (c Client) Build(ctx context.Context, src string, opts BuildOptions) (BuildResult, error) {
+	fmt.Println("Building image...")
+	return c.build(ctx, src, nil, opts, os.Stdout, nil)
+}
+
+// BuildWithHandler builds the image as Build does, delivering each
+// parsed build stream message to handler instead of printing it
+func (c Client) BuildWithHandler(ctx context.Context, src string, opts BuildOptions, handler func(BuildEvent)) (BuildResult, error) {
+	return c.build(ctx, src, nil, opts, io.Discard, handler)
+}
+
+// BuildFS builds the image as Build does, from the content of fsys
+// (e.g. an embed.FS) instead of a folder. Symlinks are only supported
+// by the file systems implementing ReadLink(name) (string, error).
+func (c Client) BuildFS(ctx context.Context, fsys fs.FS, opts BuildOptions) (BuildResult, error) {
+	fmt.Println("Building image...")
+	return c.build(ctx, fsContextName, fsys, opts, os.Stdout, nil)
+}
+
+// BuildWithContextAndDockerfile builds the image as BuildFS does, with
+// the Dockerfile read from dockerfile in place of the contextFS one
+// (the ContextSubdir of contextFS being the context root). Without
+// contextFS, the Dockerfile is built without context, as with
+// BuildOptions.Dockerfile.
+func (c Client) BuildWithContextAndDockerfile(ctx context.Context, contextFS fs.FS, dockerfile io.Reader, opts BuildOptions) (BuildResult, error) {
+	content, err := io.ReadAll(dockerfile)
 	if err != nil {
-		err = fmt.Errorf("%w: %w", BuildDockerAPIErr, err)
-		return err
+		return BuildResult{}, fmt.Errorf("%w: %w: %w", ImageBuildErr, DockerfileNotFoundErr, err)
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
-
-	scanner := bufio.NewScanner(response.Body)
-	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+	fmt.Println("Building image...")
+	if contextFS == nil {
+		opts.Dockerfile = content
+		return c.build(ctx, fsContextName, nil, opts, os.Stdout, nil)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return err
+	if opts.ContextSubdir != "" {
+		sub, err := fs.Sub(contextFS, opts.ContextSubdir)
+		if err != nil {
+			return BuildResult{}, fmt.Errorf("%w: %w: %w", ImageBuildErr, ContextDirReadErr, err)
+		}
+		contextFS = sub
+		opts.ContextSubdir = ""
 	}
-	return nil
+	opts.Dockerfile = nil
+	fsys := dockerfileFS{FS: contextFS, content: content, modTime: time.Now()}
+	return c.build(ctx, fsContextName, fsys, opts, os.Stdout, nil)
 }
 
-func buildRequestReaderWithAllFiles(src string) (io.Reader, error) {
-	srcAbs, err := filepath.Abs(src)
+// fsContextName stands for the source of the builds from a fs.FS
+const fsContextName = "fs.FS"
+
+// build runs the build of the src folder, or of fsys if not nil,
+// printing its output to out and passing the stream events to handler
+// (if not nil)
+func (c Client) build(ctx context.Context, src string, fsys fs.FS, opts BuildOptions, out io.Writer, handler func(BuildEvent)) (res BuildResult, err error) {
+	if opts.AlsoFinal && opts.Target != "" {
+		return c.buildAlsoFinal(ctx, src, fsys, opts, out, handler)
+	}
+	tag, alsoTags, err := buildTags(opts)
 	if err != nil {
-		err = fmt.Errorf("%w: %w", DockerfileNotFoundErr, err)
-		return nil, err
+		return res, err
+	}
+	res = BuildResult{Tag: tag}
+	if opts.Ephemeral && (opts.Tag != "" || len(alsoTags) > 0) {
+		return res, fmt.Errorf("%w: ephemeral builds can't be tagged", InvalidTagErr)
+	}
+	if opts.Ephemeral {
+		res.Tag = randomTag(ephemeralTagRepo)
+		if !opts.keepEphemeral {
+			// res.Tag is read on return, inline builds changing it
+			defer func() {
+				c.removeEphemeral(out, res.Tag)
+			}()
+		}
+	}
+	if opts.InlineCache {
+		opts.BuildArgs = withInlineCache(opts.BuildArgs)
+	}
+	var rec *buildRecorder
+	if opts.HistoryDir != "" {
+		_, isRemote := parseRemoteContext(src)
+		rec = newBuildRecorder(opts.HistoryDir, opts.HistoryKeep, src, fsys == nil && !isRemote, opts)
+		out = io.MultiWriter(out, &rec.log)
+		defer func() {
+			rec.Save(res, err)
+		}()
+	}
+	if opts.Heartbeat > 0 {
+		out = &syncWriter{w: out}
 	}
 
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
-	defer func() {
-		_ = tw.Close()
-	}()
+	inline := len(opts.Dockerfile) > 0
+	var remote string
+	if rc, ok := parseRemoteContext(src); ok && !inline && fsys == nil {
+		if !rc.Local() {
+			remote = rc.String()
+		} else {
+			dir, err := cloneRemoteContext(ctx, rc, opts.GitSSH)
+			if err != nil {
+				return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+			}
+			defer func() {
+				_ = os.RemoveAll(dir)
+			}()
+			src = dir
+			opts.ContextSubdir = filepath.Join(rc.Subdir, opts.ContextSubdir)
+		}
+	}
 
-	dir, err := os.ReadDir(srcAbs)
+	warnings, err := warningPatterns(opts)
 	if err != nil {
-		err = fmt.Errorf("%w: %w", ContextDirReadErr, err)
-		return nil, err
+		return res, err
+	}
+	printer, err := newBuildPrinter(out, opts, warnings)
+	if err != nil {
+		return res, err
+	}
+	collector := newWarningCollector(warnings)
+	if err := opts.Override.validate(); err != nil {
+		return res, err
+	}
+	if opts.CPUShares < 0 {
+		return res, fmt.Errorf("%w: cpu shares %d (expected a positive weight, e.g. 512)", InvalidResourceLimitErr, opts.CPUShares)
+	}
+	ciMode, err := ParseCIMetadataMode(string(opts.CIMetadata))
+	if err != nil {
+		return res, err
+	}
+	var ci *CIMetadata
+	if ciMode == CIMetadataAuto {
+		ci = detectCI(os.Getenv)
+	}
+	if ci != nil {
+		opts.Labels = ci.applyLabels(opts.Labels)
+		res.CIMetadata = ci
 	}
 
-	for _, d := range dir {
-		if !d.IsDir() {
-			b, err := readFile(src, d.Name())
+	if opts.Platform != "" {
+		if _, err := parsePlatform(opts.Platform); err != nil {
+			return res, err
+		}
+		if err := c.Require(ctx, FeatureBuildPlatform); err != nil {
+			return res, err
+		}
+		if err := c.ensureEmulation(ctx, opts.Platform, opts.Emulation); err != nil {
+			return res, err
+		}
+	}
+
+	defaults := map[string]*string{}
+	var cache *resultCache
+	var cacheKey string
+	var dockerFileReader io.Reader
+	// sessionKey tells the BuildKit sessions of the same context apart,
+	// the absolute root for folders (src can be relative, like ".")
+	sessionKey := src
+	switch {
+	case inline:
+		opts.Dockerfile = checkDockerfileEncoding(out, opts.Dockerfile, opts.NormalizeDockerfile)
+		df, err := parseDockerfile(bytes.NewReader(opts.Dockerfile))
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if err := df.checkTarget(opts.Target); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if sources := df.ContextSources(); len(sources) > 0 {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, noContextError(sources))
+		}
+		if opts.PullBaseImages {
+			if err := c.pullBaseImages(ctx, out, df, opts.Platform, opts.QuietPull); err != nil {
+				return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+			}
+		}
+		defaults = df.ArgDefaults()
+		if ci != nil {
+			opts.BuildArgs = ci.applyBuildArgs(opts.BuildArgs, defaults)
+		}
+		if rec != nil {
+			rec.Dockerfile(opts.Dockerfile)
+		}
+		dockerFileReader, err = buildRequestReaderWithDockerfile(opts.Dockerfile)
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if !opts.Ephemeral && opts.Tag == "" {
+			res.Tag = randomTag(inlineTagRepo)
+		}
+	case remote == "":
+		if fsys == nil {
+			root, err := contextRoot(src, opts.ContextSubdir)
 			if err != nil {
-				err = fmt.Errorf("%w (opening %s):%w", ContextFilesReadErr, d.Name(), err)
-				return nil, err
+				return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
 			}
-
-			i, _ := d.Info()
-			tarHeader := &tar.Header{
-				Name: d.Name(),
-				Size: int64(len(b)),
-				Mode: int64(i.Mode()),
+			fsys = contextFS(root)
+			sessionKey = root
+		} else if opts.ContextSubdir != "" {
+			sub, err := fs.Sub(fsys, opts.ContextSubdir)
+			if err != nil {
+				return res, fmt.Errorf("%w: %w: %w", ImageBuildErr, ContextDirReadErr, err)
+			}
+			fsys = sub
+		}
+		if content, err := fs.ReadFile(fsys, dockerfileName); err == nil {
+			if normalized := checkDockerfileEncoding(out, content, opts.NormalizeDockerfile); !bytes.Equal(normalized, content) {
+				modTime := time.Now()
+				if info, err := fs.Stat(fsys, dockerfileName); err == nil {
+					modTime = info.ModTime()
+				}
+				fsys = dockerfileFS{FS: fsys, content: normalized, modTime: modTime}
 			}
-			err = tw.WriteHeader(tarHeader)
+		}
+		df, err := readDockerfile(fsys)
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if err := df.checkTarget(opts.Target); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		defaults = df.ArgDefaults()
+		if ci != nil {
+			opts.BuildArgs = ci.applyBuildArgs(opts.BuildArgs, defaults)
+		}
+		if rec != nil {
+			if content, err := fs.ReadFile(fsys, dockerfileName); err == nil {
+				rec.Dockerfile(content)
+			}
+		}
+		entries, err := contextEntries(fsys, buildContextTarOptions(opts))
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if err := checkContextFiles(entries, opts.MaxContextFiles); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if err := checkContextSources(fsys, df.ContextSources(), entries); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		if opts.PullBaseImages {
+			if err := c.pullBaseImages(ctx, out, df, opts.Platform, opts.QuietPull); err != nil {
+				return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+			}
+		}
+		// the cached result may be built on base images older than the
+		// pulled ones
+		if opts.ResultCacheDir != "" && len(opts.Outputs) == 0 && !opts.Ephemeral && !opts.PullBaseImages {
+			cache, err = openResultCache(opts.ResultCacheDir)
+			if err != nil {
+				return res, err
+			}
+			args := effectiveBuildArgs(defaults, opts.BuildArgs)
+			cacheKey, err = resultCacheKey(fsys, opts, res.Tag, args)
 			if err != nil {
-				err = fmt.Errorf("%w (writing header %s):%w", ContextFilesReadErr, d.Name(), err)
-				return nil, err
+				return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
 			}
-			_, err = tw.Write(b)
+			id, ok, err := c.cachedImage(ctx, cache, cacheKey, res.Tag)
 			if err != nil {
-				err = fmt.Errorf("%w (writing content %s):%w", ContextFilesReadErr, d.Name(), err)
-				return nil, err
+				return res, err
 			}
+			if ok {
+				_, _ = fmt.Fprintln(out, "Using cached build result:", id)
+				res.ImageID = id
+				res.BuildArgs = args
+				if err := c.tagAlso(ctx, out, &res, alsoTags); err != nil {
+					return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+				}
+				return res, nil
+			}
+		}
+
+		contextReader, err := buildRequestReaderWithAllFiles(fsys, opts)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ImageBuildErr, err)
+			return res, err
+		}
+		// stops the archive writing if the build fails before
+		// sending it
+		defer func() {
+			_ = contextReader.Close()
+		}()
+		dockerFileReader = contextReader
+		if rec != nil {
+			dockerFileReader = rec.Context(contextReader)
 		}
 	}
-	if err := tw.Flush(); err != nil {
-		err = fmt.Errorf("%w (flushing header):%w", ContextFilesReadErr, err)
-		return nil, err
+	if opts.Compress && dockerFileReader != nil {
+		compressed, err := compressContext(dockerFileReader, opts.CompressionLevel)
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+		defer func() {
+			_ = compressed.Close()
+		}()
+		dockerFileReader = compressed
+	}
+	res.BuildArgs = effectiveBuildArgs(defaults, opts.BuildArgs)
+	if ci != nil {
+		_, _ = fmt.Fprintln(out, "CI metadata:", ci)
+	}
+	if opts.PrintOptions {
+		printBuildOptions(out, src, remote, opts, defaults, res.BuildArgs)
+	}
+	if opts.PrintResolvedDockerfile {
+		if err := printResolvedDockerfile(out, fsys, remote, opts); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
 	}
 
-	return bytes.NewReader(buf.Bytes()), nil
-}
+	buildOpts := types.ImageBuildOptions{
+		Tags:          []string{res.Tag},
+		RemoteContext: remote,
+		Platform:      opts.Platform,
+		BuildArgs:     opts.BuildArgs,
+		Target:        opts.Target,
+		CPUShares:     opts.CPUShares,
+		Labels:        opts.Labels,
+		// the base images of a remote context aren't known beforehand,
+		// the daemon pulls them in the build stream
+		PullParent: opts.PullBaseImages && remote != "",
+	}
+	if len(opts.Outputs) > 0 && !opts.BuildKit {
+		return res, fmt.Errorf("%w: outputs require BuildKit", InvalidOutputErr)
+	}
+	if opts.InlineCache && !opts.BuildKit {
+		return res, fmt.Errorf("%w: inline cache requires BuildKit", ImageBuildErr)
+	}
+	if opts.BuildKit {
+		if err := c.Require(ctx, FeatureBuildKit); err != nil {
+			return res, err
+		}
+		if len(opts.Outputs) > 0 {
+			if err := c.Require(ctx, FeatureBuildOutputs); err != nil {
+				return res, err
+			}
+		}
+		s, err := c.startSession(ctx, sessionKey)
+		if err != nil {
+			return res, err
+		}
+		defer func() {
+			_ = s.Close()
+		}()
+		buildOpts.Version = types.BuilderBuildKit
+		buildOpts.SessionID = s.ID()
+		buildOpts.Outputs = opts.Outputs
+	}
 
-func buildRequestReaderWithDockerfile(src string) (io.Reader, error) {
-	srcAbs, err := filepath.Abs(src)
+	start := time.Now()
+	response, err := c.d.ImageBuild(
+		ctx,
+		dockerFileReader,
+		buildOpts,
+	)
 	if err != nil {
-		err = fmt.Errorf("%w: %w", DockerfileNotFoundErr, err)
-		return nil, err
+		err = fmt.Errorf("%w: %w", BuildDockerAPIErr, err)
+		return res, err
 	}
-
-	b, err := readFile(srcAbs, "Dockerfile")
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
 	defer func() {
-		_ = tw.Close()
+		_ = response.Body.Close()
 	}()
 
-	tarHeader := &tar.Header{
-		Name: "Dockerfile",
-		Size: int64(len(b)),
+	body := io.Reader(response.Body)
+	if opts.RecordStream != "" {
+		rec, err := os.Create(opts.RecordStream)
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", BuildStreamRecordErr, err)
+		}
+		defer func() {
+			_ = rec.Close()
+		}()
+		body = io.TeeReader(body, rec)
 	}
-	err = tw.WriteHeader(tarHeader)
+	intermediates := newIntermediateCollector()
+	err = readBuildStream(body, out, opts, printer, collector, handler, &res, intermediates.Handle)
 	if err != nil {
-		err = fmt.Errorf("%w (writing %s):%w", ContextFilesReadErr, "Dockerfile", err)
-		return nil, err
+		if opts.CleanFailedBuilds && !opts.KeepImages && !opts.BuildKit {
+			c.cleanFailedBuild(out, start, intermediates)
+		}
+		err = fmt.Errorf("%w: %w", ImageBuildErr, err)
+		return res, err
 	}
-	if err := tw.Close(); err != nil {
-		err = fmt.Errorf("%w (closing header):%w", ContextFilesReadErr, err)
-		return nil, err
+
+	if opts.FailOnWarn {
+		if err := collector.Err(); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+	}
+	if o := opts.Override; o.Entrypoint != "" || o.Cmd != "" {
+		if res.ImageID == "" {
+			return res, fmt.Errorf("%w: %w: the built image ID is unknown", ImageBuildErr, CommandOverrideErr)
+		}
+		res.ImageID, err = c.overrideCommand(ctx, res.ImageID, res.Tag, o)
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
 	}
+	if res.ImageID != "" {
+		if err := c.checkImage(ctx, out, res.ImageID, opts, res.BuildArgs); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+	} else if opts.RequireHealthcheck {
+		return res, fmt.Errorf("%w: %w: the built image ID is unknown", ImageBuildErr, MissingHealthcheckErr)
+	}
+	if cache != nil && res.ImageID != "" {
+		if err := cache.Put(cacheKey, res.ImageID); err != nil {
+			slog.With("error", err.Error()).Warn("ResultCacheWriteFailed")
+		}
+	}
+	if err := c.tagAlso(ctx, out, &res, alsoTags); err != nil {
+		return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+	}
+	if opts.PostBuildHook != "" {
+		if err := runPostBuildHook(ctx, out, opts.PostBuildHook, res); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+	}
+	return res, nil
+}
 
-	return bytes.NewReader(buf.Bytes()), nil
+// readBuildStream parses the daemon build stream body, passing each
+// event to the printer, collector, handler and extra ones, and sets the
+// image ID and steps of res. The last output lines are printed again if
+// the build failed.
+func readBuildStream(body io.Reader, out io.Writer, opts BuildOptions, printer *buildPrinter, collector *warningCollector, handler func(BuildEvent), res *BuildResult, extra ...func(buildEvent)) error {
+	var hb *heartbeat
+	if opts.Heartbeat > 0 {
+		hb = startHeartbeat(out, opts.Heartbeat)
+	}
+	var steps []*buildStep
+	tail := newTailBuffer(opts.Tail)
+	err := newStreamParser().Parse(body, func(e buildEvent) {
+		if hb != nil {
+			hb.Handle(e)
+		}
+		tail.Handle(e)
+		for _, h := range extra {
+			h(e)
+		}
+		if e.Kind == eventStepStart {
+			steps = append(steps, e.Step)
+		}
+		if e.Kind == eventAux {
+			var built types.BuildResult
+			if err := json.Unmarshal(*e.Aux, &built); err == nil && built.ID != "" {
+				res.ImageID = built.ID
+			}
+		}
+		printer.Handle(e)
+		collector.Handle(e)
+		if handler != nil {
+			handler(publicEvent(e))
+		}
+	})
+	if hb != nil {
+		hb.Stop()
+	}
+	for _, s := range steps {
+		res.Steps = append(res.Steps, s.public())
+	}
+	if err != nil {
+		tail.Print(out)
+		collector.Summary(out)
+		return err
+	}
+	printer.Close()
+	collector.Summary(out)
+	return nil
 }
 
-func readFile(srcFolder, fileName string) ([]byte, error) {
-	f, err := os.Open(filepath.Join(srcFolder, fileName))
+// buildAlsoFinal builds the target stage, then the last one, which
+// reuses the cache of the steps they share
+func (c Client) buildAlsoFinal(ctx context.Context, src string, fsys fs.FS, opts BuildOptions, out io.Writer, handler func(BuildEvent)) (BuildResult, error) {
+	opts.AlsoFinal = false
+	var tags []string
+	if opts.Ephemeral {
+		// the target image is kept until the final build reused it
+		opts.keepEphemeral = true
+		defer func() {
+			for _, t := range tags {
+				c.removeEphemeral(out, t)
+			}
+		}()
+	}
+	// the tags given name the final image
+	targetOpts := opts
+	targetOpts.Tag, targetOpts.AlsoTags = "", nil
+	target, err := c.build(ctx, src, fsys, targetOpts, out, handler)
+	tags = append(tags, target.Tag)
 	if err != nil {
-		err = fmt.Errorf("%w (opening %s):%w", ContextFilesReadErr, fileName, err)
-		return nil, err
+		return target, err
 	}
-	defer func() {
-		_ = f.Close()
-	}()
+	opts.Target = ""
+	res, err := c.build(ctx, src, fsys, opts, out, handler)
+	tags = append(tags, res.Tag)
+	res.TargetTag = target.Tag
+	res.TargetImageID = target.ImageID
+	return res, err
+}
 
-	b, err := io.ReadAll(f)
+// printResolvedDockerfile prints the Dockerfile of the build with its
+// ARG and ENV references resolved (not available for remote contexts)
+func printResolvedDockerfile(out io.Writer, fsys fs.FS, remote string, opts BuildOptions) error {
+	if remote != "" {
+		_, _ = fmt.Fprintln(out, "Resolved Dockerfile: not available for remote context", remote)
+		return nil
+	}
+	content := opts.Dockerfile
+	if len(content) == 0 {
+		var err error
+		content, err = fs.ReadFile(fsys, dockerfileName)
+		if err != nil {
+			return fmt.Errorf("%w: %w", DockerfileNotFoundErr, err)
+		}
+	}
+	resolved, err := resolveDockerfile(bytes.NewReader(content), opts.BuildArgs)
 	if err != nil {
-		err = fmt.Errorf("%w (reading %s):%w", ContextFilesReadErr, fileName, err)
-		return nil, err
+		return err
+	}
+	_, _ = fmt.Fprint(out, "Resolved Dockerfile:\n", resolved)
+	return nil
+}
+
+// printBuildOptions prints the effective build settings, telling the
+// build args that come from Dockerfile defaults apart
+func printBuildOptions(out io.Writer, src, remote string, opts BuildOptions, defaults map[string]*string, args map[string]string) {
+	_, _ = fmt.Fprintln(out, "Build options:")
+	switch {
+	case len(opts.Dockerfile) > 0:
+		_, _ = fmt.Fprintln(out, "  context: none (inline Dockerfile)")
+	case remote != "":
+		_, _ = fmt.Fprintln(out, "  context:", remote)
+	default:
+		_, _ = fmt.Fprintln(out, "  context:", filepath.Join(src, opts.ContextSubdir))
+	}
+	if opts.Platform != "" {
+		_, _ = fmt.Fprintln(out, "  platform:", opts.Platform)
 	}
+	_, _ = fmt.Fprintln(out, "  buildkit:", opts.BuildKit)
 
-	slog.With("file_content", string(b), "file_name", fileName).Info("FileContent")
-	return b, nil
+	names := make([]string, 0, len(args))
+	for k := range args {
+		names = append(names, k)
+	}
+	for k, v := range defaults {
+		if _, ok := args[k]; !ok && v == nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintln(out, "  build args:")
+	for _, k := range names {
+		v, ok := args[k]
+		switch {
+		case !ok:
+			_, _ = fmt.Fprintf(out, "    %s (unset)\n", k)
+		case opts.BuildArgs[k] == nil:
+			_, _ = fmt.Fprintf(out, "    %s=%s (Dockerfile default)\n", k, v)
+		default:
+			_, _ = fmt.Fprintf(out, "    %s=%s\n", k, v)
+		}
+	}
+}
+
+// randomTag generates a tag of repo, for the inline Dockerfile and
+// ephemeral builds
+func randomTag(repo string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return repo + ":" + hex.EncodeToString(b)
+}
+
+// removeEphemeral removes the ephemeral image tag, and the image if no
+// other tag references it
+func (c Client) removeEphemeral(out io.Writer, tag string) {
+	if _, err := c.d.ImageRemove(context.Background(), tag, types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+		if !client.IsErrNotFound(err) {
+			slog.With("tag", tag, "error", err.Error()).Warn("EphemeralImageRemoveFailed")
+		}
+		return
+	}
+	_, _ = fmt.Fprintln(out, "Removed ephemeral image", tag)
 }
@@ -0,0 +1,299 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestConcurrencyOptions(t *testing.T) {
+	c := &Client{}
+	for _, opt := range []ClientOption{WithMaxConcurrentUploads(2), WithMaxConcurrentDownloads(0)} {
+		opt(c)
+	}
+	if cap(c.uploads) != 2 {
+		t.Errorf("uploads capacity = %d, want 2", cap(c.uploads))
+	}
+	if c.downloads != nil {
+		t.Errorf("downloads = %v, want no limit", c.downloads)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	sem := make(chan struct{}, 1)
+	release, err := acquire(context.Background(), sem)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := acquire(ctx, sem); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire on a full semaphore = %v, want %v", err, context.Canceled)
+	}
+
+	release()
+	release, err = acquire(context.Background(), sem)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	release()
+
+	if _, err := acquire(ctx, nil); err != nil {
+		t.Errorf("acquire without limit = %v, want none", err)
+	}
+}
+
+func TestWithHost(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://from-env.local:2375")
+	newClient := func(opts ...ClientOption) *Client {
+		t.Helper()
+		var c *Client
+		var err error
+		captureStdout(t, func() {
+			c, err = NewClient(opts...)
+		})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		return c
+	}
+
+	a := newClient(WithHost("tcp://daemon-a.local:2376"))
+	b := newClient(WithReadOnly(), WithHost("unix:///tmp/daemon-b.sock"))
+	if got := a.d.DaemonHost(); got != "tcp://daemon-a.local:2376" {
+		t.Errorf("first client host = %s, want tcp://daemon-a.local:2376", got)
+	}
+	if got := b.d.DaemonHost(); got != "unix:///tmp/daemon-b.sock" {
+		t.Errorf("second client host = %s, want unix:///tmp/daemon-b.sock", got)
+	}
+	if _, ok := b.d.(readOnlyClient); !ok {
+		t.Errorf("second client API = %T, want the read-only guard whatever the option order", b.d)
+	}
+	if got := newClient().d.DaemonHost(); got != "tcp://from-env.local:2375" {
+		t.Errorf("default client host = %s, want the DOCKER_HOST one", got)
+	}
+}
+
+func TestNewClientUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want string
+	}{
+		{name: "default", want: DefaultUserAgent()},
+		{name: "override", opts: []ClientOption{WithUserAgent("ci-pipeline/1.0")}, want: "ci-pipeline/1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case got <- r.Header.Get("User-Agent"):
+				default:
+				}
+				w.Header().Set("API-Version", "1.44")
+				_, _ = w.Write([]byte("OK"))
+			}))
+			defer srv.Close()
+
+			opts := append([]ClientOption{WithHost("tcp://" + strings.TrimPrefix(srv.URL, "http://"))}, tt.opts...)
+			c, err := NewClient(opts...)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			if _, err := c.d.Ping(context.Background()); err != nil {
+				t.Fatalf("Ping: %v", err)
+			}
+			if ua := <-got; ua != tt.want {
+				t.Errorf("User-Agent = %q, want %q", ua, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	if ua := DefaultUserAgent(); !strings.HasPrefix(ua, "docker-runner/") {
+		t.Errorf("DefaultUserAgent = %q, want the docker-runner prefix", ua)
+	}
+}
+
+func TestBuildTarget(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM golang:1.22 AS build\nFROM alpine:3.19\n")}}
+	tests := []struct {
+		name       string
+		opts       BuildOptions
+		wantBuilds []string
+		wantTag    string
+		wantTarget string
+		wantErr    error
+	}{
+		{name: "last stage", wantBuilds: []string{""}, wantTag: buildTag},
+		{name: "target", opts: BuildOptions{Target: "build"}, wantBuilds: []string{"build"}, wantTag: buildTag + ":build"},
+		{
+			name:       "also final",
+			opts:       BuildOptions{Target: "build", AlsoFinal: true},
+			wantBuilds: []string{"build", ""},
+			wantTag:    buildTag,
+			wantTarget: buildTag + ":build",
+		},
+		{name: "unknown target", opts: BuildOptions{Target: "release"}, wantErr: UnknownTargetErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			res, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, io.Discard, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || api.builds != 0 {
+					t.Errorf("err = %v after %d builds, want %v before building", err, api.builds, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			var targets []string
+			for _, o := range api.options {
+				targets = append(targets, o.Target)
+			}
+			if !equalStrings(targets, tt.wantBuilds) {
+				t.Errorf("built targets = %q, want %q", targets, tt.wantBuilds)
+			}
+			if res.Tag != tt.wantTag || res.TargetTag != tt.wantTarget {
+				t.Errorf("tags = %q, %q, want %q, %q", res.Tag, res.TargetTag, tt.wantTag, tt.wantTarget)
+			}
+			if tt.wantTarget != "" && res.TargetImageID != testImageID {
+				t.Errorf("TargetImageID = %q, want %q", res.TargetImageID, testImageID)
+			}
+		})
+	}
+}
+
+func TestRandomTag(t *testing.T) {
+	a, b := randomTag(ephemeralTagRepo), randomTag(ephemeralTagRepo)
+	if !strings.HasPrefix(a, ephemeralTagRepo+":") || len(a) != len(ephemeralTagRepo)+9 || a == b {
+		t.Errorf("randomTag = %q, %q, want distinct %s:<8 hex> tags", a, b, ephemeralTagRepo)
+	}
+}
+
+func TestBuildEphemeral(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM golang:1.22 AS build\nFROM alpine:3.19\n")}}
+	tests := []struct {
+		name        string
+		opts        BuildOptions
+		stream      string
+		wantRemoved int
+		wantErr     error
+	}{
+		{name: "built", opts: BuildOptions{Ephemeral: true}, wantRemoved: 1},
+		{name: "failed", opts: BuildOptions{Ephemeral: true}, stream: failedStream("boom", "FROM alpine:3.19"), wantRemoved: 1, wantErr: BuildStepErr},
+		{name: "also final", opts: BuildOptions{Ephemeral: true, Target: "build", AlsoFinal: true}, wantRemoved: 2},
+		{name: "tagged", opts: BuildOptions{Ephemeral: true, Tag: "app:1"}, wantErr: InvalidTagErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{stream: tt.stream}
+			res, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, io.Discard, nil)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			removed := api.removed()
+			if len(removed) != tt.wantRemoved {
+				t.Fatalf("removed = %v, want %d images", removed, tt.wantRemoved)
+			}
+			if tt.wantRemoved == 0 {
+				return
+			}
+			var built []string
+			for _, o := range api.options {
+				built = append(built, o.Tags...)
+			}
+			if !equalStrings(sortedNames(removed), sortedNames(built)) {
+				t.Errorf("removed = %v, want the built tags %v", removed, built)
+			}
+			if res.Tag != removed[len(removed)-1] || !strings.HasPrefix(res.Tag, ephemeralTagRepo+":") {
+				t.Errorf("tag = %q, want the last removed ephemeral tag", res.Tag)
+			}
+		})
+	}
+}
+
+func TestBuildWithContextAndDockerfile(t *testing.T) {
+	const dockerfile = "FROM alpine:3.19\nCOPY app.txt /\n"
+	contextFS := fstest.MapFS{
+		dockerfileName:          {Data: []byte("FROM scratch\n")},
+		"app.txt":               {Data: []byte("root")},
+		"sub/app.txt":           {Data: []byte("sub")},
+		"sub/" + dockerfileName: {Data: []byte("FROM scratch\n")},
+	}
+	tests := []struct {
+		name    string
+		fsys    fs.FS
+		subdir  string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name: "context",
+			fsys: contextFS,
+			want: map[string]string{
+				dockerfileName:          dockerfile,
+				"app.txt":               "root",
+				"sub/app.txt":           "sub",
+				"sub/" + dockerfileName: "FROM scratch\n",
+			},
+		},
+		{
+			name:   "subdir",
+			fsys:   contextFS,
+			subdir: "sub",
+			want:   map[string]string{dockerfileName: dockerfile, "app.txt": "sub"},
+		},
+		{
+			name:    "no context",
+			wantErr: NoBuildContextErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			_, err := newTestClient(api).BuildWithContextAndDockerfile(context.Background(), tt.fsys, strings.NewReader(dockerfile), BuildOptions{ContextSubdir: tt.subdir})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildWithContextAndDockerfile: %v", err)
+			}
+			got := map[string]string{}
+			for name, content := range api.lastBuild(t).Files {
+				if !strings.HasSuffix(name, "/") {
+					got[name] = content
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("context files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildWithContextAndDockerfileInline(t *testing.T) {
+	api := &buildAPI{}
+	_, err := newTestClient(api).BuildWithContextAndDockerfile(context.Background(), nil, strings.NewReader("FROM alpine:3.19\n"), BuildOptions{})
+	if err != nil {
+		t.Fatalf("BuildWithContextAndDockerfile: %v", err)
+	}
+	if got := api.lastBuild(t).Files; !reflect.DeepEqual(got, map[string]string{dockerfileName: "FROM alpine:3.19\n"}) {
+		t.Errorf("context files = %v, want the Dockerfile only", got)
+	}
+}
@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// CommandSource tells where the effective entrypoint or command comes
+// from
+type CommandSource string
+
+const (
+	CommandFromImage    CommandSource = "image"
+	CommandFromOverride CommandSource = "override"
+	CommandFromNone     CommandSource = "none"
+)
+
+// EffectiveCommand is the process a container of an image runs, given
+// the entrypoint and command overrides
+type EffectiveCommand struct {
+	Ref              string        `json:"ref"`
+	ImageEntrypoint  []string      `json:"image_entrypoint"`
+	ImageCmd         []string      `json:"image_cmd"`
+	Entrypoint       []string      `json:"entrypoint"`
+	EntrypointSource CommandSource `json:"entrypoint_source"`
+	Cmd              []string      `json:"cmd"`
+	CmdSource        CommandSource `json:"cmd_source"`
+	// Argv is the entrypoint followed by the command (empty if the
+	// daemon would refuse to create the container)
+	Argv  []string `json:"argv"`
+	Notes []string `json:"notes"`
+}
+
+// mergeCommand resolves the entrypoint and command of a container as
+// the daemon merges the create config with the image one. A nil
+// override keeps the image value; an entrypoint override drops the
+// image command, even the [""] one, which then clears the entrypoint.
+func mergeCommand(imageEntrypoint, imageCmd, entrypoint, cmd []string) EffectiveCommand {
+	e := EffectiveCommand{
+		ImageEntrypoint:  imageEntrypoint,
+		ImageCmd:         imageCmd,
+		Entrypoint:       entrypoint,
+		EntrypointSource: CommandFromOverride,
+		Cmd:              cmd,
+		CmdSource:        CommandFromOverride,
+	}
+	if len(entrypoint) == 0 {
+		if len(cmd) == 0 {
+			e.Cmd, e.CmdSource = imageCmd, CommandFromImage
+		}
+		if entrypoint == nil {
+			e.Entrypoint, e.EntrypointSource = imageEntrypoint, CommandFromImage
+		}
+	} else if len(cmd) == 0 {
+		e.Cmd, e.CmdSource = nil, CommandFromNone
+	}
+	if len(e.Entrypoint) == 1 && e.Entrypoint[0] == "" {
+		e.Entrypoint = nil
+	}
+	if len(e.Entrypoint) == 0 {
+		e.EntrypointSource = CommandFromNone
+	}
+	if len(e.Cmd) == 0 {
+		e.CmdSource = CommandFromNone
+	}
+	e.Argv = append(append([]string{}, e.Entrypoint...), e.Cmd...)
+	e.Notes = commandNotes(e, entrypoint, cmd)
+	return e
+}
+
+// commandNotes explains the merge outcomes that commonly surprise:
+// dropped image values and shell form behaviors
+func commandNotes(e EffectiveCommand, entrypoint, cmd []string) []string {
+	var notes []string
+	if len(e.Argv) == 0 {
+		return append(notes, "no entrypoint nor command: the daemon refuses to create the container")
+	}
+	if len(entrypoint) > 0 && len(e.ImageCmd) > 0 && len(cmd) == 0 {
+		notes = append(notes, "the entrypoint override drops the image command "+jsonArray(e.ImageCmd))
+	}
+	if entrypoint == nil && len(cmd) > 0 && len(e.ImageEntrypoint) > 0 {
+		note := "the args are passed to the image entrypoint, they don't replace it"
+		if len(e.ImageCmd) > 0 {
+			note += ", and replace the image command " + jsonArray(e.ImageCmd) + " instead of adding to it"
+		}
+		notes = append(notes, note)
+	}
+	if isShellForm(e.Entrypoint) {
+		notes = append(notes, "the entrypoint is in shell form: env vars are expanded by the shell, which runs as PID 1 and doesn't forward signals")
+		if len(e.Cmd) > 0 {
+			notes = append(notes, "sh -c ignores the command after the script, it only gets it as $0, $1...")
+		}
+	} else if len(e.Entrypoint) == 0 && isShellForm(e.Cmd) {
+		notes = append(notes, "the command is in shell form: env vars are expanded by the shell, which runs as PID 1 and doesn't forward signals")
+	} else if strings.Contains(strings.Join(e.Argv, " "), "$") {
+		notes = append(notes, "exec form: $VAR references are passed as is, not expanded")
+	}
+	return notes
+}
+
+// isShellForm tells if argv is a Dockerfile shell form command
+func isShellForm(argv []string) bool {
+	return len(argv) >= 2 && (argv[0] == "/bin/sh" || argv[0] == "sh" || argv[0] == "/bin/bash") && argv[1] == "-c"
+}
+
+// EffectiveCommand inspects the local image ref and resolves the
+// process its containers run with the overrides (nil entrypoint to
+// keep the image one)
+func (c Client) EffectiveCommand(ctx context.Context, ref string, entrypoint, cmd []string) (EffectiveCommand, error) {
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return EffectiveCommand{}, fmt.Errorf("%w: %s", ImageNotFoundErr, ref)
+		}
+		return EffectiveCommand{}, fmt.Errorf("%w: %w", ImageInspectErr, err)
+	}
+	var imageEntrypoint, imageCmd []string
+	if inspect.Config != nil {
+		imageEntrypoint, imageCmd = inspect.Config.Entrypoint, inspect.Config.Cmd
+	}
+	e := mergeCommand(imageEntrypoint, imageCmd, entrypoint, cmd)
+	e.Ref = ref
+	return e, nil
+}
+
+// logEffectiveCommand logs the process the run container starts, at
+// the debug level only, as it costs an inspect
+func (c Client) logEffectiveCommand(ctx context.Context, image string, cmd []string) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	e, err := c.EffectiveCommand(ctx, image, nil, cmd)
+	if err != nil {
+		slog.With("image", image, "error", err.Error()).Debug("EffectiveCommandFailed")
+		return
+	}
+	slog.With("image", image, "argv", jsonArray(e.Argv), "notes", e.Notes).Debug("EffectiveCommand")
+}
+
+// Write prints the effective command, its sources and notes
+func (e EffectiveCommand) Write(w io.Writer) {
+	orNone := func(v []string) string {
+		if len(v) == 0 {
+			return "-"
+		}
+		return jsonArray(v)
+	}
+	_, _ = fmt.Fprintln(w, "Image:           ", e.Ref)
+	_, _ = fmt.Fprintln(w, "Image entrypoint:", orNone(e.ImageEntrypoint))
+	_, _ = fmt.Fprintln(w, "Image cmd:       ", orNone(e.ImageCmd))
+	_, _ = fmt.Fprintf(w, "Entrypoint:       %s (%s)\n", orNone(e.Entrypoint), e.EntrypointSource)
+	_, _ = fmt.Fprintf(w, "Cmd:              %s (%s)\n", orNone(e.Cmd), e.CmdSource)
+	_, _ = fmt.Fprintln(w, "Runs:            ", orNone(e.Argv))
+	if len(e.Notes) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintln(w, "Notes:")
+	for _, n := range e.Notes {
+		_, _ = fmt.Fprintln(w, "  - "+n)
+	}
+}
@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestMergeCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		imageEntry     []string
+		imageCmd       []string
+		entrypoint     []string
+		cmd            []string
+		wantArgv       []string
+		wantEntrySrc   CommandSource
+		wantCmdSrc     CommandSource
+		wantNoteSubstr string
+	}{
+		{
+			name:         "image values",
+			imageEntry:   []string{"/app"},
+			imageCmd:     []string{"serve"},
+			wantArgv:     []string{"/app", "serve"},
+			wantEntrySrc: CommandFromImage,
+			wantCmdSrc:   CommandFromImage,
+		},
+		{
+			name:           "args replace the image command",
+			imageEntry:     []string{"/app"},
+			imageCmd:       []string{"serve"},
+			cmd:            []string{"migrate"},
+			wantArgv:       []string{"/app", "migrate"},
+			wantEntrySrc:   CommandFromImage,
+			wantCmdSrc:     CommandFromOverride,
+			wantNoteSubstr: `replace the image command ["serve"]`,
+		},
+		{
+			name:           "entrypoint override drops the image command",
+			imageEntry:     []string{"/app"},
+			imageCmd:       []string{"serve"},
+			entrypoint:     []string{"/bin/sh"},
+			wantArgv:       []string{"/bin/sh"},
+			wantEntrySrc:   CommandFromOverride,
+			wantCmdSrc:     CommandFromNone,
+			wantNoteSubstr: `drops the image command ["serve"]`,
+		},
+		{
+			name:         "empty entrypoint override clears it",
+			imageEntry:   []string{"/app"},
+			imageCmd:     []string{"serve"},
+			entrypoint:   []string{""},
+			cmd:          []string{"ls"},
+			wantArgv:     []string{"ls"},
+			wantEntrySrc: CommandFromNone,
+			wantCmdSrc:   CommandFromOverride,
+		},
+		{
+			name:         "empty slice entrypoint keeps the image command",
+			imageEntry:   []string{"/app"},
+			imageCmd:     []string{"serve"},
+			entrypoint:   []string{},
+			wantArgv:     []string{"serve"},
+			wantEntrySrc: CommandFromNone,
+			wantCmdSrc:   CommandFromImage,
+		},
+		{
+			name:           "nothing to run",
+			wantEntrySrc:   CommandFromNone,
+			wantCmdSrc:     CommandFromNone,
+			wantNoteSubstr: "the daemon refuses to create the container",
+		},
+		{
+			name:           "shell form entrypoint",
+			imageEntry:     []string{"/bin/sh", "-c", "exec /app"},
+			imageCmd:       []string{"serve"},
+			wantArgv:       []string{"/bin/sh", "-c", "exec /app", "serve"},
+			wantEntrySrc:   CommandFromImage,
+			wantCmdSrc:     CommandFromImage,
+			wantNoteSubstr: "sh -c ignores the command after the script",
+		},
+		{
+			name:           "shell form command",
+			imageCmd:       []string{"/bin/sh", "-c", "echo $HOME"},
+			wantArgv:       []string{"/bin/sh", "-c", "echo $HOME"},
+			wantEntrySrc:   CommandFromNone,
+			wantCmdSrc:     CommandFromImage,
+			wantNoteSubstr: "the command is in shell form",
+		},
+		{
+			name:           "exec form variables",
+			imageCmd:       []string{"echo", "$HOME"},
+			wantArgv:       []string{"echo", "$HOME"},
+			wantEntrySrc:   CommandFromNone,
+			wantCmdSrc:     CommandFromImage,
+			wantNoteSubstr: "not expanded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := mergeCommand(tt.imageEntry, tt.imageCmd, tt.entrypoint, tt.cmd)
+			if !equalStrings(e.Argv, tt.wantArgv) {
+				t.Errorf("Argv = %q, want %q", e.Argv, tt.wantArgv)
+			}
+			if e.EntrypointSource != tt.wantEntrySrc || e.CmdSource != tt.wantCmdSrc {
+				t.Errorf("sources = %s, %s, want %s, %s", e.EntrypointSource, e.CmdSource, tt.wantEntrySrc, tt.wantCmdSrc)
+			}
+			notes := strings.Join(e.Notes, "\n")
+			if tt.wantNoteSubstr != "" && !strings.Contains(notes, tt.wantNoteSubstr) {
+				t.Errorf("notes = %q, want %q", e.Notes, tt.wantNoteSubstr)
+			}
+			if tt.wantNoteSubstr == "" && len(e.Notes) != 0 {
+				t.Errorf("notes = %q, want none", e.Notes)
+			}
+		})
+	}
+}
+
+func TestEffectiveCommand(t *testing.T) {
+	api := &buildAPI{image: &types.ImageInspect{Config: &container.Config{Entrypoint: []string{"/app"}, Cmd: []string{"serve"}}}}
+	e, err := newTestClient(api).EffectiveCommand(context.Background(), "app:1", nil, []string{"migrate"})
+	if err != nil {
+		t.Fatalf("EffectiveCommand: %v", err)
+	}
+	if e.Ref != "app:1" || !equalStrings(e.Argv, []string{"/app", "migrate"}) {
+		t.Errorf("EffectiveCommand = %+v, want app:1 running /app migrate", e)
+	}
+	var out strings.Builder
+	e.Write(&out)
+	for _, want := range []string{`Entrypoint:       ["/app"] (image)`, `Cmd:              ["migrate"] (override)`, `Runs:             ["/app","migrate"]`, "Notes:\n  - the args"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output = %q, want %q", out.String(), want)
+		}
+	}
+
+	_, err = newTestClient(&buildAPI{gone: []string{"app:1"}}).EffectiveCommand(context.Background(), "app:1", nil, nil)
+	if !errors.Is(err, ImageNotFoundErr) {
+		t.Errorf("err = %v, want %v", err, ImageNotFoundErr)
+	}
+}
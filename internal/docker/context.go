@@ -0,0 +1,475 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidCompressionLevelErr = errors.New("invalid compression level")
+	ContextTooLargeErr         = errors.New("build context has too many files")
+)
+
+// contextRoot resolves the directory that is used as the build
+// context root, given the source folder and an optional subdir.
+func contextRoot(src, subdir string) (string, error) {
+	srcAbs, err := filepath.Abs(src)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", DockerfileNotFoundErr, err)
+		return "", err
+	}
+	if subdir == "" {
+		return srcAbs, nil
+	}
+	root := filepath.Join(srcAbs, subdir)
+	rel, err := stripPrefix(srcAbs, root)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		err = fmt.Errorf("%w: context subdir %s is outside of %s", ContextDirReadErr, subdir, src)
+		return "", err
+	}
+	return root, nil
+}
+
+// stripPrefix returns the tar entry name for path, relative to
+// the context root prefix and using forward slashes.
+func stripPrefix(prefix, path string) (string, error) {
+	rel, err := filepath.Rel(prefix, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// buildRequestReaderWithAllFiles streams the tar of the build context
+// fsys
+func buildRequestReaderWithAllFiles(fsys fs.FS, opts BuildOptions) (io.ReadCloser, error) {
+	return tarFS(fsys, buildContextTarOptions(opts))
+}
+
+// dirFS is the fs.FS of a host folder, able to read its symlinks
+type dirFS struct {
+	fs.FS
+	root string
+}
+
+// contextFS returns the fs.FS of the root folder
+func contextFS(root string) fs.FS {
+	return dirFS{FS: os.DirFS(root), root: root}
+}
+
+// ReadLink returns the target of the symlink name
+func (d dirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+// dockerfileFS is a build context whose Dockerfile is replaced by (or,
+// if missing, added with) content
+type dockerfileFS struct {
+	fs.FS
+	content []byte
+	modTime time.Time
+}
+
+func (d dockerfileFS) info() memFileInfo {
+	return memFileInfo{name: dockerfileName, size: int64(len(d.content)), modTime: d.modTime}
+}
+
+// Open returns the in-memory Dockerfile, or the context entry name
+func (d dockerfileFS) Open(name string) (fs.File, error) {
+	if name == dockerfileName {
+		return memFile{Reader: bytes.NewReader(d.content), info: d.info()}, nil
+	}
+	return d.FS.Open(name)
+}
+
+// ReadDir lists the context folder name, the root one holding the
+// in-memory Dockerfile in place of the context one
+func (d dockerfileFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(d.FS, name)
+	if name != "." {
+		return entries, err
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	res := []fs.DirEntry{fs.FileInfoToDirEntry(d.info())}
+	for _, e := range entries {
+		if e.Name() != dockerfileName {
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+	return res, nil
+}
+
+// ReadLink returns the target of the context symlink name
+func (d dockerfileFS) ReadLink(name string) (string, error) {
+	return readLink(d.FS, name)
+}
+
+// memFile is an in-memory regular file
+type memFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f memFile) Close() error {
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string {
+	return i.name
+}
+
+func (i memFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i memFileInfo) Mode() fs.FileMode {
+	return 0o644
+}
+
+func (i memFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i memFileInfo) IsDir() bool {
+	return false
+}
+
+func (i memFileInfo) Sys() any {
+	return nil
+}
+
+// readLinkFS is a fs.FS able to read its symlinks
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// readLink returns the target of the symlink name of fsys
+func readLink(fsys fs.FS, name string) (string, error) {
+	rl, ok := fsys.(readLinkFS)
+	if !ok {
+		return "", fmt.Errorf("%w: %s: symlinks are not supported by %T", fs.ErrInvalid, name, fsys)
+	}
+	return rl.ReadLink(name)
+}
+
+// buildContextTarOptions are the settings used to tar a build context
+func buildContextTarOptions(opts BuildOptions) tarOptions {
+	return tarOptions{
+		IgnoreFile:     dockerignoreFile,
+		IgnorePatterns: opts.IgnorePatterns,
+		Keep:           []string{dockerfileName, dockerignoreFile},
+		ExplainIgnore:  opts.ExplainIgnore,
+	}
+}
+
+// compressContext gzips the context stream at the level (1 fastest to
+// 9 smallest), which the daemon detects and decompresses
+func compressContext(r io.Reader, level int) (io.ReadCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return nil, fmt.Errorf("%w: %d (expected %d to %d)", InvalidCompressionLevelErr, level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := gzip.NewWriterLevel(pw, level)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(zw, r); err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("%w (compressing):%w", ContextFilesReadErr, err))
+			return
+		}
+		_ = pw.CloseWithError(zw.Close())
+	}()
+	return pr, nil
+}
+
+// tarOptions holds the settings used to tar a folder
+type tarOptions struct {
+	// IgnoreFile is the ignore file name, relative to the root
+	IgnoreFile string
+	// IgnorePatterns are applied after the ignore file ones
+	IgnorePatterns []string
+	// Keep are the entries never excluded
+	Keep []string
+	// Deterministic zeroes times and owners, so the same content
+	// always produces the same archive
+	Deterministic bool
+	// ExplainIgnore logs, for each entry, whether it was included or
+	// excluded, and the pattern that decided it
+	ExplainIgnore bool
+}
+
+// tarDirectory streams a tar of the root folder content, with entry
+// names relative to root. A failure while writing the archive closes
+// it with the error, so the reader fails instead of seeing a truncated
+// tar. Closing the reader early stops the writing.
+func tarDirectory(root string, opts tarOptions) (io.ReadCloser, error) {
+	if _, err := os.Stat(root); err != nil {
+		err = fmt.Errorf("%w: %w", ContextDirReadErr, err)
+		return nil, err
+	}
+	return tarFS(contextFS(root), opts)
+}
+
+// tarFS streams a tar of the fsys content, as tarDirectory does
+func tarFS(fsys fs.FS, opts tarOptions) (io.ReadCloser, error) {
+	ignorer, err := newContextIgnorer(fsys, opts.IgnoreFile, opts.IgnorePatterns, opts.Keep)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(writeTarDirectory(pw, fsys, ignorer, opts))
+	}()
+	return pr, nil
+}
+
+// writeTarDirectory writes the tar of the fsys content to w
+func writeTarDirectory(w io.Writer, fsys fs.FS, ignorer *contextIgnorer, opts tarOptions) error {
+	tw := tar.NewWriter(w)
+	links := make(map[fileID]string)
+	err := walkContext(fsys, ignorer, opts.ExplainIgnore, func(name string, d fs.DirEntry) error {
+		return addTarEntry(tw, fsys, name, d, opts.Deterministic, links)
+	})
+	if err != nil {
+		// no trailer is written, the reader must not take the
+		// partial archive for a complete one
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("%w (closing archive):%w", ContextFilesReadErr, err)
+	}
+	return nil
+}
+
+// walkContext calls fn for each entry of fsys not excluded by the
+// ignorer, with its slash separated name
+func walkContext(fsys fs.FS, ignorer *contextIgnorer, explain bool, fn func(name string, d fs.DirEntry) error) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("%w: %w", ContextDirReadErr, err)
+		}
+		if name == "." {
+			return nil
+		}
+		excluded, skip, err := ignorer.Excluded(name, d.IsDir())
+		if err != nil {
+			return err
+		}
+		if explain {
+			if err := ignorer.explainDecision(name, excluded); err != nil {
+				return err
+			}
+		}
+		if skip {
+			return filepath.SkipDir
+		}
+		if excluded {
+			return nil
+		}
+		return fn(name, d)
+	})
+}
+
+// contextEntries returns the entries the build context fsys holds, by
+// name, once the ignore patterns are applied
+func contextEntries(fsys fs.FS, opts tarOptions) (map[string]fs.DirEntry, error) {
+	ignorer, err := newContextIgnorer(fsys, opts.IgnoreFile, opts.IgnorePatterns, opts.Keep)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fs.DirEntry)
+	err = walkContext(fsys, ignorer, false, func(name string, d fs.DirEntry) error {
+		entries[name] = d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// normalizeHeader clears the header fields that change between
+// copies of the same content
+func normalizeHeader(h *tar.Header) {
+	h.ModTime = time.Unix(0, 0)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	h.Uid, h.Gid = 0, 0
+	h.Uname, h.Gname = "", ""
+	h.Mode &= tarModeMask
+	h.PAXRecords = nil
+}
+
+// addTarEntry writes the header (and content, for regular files)
+// of the context entry name to the tar writer.
+func addTarEntry(tw *tar.Writer, fsys fs.FS, name string, d fs.DirEntry, deterministic bool, links map[fileID]string) error {
+	i, err := d.Info()
+	if err != nil {
+		return fmt.Errorf("%w (stat %s):%w", ContextFilesReadErr, name, err)
+	}
+
+	var link string
+	if i.Mode()&fs.ModeSymlink != 0 {
+		link, err = readLink(fsys, name)
+		if err != nil {
+			return fmt.Errorf("%w (reading link %s):%w", ContextFilesReadErr, name, err)
+		}
+	}
+
+	tarHeader, err := tar.FileInfoHeader(i, link)
+	if err != nil {
+		return fmt.Errorf("%w (building header %s):%w", ContextFilesReadErr, name, err)
+	}
+	tarHeader.Name = name
+	if d.IsDir() {
+		tarHeader.Name += "/"
+	}
+	if deterministic {
+		normalizeHeader(tarHeader)
+	}
+
+	if i.Mode().IsRegular() {
+		if st, ok := statFile(i); ok {
+			if first, ok := links[st.id]; ok && st.links > 1 {
+				// a hardlink to a file already archived gets no content
+				tarHeader.Typeflag = tar.TypeLink
+				tarHeader.Linkname = first
+				tarHeader.Size = 0
+			} else if st.links > 1 {
+				links[st.id] = name
+			}
+			if i.Size() >= sparseMinSize && st.allocated < i.Size()/2 {
+				slog.With("file", name, "size", i.Size(), "allocated", st.allocated).Warn("SparseContextFile")
+			}
+		}
+	}
+
+	if !i.Mode().IsRegular() || tarHeader.Typeflag == tar.TypeLink {
+		if err := tw.WriteHeader(tarHeader); err != nil {
+			return fmt.Errorf("%w (writing header %s):%w", ContextFilesReadErr, name, err)
+		}
+		return nil
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w (opening %s):%w", ContextFilesReadErr, name, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := tw.WriteHeader(tarHeader); err != nil {
+		return fmt.Errorf("%w (writing header %s):%w", ContextFilesReadErr, name, err)
+	}
+	return writeTarContent(tw, name, f, tarHeader.Size)
+}
+
+// sparseMinSize is the size from which the files using less than half
+// of it on disk are reported as sparse, as they are archived with their
+// full apparent size
+const sparseMinSize = 1 << 20
+
+// fileID identifies a file on the host, telling hardlinks apart
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileStat is the host file information the archive uses
+type fileStat struct {
+	id        fileID
+	links     uint64
+	allocated int64
+}
+
+// writeTarContent copies the size bytes of the entry content from r,
+// failing if r fails or doesn't have exactly size bytes (e.g. the file
+// changed since its header was written). Empty files get no content,
+// their entry is the header alone.
+func writeTarContent(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	n, err := io.Copy(tw, io.LimitReader(r, size+1))
+	if err != nil {
+		return fmt.Errorf("%w (writing content %s):%w", ContextFilesReadErr, name, err)
+	}
+	if n != size {
+		return fmt.Errorf("%w (writing content %s): read %d bytes, expected %d", ContextFilesReadErr, name, n, size)
+	}
+	return nil
+}
+
+// buildRequestReaderWithDockerfile builds a context holding only the
+// Dockerfile content
+func buildRequestReaderWithDockerfile(content []byte) (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	tarHeader := &tar.Header{
+		Name:     dockerfileName,
+		Mode:     0o644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(tarHeader); err != nil {
+		err = fmt.Errorf("%w (writing header %s):%w", ContextFilesReadErr, dockerfileName, err)
+		return nil, err
+	}
+	if _, err := tw.Write(content); err != nil {
+		err = fmt.Errorf("%w (writing content %s):%w", ContextFilesReadErr, dockerfileName, err)
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		err = fmt.Errorf("%w (closing archive):%w", ContextFilesReadErr, err)
+		return nil, err
+	}
+
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// checkContextFiles fails if the context holds more than max files
+// (directories aren't counted, no limit if zero)
+func checkContextFiles(entries map[string]fs.DirEntry, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	files := 0
+	for _, d := range entries {
+		if !d.IsDir() {
+			files++
+		}
+	}
+	if files > max {
+		return fmt.Errorf("%w: %d files, the limit is %d (exclude the folders not needed by the build in %s)", ContextTooLargeErr, files, max, dockerignoreFile)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// archiveSize returns the size of the context archive of dir
+func archiveSize(t *testing.T, dir string) int {
+	t.Helper()
+	r, err := contextTar(dir, BuildOptions{})
+	if err != nil {
+		t.Fatalf("contextTar: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading the context archive: %v", err)
+	}
+	return len(b)
+}
+
+func TestTarDirectoryHardlinks(t *testing.T) {
+	content := strings.Repeat("x", 64<<10)
+	linked := t.TempDir()
+	writeTree(t, linked, map[string]string{"a.bin": content})
+	if err := os.Link(filepath.Join(linked, "a.bin"), filepath.Join(linked, "b.bin")); err != nil {
+		t.Fatal(err)
+	}
+	copied := t.TempDir()
+	writeTree(t, copied, map[string]string{"a.bin": content, "b.bin": content})
+
+	r, err := contextTar(linked, BuildOptions{})
+	if err != nil {
+		t.Fatalf("contextTar: %v", err)
+	}
+	got := readTar(t, r)
+	if h := got.Headers["a.bin"]; h.Typeflag != tar.TypeReg || got.Files["a.bin"] != content {
+		t.Errorf("a.bin = %c with %d bytes, want the regular file", h.Typeflag, len(got.Files["a.bin"]))
+	}
+	if h := got.Headers["b.bin"]; h.Typeflag != tar.TypeLink || h.Linkname != "a.bin" || h.Size != 0 {
+		t.Errorf("b.bin = %c to %q with %d bytes, want a link to a.bin", h.Typeflag, h.Linkname, h.Size)
+	}
+	if l, c := archiveSize(t, linked), archiveSize(t, copied); l > c-len(content) {
+		t.Errorf("archive = %d bytes with the hardlink, %d with a copy, want the content once", l, c)
+	}
+}
+
+func TestTarDirectorySparseFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"dense.bin": strings.Repeat("x", sparseMinSize)})
+	sparse := filepath.Join(dir, "sparse.bin")
+	f, err := os.Create(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(4 * sparseMinSize); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	var st syscall.Stat_t
+	if err := syscall.Stat(sparse, &st); err != nil || st.Blocks*512 >= 2*sparseMinSize {
+		t.Skip("the temp folder file system doesn't support sparse files")
+	}
+
+	logs := captureLogs(t)
+	r, err := contextTar(dir, BuildOptions{})
+	if err != nil {
+		t.Fatalf("contextTar: %v", err)
+	}
+	got := readTar(t, r)
+	if len(got.Files["sparse.bin"]) != 4*sparseMinSize || !bytes.Equal([]byte(got.Files["sparse.bin"][:8]), make([]byte, 8)) {
+		t.Errorf("sparse.bin = %d bytes, want it sent with its full size", len(got.Files["sparse.bin"]))
+	}
+	warnings := logsWithMsg(logs(), "SparseContextFile")
+	if len(warnings) != 1 || warnings[0]["file"] != "sparse.bin" {
+		t.Errorf("warnings = %v, want one for sparse.bin", warnings)
+	}
+}
@@ -0,0 +1,603 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
+
+// writeTree creates the files of tree (name to content, a trailing /
+// for a folder) under dir
+func writeTree(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tarContent is the content of a context archive
+type tarContent struct {
+	// Names are the entry names, in archive order
+	Names   []string
+	Headers map[string]*tar.Header
+	Files   map[string]string
+}
+
+// readTar reads the context archive r, failing the test on error
+func readTar(t *testing.T, r io.Reader) tarContent {
+	t.Helper()
+	res := tarContent{Headers: map[string]*tar.Header{}, Files: map[string]string{}}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return res
+		}
+		if err != nil {
+			t.Fatalf("reading the context archive: %v", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", h.Name, err)
+		}
+		res.Names = append(res.Names, h.Name)
+		res.Headers[h.Name] = h
+		res.Files[h.Name] = string(b)
+	}
+}
+
+func sortedNames(names []string) []string {
+	res := append([]string(nil), names...)
+	sort.Strings(res)
+	return res
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestContextRoot(t *testing.T) {
+	src := t.TempDir()
+	tests := []struct {
+		name    string
+		subdir  string
+		want    string
+		wantErr error
+	}{
+		{name: "no subdir", want: src},
+		{name: "subdir", subdir: "services/api", want: filepath.Join(src, "services", "api")},
+		{name: "cleaned subdir", subdir: "services/../api/", want: filepath.Join(src, "api")},
+		{name: "parent", subdir: "..", wantErr: ContextDirReadErr},
+		{name: "outside", subdir: "../other", wantErr: ContextDirReadErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := contextRoot(src, tt.subdir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("contextRoot err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("contextRoot = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "src", "app")
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: filepath.Join(root, "main.go"), want: "main.go"},
+		{path: filepath.Join(root, "pkg", "util", "util.go"), want: "pkg/util/util.go"},
+		{path: root, want: "."},
+	}
+	for _, tt := range tests {
+		got, err := stripPrefix(root, tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("stripPrefix(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+// contextTar streams the tar of the src folder context with the build
+// settings
+func contextTar(src string, opts BuildOptions) (io.ReadCloser, error) {
+	root, err := contextRoot(src, opts.ContextSubdir)
+	if err != nil {
+		return nil, err
+	}
+	return tarDirectory(root, buildContextTarOptions(opts))
+}
+
+func TestContextEntryNames(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"README.md":               "top level, outside the subdir",
+		"services/api/Dockerfile": "FROM alpine:3.19\n",
+		"services/api/main.go":    "package main\n",
+		"services/api/pkg/db.go":  "package pkg\n",
+		"services/api/empty/":     "",
+	})
+	tests := []struct {
+		name   string
+		subdir string
+		want   []string
+	}{
+		{
+			name: "source root",
+			want: []string{"README.md", "services/", "services/api/", "services/api/Dockerfile", "services/api/empty/", "services/api/main.go", "services/api/pkg/", "services/api/pkg/db.go"},
+		},
+		{
+			name:   "subdir",
+			subdir: "services/api",
+			want:   []string{"Dockerfile", "empty/", "main.go", "pkg/", "pkg/db.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := contextTar(src, BuildOptions{ContextSubdir: tt.subdir})
+			if err != nil {
+				t.Fatalf("contextTar: %v", err)
+			}
+			got := readTar(t, r)
+			if names := sortedNames(got.Names); !equalStrings(names, tt.want) {
+				t.Errorf("entries = %v, want %v", names, tt.want)
+			}
+			if tt.subdir != "" && got.Files["main.go"] != "package main\n" {
+				t.Errorf("main.go content = %q", got.Files["main.go"])
+			}
+		})
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	src := t.TempDir()
+	tests := []struct {
+		name   string
+		src    string
+		subdir string
+	}{
+		{name: "missing root", src: filepath.Join(src, "missing")},
+		{name: "missing subdir", src: src, subdir: "missing"},
+		{name: "subdir outside", src: src, subdir: "../other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := contextTar(tt.src, BuildOptions{ContextSubdir: tt.subdir})
+			if !errors.Is(err, ContextDirReadErr) {
+				t.Errorf("err = %v, want %v", err, ContextDirReadErr)
+			}
+		})
+	}
+}
+
+func TestWriteTarContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		size    int64
+		wantErr bool
+	}{
+		{name: "exact", content: "hello", size: 5},
+		{name: "empty", content: "", size: 0},
+		{name: "shrunk", content: "hel", size: 5, wantErr: true},
+		{name: "grown", content: "hello world", size: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := tar.NewWriter(io.Discard)
+			if err := tw.WriteHeader(&tar.Header{Name: "f", Typeflag: tar.TypeReg, Size: tt.size, Mode: 0o644}); err != nil {
+				t.Fatal(err)
+			}
+			err := writeTarContent(tw, "f", strings.NewReader(tt.content), tt.size)
+			if tt.wantErr && !errors.Is(err, ContextFilesReadErr) {
+				t.Errorf("err = %v, want %v", err, ContextFilesReadErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+		})
+	}
+}
+
+func TestTarDirectoryCloseStopsWriting(t *testing.T) {
+	dir := t.TempDir()
+	tree := map[string]string{}
+	for i := 0; i < 100; i++ {
+		tree[fmt.Sprintf("file%03d", i)] = strings.Repeat("x", 1<<16)
+	}
+	writeTree(t, dir, tree)
+	r, err := tarDirectory(dir, tarOptions{})
+	if err != nil {
+		t.Fatalf("tarDirectory: %v", err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 1024)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	// closing the reader unblocks the writer, which stops on the
+	// closed pipe
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("read after close err = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestBuildRequestReaderWithDockerfile(t *testing.T) {
+	content := []byte("FROM alpine:3.19\nRUN echo hi\n")
+	r, err := buildRequestReaderWithDockerfile(content)
+	if err != nil {
+		t.Fatalf("buildRequestReaderWithDockerfile: %v", err)
+	}
+	req := readTar(t, r)
+	if !equalStrings(req.Names, []string{dockerfileName}) {
+		t.Fatalf("entries = %v, want only the Dockerfile", req.Names)
+	}
+	h := req.Headers[dockerfileName]
+	if h.Typeflag != tar.TypeReg || h.Size != int64(len(content)) || h.Mode != 0o644 {
+		t.Errorf("header = %+v, want a 0644 regular file of %d bytes", h, len(content))
+	}
+	if req.Files[dockerfileName] != string(content) {
+		t.Errorf("content = %q, want %q", req.Files[dockerfileName], content)
+	}
+}
+
+func TestBuildInlineDockerfile(t *testing.T) {
+	api := &buildAPI{}
+	content := "FROM alpine:3.19\nRUN echo hi\n"
+	var res BuildResult
+	var err error
+	captureStdout(t, func() {
+		res, err = newTestClient(api).Build(context.Background(), "", BuildOptions{Dockerfile: []byte(content)})
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	req := api.lastBuild(t)
+	if !equalStrings(req.Names, []string{dockerfileName}) || req.Files[dockerfileName] != content {
+		t.Errorf("context = %v, want only the inline Dockerfile", req.Files)
+	}
+	if !strings.HasPrefix(res.Tag, "docker-runner-inline:") {
+		t.Errorf("Tag = %q, want a generated docker-runner-inline tag", res.Tag)
+	}
+	if !equalStrings(req.Options.Tags, []string{res.Tag}) {
+		t.Errorf("build tags = %v, want %v", req.Options.Tags, []string{res.Tag})
+	}
+}
+
+func TestBuildInlineDockerfileContextSources(t *testing.T) {
+	api := &buildAPI{}
+	content := "FROM alpine:3.19\nCOPY --from=golang:1.22 /usr/local/go /go\nCOPY main.go /app/\n"
+	var err error
+	captureStdout(t, func() {
+		_, err = newTestClient(api).Build(context.Background(), "", BuildOptions{Dockerfile: []byte(content)})
+	})
+	if !errors.Is(err, ImageBuildErr) || !errors.Is(err, NoBuildContextErr) {
+		t.Fatalf("err = %v, want it to match %v and %v", err, ImageBuildErr, NoBuildContextErr)
+	}
+	if !strings.Contains(err.Error(), "line 3: COPY main.go") || strings.Contains(err.Error(), "/usr/local/go") {
+		t.Errorf("err = %v, want only the COPY of line 3 listed", err)
+	}
+	if api.builds != 0 {
+		t.Errorf("a build was sent to the daemon")
+	}
+}
+
+func TestCompressContext(t *testing.T) {
+	content := bytes.Repeat([]byte("docker-runner "), 1024)
+	for _, level := range []int{0, 1, 5, 9} {
+		t.Run(fmt.Sprint(level), func(t *testing.T) {
+			r, err := compressContext(bytes.NewReader(content), level)
+			if err != nil {
+				t.Fatalf("compressContext: %v", err)
+			}
+			defer func() {
+				_ = r.Close()
+			}()
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatalf("gzip.NewReader: %v", err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("decompressing: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("decompressed %d bytes, want the %d bytes of the context", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestCompressContextInvalidLevel(t *testing.T) {
+	for _, level := range []int{-1, 10} {
+		if _, err := compressContext(strings.NewReader(""), level); !errors.Is(err, InvalidCompressionLevelErr) {
+			t.Errorf("compressContext(%d) err = %v, want %v", level, err, InvalidCompressionLevelErr)
+		}
+	}
+}
+
+func TestCompressContextReadErr(t *testing.T) {
+	r, err := compressContext(iotest.ErrReader(errors.New("disk error")), 1)
+	if err != nil {
+		t.Fatalf("compressContext: %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ContextFilesReadErr) {
+		t.Errorf("read err = %v, want %v", err, ContextFilesReadErr)
+	}
+}
+
+func TestBuildCompressedContext(t *testing.T) {
+	fsys := fstest.MapFS{
+		dockerfileName: {Data: []byte("FROM alpine:3.19\nCOPY . /app\n")},
+		"main.go":      {Data: []byte("package main\n")},
+	}
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{name: "plain", compress: false},
+		{name: "compressed", compress: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			if _, err := newTestClient(api).BuildFS(context.Background(), fsys, BuildOptions{Compress: tt.compress, CompressionLevel: 9}); err != nil {
+				t.Fatalf("BuildFS: %v", err)
+			}
+			req := api.lastBuild(t)
+			if req.Gzipped != tt.compress {
+				t.Errorf("Gzipped = %v, want %v", req.Gzipped, tt.compress)
+			}
+			if got := sortedNames(req.Names); !equalStrings(got, []string{dockerfileName, "main.go"}) {
+				t.Errorf("context entries = %v", got)
+			}
+		})
+	}
+}
+
+func TestBuildMaxContextFiles(t *testing.T) {
+	tree := map[string]string{
+		dockerignoreFile: "node_modules\n",
+		dockerfileName:   "FROM alpine:3.19\nCOPY . /app\n",
+		"src/main.go":    "package main\n",
+		"src/util.go":    "package main\n",
+		"node_modules/a": "x",
+		"node_modules/b": "x",
+	}
+	tests := []struct {
+		name    string
+		max     int
+		wantErr bool
+	}{
+		{name: "no limit", max: 0},
+		// the folders and the ignored files aren't counted
+		{name: "at the limit", max: 4},
+		{name: "over the limit", max: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			_, err := testBuild(t, api, tree, BuildOptions{MaxContextFiles: tt.max})
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Build: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ImageBuildErr) || !errors.Is(err, ContextTooLargeErr) {
+				t.Fatalf("err = %v, want it to match %v and %v", err, ImageBuildErr, ContextTooLargeErr)
+			}
+			if !strings.Contains(err.Error(), "4 files, the limit is 3") {
+				t.Errorf("err = %v, want the file count and limit", err)
+			}
+			if api.builds != 0 {
+				t.Errorf("a build was sent to the daemon")
+			}
+		})
+	}
+}
+
+// linkFS is a MapFS reading its symlinks from their data, as the
+// MapFS of older Go versions can't
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (f linkFS) ReadLink(name string) (string, error) {
+	return string(f.MapFS[name].Data), nil
+}
+
+func TestBuildFS(t *testing.T) {
+	files := fstest.MapFS{
+		dockerfileName:   {Data: []byte("FROM alpine:3.19\nCOPY . /app\n")},
+		"static/app.js":  {Data: []byte("console.log(1)\n"), Mode: 0o644},
+		"bin/run.sh":     {Data: []byte("#!/bin/sh\n"), Mode: 0o755},
+		"static/current": {Data: []byte("app.js"), Mode: fs.ModeSymlink | 0o777},
+	}
+	api := &buildAPI{}
+	if _, err := newTestClient(api).BuildFS(context.Background(), linkFS{files}, BuildOptions{}); err != nil {
+		t.Fatalf("BuildFS: %v", err)
+	}
+	req := api.lastBuild(t)
+	want := []string{dockerfileName, "bin/", "bin/run.sh", "static/", "static/app.js", "static/current"}
+	if !equalStrings(req.Names, want) {
+		t.Errorf("entries = %v, want %v", req.Names, want)
+	}
+	if h := req.Headers["bin/run.sh"]; h.Mode&0o777 != 0o755 || req.Files["bin/run.sh"] != "#!/bin/sh\n" {
+		t.Errorf("run.sh = %o %q, want the executable script", h.Mode, req.Files["bin/run.sh"])
+	}
+	if h := req.Headers["static/current"]; h.Typeflag != tar.TypeSymlink || h.Linkname != "app.js" {
+		t.Errorf("current = %c -> %q, want a symlink to app.js", h.Typeflag, h.Linkname)
+	}
+}
+
+// noLinkFS hides the ReadLink method of its fs.FS
+type noLinkFS struct {
+	fs.FS
+}
+
+func TestBuildFSSymlinksUnsupported(t *testing.T) {
+	fsys := fstest.MapFS{
+		dockerfileName: {Data: []byte("FROM alpine:3.19\n")},
+		"app.js":       {},
+		"current":      {Data: []byte("app.js"), Mode: fs.ModeSymlink | 0o777},
+	}
+	_, err := newTestClient(&buildAPI{}).BuildFS(context.Background(), noLinkFS{fsys}, BuildOptions{})
+	if !errors.Is(err, ContextFilesReadErr) || !strings.Contains(err.Error(), "symlinks are not supported") {
+		t.Errorf("err = %v, want the unsupported symlink", err)
+	}
+}
+
+func TestContextFSReadLink(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"app.js": ""})
+	if err := os.Symlink("app.js", filepath.Join(dir, "current")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	got, err := readLink(contextFS(dir), "current")
+	if err != nil || got != "app.js" {
+		t.Errorf("readLink = %q, %v, want app.js", got, err)
+	}
+}
+
+func TestTarDirectoryEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".keep":        "",
+		"pkg/__init__": "",
+		"main.py":      "print(1)\n",
+	})
+	r, err := tarDirectory(dir, tarOptions{})
+	if err != nil {
+		t.Fatalf("tarDirectory: %v", err)
+	}
+	req := readTar(t, r)
+	for _, name := range []string{".keep", "pkg/__init__"} {
+		h, ok := req.Headers[name]
+		if !ok {
+			t.Fatalf("no %s entry in %v", name, req.Names)
+		}
+		if h.Typeflag != tar.TypeReg || h.Size != 0 || req.Files[name] != "" {
+			t.Errorf("%s = %c of %d bytes %q, want a header-only regular file", name, h.Typeflag, h.Size, req.Files[name])
+		}
+	}
+	if req.Files["main.py"] != "print(1)\n" {
+		t.Errorf("main.py content = %q, the entries after the empty ones are corrupted", req.Files["main.py"])
+	}
+}
+
+func TestTarFSEmptyFileGrown(t *testing.T) {
+	// the empty file got content after its header was written
+	fsys := growingFS{MapFS: fstest.MapFS{"empty": {}}, name: "empty", content: "late"}
+	r, err := tarFS(fsys, tarOptions{})
+	if err != nil {
+		t.Fatalf("tarFS: %v", err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	if _, err := io.ReadAll(r); !errors.Is(err, ContextFilesReadErr) {
+		t.Errorf("read err = %v, want %v", err, ContextFilesReadErr)
+	}
+}
+
+// growingFS serves the MapFS, but the file name reads content, whatever
+// its size
+type growingFS struct {
+	fstest.MapFS
+	name, content string
+}
+
+func (f growingFS) Open(name string) (fs.File, error) {
+	file, err := f.MapFS.Open(name)
+	if err != nil || name != f.name {
+		return file, err
+	}
+	return grownFile{File: file, content: strings.NewReader(f.content)}, nil
+}
+
+// grownFile is a file reading content instead of its own data
+type grownFile struct {
+	fs.File
+	content io.Reader
+}
+
+func (f grownFile) Read(p []byte) (int, error) {
+	return f.content.Read(p)
+}
+
+func TestDockerfileFS(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want []string
+	}{
+		{
+			name: "replaced",
+			fsys: fstest.MapFS{dockerfileName: {Data: []byte("FROM scratch\n")}, "a.txt": {}, "z.txt": {}},
+			want: []string{dockerfileName, "a.txt", "z.txt"},
+		},
+		{
+			name: "added",
+			fsys: fstest.MapFS{"a.txt": {}, "sub/b.txt": {}},
+			want: []string{dockerfileName, "a.txt", "sub"},
+		},
+		{name: "empty context", fsys: fstest.MapFS{}, want: []string{dockerfileName}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := dockerfileFS{FS: tt.fsys, content: []byte("FROM alpine:3.19\n")}
+			entries, err := fs.ReadDir(fsys, ".")
+			if err != nil {
+				t.Fatalf("ReadDir: %v", err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if !equalStrings(names, tt.want) {
+				t.Errorf("entries = %v, want %v", names, tt.want)
+			}
+			b, err := fs.ReadFile(fsys, dockerfileName)
+			if err != nil || string(b) != "FROM alpine:3.19\n" {
+				t.Errorf("Dockerfile = %q, %v, want the in-memory one", b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	ContentDigestErr = errors.New("failed to compute content digest")
+)
+
+// tarModeMask keeps the permission, setuid, setgid and sticky bits
+const tarModeMask = 0o7777
+
+// digestTar computes the content digest of a tar stream while it is
+// read. Each entry is hashed from its name (without prefix), type,
+// mode, link target and content, and the entry hashes are combined
+// sorted by name, so the digest does not depend on times, owners,
+// entry order or the archive encoding.
+func digestTar(r io.Reader, prefix string) (digest.Digest, error) {
+	entries := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ContentDigestErr, err)
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(h.Name, "./"), prefix)
+		if name == "" || name == "/" {
+			continue
+		}
+		typeflag := h.Typeflag
+		if typeflag == tar.TypeRegA {
+			typeflag = tar.TypeReg
+		}
+		if typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+			name += "/"
+		}
+
+		link := h.Linkname
+		if typeflag == tar.TypeLink {
+			// hardlink targets are entry names too
+			link = strings.TrimPrefix(strings.TrimPrefix(link, "./"), prefix)
+		}
+
+		hash := sha256.New()
+		_, _ = fmt.Fprintf(hash, "%s\x00%c\x00%o\x00%s\x00", name, typeflag, h.Mode&tarModeMask, link)
+		if _, err := io.Copy(hash, tr); err != nil {
+			return "", fmt.Errorf("%w (reading %s): %w", ContentDigestErr, name, err)
+		}
+		entries[name] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	digester := digest.Canonical.Digester()
+	for _, name := range names {
+		_, _ = fmt.Fprintf(digester.Hash(), "%s %s\n", entries[name], name)
+	}
+	return digester.Digest(), nil
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+)
+
+type tarEntry struct {
+	name    string
+	mode    int64
+	content string
+	modTime time.Time
+	uid     int
+	dir     bool
+}
+
+func makeTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.content)), ModTime: e.modTime, Uid: e.uid, Typeflag: tar.TypeReg}
+		if e.dir {
+			h.Typeflag = tar.TypeDir
+			h.Size = 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDigestTar(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	base := makeTar(t,
+		tarEntry{name: "seed/", mode: 0o755, dir: true},
+		tarEntry{name: "seed/users.sql", mode: 0o644, content: "INSERT INTO users VALUES (1);"},
+		tarEntry{name: "README", mode: 0o644, content: "fixtures"},
+	)
+	digest, err := digestTar(bytes.NewReader(base), "")
+	if err != nil {
+		t.Fatalf("digestTar: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tar    []byte
+		prefix string
+		same   bool
+	}{
+		{
+			name: "other order, times and owners",
+			tar: makeTar(t,
+				tarEntry{name: "README", mode: 0o644, content: "fixtures", modTime: now, uid: 1000},
+				tarEntry{name: "seed", mode: 0o755, dir: true, modTime: now},
+				tarEntry{name: "./seed/users.sql", mode: 0o100644, content: "INSERT INTO users VALUES (1);", uid: 1000},
+			),
+			same: true,
+		},
+		{
+			name: "copied back from the volume",
+			tar: makeTar(t,
+				tarEntry{name: "data/", mode: 0o755, dir: true},
+				tarEntry{name: "data/seed/", mode: 0o755, dir: true},
+				tarEntry{name: "data/seed/users.sql", mode: 0o644, content: "INSERT INTO users VALUES (1);"},
+				tarEntry{name: "data/README", mode: 0o644, content: "fixtures"},
+			),
+			prefix: "data/",
+			same:   true,
+		},
+		{
+			name: "content changed",
+			tar: makeTar(t,
+				tarEntry{name: "seed/", mode: 0o755, dir: true},
+				tarEntry{name: "seed/users.sql", mode: 0o644, content: "INSERT INTO users VALUES (2);"},
+				tarEntry{name: "README", mode: 0o644, content: "fixtures"},
+			),
+		},
+		{
+			name: "mode changed",
+			tar: makeTar(t,
+				tarEntry{name: "seed/", mode: 0o755, dir: true},
+				tarEntry{name: "seed/users.sql", mode: 0o600, content: "INSERT INTO users VALUES (1);"},
+				tarEntry{name: "README", mode: 0o644, content: "fixtures"},
+			),
+		},
+		{
+			name: "file renamed",
+			tar: makeTar(t,
+				tarEntry{name: "seed/", mode: 0o755, dir: true},
+				tarEntry{name: "seed/accounts.sql", mode: 0o644, content: "INSERT INTO users VALUES (1);"},
+				tarEntry{name: "README", mode: 0o644, content: "fixtures"},
+			),
+		},
+		{
+			name: "file added",
+			tar: makeTar(t,
+				tarEntry{name: "seed/", mode: 0o755, dir: true},
+				tarEntry{name: "seed/users.sql", mode: 0o644, content: "INSERT INTO users VALUES (1);"},
+				tarEntry{name: "README", mode: 0o644, content: "fixtures"},
+				tarEntry{name: "LICENSE", mode: 0o644},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := digestTar(bytes.NewReader(tt.tar), tt.prefix)
+			if err != nil {
+				t.Fatalf("digestTar: %v", err)
+			}
+			if (got == digest) != tt.same {
+				t.Errorf("digest = %s, base %s, want same %v", got, digest, tt.same)
+			}
+		})
+	}
+}
+
+func TestDigestTarCorrupted(t *testing.T) {
+	b := makeTar(t, tarEntry{name: "README", mode: 0o644, content: "fixtures"})
+	if _, err := digestTar(bytes.NewReader(b[:515]), ""); err == nil {
+		t.Error("digestTar of a truncated archive succeeded")
+	}
+}
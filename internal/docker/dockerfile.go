@@ -0,0 +1,349 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+var (
+	DockerfileParseErr = errors.New("failed to parse Dockerfile")
+	NoBuildContextErr  = errors.New("dockerfile references context files but there is no context")
+	MissingSourceErr   = errors.New("dockerfile source not found in the build context")
+	UnknownTargetErr   = errors.New("unknown build target stage")
+)
+
+// utf8BOM is the byte order mark some editors save UTF-8 files with
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// dockerfile is a parsed Dockerfile
+type dockerfile struct {
+	// Stages are the FROM blocks, in order
+	Stages []instructions.Stage
+	// MetaArgs are the ARG instructions before the first FROM
+	MetaArgs []instructions.ArgCommand
+}
+
+// parseDockerfile parses the Dockerfile content of r
+func parseDockerfile(r io.Reader) (*dockerfile, error) {
+	res, err := parser.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", DockerfileParseErr, err)
+	}
+	stages, metaArgs, err := instructions.Parse(res.AST)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", DockerfileParseErr, err)
+	}
+	return &dockerfile{Stages: stages, MetaArgs: metaArgs}, nil
+}
+
+// readDockerfile parses the Dockerfile of the context fsys
+func readDockerfile(fsys fs.FS) (*dockerfile, error) {
+	f, err := fsys.Open(dockerfileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", DockerfileNotFoundErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return parseDockerfile(f)
+}
+
+// dockerfileEncodingIssues tells the UTF-8 BOM and CRLF line endings
+// of the Dockerfile content, which confuse older parsers
+func dockerfileEncodingIssues(content []byte) []string {
+	var issues []string
+	if bytes.HasPrefix(content, utf8BOM) {
+		issues = append(issues, "a UTF-8 BOM")
+	}
+	if n := bytes.Count(content, []byte("\r\n")); n > 0 {
+		issues = append(issues, fmt.Sprintf("CRLF line endings (%d lines)", n))
+	}
+	return issues
+}
+
+// normalizeDockerfile strips the UTF-8 BOM of the Dockerfile content
+// and converts its CRLF line endings to LF
+func normalizeDockerfile(content []byte) []byte {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// checkDockerfileEncoding warns about the encoding issues of the
+// Dockerfile content, returning it normalized if asked for
+func checkDockerfileEncoding(out io.Writer, content []byte, normalize bool) []byte {
+	issues := dockerfileEncodingIssues(content)
+	if len(issues) == 0 {
+		return content
+	}
+	if normalize {
+		_, _ = fmt.Fprintf(out, "Normalized the Dockerfile, which had %s\n", strings.Join(issues, " and "))
+		return normalizeDockerfile(content)
+	}
+	_, _ = fmt.Fprintf(out, "WARNING: the Dockerfile has %s, which confuse older parsers (--normalize-dockerfile fixes it for the build)\n", strings.Join(issues, " and "))
+	return content
+}
+
+// ArgDefaults returns the default value of each declared ARG (nil when
+// declared without one). The first declaration with a default wins.
+func (d *dockerfile) ArgDefaults() map[string]*string {
+	defaults := make(map[string]*string)
+	add := func(a instructions.ArgCommand) {
+		for _, kv := range a.Args {
+			if v, ok := defaults[kv.Key]; !ok || v == nil {
+				defaults[kv.Key] = kv.Value
+			}
+		}
+	}
+	for _, a := range d.MetaArgs {
+		add(a)
+	}
+	for _, s := range d.Stages {
+		for _, cmd := range s.Commands {
+			if a, ok := cmd.(*instructions.ArgCommand); ok {
+				add(*a)
+			}
+		}
+	}
+	return defaults
+}
+
+// checkTarget fails if target isn't the name of a stage (stage names
+// are case insensitive)
+func (d *dockerfile) checkTarget(target string) error {
+	if target == "" {
+		return nil
+	}
+	names := make([]string, 0, len(d.Stages))
+	for _, s := range d.Stages {
+		if strings.EqualFold(s.Name, target) {
+			return nil
+		}
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+	}
+	return fmt.Errorf("%w: %q (stages: %s)", UnknownTargetErr, target, strings.Join(names, ", "))
+}
+
+// expandMetaArgs expands the references to the ARG defaults declared
+// before the first FROM, leaving the others as is
+func (d *dockerfile) expandMetaArgs(s string) string {
+	defaults := map[string]string{}
+	for _, a := range d.MetaArgs {
+		for _, kv := range a.Args {
+			if kv.Value != nil {
+				defaults[kv.Key] = *kv.Value
+			}
+		}
+	}
+	return os.Expand(s, func(k string) string {
+		if v, ok := defaults[k]; ok {
+			return v
+		}
+		return "$" + k
+	})
+}
+
+// baseImages returns the images the stages are built on, once each,
+// leaving out scratch, the earlier stages and the unresolved ARG
+// references
+func (d *dockerfile) baseImages() []string {
+	var res []string
+	seen := map[string]bool{"scratch": true}
+	for i, s := range d.Stages {
+		base := d.expandMetaArgs(s.BaseName)
+		stage := false
+		for _, prev := range d.Stages[:i] {
+			if prev.Name != "" && strings.EqualFold(prev.Name, base) {
+				stage = true
+				break
+			}
+		}
+		if stage || seen[base] || strings.Contains(base, "$") {
+			continue
+		}
+		seen[base] = true
+		res = append(res, base)
+	}
+	return res
+}
+
+// StageInfo describes a Dockerfile stage
+type StageInfo struct {
+	// Index is the stage position, from 0
+	Index int
+	// Name is the FROM ... AS name (empty if unnamed)
+	Name string
+	// Base is the FROM image or stage, as written
+	Base string
+	// BaseStage tells if Base is an earlier stage
+	BaseStage bool
+	// Platform is the FROM --platform value
+	Platform string
+	// Line is the FROM line number
+	Line int
+}
+
+// ReadStages parses the Dockerfile of src (and subdir) and returns its
+// stages, in order
+func ReadStages(src, subdir string) ([]StageInfo, error) {
+	root, err := contextRoot(src, subdir)
+	if err != nil {
+		return nil, err
+	}
+	df, err := readDockerfile(contextFS(root))
+	if err != nil {
+		return nil, err
+	}
+	return df.StageInfos(), nil
+}
+
+// StageInfos returns the description of each stage
+func (d *dockerfile) StageInfos() []StageInfo {
+	res := make([]StageInfo, 0, len(d.Stages))
+	for i, s := range d.Stages {
+		info := StageInfo{Index: i, Name: s.Name, Base: s.BaseName, Platform: s.Platform}
+		if len(s.Location) > 0 {
+			info.Line = s.Location[0].Start.Line
+		}
+		for _, prev := range d.Stages[:i] {
+			if prev.Name != "" && strings.EqualFold(prev.Name, s.BaseName) {
+				info.BaseStage = true
+				break
+			}
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+// effectiveBuildArgs merges the Dockerfile ARG defaults with the build
+// args given, which take precedence. Args without a value are left out.
+func effectiveBuildArgs(defaults, args map[string]*string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range defaults {
+		if v != nil {
+			res[k] = *v
+		}
+	}
+	for k, v := range args {
+		if v != nil {
+			res[k] = *v
+		}
+	}
+	return res
+}
+
+// contextSource is a COPY/ADD source read from the build context
+type contextSource struct {
+	Line        int
+	Instruction string
+	Source      string
+}
+
+func (s contextSource) String() string {
+	return fmt.Sprintf("line %d: %s %s", s.Line, s.Instruction, s.Source)
+}
+
+// ContextSources returns the COPY and ADD sources read from the build
+// context, leaving out copies from other stages or images, heredocs and
+// ADD URLs
+func (d *dockerfile) ContextSources() []contextSource {
+	var res []contextSource
+	for _, s := range d.Stages {
+		for _, cmd := range s.Commands {
+			var paths []string
+			switch c := cmd.(type) {
+			case *instructions.CopyCommand:
+				if c.From == "" {
+					paths = c.SourcePaths
+				}
+			case *instructions.AddCommand:
+				for _, p := range c.SourcePaths {
+					if !isRemoteSource(p) {
+						paths = append(paths, p)
+					}
+				}
+			}
+			line := 0
+			if loc := cmd.Location(); len(loc) > 0 {
+				line = loc[0].Start.Line
+			}
+			for _, p := range paths {
+				res = append(res, contextSource{Line: line, Instruction: strings.ToUpper(cmd.Name()), Source: p})
+			}
+		}
+	}
+	return res
+}
+
+// isRemoteSource tells if an ADD source is fetched by the builder
+// (URL or git repository) instead of read from the context
+func isRemoteSource(src string) bool {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "git@") {
+		return true
+	}
+	_, ok := parseRemoteContext(src)
+	return ok
+}
+
+// noContextError lists the context sources of a Dockerfile built
+// without context
+func noContextError(sources []contextSource) error {
+	lines := make([]string, 0, len(sources))
+	for _, s := range sources {
+		lines = append(lines, s.String())
+	}
+	return fmt.Errorf("%w (inline Dockerfiles are built without context, use a source folder instead): %s", NoBuildContextErr, strings.Join(lines, "; "))
+}
+
+// checkContextSources fails if a COPY/ADD source matches no entry of
+// the build context fsys, telling the sources excluded by the ignore
+// patterns apart. Sources using build args or env vars aren't checked.
+func checkContextSources(fsys fs.FS, sources []contextSource, entries map[string]fs.DirEntry) error {
+	var missing []string
+	for _, s := range sources {
+		if strings.Contains(s.Source, "$") {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(s.Source, "/"))
+		if name == "." || name == "" || contextHasSource(entries, name) {
+			continue
+		}
+		msg := s.String()
+		if _, err := fs.Stat(fsys, name); err == nil {
+			msg += " (excluded by " + dockerignoreFile + ")"
+		}
+		missing = append(missing, msg)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", MissingSourceErr, strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// contextHasSource tells if the source name, which can be a glob,
+// matches an entry of the context
+func contextHasSource(entries map[string]fs.DirEntry, name string) bool {
+	if _, ok := entries[name]; ok {
+		return true
+	}
+	if !strings.ContainsAny(name, "*?[") {
+		return false
+	}
+	for e := range entries {
+		if ok, _ := path.Match(name, e); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// derefArgs returns the values of args, "<nil>" for the ones without
+func derefArgs(args map[string]*string) map[string]string {
+	res := make(map[string]string, len(args))
+	for k, v := range args {
+		if v == nil {
+			res[k] = "<nil>"
+			continue
+		}
+		res[k] = *v
+	}
+	return res
+}
+
+func TestArgDefaults(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader(`ARG BASE=alpine:3.19
+ARG VERSION
+FROM ${BASE}
+ARG VERSION=1.0
+ARG BASE=ignored
+ARG DEBUG
+FROM scratch
+ARG DEBUG=false
+`))
+	if err != nil {
+		t.Fatalf("parseDockerfile: %v", err)
+	}
+	want := map[string]string{
+		"BASE":    "alpine:3.19",
+		"VERSION": "1.0",
+		"DEBUG":   "false",
+	}
+	if got := derefArgs(df.ArgDefaults()); !reflect.DeepEqual(got, want) {
+		t.Errorf("ArgDefaults = %v, want %v", got, want)
+	}
+}
+
+func TestArgDefaultsWithoutValue(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader("FROM alpine:3.19\nARG TOKEN\n"))
+	if err != nil {
+		t.Fatalf("parseDockerfile: %v", err)
+	}
+	defaults := df.ArgDefaults()
+	if v, ok := defaults["TOKEN"]; !ok || v != nil {
+		t.Errorf("ArgDefaults = %v, want TOKEN declared without a value", derefArgs(defaults))
+	}
+}
+
+func TestEffectiveBuildArgs(t *testing.T) {
+	defaults := map[string]*string{
+		"VERSION": strPtr("1.0"),
+		"DEBUG":   strPtr("false"),
+		"TOKEN":   nil,
+	}
+	args := map[string]*string{
+		"VERSION": strPtr("2.0"),
+		"EXTRA":   strPtr("x"),
+		"DEBUG":   nil,
+	}
+	want := map[string]string{
+		"VERSION": "2.0",
+		"DEBUG":   "false",
+		"EXTRA":   "x",
+	}
+	if got := effectiveBuildArgs(defaults, args); !reflect.DeepEqual(got, want) {
+		t.Errorf("effectiveBuildArgs = %v, want %v", got, want)
+	}
+}
+
+func TestBuildResultBuildArgs(t *testing.T) {
+	res, err := testBuild(t, &buildAPI{}, map[string]string{dockerfileName: "FROM alpine:3.19\nARG VERSION=1.0\nARG DEBUG=false\nARG TOKEN\n"}, BuildOptions{
+		BuildArgs: map[string]*string{"DEBUG": strPtr("true")},
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	want := map[string]string{"VERSION": "1.0", "DEBUG": "true"}
+	if !reflect.DeepEqual(res.BuildArgs, want) {
+		t.Errorf("BuildArgs = %v, want %v", res.BuildArgs, want)
+	}
+}
+
+func TestContextSources(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader(`FROM golang:1.22 AS build
+COPY go.mod go.sum ./
+ADD https://example.com/tool.tgz /tmp/
+ADD git@github.com:org/repo.git /src
+ADD vendor.tgz /vendor/
+FROM alpine:3.19
+COPY --from=build /out/app /app
+COPY <<EOF /etc/motd
+hello
+EOF
+copy config.yml /etc/app/
+`))
+	if err != nil {
+		t.Fatalf("parseDockerfile: %v", err)
+	}
+	want := []string{
+		"line 2: COPY go.mod",
+		"line 2: COPY go.sum",
+		"line 5: ADD vendor.tgz",
+		"line 11: COPY config.yml",
+	}
+	var got []string
+	for _, s := range df.ContextSources() {
+		got = append(got, s.String())
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("ContextSources = %v, want %v", got, want)
+	}
+}
+
+func TestBuildChecksContextSources(t *testing.T) {
+	files := map[string]string{
+		dockerignoreFile:  "secrets.env\n",
+		"go.mod":          "module app\n",
+		"cmd/app/main.go": "package main\n",
+		"secrets.env":     "TOKEN=x\n",
+	}
+	tests := []struct {
+		name       string
+		dockerfile string
+		wantErr    bool
+		wantMsg    []string
+	}{
+		{name: "all found", dockerfile: "FROM golang:1.22\nCOPY go.mod ./\nCOPY ./cmd /src/cmd\nCOPY /cmd/app/main.go /src/\n"},
+		{name: "glob", dockerfile: "FROM golang:1.22\nCOPY *.mod ./\nCOPY cmd/*/main.go /src/\n"},
+		{name: "whole context", dockerfile: "FROM golang:1.22\nCOPY . /src\n"},
+		{name: "build arg", dockerfile: "FROM golang:1.22\nARG SRC=missing\nCOPY $SRC /src\n"},
+		{name: "other stage", dockerfile: "FROM golang:1.22 AS build\nFROM alpine:3.19\nCOPY --from=build /missing /app\n"},
+		{
+			name:       "missing",
+			dockerfile: "FROM golang:1.22\nCOPY go.sum ./\nCOPY *.txt ./\n",
+			wantErr:    true,
+			wantMsg:    []string{"line 2: COPY go.sum", "line 3: COPY *.txt"},
+		},
+		{
+			name:       "ignored",
+			dockerfile: "FROM golang:1.22\nCOPY secrets.env /etc/\n",
+			wantErr:    true,
+			wantMsg:    []string{"line 2: COPY secrets.env (excluded by .dockerignore)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := map[string]string{dockerfileName: tt.dockerfile}
+			for k, v := range files {
+				tree[k] = v
+			}
+			api := &buildAPI{}
+			_, err := testBuild(t, api, tree, BuildOptions{})
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Build: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ImageBuildErr) || !errors.Is(err, MissingSourceErr) {
+				t.Fatalf("err = %v, want it to match %v and %v", err, ImageBuildErr, MissingSourceErr)
+			}
+			for _, m := range tt.wantMsg {
+				if !strings.Contains(err.Error(), m) {
+					t.Errorf("err = %v, want it to list %q", err, m)
+				}
+			}
+			if api.builds != 0 {
+				t.Errorf("a build was sent to the daemon")
+			}
+		})
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader("FROM golang:1.22 AS Build\nFROM build AS test\nFROM alpine:3.19\n"))
+	if err != nil {
+		t.Fatalf("parseDockerfile: %v", err)
+	}
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{target: ""},
+		{target: "build"},
+		{target: "BUILD"},
+		{target: "test"},
+		{target: "release", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			err := df.checkTarget(tt.target)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("err = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, UnknownTargetErr) || !strings.Contains(err.Error(), "stages: build, test") {
+				t.Errorf("err = %v, want %v listing the stages", err, UnknownTargetErr)
+			}
+		})
+	}
+}
+
+func TestReadStages(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"app/" + dockerfileName: `ARG GO=1.22
+FROM golang:${GO} AS Build
+RUN go build ./...
+
+FROM --platform=linux/amd64 build AS test
+FROM alpine:3.19
+FROM test
+`,
+	})
+	got, err := ReadStages(dir, "app")
+	if err != nil {
+		t.Fatalf("ReadStages: %v", err)
+	}
+	want := []StageInfo{
+		{Index: 0, Name: "build", Base: "golang:${GO}", Line: 2},
+		{Index: 1, Name: "test", Base: "build", BaseStage: true, Platform: "linux/amd64", Line: 5},
+		{Index: 2, Base: "alpine:3.19", Line: 6},
+		{Index: 3, Base: "test", BaseStage: true, Line: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadStages =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestReadStagesErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadStages(dir, ""); !errors.Is(err, DockerfileNotFoundErr) {
+		t.Errorf("err = %v, want %v", err, DockerfileNotFoundErr)
+	}
+	if _, err := ReadStages(dir, "../outside"); !errors.Is(err, ContextDirReadErr) {
+		t.Errorf("err = %v, want %v", err, ContextDirReadErr)
+	}
+}
+
+func TestDockerfileEncodingIssues(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		want       []string
+		normalized string
+	}{
+		{name: "clean", content: "FROM alpine:3.19\nRUN true\n", normalized: "FROM alpine:3.19\nRUN true\n"},
+		{name: "bom", content: "\ufeffFROM alpine:3.19\n", want: []string{"a UTF-8 BOM"}, normalized: "FROM alpine:3.19\n"},
+		{name: "crlf", content: "FROM alpine:3.19\r\nRUN true\r\n", want: []string{"CRLF line endings (2 lines)"}, normalized: "FROM alpine:3.19\nRUN true\n"},
+		{name: "both", content: "\ufeffFROM alpine:3.19\r\nRUN true\n", want: []string{"a UTF-8 BOM", "CRLF line endings (1 lines)"}, normalized: "FROM alpine:3.19\nRUN true\n"},
+		{name: "lone cr", content: "FROM alpine:3.19\rRUN true\n", normalized: "FROM alpine:3.19\rRUN true\n"},
+		{name: "bom not at the start", content: "FROM alpine:3.19\n# \ufeff\n", normalized: "FROM alpine:3.19\n# \ufeff\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dockerfileEncodingIssues([]byte(tt.content)); !equalStrings(got, tt.want) {
+				t.Errorf("dockerfileEncodingIssues = %v, want %v", got, tt.want)
+			}
+			if got := string(normalizeDockerfile([]byte(tt.content))); got != tt.normalized {
+				t.Errorf("normalizeDockerfile = %q, want %q", got, tt.normalized)
+			}
+		})
+	}
+}
+
+func TestBuildDockerfileEncoding(t *testing.T) {
+	content := "\ufeffFROM alpine:3.19\r\nRUN true\r\n"
+	tests := []struct {
+		name      string
+		inline    bool
+		normalize bool
+		want      string
+		wantOut   string
+	}{
+		{name: "folder warns", want: content, wantOut: "WARNING: the Dockerfile has a UTF-8 BOM and CRLF line endings (2 lines)"},
+		{name: "folder normalized", normalize: true, want: "FROM alpine:3.19\nRUN true\n", wantOut: "Normalized the Dockerfile, which had a UTF-8 BOM and CRLF line endings (2 lines)"},
+		{name: "inline warns", inline: true, want: content, wantOut: "WARNING: the Dockerfile has a UTF-8 BOM"},
+		{name: "inline normalized", inline: true, normalize: true, want: "FROM alpine:3.19\nRUN true\n", wantOut: "Normalized the Dockerfile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			opts := BuildOptions{NormalizeDockerfile: tt.normalize}
+			var fsys fs.FS
+			if tt.inline {
+				opts.Dockerfile = []byte(content)
+			} else {
+				fsys = fstest.MapFS{dockerfileName: {Data: []byte(content)}}
+			}
+			var out strings.Builder
+			if _, err := newTestClient(api).build(context.Background(), fsContextName, fsys, opts, &out, nil); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("output = %q, want it to contain %q", out.String(), tt.wantOut)
+			}
+			if got := api.lastBuild(t).Files[dockerfileName]; got != tt.want {
+				t.Errorf("sent Dockerfile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCleanDockerfileNoWarning(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	var out strings.Builder
+	if _, err := newTestClient(&buildAPI{}).build(context.Background(), fsContextName, fsys, BuildOptions{}, &out, nil); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if strings.Contains(out.String(), "WARNING") || strings.Contains(out.String(), "Normalized") {
+		t.Errorf("output = %q, want no encoding message", out.String())
+	}
+}
+
+func TestBaseImages(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []string
+	}{
+		{name: "single stage", dockerfile: "FROM alpine:3.19\n", want: []string{"alpine:3.19"}},
+		{name: "scratch", dockerfile: "FROM scratch\n"},
+		{
+			name:       "stages",
+			dockerfile: "FROM golang:1.22 AS build\nFROM build AS test\nFROM alpine:3.19\nFROM golang:1.22\n",
+			want:       []string{"golang:1.22", "alpine:3.19"},
+		},
+		{
+			name:       "meta args",
+			dockerfile: "ARG GO=1.22\nARG BASE\nFROM golang:${GO} AS build\nFROM $BASE\n",
+			want:       []string{"golang:1.22"},
+		},
+		{name: "image named as a later stage", dockerfile: "FROM app\nFROM alpine:3.19 AS app\n", want: []string{"app", "alpine:3.19"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := parseDockerfile(strings.NewReader(tt.dockerfile))
+			if err != nil {
+				t.Fatalf("parseDockerfile: %v", err)
+			}
+			if got := df.baseImages(); !equalStrings(got, tt.want) {
+				t.Errorf("baseImages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
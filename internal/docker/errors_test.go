@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() error
+		want error
+	}{
+		{"pull policy", func() error { _, err := ParsePullPolicy("sometimes"); return err }, InvalidPullPolicyErr},
+		{"build output mode", func() error { _, err := ParseBuildOutputMode("loud"); return err }, InvalidBuildOutputErr},
+		{"output", func() error { _, err := ParseOutput("type=tar"); return err }, InvalidOutputErr},
+		{"teardown policy", func() error { _, err := ParseTeardownPolicy("later"); return err }, InvalidTeardownPolicyErr},
+		{"memory limit", func() error { _, err := ParseMemoryLimit("lots"); return err }, InvalidResourceLimitErr},
+		{"cpus", func() error { _, err := ParseCPUs("-1"); return err }, InvalidResourceLimitErr},
+		{"cpuset", func() error { _, err := ParseCPUSet("3-1"); return err }, InvalidResourceLimitErr},
+		{"capture size", func() error { _, err := ParseCaptureSize("big"); return err }, InvalidCaptureSizeErr},
+		{"ci metadata mode", func() error { _, err := ParseCIMetadataMode("always"); return err }, InvalidCIMetadataModeErr},
+		{"idle threshold", func() error { _, err := ParseIdleThreshold("soon"); return err }, InvalidThresholdErr},
+		{"init container", func() error { _, err := ParseInitContainer("alpine"); return err }, InvalidInitContainerErr},
+		{"mirror", func() error { _, _, err := ParseMirror("docker.io"); return err }, InvalidMirrorErr},
+		{"external network", func() error { _, err := ParseExternalNetwork("backend"); return err }, InvalidNetworkErr},
+		{"netns", func() error { _, err := ParseNetNS("db"); return err }, InvalidNetworkErr},
+		{"overlay", func() error { _, err := ParseOverlay("src:relative"); return err }, InvalidOverlayErr},
+		{"host key checking", func() error { _, err := ParseHostKeyChecking("maybe"); return err }, InvalidHostKeyCheckErr},
+		{"tls spec", func() error { _, err := ParseTLSSpec("dir=/tls"); return err }, InvalidTLSSpecErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// errAPI fakes a daemon failing every call with err
+type errAPI struct {
+	client.APIClient
+	err error
+}
+
+func (f errAPI) Info(_ context.Context) (system.Info, error) {
+	return system.Info{}, f.err
+}
+
+func (f errAPI) ServerVersion(_ context.Context) (types.Version, error) {
+	return types.Version{}, f.err
+}
+
+func (f errAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, f.err
+}
+
+func (f errAPI) ImagePull(_ context.Context, _ string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	return nil, f.err
+}
+
+func (f errAPI) ImagePush(_ context.Context, _ string, _ types.ImagePushOptions) (io.ReadCloser, error) {
+	return nil, f.err
+}
+
+func (f errAPI) ImageHistory(_ context.Context, _ string) ([]image.HistoryResponseItem, error) {
+	return nil, f.err
+}
+
+func (f errAPI) ContainerInspect(_ context.Context, _ string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{}, f.err
+}
+
+func (f errAPI) Ping(_ context.Context) (types.Ping, error) {
+	return types.Ping{}, f.err
+}
+
+// createErrAPI fakes a daemon with the images, failing the container
+// creations with err
+type createErrAPI struct {
+	client.APIClient
+	err error
+}
+
+func (f createErrAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, nil
+}
+
+func (f createErrAPI) ContainerCreate(_ context.Context, _ *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	return container.CreateResponse{}, f.err
+}
+
+func TestDaemonErrorsWrapSentinels(t *testing.T) {
+	useDockerConfig(t, nil)
+	useTestRetryPolicy(t)
+	daemonErr := errdefs.System(errors.New("daemon exploded"))
+	c := newTestClient(errAPI{err: daemonErr})
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		fn   func() error
+		want error
+	}{
+		{"default platform", func() error { _, err := c.DefaultPlatform(ctx); return err }, DaemonInfoErr},
+		{"server version", func() error { _, _, err := c.ServerVersion(ctx); return err }, DaemonInfoErr},
+		{"capabilities", func() error { _, err := c.Capabilities(ctx); return err }, DaemonInfoErr},
+		{"image summary", func() error { _, err := c.ImageSummary(ctx, "alpine"); return err }, ImageInspectErr},
+		{"pull", func() error { return c.Pull(ctx, "alpine") }, ImagePullErr},
+		{"push", func() error { _, err := c.Push(ctx, "registry.local/alpine:3.19"); return err }, ImagePushErr},
+		{"secret scan", func() error { _, err := c.ScanImageSecrets(ctx, "alpine", SecretScanOptions{}); return err }, SecretScanErr},
+		{"profile", func() error { _, err := c.Profile(ctx, "db", ProfileOptions{}); return err }, ProfileErr},
+		{"run", func() error {
+			_, err := newTestClient(createErrAPI{err: daemonErr}).Run(ctx, "alpine", RunOptions{})
+			return err
+		}, ContainerRunErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			// the daemon error is kept too, for the callers to branch on
+			if !errors.Is(err, daemonErr) {
+				t.Errorf("err = %v, doesn't wrap the daemon error", err)
+			}
+		})
+	}
+}
+
+func TestImageNotFoundErr(t *testing.T) {
+	c := newTestClient(errAPI{err: errdefs.NotFound(errors.New("no such image"))})
+	_, err := c.ImageSummary(context.Background(), "alpine")
+	if !errors.Is(err, ImageNotFoundErr) {
+		t.Errorf("err = %v, want %v", err, ImageNotFoundErr)
+	}
+}
+
+func TestImageRefErrorAs(t *testing.T) {
+	useDockerConfig(t, nil)
+	useTestRetryPolicy(t)
+	denied := errdefs.Unauthorized(errors.New("pull access denied"))
+	c := newTestClient(errAPI{err: denied})
+
+	tests := []struct {
+		name string
+		fn   func() error
+		kind error
+		ref  string
+	}{
+		{"pull", func() error { return c.Pull(context.Background(), "alpine:3.19") }, ImagePullErr, "alpine:3.19"},
+		{"push", func() error { _, err := c.Push(context.Background(), "registry.local/app:1"); return err }, ImagePushErr, "registry.local/app:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			var refErr *ImageRefError
+			if !errors.As(err, &refErr) {
+				t.Fatalf("err = %v (%T), want an *ImageRefError", err, err)
+			}
+			if refErr.Kind != tt.kind || refErr.Ref != tt.ref {
+				t.Errorf("ImageRefError = {%v %s}, want {%v %s}", refErr.Kind, refErr.Ref, tt.kind, tt.ref)
+			}
+			if !errors.Is(err, tt.kind) || !errors.Is(err, denied) {
+				t.Errorf("err = %v, want it to match %v and the daemon error", err, tt.kind)
+			}
+			if !errdefs.IsUnauthorized(refErr.Err) {
+				t.Errorf("ImageRefError.Err = %v, want the unauthorized daemon error", refErr.Err)
+			}
+		})
+	}
+}
+
+func TestBuildStepErrorAs(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   BuildStepError
+	}{
+		{
+			name:   "failing step",
+			stream: failedStream("The command '/bin/sh -c make' returned a non-zero code: 2", "FROM alpine:3.19", "RUN make"),
+			want:   BuildStepError{Step: 2, Total: 2, Instruction: "RUN make", Message: "The command '/bin/sh -c make' returned a non-zero code: 2"},
+		},
+		{
+			name:   "before the first step",
+			stream: failedStream("dockerfile parse error line 1: unknown instruction: FORM"),
+			want:   BuildStepError{Message: "dockerfile parse error line 1: unknown instruction: FORM"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{stream: tt.stream}
+			_, err := testBuild(t, api, map[string]string{dockerfileName: "FROM alpine:3.19\nRUN make\n"}, BuildOptions{})
+			if !errors.Is(err, ImageBuildErr) || !errors.Is(err, BuildStepErr) {
+				t.Fatalf("err = %v, want it to match %v and %v", err, ImageBuildErr, BuildStepErr)
+			}
+			var stepErr *BuildStepError
+			if !errors.As(err, &stepErr) {
+				t.Fatalf("err = %v, want a *BuildStepError", err)
+			}
+			if *stepErr != tt.want {
+				t.Errorf("BuildStepError = %+v, want %+v", *stepErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDaemonErr(t *testing.T) {
+	api := &buildAPI{buildErr: errdefs.System(errors.New("no space left on device"))}
+	_, err := testBuild(t, api, map[string]string{dockerfileName: "FROM alpine:3.19\n"}, BuildOptions{})
+	if !errors.Is(err, BuildDockerAPIErr) || !errors.Is(err, api.buildErr) {
+		t.Errorf("err = %v, want it to match %v and the daemon error", err, BuildDockerAPIErr)
+	}
+}
+
+func TestDockerfileNotFoundErr(t *testing.T) {
+	_, err := testBuild(t, &buildAPI{}, map[string]string{"main.go": ""}, BuildOptions{})
+	if !errors.Is(err, ImageBuildErr) || !errors.Is(err, DockerfileNotFoundErr) {
+		t.Errorf("err = %v, want it to match %v and %v", err, ImageBuildErr, DockerfileNotFoundErr)
+	}
+}
@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+var (
+	EstimateErr = errors.New("failed to estimate image size")
+)
+
+// EstimateOptions defines the image size estimate of a build
+type EstimateOptions struct {
+	// ContextSubdir is the folder, relative to the source one, used as
+	// the build context root
+	ContextSubdir string
+	// Target is the stage estimated (the last one if empty)
+	Target string
+	// Pull pulls the base image when it isn't local, its size being
+	// unknown otherwise
+	Pull bool
+}
+
+// SizeEstimate is the ballpark size of the image a build produces: the
+// base image size plus the build context size, a rough proxy of what
+// the stages add
+type SizeEstimate struct {
+	// Base is the base image of the estimated stage, following the
+	// stages it is built on
+	Base string
+	// BaseSize is the base image size (0 for scratch or if unknown)
+	BaseSize int64
+	// BaseKnown tells if the base image size is known (i.e. local)
+	BaseKnown bool
+	// BaseRemote tells if the registry has the base image, when it
+	// isn't local
+	BaseRemote bool
+	// ContextBytes is the size of the build context files, once the
+	// ignore rules are applied
+	ContextBytes int64
+	ContextFiles int
+}
+
+// Total is the estimated image size
+func (e SizeEstimate) Total() int64 {
+	return e.BaseSize + e.ContextBytes
+}
+
+// EstimateImageSize estimates the size of the image built from src,
+// with the metadata of the base image: the local one, or the registry
+// one when missing (its size is then unknown unless pulled)
+func (c Client) EstimateImageSize(ctx context.Context, src string, opts EstimateOptions) (SizeEstimate, error) {
+	root, err := contextRoot(src, opts.ContextSubdir)
+	if err != nil {
+		return SizeEstimate{}, err
+	}
+	fsys := contextFS(root)
+	df, err := readDockerfile(fsys)
+	if err != nil {
+		return SizeEstimate{}, err
+	}
+	if err := df.checkTarget(opts.Target); err != nil {
+		return SizeEstimate{}, err
+	}
+	entries, err := contextEntries(fsys, buildContextTarOptions(BuildOptions{}))
+	if err != nil {
+		return SizeEstimate{}, fmt.Errorf("%w: %w", EstimateErr, err)
+	}
+	e := SizeEstimate{Base: df.baseImage(opts.Target)}
+	for _, d := range entries {
+		if d.IsDir() {
+			continue
+		}
+		i, err := d.Info()
+		if err != nil {
+			return SizeEstimate{}, fmt.Errorf("%w: %w", EstimateErr, err)
+		}
+		e.ContextFiles++
+		e.ContextBytes += i.Size()
+	}
+	if e.Base == "scratch" {
+		e.BaseKnown = true
+		return e, nil
+	}
+	if strings.Contains(e.Base, "$") {
+		return e, nil
+	}
+	if opts.Pull {
+		if err := c.ensureImage(ctx, e.Base, "", PullMissing); err != nil {
+			return SizeEstimate{}, fmt.Errorf("%w: %w", EstimateErr, err)
+		}
+	}
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, e.Base)
+	if err == nil {
+		e.BaseSize, e.BaseKnown = inspect.Size, true
+		return e, nil
+	}
+	if !client.IsErrNotFound(err) {
+		return SizeEstimate{}, fmt.Errorf("%w: %w", EstimateErr, err)
+	}
+	// the registry only tells the manifest exists, not the layer sizes
+	if _, err := c.d.DistributionInspect(ctx, e.Base, ""); err != nil {
+		return SizeEstimate{}, fmt.Errorf("%w: %w: %s: %w", EstimateErr, ImageNotFoundErr, e.Base, err)
+	}
+	e.BaseRemote = true
+	return e, nil
+}
+
+// baseImage returns the image the target stage (the last one if empty)
+// is built on, following the stages it's based on, with the meta ARG
+// defaults expanded
+func (d *dockerfile) baseImage(target string) string {
+	if len(d.Stages) == 0 {
+		return ""
+	}
+	idx := len(d.Stages) - 1
+	for i, s := range d.Stages {
+		if target != "" && strings.EqualFold(s.Name, target) {
+			idx = i
+			break
+		}
+	}
+	base := d.expandMetaArgs(d.Stages[idx].BaseName)
+	// a stage can only be based on the ones before it
+	for found := true; found; {
+		found = false
+		for i := idx - 1; i >= 0; i-- {
+			if s := d.Stages[i]; s.Name != "" && strings.EqualFold(s.Name, base) {
+				idx, base, found = i, d.expandMetaArgs(s.BaseName), true
+				break
+			}
+		}
+	}
+	return base
+}
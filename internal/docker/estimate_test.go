@@ -0,0 +1,188 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// estimateAPI fakes a daemon with the local images, by ref, and a
+// registry with the remote ones
+type estimateAPI struct {
+	client.APIClient
+	local  map[string]int64
+	remote map[string]int64
+	pulls  []string
+}
+
+func (f *estimateAPI) ImageInspectWithRaw(_ context.Context, ref string) (types.ImageInspect, []byte, error) {
+	size, ok := f.local[ref]
+	if !ok {
+		return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image: " + ref))
+	}
+	return types.ImageInspect{ID: testImageID, Size: size}, nil, nil
+}
+
+func (f *estimateAPI) DistributionInspect(_ context.Context, ref, _ string) (registry.DistributionInspect, error) {
+	if _, ok := f.remote[ref]; !ok {
+		return registry.DistributionInspect{}, errdefs.NotFound(errors.New("manifest unknown"))
+	}
+	return registry.DistributionInspect{}, nil
+}
+
+func (f *estimateAPI) ImagePull(_ context.Context, ref string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	f.pulls = append(f.pulls, ref)
+	size, ok := f.remote[ref]
+	if !ok {
+		return nil, errdefs.NotFound(errors.New("manifest unknown"))
+	}
+	f.local[ref] = size
+	return io.NopCloser(strings.NewReader(`{"status":"Pull complete"}` + "\n")), nil
+}
+
+func TestBaseImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		target     string
+		want       string
+	}{
+		{name: "single stage", dockerfile: "FROM alpine:3.19\n", want: "alpine:3.19"},
+		{name: "last stage", dockerfile: "FROM golang:1.22 AS build\nFROM alpine:3.19\n", want: "alpine:3.19"},
+		{name: "target", dockerfile: "FROM golang:1.22 AS build\nFROM alpine:3.19\n", target: "BUILD", want: "golang:1.22"},
+		{name: "stage chain", dockerfile: "FROM golang:1.22 AS base\nFROM base AS build\nFROM build\n", want: "golang:1.22"},
+		{name: "meta arg", dockerfile: "ARG GO=1.22\nFROM golang:${GO}\n", want: "golang:1.22"},
+		{name: "meta arg in chain", dockerfile: "ARG BASE=debian:12\nFROM $BASE AS base\nFROM base\n", want: "debian:12"},
+		{name: "meta arg without default", dockerfile: "ARG BASE\nFROM $BASE\n", want: "$BASE"},
+		{name: "later stage of the same name", dockerfile: "FROM build\nFROM alpine:3.19 AS build\n", target: "build", want: "alpine:3.19"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := parseDockerfile(strings.NewReader(tt.dockerfile))
+			if err != nil {
+				t.Fatalf("parseDockerfile: %v", err)
+			}
+			if got := df.baseImage(tt.target); got != tt.want {
+				t.Errorf("baseImage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateImageSize(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	useTestRetryPolicy(t)
+	files := map[string]string{
+		"main.go":        "package main\n",
+		"go.mod":         "module app\n",
+		"build.log":      strings.Repeat("x", 1000),
+		dockerignoreFile: "*.log\n",
+	}
+	contextBytes := int64(len("package main\n") + len("module app\n") + len("*.log\n"))
+	tests := []struct {
+		name       string
+		dockerfile string
+		opts       EstimateOptions
+		want       SizeEstimate
+		wantPulls  int
+		wantErr    error
+	}{
+		{
+			name:       "local base",
+			dockerfile: "FROM alpine:3.19\nCOPY . /app\n",
+			want:       SizeEstimate{Base: "alpine:3.19", BaseSize: 7000, BaseKnown: true},
+		},
+		{
+			name:       "scratch",
+			dockerfile: "FROM scratch\nCOPY . /\n",
+			want:       SizeEstimate{Base: "scratch", BaseKnown: true},
+		},
+		{
+			name:       "remote base",
+			dockerfile: "FROM golang:1.22\n",
+			want:       SizeEstimate{Base: "golang:1.22", BaseRemote: true},
+		},
+		{
+			name:       "remote base pulled",
+			dockerfile: "FROM golang:1.22\n",
+			opts:       EstimateOptions{Pull: true},
+			want:       SizeEstimate{Base: "golang:1.22", BaseSize: 800000, BaseKnown: true},
+			wantPulls:  1,
+		},
+		{
+			name:       "unresolved base",
+			dockerfile: "ARG BASE\nFROM $BASE\n",
+			want:       SizeEstimate{Base: "$BASE"},
+		},
+		{
+			name:       "missing base",
+			dockerfile: "FROM nothing:here\n",
+			wantErr:    ImageNotFoundErr,
+		},
+		{
+			name:       "unknown target",
+			dockerfile: "FROM alpine:3.19\n",
+			opts:       EstimateOptions{Target: "release"},
+			wantErr:    UnknownTargetErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tree := map[string]string{dockerfileName: tt.dockerfile}
+			for k, v := range files {
+				tree[k] = v
+			}
+			writeTree(t, dir, tree)
+			api := &estimateAPI{
+				local:  map[string]int64{"alpine:3.19": 7000},
+				remote: map[string]int64{"golang:1.22": 800000},
+			}
+			got, err := newTestClient(api).EstimateImageSize(context.Background(), dir, tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EstimateImageSize: %v", err)
+			}
+			tt.want.ContextFiles = 4
+			tt.want.ContextBytes = contextBytes + int64(len(tt.dockerfile))
+			if got != tt.want {
+				t.Errorf("EstimateImageSize = %+v, want %+v", got, tt.want)
+			}
+			if got.Total() != tt.want.BaseSize+tt.want.ContextBytes {
+				t.Errorf("Total = %d, want %d", got.Total(), tt.want.BaseSize+tt.want.ContextBytes)
+			}
+			if len(api.pulls) != tt.wantPulls {
+				t.Errorf("pulls = %v, want %d", api.pulls, tt.wantPulls)
+			}
+		})
+	}
+}
+
+func TestEstimateImageSizeContextSubdir(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		filepath.Join("app", dockerfileName): "FROM scratch\n",
+		filepath.Join("app", "main.go"):      "package main\n",
+		"README.md":                          "outside the context\n",
+	})
+	got, err := newTestClient(&estimateAPI{}).EstimateImageSize(context.Background(), dir, EstimateOptions{ContextSubdir: "app"})
+	if err != nil {
+		t.Fatalf("EstimateImageSize: %v", err)
+	}
+	if got.ContextFiles != 2 || got.ContextBytes != int64(len("FROM scratch\n")+len("package main\n")) {
+		t.Errorf("context = %d files, %d bytes, want only the app folder", got.ContextFiles, got.ContextBytes)
+	}
+}
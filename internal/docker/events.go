@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BuildEventKind is the type of a BuildEvent
+type BuildEventKind string
+
+const (
+	// BuildEventStep starts a new Dockerfile step
+	BuildEventStep BuildEventKind = "step"
+	// BuildEventStream is an output line of the current step
+	BuildEventStream BuildEventKind = "stream"
+	// BuildEventStatus is a progress status (e.g. pulls of FROM images)
+	BuildEventStatus BuildEventKind = "status"
+	// BuildEventAux is an aux message (e.g. the built image ID)
+	BuildEventAux BuildEventKind = "aux"
+	// BuildEventError is the error reported by the daemon
+	BuildEventError BuildEventKind = "error"
+)
+
+// BuildStep describes a Dockerfile step of a build
+type BuildStep struct {
+	Number      int
+	Total       int
+	Instruction string
+	Cached      bool
+	Start       time.Time
+	// Duration is set once the step is done (zero in the step events)
+	Duration time.Duration
+}
+
+// BuildEvent is a parsed message of the build stream
+type BuildEvent struct {
+	Kind BuildEventKind
+	// Step is the step being executed, nil before the first one
+	Step *BuildStep
+	// Text is the output line, status or error message
+	Text string
+	// Aux is the raw aux payload of aux events
+	Aux json.RawMessage
+}
+
+var buildEventKinds = map[buildEventKind]BuildEventKind{
+	eventStepStart: BuildEventStep,
+	eventLine:      BuildEventStream,
+	eventStatus:    BuildEventStatus,
+	eventAux:       BuildEventAux,
+	eventError:     BuildEventError,
+}
+
+// publicEvent converts a parsed stream event to the exported form,
+// copying the step so handlers can keep it
+func publicEvent(e buildEvent) BuildEvent {
+	pe := BuildEvent{Kind: buildEventKinds[e.Kind], Text: e.Text}
+	if e.Step != nil {
+		step := e.Step.public()
+		pe.Step = &step
+	}
+	if e.Aux != nil {
+		pe.Aux = append(json.RawMessage(nil), *e.Aux...)
+	}
+	return pe
+}
+
+// public returns the exported form of the step
+func (s *buildStep) public() BuildStep {
+	return BuildStep{
+		Number:      s.Number,
+		Total:       s.Total,
+		Instruction: s.Instruction,
+		Cached:      s.Cached,
+		Start:       s.Start,
+		Duration:    s.Duration,
+	}
+}
@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildWithHandlerEvents(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{dockerfileName: "FROM alpine:3.19\nRUN make\n"})
+	api := &buildAPI{stream: classicStream(testImageID, "FROM alpine:3.19", "RUN make")}
+	var events []BuildEvent
+	res, err := newTestClient(api).BuildWithHandler(context.Background(), src, BuildOptions{}, func(e BuildEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("BuildWithHandler: %v", err)
+	}
+
+	want := []struct {
+		kind BuildEventKind
+		text string
+		step int
+	}{
+		{BuildEventStep, "Step 1/2 : FROM alpine:3.19", 1},
+		{BuildEventStream, " ---> Running in 000000000001", 1},
+		{BuildEventStep, "Step 2/2 : RUN make", 2},
+		{BuildEventStream, " ---> Running in 000000000002", 2},
+		{BuildEventAux, "", 2},
+		{BuildEventStream, "Successfully built 4b825dc642cb", 2},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Kind != w.kind || e.Text != w.text || e.Step == nil || e.Step.Number != w.step {
+			t.Errorf("event %d = %s %q step %+v, want %s %q in step %d", i, e.Kind, e.Text, e.Step, w.kind, w.text, w.step)
+		}
+	}
+	var aux struct{ ID string }
+	if err := json.Unmarshal(events[4].Aux, &aux); err != nil || aux.ID != testImageID {
+		t.Errorf("aux = %s, want the image ID", events[4].Aux)
+	}
+	if e := events[0]; e.Step.Total != 2 || e.Step.Instruction != "FROM alpine:3.19" || e.Step.Duration != 0 {
+		t.Errorf("step event = %+v, want the started step 1/2", *e.Step)
+	}
+	if res.ImageID != testImageID || len(res.Steps) != 2 {
+		t.Errorf("result = %s with %d steps, want %s with 2", res.ImageID, len(res.Steps), testImageID)
+	}
+}
+
+func TestBuildWithHandlerErrorEvent(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{dockerfileName: "FROM alpine:3.19\nRUN make\n"})
+	msg := "The command '/bin/sh -c make' returned a non-zero code: 2"
+	api := &buildAPI{stream: failedStream(msg, "FROM alpine:3.19", "RUN make")}
+	var last BuildEvent
+	_, err := newTestClient(api).BuildWithHandler(context.Background(), src, BuildOptions{}, func(e BuildEvent) {
+		last = e
+	})
+	if err == nil {
+		t.Fatal("err = nil, want the build failure")
+	}
+	if last.Kind != BuildEventError || last.Text != msg || last.Step == nil || last.Step.Number != 2 {
+		t.Errorf("last event = %s %q step %+v, want the error of step 2", last.Kind, last.Text, last.Step)
+	}
+}
+
+func TestPublicEventCopiesStep(t *testing.T) {
+	step := &buildStep{Number: 1, Total: 1, Instruction: "FROM alpine:3.19"}
+	aux := json.RawMessage(`{"ID":"x"}`)
+	e := publicEvent(buildEvent{Kind: eventAux, Step: step, Aux: &aux})
+	step.Number = 2
+	aux[2] = 'y'
+	if e.Step.Number != 1 || string(e.Aux) != `{"ID":"x"}` {
+		t.Errorf("event = %+v %s, want a copy unchanged by the parser", *e.Step, e.Aux)
+	}
+}
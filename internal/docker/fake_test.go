@@ -0,0 +1,456 @@
+package docker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testImageID is the ID of the images the fake builds produce
+const testImageID = "sha256:4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// testContainerID is the ID of the containers the fakes create
+const testContainerID = "0123456789abcdef0123456789abcdef"
+
+// buildAPI fakes the daemon side of the builds: ImageBuild counts the
+// request (keeping its options and the last context) and answers stream
+// (classicStream(testImageID) if empty), the images being inspected as
+// image (an empty one if nil) with the history, unless gone. The builds
+// fail with buildErr, the tags and pushes with tagErr and pushErr, the
+// pushes reporting pushed. The daemon negotiates the api version and
+// lists images.
+type buildAPI struct {
+	client.APIClient
+	stream   string
+	buildErr error
+	image    *types.ImageInspect
+	history  []image.HistoryResponseItem
+	// gone are the images removed from the daemon
+	gone    []string
+	tagErr  error
+	pushErr error
+	pushed  string
+	// api is the daemon API version (1.43 if empty)
+	api string
+	// images are the images listed
+	images []image.Summary
+
+	mu      sync.Mutex
+	version string
+	builds  int
+	// context is the one of the last build, options the ones of each
+	context []byte
+	options []types.ImageBuildOptions
+	// calls are the tag, remove and push calls, in order
+	calls []string
+	// containers are the removed containers
+	containers []string
+}
+
+func (f *buildAPI) ImageBuild(_ context.Context, buildContext io.Reader, opts types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	var b []byte
+	if buildContext != nil {
+		var err error
+		if b, err = io.ReadAll(buildContext); err != nil {
+			return types.ImageBuildResponse{}, err
+		}
+	}
+	f.mu.Lock()
+	f.builds++
+	f.context = b
+	f.options = append(f.options, opts)
+	f.mu.Unlock()
+	if f.buildErr != nil {
+		return types.ImageBuildResponse{}, f.buildErr
+	}
+	stream := f.stream
+	if stream == "" {
+		stream = classicStream(testImageID, "FROM alpine:3.19")
+	}
+	return types.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(stream))}, nil
+}
+
+// buildRequest is what a build sent to the fake daemon
+type buildRequest struct {
+	tarContent
+	Options types.ImageBuildOptions
+	// Gzipped tells if the context was compressed
+	Gzipped bool
+}
+
+func (f *buildAPI) Ping(_ context.Context) (types.Ping, error) {
+	api := f.api
+	if api == "" {
+		api = "1.43"
+	}
+	return types.Ping{APIVersion: api}, nil
+}
+
+func (f *buildAPI) NegotiateAPIVersionPing(p types.Ping) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = p.APIVersion
+}
+
+func (f *buildAPI) ClientVersion() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.version
+}
+
+// lastBuild returns the last build request, failing the test if none
+// was sent
+func (f *buildAPI) lastBuild(t *testing.T) buildRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.builds == 0 {
+		t.Fatal("no build sent to the daemon")
+	}
+	req := buildRequest{Options: f.options[len(f.options)-1]}
+	var r io.Reader = bytes.NewReader(f.context)
+	if bytes.HasPrefix(f.context, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("reading the build context: %v", err)
+		}
+		req.Gzipped = true
+		r = zr
+	}
+	req.tarContent = readTar(t, r)
+	return req
+}
+
+func (f *buildAPI) ImageInspectWithRaw(_ context.Context, ref string) (types.ImageInspect, []byte, error) {
+	if slices.Contains(f.gone, ref) {
+		return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image: " + ref))
+	}
+	var res types.ImageInspect
+	if f.image != nil {
+		res = *f.image
+	}
+	if res.ID == "" {
+		res.ID = testImageID
+	}
+	return res, nil, nil
+}
+
+func (f *buildAPI) ImageHistory(_ context.Context, _ string) ([]image.HistoryResponseItem, error) {
+	return f.history, nil
+}
+
+func (f *buildAPI) ImageTag(_ context.Context, src, ref string) error {
+	f.record("tag " + src + " " + ref)
+	return f.tagErr
+}
+
+func (f *buildAPI) ImageRemove(_ context.Context, ref string, _ types.ImageRemoveOptions) ([]image.DeleteResponse, error) {
+	f.record("remove " + ref)
+	return nil, nil
+}
+
+// tagged returns the references tagged, in order
+func (f *buildAPI) tagged() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []string
+	for _, c := range f.calls {
+		if args, ok := strings.CutPrefix(c, "tag "); ok {
+			res = append(res, args[strings.LastIndex(args, " ")+1:])
+		}
+	}
+	return res
+}
+
+// removed returns the removed images, in order
+func (f *buildAPI) removed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []string
+	for _, c := range f.calls {
+		if ref, ok := strings.CutPrefix(c, "remove "); ok {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+func (f *buildAPI) ImageList(_ context.Context, _ types.ImageListOptions) ([]image.Summary, error) {
+	return f.images, nil
+}
+
+func (f *buildAPI) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers = append(f.containers, id)
+	return nil
+}
+
+func (f *buildAPI) ImagePush(_ context.Context, ref string, _ types.ImagePushOptions) (io.ReadCloser, error) {
+	f.record("push " + ref)
+	if f.pushErr != nil {
+		return nil, f.pushErr
+	}
+	return io.NopCloser(strings.NewReader(streamMessage(map[string]any{"aux": types.PushResult{Digest: f.pushed}}))), nil
+}
+
+func (f *buildAPI) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// testBuild builds the context tree with the fake daemon api, hiding
+// the build output
+func testBuild(t *testing.T, api client.APIClient, tree map[string]string, opts BuildOptions) (BuildResult, error) {
+	t.Helper()
+	src := t.TempDir()
+	writeTree(t, src, tree)
+	var res BuildResult
+	var err error
+	captureStdout(t, func() {
+		res, err = newTestClient(api).Build(context.Background(), src, opts)
+	})
+	return res, err
+}
+
+// captureStdout returns what fn prints to the standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = saved
+	}()
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	fn()
+	_ = w.Close()
+	return <-done
+}
+
+// newTestClient returns a client of the fake daemon api
+func newTestClient(api client.APIClient) Client {
+	return Client{d: api, caps: &capabilityCache{}}
+}
+
+// streamMessage encodes a build stream message
+func streamMessage(m map[string]any) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return string(b) + "\n"
+}
+
+// classicStream is the stream of a classic builder build running the
+// instructions, one step each in the container 00000000000N, and
+// producing the image id (none if empty)
+func classicStream(id string, instructions ...string) string {
+	var b strings.Builder
+	for i, ins := range instructions {
+		b.WriteString(streamMessage(map[string]any{"stream": "Step " + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(instructions)) + " : " + ins + "\n"}))
+		b.WriteString(streamMessage(map[string]any{"stream": fmt.Sprintf(" ---> Running in %012d\n", i+1)}))
+	}
+	if id != "" {
+		b.WriteString(streamMessage(map[string]any{"aux": map[string]string{"ID": id}}))
+		b.WriteString(streamMessage(map[string]any{"stream": "Successfully built " + strings.TrimPrefix(id, "sha256:")[:12] + "\n"}))
+	}
+	return b.String()
+}
+
+// failedStream is the stream of a classic builder build failing on the
+// last instruction with msg
+func failedStream(msg string, instructions ...string) string {
+	return classicStream("", instructions...) + streamMessage(map[string]any{
+		"errorDetail": map[string]any{"message": msg},
+		"error":       msg,
+	})
+}
+
+// logRecord is a captured log line
+type logRecord map[string]any
+
+// captureLogs records the default logger lines for the test
+func captureLogs(t *testing.T) func() []logRecord {
+	t.Helper()
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&lockedWriter{mu: &mu, w: &buf}, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		slog.SetDefault(saved)
+	})
+	return func() []logRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		var res []logRecord
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for {
+			var r logRecord
+			if err := dec.Decode(&r); err != nil {
+				return res
+			}
+			res = append(res, r)
+		}
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// logsWithMsg returns the records of the message
+func logsWithMsg(records []logRecord, msg string) []logRecord {
+	var res []logRecord
+	for _, r := range records {
+		if r["msg"] == msg {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// helperRun is a container created on the fake helper daemon
+type helperRun struct {
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Platform   *ocispec.Platform
+	Name       string
+}
+
+// helperAPI fakes a daemon of the platform os/arch (linux/x86_64 if
+// empty) running short lived containers: run decides the exit code
+// and output of each (0 and none if nil), images are inspected as
+// image (an empty one if nil)
+type helperAPI struct {
+	client.APIClient
+	os, arch string
+	image    *types.ImageInspect
+	run      func(r helperRun) (int, string)
+
+	mu      sync.Mutex
+	runs    []helperRun
+	removed []string
+	results map[string]helperResult
+}
+
+type helperResult struct {
+	code int
+	out  string
+}
+
+func (f *helperAPI) Info(_ context.Context) (system.Info, error) {
+	info := system.Info{OSType: f.os, Architecture: f.arch}
+	if info.OSType == "" {
+		info.OSType, info.Architecture = "linux", "x86_64"
+	}
+	return info, nil
+}
+
+func (f *helperAPI) Ping(_ context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: "1.43"}, nil
+}
+
+func (f *helperAPI) NegotiateAPIVersionPing(types.Ping) {}
+
+func (f *helperAPI) ClientVersion() string {
+	return "1.43"
+}
+
+func (f *helperAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	if f.image != nil {
+		return *f.image, nil, nil
+	}
+	return types.ImageInspect{}, nil, nil
+}
+
+func (f *helperAPI) ContainerCreate(_ context.Context, cfg *container.Config, hostCfg *container.HostConfig, _ *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error) {
+	r := helperRun{Config: cfg, HostConfig: hostCfg, Platform: platform, Name: name}
+	var res helperResult
+	if f.run != nil {
+		res.code, res.out = f.run(r)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("%064d", len(f.runs)+1)
+	f.runs = append(f.runs, r)
+	if f.results == nil {
+		f.results = map[string]helperResult{}
+	}
+	f.results[id] = res
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (f *helperAPI) ContainerWait(_ context.Context, id string, _ container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	waitC := make(chan container.WaitResponse, 1)
+	waitC <- container.WaitResponse{StatusCode: int64(f.results[id].code)}
+	return waitC, make(chan error)
+}
+
+func (f *helperAPI) ContainerStart(_ context.Context, _ string, _ container.StartOptions) error {
+	return nil
+}
+
+func (f *helperAPI) ContainerLogs(_ context.Context, id string, _ container.LogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var buf bytes.Buffer
+	_, _ = stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(f.results[id].out))
+	return io.NopCloser(&buf), nil
+}
+
+func (f *helperAPI) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+// images returns the images of the containers run, in order
+func (f *helperAPI) images() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []string
+	for _, r := range f.runs {
+		res = append(res, r.Config.Image)
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// syncWriter serializes the writes of the build printer and the
+// heartbeat
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// heartbeat prints a line when the build stream stays silent for an
+// interval, so CI systems don't kill the job for inactivity
+type heartbeat struct {
+	out      io.Writer
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last time.Time
+	step *buildStep
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startHeartbeat starts the heartbeat, checking for silence every
+// interval
+func startHeartbeat(out io.Writer, interval time.Duration) *heartbeat {
+	h := &heartbeat{
+		out:      out,
+		interval: interval,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	h.last = h.now()
+	go h.loop(time.NewTicker(interval))
+	return h
+}
+
+// Handle records the output events of the build stream
+func (h *heartbeat) Handle(e buildEvent) {
+	if e.Kind != eventLine && e.Kind != eventStepStart {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = h.now()
+	h.step = e.Step
+}
+
+// Stop stops the heartbeat, waiting for a pending line
+func (h *heartbeat) Stop() {
+	close(h.stop)
+	<-h.done
+}
+
+func (h *heartbeat) loop(t *time.Ticker) {
+	defer close(h.done)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+			h.beat()
+		}
+	}
+}
+
+// beat prints the heartbeat line if nothing was output for an interval
+func (h *heartbeat) beat() {
+	h.mu.Lock()
+	silent := h.now().Sub(h.last)
+	step := h.step
+	h.mu.Unlock()
+	if silent < h.interval {
+		return
+	}
+	silent = silent.Truncate(time.Second)
+	if step != nil {
+		_, _ = fmt.Fprintf(h.out, "still building... (step %d/%d, no output for %s)\n", step.Number, step.Total, silent)
+		return
+	}
+	_, _ = fmt.Fprintf(h.out, "still building... (no output for %s)\n", silent)
+}
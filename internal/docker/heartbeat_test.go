@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatBeat(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	h := &heartbeat{out: &out, interval: 30 * time.Second, now: func() time.Time { return now }, last: now}
+
+	beat := func() string {
+		out.Reset()
+		h.beat()
+		return out.String()
+	}
+
+	now = now.Add(10 * time.Second)
+	if got := beat(); got != "" {
+		t.Errorf("beat before the interval = %q, want nothing", got)
+	}
+	now = now.Add(25*time.Second + 300*time.Millisecond)
+	if got, want := beat(), "still building... (no output for 35s)\n"; got != want {
+		t.Errorf("beat = %q, want %q", got, want)
+	}
+
+	h.Handle(buildEvent{Kind: eventStepStart, Step: &buildStep{Number: 2, Total: 5, Instruction: "RUN make"}})
+	now = now.Add(5 * time.Second)
+	if got := beat(); got != "" {
+		t.Errorf("beat after a step started = %q, want nothing", got)
+	}
+
+	// progress statuses aren't output, they don't reset the silence
+	h.Handle(buildEvent{Kind: eventStatus, Text: "Downloading"})
+	now = now.Add(40 * time.Second)
+	if got, want := beat(), "still building... (step 2/5, no output for 45s)\n"; got != want {
+		t.Errorf("beat = %q, want %q", got, want)
+	}
+
+	h.Handle(buildEvent{Kind: eventLine, Text: "compiling", Step: &buildStep{Number: 2, Total: 5}})
+	if got := beat(); got != "" {
+		t.Errorf("beat after an output line = %q, want nothing", got)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	var buf bytes.Buffer
+	out := &syncWriter{w: &buf}
+	h := startHeartbeat(out, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	h.Stop()
+
+	out.mu.Lock()
+	got := buf.String()
+	out.mu.Unlock()
+	if !strings.Contains(got, "still building... (no output for") {
+		t.Errorf("output = %q, want heartbeat lines", got)
+	}
+
+	// nothing is printed once stopped
+	time.Sleep(30 * time.Millisecond)
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if buf.String() != got {
+		t.Errorf("output after Stop = %q, want %q", buf.String(), got)
+	}
+}
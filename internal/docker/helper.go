@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// helperImage is the small image used by the runner own short lived
+// containers
+const helperImage = "alpine:3.19"
+
+// runHelper runs a short lived container to completion and returns
+// its exit code and combined output. The container is removed
+// afterwards.
+func (c Client) runHelper(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, platform *ocispec.Platform) (int, string, error) {
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	cfg.Labels[ManagedLabel] = "true"
+	original := cfg.Image
+	cfg.Image = c.mirrorImage(cfg.Image)
+	labelOriginalImage(cfg.Labels, original, cfg.Image)
+
+	var platformName string
+	if platform != nil {
+		platformName = formatPlatform(*platform)
+	}
+	if err := c.ensureImage(ctx, cfg.Image, platformName, PullMissing); err != nil {
+		return 0, "", err
+	}
+
+	created, err := c.d.ContainerCreate(ctx, cfg, hostCfg, nil, platform, "")
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer func() {
+		_ = c.d.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	}()
+
+	waitC, waitErrC := c.d.ContainerWait(ctx, created.ID, container.WaitConditionNextExit)
+	if err := c.d.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return 0, "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+
+	var code int
+	select {
+	case res := <-waitC:
+		code = int(res.StatusCode)
+	case err := <-waitErrC:
+		return 0, "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+
+	logs, err := c.d.ContainerLogs(ctx, created.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return code, "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer func() {
+		_ = logs.Close()
+	}()
+	out := new(bytes.Buffer)
+	if _, err := stdcopy.StdCopy(out, out, logs); err != nil {
+		return code, "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	return code, out.String(), nil
+}
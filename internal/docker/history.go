@@ -0,0 +1,337 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	BuildHistoryErr  = errors.New("failed to use build history")
+	BuildNotFoundErr = errors.New("build not found in history")
+)
+
+const (
+	// DefaultHistoryKeep is the number of builds kept in the history
+	DefaultHistoryKeep = 100
+
+	historyIndexFile = "builds.jsonl"
+	historyLogsDir   = "logs"
+	// redactedValue replaces the sensitive build arg values
+	redactedValue = "****"
+)
+
+// errorClasses name the build failures recorded in the history, most
+// specific first
+var errorClasses = []struct {
+	Name string
+	Err  error
+}{
+	{"step", BuildStepErr},
+	{"missing-source", MissingSourceErr},
+	{"no-context", NoBuildContextErr},
+	{"context-too-large", ContextTooLargeErr},
+	{"dockerfile-parse", DockerfileParseErr},
+	{"dockerfile-not-found", DockerfileNotFoundErr},
+	{"secret-leak", SecretLeakErr},
+	{"missing-healthcheck", MissingHealthcheckErr},
+	{"warnings", BuildWarningsErr},
+	{"post-build-hook", PostBuildHookErr},
+	{"unsupported", FeatureUnsupportedErr},
+	{"stream", BuildStreamReadErr},
+	{"daemon", BuildDockerAPIErr},
+}
+
+// BuildRecord is the metadata of a past build
+type BuildRecord struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Context is the context folder, remote context URL or "inline"
+	Context string `json:"context"`
+	// ContextDigest is the digest of the context archive sent to the
+	// daemon (empty for remote and inline builds)
+	ContextDigest    string `json:"context_digest,omitempty"`
+	DockerfileDigest string `json:"dockerfile_digest,omitempty"`
+	// BuildArgs are the effective build args, the sensitive values
+	// redacted
+	BuildArgs   map[string]string `json:"build_args,omitempty"`
+	Tags        []string          `json:"tags"`
+	ImageID     string            `json:"image_id,omitempty"`
+	Duration    time.Duration     `json:"duration"`
+	Steps       int               `json:"steps"`
+	CachedSteps int               `json:"cached_steps"`
+	Error       string            `json:"error,omitempty"`
+	// ErrorClass is the kind of failure (step, missing-source...)
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// Failed tells if the build failed
+func (r BuildRecord) Failed() bool {
+	return r.Error != ""
+}
+
+// CacheHitRatio is the share of the steps served from cache
+func (r BuildRecord) CacheHitRatio() float64 {
+	if r.Steps == 0 {
+		return 0
+	}
+	return float64(r.CachedSteps) / float64(r.Steps)
+}
+
+// DefaultHistoryDir returns the folder of the build history in the user
+// cache folder
+func DefaultHistoryDir() (string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	return filepath.Join(dir, "history"), nil
+}
+
+// ReadBuildHistory returns the recorded builds of the history folder,
+// oldest first
+func ReadBuildHistory(dir string) ([]BuildRecord, error) {
+	f, err := os.Open(filepath.Join(dir, historyIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var res []BuildRecord
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for s.Scan() {
+		var r BuildRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			slog.With("error", err.Error()).Warn("BuildHistoryRecordSkipped")
+			continue
+		}
+		res = append(res, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	return res, nil
+}
+
+// FindBuild returns the recorded build whose ID starts with id
+func FindBuild(dir, id string) (BuildRecord, error) {
+	records, err := ReadBuildHistory(dir)
+	if err != nil {
+		return BuildRecord{}, err
+	}
+	var found []BuildRecord
+	for _, r := range records {
+		if strings.HasPrefix(r.ID, id) {
+			found = append(found, r)
+		}
+	}
+	switch {
+	case id == "" || len(found) == 0:
+		return BuildRecord{}, fmt.Errorf("%w: %q", BuildNotFoundErr, id)
+	case len(found) > 1:
+		return BuildRecord{}, fmt.Errorf("%w: %q matches %d builds", BuildHistoryErr, id, len(found))
+	}
+	return found[0], nil
+}
+
+// WriteBuildLog writes the stored output of the build to w
+func WriteBuildLog(dir, id string, w io.Writer) error {
+	f, err := os.Open(filepath.Join(dir, historyLogsDir, id+".log.gz"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	if _, err := io.Copy(w, zr); err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	return nil
+}
+
+// buildRecorder collects the metadata and output of a build for the
+// history
+type buildRecorder struct {
+	dir   string
+	keep  int
+	start time.Time
+	rec   BuildRecord
+	log   bytes.Buffer
+	// context hashes the context archive as the daemon reads it
+	context hash.Hash
+}
+
+// newBuildRecorder starts recording a build of src, a local context
+// folder if local is set
+func newBuildRecorder(dir string, keep int, src string, local bool, opts BuildOptions) *buildRecorder {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	if keep <= 0 {
+		keep = DefaultHistoryKeep
+	}
+	r := &buildRecorder{
+		dir:   dir,
+		keep:  keep,
+		start: time.Now(),
+		rec:   BuildRecord{ID: hex.EncodeToString(id)},
+	}
+	switch {
+	case len(opts.Dockerfile) > 0:
+		r.rec.Context = "inline"
+	case local:
+		r.rec.Context = filepath.Join(src, opts.ContextSubdir)
+		if abs, err := filepath.Abs(r.rec.Context); err == nil {
+			r.rec.Context = abs
+		}
+	default:
+		r.rec.Context = src
+	}
+	return r
+}
+
+// Context records the context archive digest while r is read
+func (r *buildRecorder) Context(rd io.Reader) io.Reader {
+	r.context = sha256.New()
+	return io.TeeReader(rd, r.context)
+}
+
+// Dockerfile records the Dockerfile digest
+func (r *buildRecorder) Dockerfile(content []byte) {
+	sum := sha256.Sum256(content)
+	r.rec.DockerfileDigest = "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Save records the build outcome, redacting the sensitive build args in
+// the record and the log. Failures are logged.
+func (r *buildRecorder) Save(res BuildResult, buildErr error) {
+	r.rec.Time = r.start
+	r.rec.Duration = time.Since(r.start).Round(time.Millisecond)
+	r.rec.Tags = []string{res.Tag}
+	r.rec.ImageID = res.ImageID
+	r.rec.Steps = len(res.Steps)
+	for _, s := range res.Steps {
+		if s.Cached {
+			r.rec.CachedSteps++
+		}
+	}
+	if r.context != nil && buildErr == nil {
+		r.rec.ContextDigest = "sha256:" + hex.EncodeToString(r.context.Sum(nil))
+	}
+	secrets := SensitiveBuildArgs(res.BuildArgs)
+	if len(res.BuildArgs) > 0 {
+		r.rec.BuildArgs = make(map[string]string, len(res.BuildArgs))
+		for k, v := range res.BuildArgs {
+			if sensitiveArgRe.MatchString(k) {
+				v = redactedValue
+			}
+			r.rec.BuildArgs[k] = v
+		}
+	}
+	log := r.log.String()
+	if buildErr != nil {
+		r.rec.Error = buildErr.Error()
+		r.rec.ErrorClass = "other"
+		for _, c := range errorClasses {
+			if errors.Is(buildErr, c.Err) {
+				r.rec.ErrorClass = c.Name
+				break
+			}
+		}
+	}
+	for _, v := range secrets {
+		log = strings.ReplaceAll(log, v, redactedValue)
+		r.rec.Error = strings.ReplaceAll(r.rec.Error, v, redactedValue)
+	}
+	if err := r.write(log); err != nil {
+		slog.With("build", r.rec.ID, "error", err.Error()).Warn("BuildHistoryWriteFailed")
+	}
+}
+
+// write stores the compressed log, appends the record to the index and
+// prunes the oldest builds beyond the retention
+func (r *buildRecorder) write(log string) error {
+	if err := os.MkdirAll(filepath.Join(r.dir, historyLogsDir), 0o755); err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(log))
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	if err := os.WriteFile(r.logPath(r.rec.ID), buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+
+	line, err := json.Marshal(r.rec)
+	if err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	f, err := os.OpenFile(filepath.Join(r.dir, historyIndexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	return r.prune()
+}
+
+// prune removes the oldest builds and their logs beyond the retention
+func (r *buildRecorder) prune() error {
+	records, err := ReadBuildHistory(r.dir)
+	if err != nil || len(records) <= r.keep {
+		return err
+	}
+	old, kept := records[:len(records)-r.keep], records[len(records)-r.keep:]
+	var buf bytes.Buffer
+	for _, rec := range kept {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp := filepath.Join(r.dir, historyIndexFile+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(r.dir, historyIndexFile)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%w: %w", BuildHistoryErr, err)
+	}
+	for _, rec := range old {
+		_ = os.Remove(r.logPath(rec.ID))
+	}
+	return nil
+}
+
+func (r *buildRecorder) logPath(id string) string {
+	return filepath.Join(r.dir, historyLogsDir, id+".log.gz")
+}
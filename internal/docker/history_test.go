@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// historyBuild builds fsys recording it in the dir history
+func historyBuild(t *testing.T, dir string, keep int, stream string, args map[string]*string) error {
+	t.Helper()
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nARG API_TOKEN\nRUN make\n")}}
+	_, err := newTestClient(&buildAPI{stream: stream}).build(context.Background(), fsContextName, fsys, BuildOptions{
+		HistoryDir:  dir,
+		HistoryKeep: keep,
+		BuildArgs:   args,
+	}, io.Discard, nil)
+	return err
+}
+
+func TestBuildHistoryRecord(t *testing.T) {
+	dir := t.TempDir()
+	const secret = "s3cr3t-token-value"
+	stream := classicStream(testImageID, "FROM alpine:3.19", "RUN make "+secret)
+	err := historyBuild(t, dir, 0, stream, map[string]*string{"API_TOKEN": strPtr(secret), "VERSION": strPtr("1.0")})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	records, err := ReadBuildHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildHistory: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("recorded %d builds, want 1", len(records))
+	}
+	r := records[0]
+	if r.Failed() || r.ImageID != testImageID || r.Context != fsContextName || r.Steps != 2 {
+		t.Errorf("record = %+v, want the successful build of %s", r, testImageID)
+	}
+	if !strings.HasPrefix(r.ContextDigest, "sha256:") || !strings.HasPrefix(r.DockerfileDigest, "sha256:") {
+		t.Errorf("digests = %q, %q, want both recorded", r.ContextDigest, r.DockerfileDigest)
+	}
+	if r.BuildArgs["API_TOKEN"] != redactedValue || r.BuildArgs["VERSION"] != "1.0" {
+		t.Errorf("build args = %v, want API_TOKEN redacted", r.BuildArgs)
+	}
+
+	var log strings.Builder
+	if err := WriteBuildLog(dir, r.ID, &log); err != nil {
+		t.Fatalf("WriteBuildLog: %v", err)
+	}
+	if !strings.Contains(log.String(), "RUN make "+redactedValue) || strings.Contains(log.String(), secret) {
+		t.Errorf("log = %q, want the secret redacted", log.String())
+	}
+}
+
+func TestBuildHistoryFailure(t *testing.T) {
+	dir := t.TempDir()
+	if err := historyBuild(t, dir, 0, failedStream("returned a non-zero code: 2", "FROM alpine:3.19", "RUN make"), nil); err == nil {
+		t.Fatal("build err = nil, want the step failure")
+	}
+	records, err := ReadBuildHistory(dir)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ReadBuildHistory = %v, %v, want 1 build", records, err)
+	}
+	r := records[0]
+	if !r.Failed() || r.ErrorClass != "step" || r.ContextDigest != "" {
+		t.Errorf("record = %+v, want a step failure without context digest", r)
+	}
+}
+
+func TestBuildHistoryPrune(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		if err := historyBuild(t, dir, 2, classicStream(testImageID, "FROM alpine:3.19"), nil); err != nil {
+			t.Fatalf("build: %v", err)
+		}
+	}
+	records, err := ReadBuildHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildHistory: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("kept %d builds, want 2", len(records))
+	}
+	logs, err := os.ReadDir(filepath.Join(dir, historyLogsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("kept %d logs, want 2", len(logs))
+	}
+}
+
+func TestReadBuildHistory(t *testing.T) {
+	dir := t.TempDir()
+	if records, err := ReadBuildHistory(dir); err != nil || records != nil {
+		t.Errorf("ReadBuildHistory = %v, %v, want no builds", records, err)
+	}
+	index := `{"id":"abc123","tags":["app:1"]}
+not json
+{"id":"abd456","tags":["app:2"]}
+`
+	if err := os.WriteFile(filepath.Join(dir, historyIndexFile), []byte(index), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadBuildHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildHistory: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "abc123" || records[1].ID != "abd456" {
+		t.Errorf("records = %+v, want abc123 and abd456", records)
+	}
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr error
+	}{
+		{id: "abc", want: "abc123"},
+		{id: "abd456", want: "abd456"},
+		{id: "ab", wantErr: BuildHistoryErr},
+		{id: "fff", wantErr: BuildNotFoundErr},
+		{id: "", wantErr: BuildNotFoundErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			r, err := FindBuild(dir, tt.id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || r.ID != tt.want {
+				t.Errorf("FindBuild = %q, %v, want %q", r.ID, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRecordCacheHitRatio(t *testing.T) {
+	if got := (BuildRecord{}).CacheHitRatio(); got != 0 {
+		t.Errorf("CacheHitRatio = %v, want 0 without steps", got)
+	}
+	if got := (BuildRecord{Steps: 4, CachedSteps: 3}).CacheHitRatio(); got != 0.75 {
+		t.Errorf("CacheHitRatio = %v, want 0.75", got)
+	}
+}
@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	PostBuildHookErr = errors.New("post-build hook failed")
+)
+
+// postBuildHookEnv is the environment of the post-build hook of the
+// built image
+func postBuildHookEnv(res BuildResult) []string {
+	return append(os.Environ(),
+		"DR_IMAGE_ID="+res.ImageID,
+		"DR_IMAGE_TAGS="+strings.Join(append([]string{res.Tag}, res.AlsoTags...), " "),
+	)
+}
+
+// runPostBuildHook runs the hook command line through /bin/sh -c, with
+// the built image ID and space separated tags in DR_IMAGE_ID and
+// DR_IMAGE_TAGS, failing if it exits with a non zero code
+func runPostBuildHook(ctx context.Context, out io.Writer, hook string, res BuildResult) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+	cmd.Env = postBuildHookEnv(res)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%w: %q exited with code %d", PostBuildHookErr, hook, exitErr.ExitCode())
+		}
+		return fmt.Errorf("%w: %q: %w", PostBuildHookErr, hook, err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunPostBuildHook(t *testing.T) {
+	res := BuildResult{ImageID: testImageID, Tag: "app:1", AlsoTags: []string{"app:latest", "registry.local/app:1"}}
+	var out bytes.Buffer
+	if err := runPostBuildHook(context.Background(), &out, `echo "$DR_IMAGE_ID|$DR_IMAGE_TAGS"; echo warn >&2`, res); err != nil {
+		t.Fatalf("runPostBuildHook: %v", err)
+	}
+	want := testImageID + "|app:1 app:latest registry.local/app:1\nwarn\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunPostBuildHookFailure(t *testing.T) {
+	err := runPostBuildHook(context.Background(), &bytes.Buffer{}, "exit 3", BuildResult{})
+	if !errors.Is(err, PostBuildHookErr) || !strings.Contains(err.Error(), "exited with code 3") {
+		t.Errorf("err = %v, want %v with the exit code", err, PostBuildHookErr)
+	}
+}
+
+func TestBuildPostBuildHook(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	dir := t.TempDir()
+	hook := `echo "$DR_IMAGE_ID" > ` + filepath.Join(dir, "hook.out")
+	if _, err := newTestClient(&buildAPI{}).BuildFS(context.Background(), fsys, BuildOptions{PostBuildHook: hook}); err != nil {
+		t.Fatalf("BuildFS: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "hook.out"))
+	if err != nil || string(b) != testImageID+"\n" {
+		t.Errorf("hook output = %q, %v, want the built image ID", b, err)
+	}
+
+	_, err = newTestClient(&buildAPI{}).BuildFS(context.Background(), fsys, BuildOptions{PostBuildHook: "false"})
+	if !errors.Is(err, PostBuildHookErr) {
+		t.Errorf("err = %v, want %v", err, PostBuildHookErr)
+	}
+}
@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+var (
+	IgnoreFileReadErr = errors.New("failed to read ignore patterns")
+)
+
+const (
+	dockerignoreFile = ".dockerignore"
+	dockerfileName   = "Dockerfile"
+)
+
+// contextIgnorer decides which context entries are left out of
+// the build request
+type contextIgnorer struct {
+	pm *patternmatcher.PatternMatcher
+	// patterns are the patterns matched one by one by Explain, each
+	// compiled once
+	patterns []ignorePattern
+}
+
+// ignorePattern is an ignore pattern with its own matcher, ignoring
+// its negation
+type ignorePattern struct {
+	raw string
+	pm  *patternmatcher.PatternMatcher
+}
+
+// newContextIgnorer loads the patterns of the ignore file of fsys (if
+// set and present) merged with the extra patterns, which are applied
+// after the file ones. The keep entries are never excluded.
+func newContextIgnorer(fsys fs.FS, ignoreFile string, extra []string, keep []string) (*contextIgnorer, error) {
+	var patterns []string
+	if ignoreFile != "" {
+		var err error
+		patterns, err = readIgnoreFile(fsys, ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	patterns = append(patterns, extra...)
+	if len(patterns) == 0 {
+		return &contextIgnorer{}, nil
+	}
+	for _, k := range keep {
+		patterns = append(patterns, "!"+k)
+	}
+
+	pm, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	single := make([]ignorePattern, 0, len(patterns))
+	for _, p := range patterns {
+		spm, err := patternmatcher.New([]string{strings.TrimPrefix(p, "!")})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+		}
+		single = append(single, ignorePattern{raw: p, pm: spm})
+	}
+	return &contextIgnorer{pm: pm, patterns: single}, nil
+}
+
+func readIgnoreFile(fsys fs.FS, name string) ([]string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %w", IgnoreFileReadErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w (reading %s): %w", IgnoreFileReadErr, name, err)
+	}
+	return patterns, nil
+}
+
+// Excluded tells if the entry name (slash separated, relative to
+// the context root) is ignored, and if the walk can skip it
+// entirely (directories with no exception patterns left to match)
+func (i *contextIgnorer) Excluded(name string, isDir bool) (excluded bool, skip bool, err error) {
+	if i.pm == nil {
+		return false, false, nil
+	}
+	excluded, err = i.pm.MatchesOrParentMatches(name)
+	if err != nil {
+		return false, false, fmt.Errorf("%w (matching %s): %w", IgnoreFileReadErr, name, err)
+	}
+	return excluded, excluded && isDir && !i.pm.Exclusions(), nil
+}
+
+// Explain returns the pattern that decided whether the entry name is
+// excluded (the last matching one), or an empty string if none matched
+func (i *contextIgnorer) Explain(name string) (string, error) {
+	var decided string
+	for _, p := range i.patterns {
+		matched, err := p.pm.MatchesOrParentMatches(name)
+		if err != nil {
+			return "", fmt.Errorf("%w (matching %s): %w", IgnoreFileReadErr, name, err)
+		}
+		if matched {
+			decided = p.raw
+		}
+	}
+	return decided, nil
+}
+
+// explainDecision logs the ignore decision about the entry name
+func (i *contextIgnorer) explainDecision(name string, excluded bool) error {
+	pattern, err := i.Explain(name)
+	if err != nil {
+		return err
+	}
+	decision := "included"
+	if excluded {
+		decision = "excluded"
+	}
+	slog.With("file", name, "decision", decision, "pattern", pattern).Info("IgnoreDecision")
+	return nil
+}
@@ -0,0 +1,199 @@
+package docker
+
+import (
+	"testing"
+)
+
+func TestContextDockerignore(t *testing.T) {
+	tree := map[string]string{
+		"Dockerfile":        "FROM alpine:3.19\n",
+		"main.go":           "package main\n",
+		"main_test.go":      "package main\n",
+		"docs/guide.md":     "# guide\n",
+		"docs/keep.md":      "# keep\n",
+		"node_modules/x.js": "",
+		".git/HEAD":         "ref: refs/heads/main\n",
+	}
+	tests := []struct {
+		name     string
+		ignore   string
+		patterns []string
+		want     []string
+	}{
+		{
+			name: "no ignore file",
+			want: []string{".git/", ".git/HEAD", "Dockerfile", "docs/", "docs/guide.md", "docs/keep.md", "main.go", "main_test.go", "node_modules/", "node_modules/x.js"},
+		},
+		{
+			name:   "folders and globs",
+			ignore: ".git\nnode_modules\n*_test.go\n",
+			want:   []string{".dockerignore", "Dockerfile", "docs/", "docs/guide.md", "docs/keep.md", "main.go"},
+		},
+		{
+			// the excluded folder gets no entry, as with the docker CLI
+			name:   "exception",
+			ignore: "docs\n!docs/keep.md\n.git\nnode_modules\n",
+			want:   []string{".dockerignore", "Dockerfile", "docs/keep.md", "main.go", "main_test.go"},
+		},
+		{
+			name:   "Dockerfile and ignore file kept",
+			ignore: "*\n",
+			want:   []string{".dockerignore", "Dockerfile"},
+		},
+		{
+			name:     "patterns after the file",
+			ignore:   ".git\nnode_modules\n",
+			patterns: []string{"docs", "!main_test.go", "*.go"},
+			want:     []string{".dockerignore", "Dockerfile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, tree)
+			if tt.ignore != "" {
+				writeTree(t, dir, map[string]string{dockerignoreFile: tt.ignore})
+			}
+			r, err := contextTar(dir, BuildOptions{IgnorePatterns: tt.patterns})
+			if err != nil {
+				t.Fatalf("contextTar: %v", err)
+			}
+			if names := sortedNames(readTar(t, r).Names); !equalStrings(names, tt.want) {
+				t.Errorf("entries = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextIgnorerExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		entry    string
+		isDir    bool
+		excluded bool
+	}{
+		{name: "no patterns", entry: "node_modules", isDir: true},
+		{name: "excluded folder", patterns: []string{"node_modules"}, entry: "node_modules", isDir: true, excluded: true},
+		{name: "excluded file", patterns: []string{"*.log"}, entry: "app.log", excluded: true},
+		{name: "folder with exceptions", patterns: []string{"docs", "!docs/keep.md"}, entry: "docs", isDir: true, excluded: true},
+		{name: "kept Dockerfile", patterns: []string{"*"}, entry: dockerfileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignorer, err := newContextIgnorer(nil, "", tt.patterns, []string{dockerfileName, dockerignoreFile})
+			if err != nil {
+				t.Fatalf("newContextIgnorer: %v", err)
+			}
+			excluded, _, err := ignorer.Excluded(tt.entry, tt.isDir)
+			if err != nil {
+				t.Fatalf("Excluded: %v", err)
+			}
+			if excluded != tt.excluded {
+				t.Errorf("Excluded(%s) = %v, want %v", tt.entry, excluded, tt.excluded)
+			}
+		})
+	}
+}
+
+func TestContextIgnorerExplain(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{dockerignoreFile: "*.log\nbuild/\n!build/keep.txt\n"})
+	ignorer, err := newContextIgnorer(contextFS(dir), dockerignoreFile, []string{"tmp"}, nil)
+	if err != nil {
+		t.Fatalf("newContextIgnorer: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		excluded bool
+		pattern  string
+	}{
+		{name: "main.go", excluded: false, pattern: ""},
+		{name: "app.log", excluded: true, pattern: "*.log"},
+		{name: "build/out.bin", excluded: true, pattern: "build"},
+		{name: "build/keep.txt", excluded: false, pattern: "!build/keep.txt"},
+		{name: "tmp", excluded: true, pattern: "tmp"},
+		{name: "tmp/cache", excluded: true, pattern: "tmp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			excluded, _, err := ignorer.Excluded(tt.name, false)
+			if err != nil {
+				t.Fatalf("Excluded: %v", err)
+			}
+			if excluded != tt.excluded {
+				t.Errorf("Excluded = %v, want %v", excluded, tt.excluded)
+			}
+			pattern, err := ignorer.Explain(tt.name)
+			if err != nil {
+				t.Fatalf("Explain: %v", err)
+			}
+			if pattern != tt.pattern {
+				t.Errorf("Explain = %q, want %q", pattern, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestContextIgnorerExplainNoPatterns(t *testing.T) {
+	ignorer, err := newContextIgnorer(nil, "", nil, nil)
+	if err != nil {
+		t.Fatalf("newContextIgnorer: %v", err)
+	}
+	pattern, err := ignorer.Explain("main.go")
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if pattern != "" {
+		t.Errorf("Explain = %q, want no pattern", pattern)
+	}
+}
+
+func TestExplainIgnoreLogsDecisions(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		dockerfileName:   "FROM alpine:3.19\n",
+		dockerignoreFile: "*.log\n",
+		"main.go":        "package main\n",
+		"debug.log":      "noise",
+	})
+	logs := captureLogs(t)
+	r, err := contextTar(dir, BuildOptions{ExplainIgnore: true})
+	if err != nil {
+		t.Fatalf("contextTar: %v", err)
+	}
+	readTar(t, r)
+
+	want := map[string][2]string{
+		dockerignoreFile: {"included", "!.dockerignore"},
+		dockerfileName:   {"included", "!Dockerfile"},
+		"main.go":        {"included", ""},
+		"debug.log":      {"excluded", "*.log"},
+	}
+	decisions := logsWithMsg(logs(), "IgnoreDecision")
+	if len(decisions) != len(want) {
+		t.Fatalf("decisions = %v, want one per entry", decisions)
+	}
+	for _, d := range decisions {
+		file, _ := d["file"].(string)
+		w, ok := want[file]
+		if !ok || d["decision"] != w[0] || d["pattern"] != w[1] {
+			t.Errorf("decision = %v, want %s by %q", d, w[0], w[1])
+		}
+	}
+}
+
+func TestExplainIgnoreOff(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"main.go": ""})
+	logs := captureLogs(t)
+	r, err := contextTar(dir, BuildOptions{IgnorePatterns: []string{"*.log"}})
+	if err != nil {
+		t.Fatalf("contextTar: %v", err)
+	}
+	readTar(t, r)
+	if d := logsWithMsg(logs(), "IgnoreDecision"); len(d) != 0 {
+		t.Errorf("decisions logged without ExplainIgnore: %v", d)
+	}
+}
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	MissingHealthcheckErr = errors.New("image has no healthcheck")
+)
+
+// checkImage runs the post-build checks of opts over the built image,
+// built with the args
+func (c Client) checkImage(ctx context.Context, out io.Writer, image string, opts BuildOptions, args map[string]string) error {
+	if err := c.checkSecrets(ctx, out, image, args, opts.StrictSecrets); err != nil {
+		return err
+	}
+	if !opts.RequireHealthcheck {
+		return nil
+	}
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ImageBuildErr, err)
+	}
+	return checkHealthcheck(image, inspect)
+}
+
+// checkHealthcheck fails if the image config defines no healthcheck,
+// or disables the one of its base image (HEALTHCHECK NONE)
+func checkHealthcheck(image string, inspect types.ImageInspect) error {
+	if inspect.Config == nil || inspect.Config.Healthcheck == nil {
+		return fmt.Errorf("%w: %s", MissingHealthcheckErr, image)
+	}
+	test := inspect.Config.Healthcheck.Test
+	if len(test) == 0 || test[0] == "NONE" {
+		return fmt.Errorf("%w: %s", MissingHealthcheckErr, image)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestCheckHealthcheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *container.Config
+		wantErr bool
+	}{
+		{name: "no config", config: nil, wantErr: true},
+		{name: "no healthcheck", config: &container.Config{}, wantErr: true},
+		{name: "disabled", config: &container.Config{Healthcheck: &container.HealthConfig{Test: []string{"NONE"}}}, wantErr: true},
+		{name: "empty test", config: &container.Config{Healthcheck: &container.HealthConfig{}}, wantErr: true},
+		{name: "defined", config: &container.Config{Healthcheck: &container.HealthConfig{Test: []string{"CMD-SHELL", "curl -f http://localhost/"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkHealthcheck("app:1", types.ImageInspect{Config: tt.config})
+			if tt.wantErr && !errors.Is(err, MissingHealthcheckErr) {
+				t.Errorf("err = %v, want %v", err, MissingHealthcheckErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+		})
+	}
+}
+
+func TestBuildRequireHealthcheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		require bool
+		image   *types.ImageInspect
+		wantErr bool
+	}{
+		{name: "not required", require: false},
+		{name: "missing", require: true, wantErr: true},
+		{name: "defined", require: true, image: &types.ImageInspect{Config: &container.Config{
+			Healthcheck: &container.HealthConfig{Test: []string{"CMD", "/healthz"}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{image: tt.image}
+			_, err := testBuild(t, api, map[string]string{dockerfileName: "FROM alpine:3.19\n"}, BuildOptions{RequireHealthcheck: tt.require})
+			if tt.wantErr && !errors.Is(err, MissingHealthcheckErr) {
+				t.Errorf("err = %v, want %v", err, MissingHealthcheckErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+		})
+	}
+}
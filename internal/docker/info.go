@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/system"
+)
+
+var (
+	DaemonInfoErr = errors.New("failed to read daemon info")
+)
+
+// archAliases maps the daemon reported (uname-like) architecture
+// names to the names used in platform strings
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"i386":    "386",
+	"i686":    "386",
+	"aarch64": "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+}
+
+// DefaultPlatform returns the platform (os/arch) the daemon builds
+// for when no platform is asked for
+func (c Client) DefaultPlatform(ctx context.Context) (string, error) {
+	info, err := c.d.Info(ctx)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", DaemonInfoErr, err)
+		return "", err
+	}
+	return platformFromInfo(info), nil
+}
+
+// ServerVersion returns the daemon version and API version
+func (c Client) ServerVersion(ctx context.Context) (string, string, error) {
+	v, err := c.d.ServerVersion(ctx)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", DaemonInfoErr, err)
+		return "", "", err
+	}
+	return v.Version, v.APIVersion, nil
+}
+
+func platformFromInfo(info system.Info) string {
+	arch := info.Architecture
+	if alias, ok := archAliases[arch]; ok {
+		arch = alias
+	}
+	return info.OSType + "/" + arch
+}
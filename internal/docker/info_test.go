@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+)
+
+func TestPlatformFromInfo(t *testing.T) {
+	tests := []struct {
+		os, arch string
+		want     string
+	}{
+		{"linux", "x86_64", "linux/amd64"},
+		{"linux", "aarch64", "linux/arm64"},
+		{"linux", "armv7l", "linux/arm/v7"},
+		{"linux", "armv6l", "linux/arm/v6"},
+		{"linux", "i686", "linux/386"},
+		{"windows", "x86_64", "windows/amd64"},
+		// already in the platform form, or unknown
+		{"linux", "arm64", "linux/arm64"},
+		{"linux", "s390x", "linux/s390x"},
+	}
+	for _, tt := range tests {
+		if got := platformFromInfo(system.Info{OSType: tt.os, Architecture: tt.arch}); got != tt.want {
+			t.Errorf("platformFromInfo(%s, %s) = %q, want %q", tt.os, tt.arch, got, tt.want)
+		}
+	}
+}
+
+// infoAPI answers the daemon info and version
+type infoAPI struct {
+	client.APIClient
+	info    system.Info
+	version types.Version
+}
+
+func (f infoAPI) Info(_ context.Context) (system.Info, error) {
+	return f.info, nil
+}
+
+func (f infoAPI) ServerVersion(_ context.Context) (types.Version, error) {
+	return f.version, nil
+}
+
+func TestDefaultPlatform(t *testing.T) {
+	c := newTestClient(infoAPI{info: system.Info{OSType: "linux", Architecture: "aarch64"}})
+	got, err := c.DefaultPlatform(context.Background())
+	if err != nil || got != "linux/arm64" {
+		t.Errorf("DefaultPlatform = %q, %v, want linux/arm64", got, err)
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	c := newTestClient(infoAPI{version: types.Version{Version: "25.0.3", APIVersion: "1.44"}})
+	version, api, err := c.ServerVersion(context.Background())
+	if err != nil || version != "25.0.3" || api != "1.44" {
+		t.Errorf("ServerVersion = %q, %q, %v, want 25.0.3, 1.44", version, api, err)
+	}
+}
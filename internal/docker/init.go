@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	InvalidInitContainerErr = errors.New("invalid init container")
+	InitContainerErr        = errors.New("init container failed")
+)
+
+// InitContainer is a one-shot container run to completion before the
+// main one, sharing its mounts
+type InitContainer struct {
+	Image string
+	// Cmd overrides the image command (the image one if empty)
+	Cmd []string
+}
+
+// ParseInitContainer parses an IMAGE:CMD init container. The command
+// starts after the last colon of the first word, so a tagged image is
+// given as IMAGE:TAG:CMD. It runs through /bin/sh -c.
+func ParseInitContainer(s string) (InitContainer, error) {
+	word, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	i := strings.LastIndex(word, ":")
+	if i < 0 {
+		return InitContainer{}, fmt.Errorf("%w: %q (expected IMAGE:CMD)", InvalidInitContainerErr, s)
+	}
+	image := word[:i]
+	cmd := strings.TrimSpace(word[i+1:] + " " + rest)
+	if image == "" || cmd == "" {
+		return InitContainer{}, fmt.Errorf("%w: %q (expected IMAGE:CMD)", InvalidInitContainerErr, s)
+	}
+	return InitContainer{Image: image, Cmd: []string{"/bin/sh", "-c", cmd}}, nil
+}
+
+// createSharedVolumes creates the anonymous managed volumes shared by
+// the init containers and the main one, returning their mounts
+func (c Client) createSharedVolumes(ctx context.Context, targets []string) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	for _, t := range targets {
+		if !strings.HasPrefix(t, "/") {
+			c.removeVolumes(mounts)
+			return nil, fmt.Errorf("%w: shared volume path %q must be absolute", InvalidInitContainerErr, t)
+		}
+		v, err := c.d.VolumeCreate(ctx, volume.CreateOptions{
+			Labels: map[string]string{ManagedLabel: "true"},
+		})
+		if err != nil {
+			c.removeVolumes(mounts)
+			return nil, fmt.Errorf("%w: %w", ContainerRunErr, err)
+		}
+		mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: v.Name, Target: t})
+	}
+	return mounts, nil
+}
+
+// removeVolumes removes the volumes of the mounts
+func (c Client) removeVolumes(mounts []mount.Mount) {
+	for _, m := range mounts {
+		_ = c.d.VolumeRemove(context.Background(), m.Source, true)
+	}
+}
+
+// runInitContainers runs the init containers in order, with the main
+// container mounts, failing with the logs of the first one that
+// doesn't exit with 0
+func (c Client) runInitContainers(ctx context.Context, inits []InitContainer, mounts []mount.Mount, platform *ocispec.Platform) error {
+	for i, ic := range inits {
+		fmt.Printf("Init container %d/%d: %s\n", i+1, len(inits), ic.Image)
+		code, out, err := c.runHelper(ctx, &container.Config{
+			Image: ic.Image,
+			Cmd:   ic.Cmd,
+		}, &container.HostConfig{
+			Mounts: mounts,
+		}, platform)
+		if err != nil {
+			return fmt.Errorf("%w: %d/%d (%s): %w", InitContainerErr, i+1, len(inits), ic.Image, err)
+		}
+		if code != 0 {
+			return fmt.Errorf("%w: %d/%d (%s) exited with code %d:\n%s", InitContainerErr, i+1, len(inits), ic.Image, code, strings.TrimRight(out, "\n"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestParseInitContainer(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    InitContainer
+		wantErr bool
+	}{
+		{s: "alpine:touch /data/ready", want: InitContainer{Image: "alpine", Cmd: []string{"/bin/sh", "-c", "touch /data/ready"}}},
+		{s: "alpine:3.19:touch /data/ready", want: InitContainer{Image: "alpine:3.19", Cmd: []string{"/bin/sh", "-c", "touch /data/ready"}}},
+		{s: "registry.local:5000/tools:1.2:migrate --up", want: InitContainer{Image: "registry.local:5000/tools:1.2", Cmd: []string{"/bin/sh", "-c", "migrate --up"}}},
+		{s: "  busybox:sleep 1  ", want: InitContainer{Image: "busybox", Cmd: []string{"/bin/sh", "-c", "sleep 1"}}},
+		{s: "alpine", wantErr: true},
+		{s: "alpine:", wantErr: true},
+		{s: ":touch /data/ready", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseInitContainer(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidInitContainerErr) {
+					t.Errorf("err = %v, want %v", err, InvalidInitContainerErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseInitContainer: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseInitContainer = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunInitContainers(t *testing.T) {
+	mounts := []mount.Mount{{Type: mount.TypeVolume, Source: "shared", Target: "/data"}}
+	inits := []InitContainer{
+		{Image: "alpine:3.19", Cmd: []string{"/bin/sh", "-c", "touch /data/ready"}},
+		{Image: "busybox", Cmd: []string{"/bin/sh", "-c", "migrate"}},
+		{Image: "never-run"},
+	}
+	api := &helperAPI{run: func(r helperRun) (int, string) {
+		if r.Config.Image == "busybox" {
+			return 3, "migration failed\n"
+		}
+		return 0, ""
+	}}
+	err := newTestClient(api).runInitContainers(context.Background(), inits, mounts, nil)
+	if !errors.Is(err, InitContainerErr) {
+		t.Fatalf("err = %v, want %v", err, InitContainerErr)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "2/3 (busybox) exited with code 3") || !strings.Contains(msg, "migration failed") {
+		t.Errorf("err = %v, want the failing container and its logs", err)
+	}
+	if got := api.images(); !equalStrings(got, []string{"alpine:3.19", "busybox"}) {
+		t.Errorf("ran %v, want the init containers up to the failing one", got)
+	}
+	for _, r := range api.runs {
+		if !reflect.DeepEqual(r.HostConfig.Mounts, mounts) {
+			t.Errorf("%s mounts = %+v, want the main container ones", r.Config.Image, r.HostConfig.Mounts)
+		}
+	}
+	if len(api.removed) != 2 {
+		t.Errorf("%d containers removed, want 2", len(api.removed))
+	}
+}
+
+func TestCreateSharedVolumes(t *testing.T) {
+	api := &volumeAPI{}
+	mounts, err := newTestClient(api).createSharedVolumes(context.Background(), []string{"/data", "/cache"})
+	if err != nil {
+		t.Fatalf("createSharedVolumes: %v", err)
+	}
+	if len(mounts) != 2 || mounts[0].Target != "/data" || mounts[1].Target != "/cache" || api.created != 2 {
+		t.Errorf("mounts = %+v (%d volumes created), want /data and /cache", mounts, api.created)
+	}
+
+	api = &volumeAPI{}
+	_, err = newTestClient(api).createSharedVolumes(context.Background(), []string{"/data", "cache"})
+	if !errors.Is(err, InvalidInitContainerErr) {
+		t.Fatalf("err = %v, want %v", err, InvalidInitContainerErr)
+	}
+	if api.removed != api.created {
+		t.Errorf("%d volumes created, %d removed, want none left", api.created, api.removed)
+	}
+}
@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+var (
+	runningInRe    = regexp.MustCompile(`^\s*---> Running in ([0-9a-f]{12,64})\s*$`)
+	removingRe     = regexp.MustCompile(`^\s*Removing intermediate container ([0-9a-f]{12,64})\s*$`)
+	stepImageRe    = regexp.MustCompile(`^\s*---> ([0-9a-f]{12,64})\s*$`)
+	untaggedImages = map[string]bool{"<none>:<none>": true, "<none>@<none>": true}
+)
+
+// intermediateCollector records the intermediate containers and step
+// images a classic builder build reports in its output
+type intermediateCollector struct {
+	// containers are the step containers, in order, and removed the
+	// ones the builder removed itself
+	containers []string
+	removed    map[string]bool
+	// images are the step images, in order
+	images []string
+}
+
+func newIntermediateCollector() *intermediateCollector {
+	return &intermediateCollector{removed: map[string]bool{}}
+}
+
+func (c *intermediateCollector) Handle(e buildEvent) {
+	if e.Kind != eventLine {
+		return
+	}
+	if m := runningInRe.FindStringSubmatch(e.Text); m != nil {
+		c.containers = append(c.containers, m[1])
+	} else if m := removingRe.FindStringSubmatch(e.Text); m != nil {
+		c.removed[m[1]] = true
+	} else if m := stepImageRe.FindStringSubmatch(e.Text); m != nil {
+		c.images = append(c.images, m[1])
+	}
+}
+
+// Leftovers returns the step containers never removed, the last first
+func (c *intermediateCollector) Leftovers() []string {
+	var res []string
+	for i := len(c.containers) - 1; i >= 0; i-- {
+		if id := c.containers[i]; !c.removed[id] {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// danglingStepImages selects, the last step first, the untagged images
+// of the step ids created after the build start. Cache hits, created
+// by earlier builds, fall before it. Creation times are in seconds, so
+// the start second itself is left out.
+func danglingStepImages(ids []string, images []types.ImageSummary, start time.Time) []types.ImageSummary {
+	byID := map[string]types.ImageSummary{}
+	for _, img := range images {
+		byID[strings.TrimPrefix(img.ID, "sha256:")] = img
+	}
+	var res []types.ImageSummary
+	seen := map[string]bool{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		for id, img := range byID {
+			if !strings.HasPrefix(id, ids[i]) || seen[id] {
+				continue
+			}
+			seen[id] = true
+			if img.Created > start.Unix() && isUntagged(img) {
+				res = append(res, img)
+			}
+		}
+	}
+	return res
+}
+
+func isUntagged(img types.ImageSummary) bool {
+	for _, t := range img.RepoTags {
+		if !untaggedImages[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanFailedBuild removes the leftover step containers and the
+// dangling step images of a failed classic builder build started at
+// start, never touching anything older
+func (c Client) cleanFailedBuild(out io.Writer, start time.Time, ic *intermediateCollector) {
+	ctx := context.Background()
+	var containers, images int
+	for _, id := range ic.Leftovers() {
+		if err := c.d.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+			slog.With("container", id, "error", err.Error()).Warn("IntermediateContainerRemoveFailed")
+			continue
+		}
+		containers++
+	}
+	if len(ic.images) > 0 {
+		list, err := c.d.ImageList(ctx, types.ImageListOptions{All: true})
+		if err != nil {
+			slog.With("error", err.Error()).Warn("IntermediateImageListFailed")
+		}
+		// children first, without pruning the parents, which can be
+		// cache hits of earlier builds
+		for _, img := range danglingStepImages(ic.images, list, start) {
+			if _, err := c.d.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{}); err != nil {
+				slog.With("image", img.ID, "error", err.Error()).Warn("IntermediateImageRemoveFailed")
+				continue
+			}
+			images++
+		}
+	}
+	if containers > 0 || images > 0 {
+		_, _ = fmt.Fprintf(out, "Removed %d intermediate containers and %d images of the failed build\n", containers, images)
+	}
+}
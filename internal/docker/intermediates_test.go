@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// Step containers and images of a failed classic builder build
+const (
+	stepContainer1 = "c0ffee000001"
+	stepContainer2 = "c0ffee000002"
+	cachedImage    = "aaaaaaaaaaaa"
+	stepImage      = "bbbbbbbbbbbb"
+)
+
+// failedIntermediatesStream is a build of 3 steps, the first a cache
+// hit, failing on the last one and leaving its container
+func failedIntermediatesStream() string {
+	var b strings.Builder
+	for _, line := range []string{
+		"Step 1/3 : FROM alpine:3.19\n",
+		" ---> " + cachedImage + "\n",
+		"Step 2/3 : RUN make\n",
+		" ---> Running in " + stepContainer1 + "\n",
+		"Removing intermediate container " + stepContainer1 + "\n",
+		" ---> " + stepImage + "\n",
+		"Step 3/3 : RUN make test\n",
+		" ---> Running in " + stepContainer2 + "\n",
+	} {
+		b.WriteString(streamMessage(map[string]any{"stream": line}))
+	}
+	b.WriteString(streamMessage(map[string]any{"errorDetail": map[string]any{"message": "boom"}, "error": "boom"}))
+	return b.String()
+}
+
+func TestIntermediateCollector(t *testing.T) {
+	ic := newIntermediateCollector()
+	for _, line := range []string{
+		" ---> " + cachedImage,
+		" ---> Running in " + stepContainer1,
+		"Removing intermediate container " + stepContainer1,
+		" ---> " + stepImage,
+		" ---> Running in " + stepContainer2,
+		"Step 4/4 : RUN echo ---> Running in " + stepContainer1,
+	} {
+		ic.Handle(buildEvent{Kind: eventLine, Text: line})
+	}
+	ic.Handle(buildEvent{Kind: eventStatus, Text: " ---> Running in c0ffee000003"})
+	if got := ic.Leftovers(); !equalStrings(got, []string{stepContainer2}) {
+		t.Errorf("Leftovers = %v, want %s", got, stepContainer2)
+	}
+	if !equalStrings(ic.images, []string{cachedImage, stepImage}) {
+		t.Errorf("images = %v, want %s and %s", ic.images, cachedImage, stepImage)
+	}
+}
+
+func TestDanglingStepImages(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	images := []image.Summary{
+		{ID: "sha256:" + cachedImage + "0001", Created: start.Unix() - 3600, RepoTags: []string{"<none>:<none>"}},
+		{ID: "sha256:" + stepImage + "0001", Created: start.Unix() + 5},
+		{ID: "sha256:cccccccccccc0001", Created: start.Unix() + 6, RepoTags: []string{"app:1"}},
+		{ID: "sha256:dddddddddddd0001", Created: start.Unix()},
+		{ID: "sha256:eeeeeeeeeeee0001", Created: start.Unix() + 7},
+	}
+	ids := []string{cachedImage, stepImage, "cccccccccccc", "dddddddddddd"}
+	var got []string
+	for _, img := range danglingStepImages(ids, images, start) {
+		got = append(got, img.ID)
+	}
+	if !equalStrings(got, []string{"sha256:" + stepImage + "0001"}) {
+		t.Errorf("danglingStepImages = %v, want only the untagged image created by the build", got)
+	}
+}
+
+func TestBuildCleansFailedBuild(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nRUN make\nRUN make test\n")}}
+	tests := []struct {
+		name           string
+		opts           BuildOptions
+		wantContainers []string
+		wantImages     []string
+	}{
+		{
+			name:           "clean",
+			opts:           BuildOptions{CleanFailedBuilds: true},
+			wantContainers: []string{stepContainer2},
+			wantImages:     []string{"sha256:" + stepImage + "0001"},
+		},
+		{name: "kept", opts: BuildOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Unix()
+			api := &buildAPI{
+				stream: failedIntermediatesStream(),
+				images: []image.Summary{
+					{ID: "sha256:" + cachedImage + "0001", Created: now - 3600},
+					{ID: "sha256:" + stepImage + "0001", Created: now + 60},
+				},
+			}
+			var out strings.Builder
+			if _, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, &out, nil); err == nil {
+				t.Fatal("build err = nil, want the step failure")
+			}
+			if !equalStrings(api.containers, tt.wantContainers) || !equalStrings(api.removed(), tt.wantImages) {
+				t.Errorf("removed containers %v and images %v, want %v and %v", api.containers, api.removed(), tt.wantContainers, tt.wantImages)
+			}
+			if cleaned := strings.Contains(out.String(), "Removed 1 intermediate containers and 1 images"); cleaned != (tt.wantContainers != nil) {
+				t.Errorf("output = %q, want the cleanup reported: %v", out.String(), tt.wantContainers != nil)
+			}
+		})
+	}
+}
+
+func TestKeepImagesSkipsFailedBuildCleanup(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepImages bool
+		want       int
+	}{
+		{name: "cleaned", want: 2},
+		{name: "keep images", keepImages: true, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{stream: failedStream("returned a non-zero code: 1", "FROM alpine:3.19", "RUN false")}
+			fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nRUN false\n")}}
+			_, err := newTestClient(api).BuildFS(context.Background(), fsys, BuildOptions{
+				CleanFailedBuilds: true,
+				KeepImages:        tt.keepImages,
+			})
+			if !errors.Is(err, BuildStepErr) {
+				t.Fatalf("BuildFS err = %v, want %v", err, BuildStepErr)
+			}
+			if got := len(api.containers); got != tt.want {
+				t.Errorf("removed step containers = %v, want %d", api.containers, tt.want)
+			}
+		})
+	}
+}
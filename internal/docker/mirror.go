@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+var (
+	InvalidMirrorErr = errors.New("invalid registry mirror")
+)
+
+// OriginalImageLabel holds the image reference asked for, on the
+// containers created from a mirror rewritten one
+const OriginalImageLabel = "docker-runner.image.original"
+
+// WithMirrors rewrites the images pulled from the registries (keys) to
+// the mirror repository prefix (values), e.g. docker.io to
+// mirror.corp/dockerhub. Other registries are left untouched.
+func WithMirrors(mirrors map[string]string) ClientOption {
+	return func(c *Client) {
+		if len(mirrors) > 0 {
+			c.mirrors = mirrors
+		}
+	}
+}
+
+// ParseMirror parses a REGISTRY=PREFIX mirror
+func ParseMirror(s string) (string, string, error) {
+	registry, prefix, ok := strings.Cut(s, "=")
+	registry = normalizeRegistry(strings.TrimSpace(registry))
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	if !ok || registry == "" || prefix == "" {
+		return "", "", fmt.Errorf("%w: %q (expected REGISTRY=PREFIX)", InvalidMirrorErr, s)
+	}
+	if _, err := reference.ParseNormalizedNamed(prefix + "/image"); err != nil {
+		return "", "", fmt.Errorf("%w: %q: %w", InvalidMirrorErr, s, err)
+	}
+	return registry, prefix, nil
+}
+
+// normalizeRegistry maps the Docker Hub aliases to docker.io
+func normalizeRegistry(r string) string {
+	switch r {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubDomain
+	}
+	return r
+}
+
+// mirrorImage returns the image reference rewritten to its registry
+// mirror. References without a mirror, or that can't be parsed, are
+// returned as is.
+func (c Client) mirrorImage(image string) string {
+	return mirrorRef(c.mirrors, image)
+}
+
+// mirrorRef rewrites the fully qualified form of ref (alpine is
+// docker.io/library/alpine) under the registry mirror prefix, keeping
+// its tag and digest
+func mirrorRef(mirrors map[string]string, ref string) string {
+	if len(mirrors) == 0 {
+		return ref
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	prefix, ok := mirrors[normalizeRegistry(reference.Domain(named))]
+	if !ok {
+		return ref
+	}
+	res := prefix + "/" + reference.Path(named)
+	if t, ok := named.(reference.Tagged); ok {
+		res += ":" + t.Tag()
+	}
+	if d, ok := named.(reference.Digested); ok {
+		res += "@" + d.Digest().String()
+	}
+	if _, err := reference.ParseNormalizedNamed(res); err != nil {
+		return ref
+	}
+	return res
+}
+
+// labelOriginalImage records the image asked for in the labels when
+// the mirror rewrote it
+func labelOriginalImage(labels map[string]string, original, image string) {
+	if original != image {
+		labels[OriginalImageLabel] = original
+	}
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMirror(t *testing.T) {
+	tests := []struct {
+		s        string
+		registry string
+		prefix   string
+		wantErr  bool
+	}{
+		{s: "docker.io=mirror.corp/dockerhub", registry: "docker.io", prefix: "mirror.corp/dockerhub"},
+		{s: " index.docker.io = mirror.corp/dockerhub/ ", registry: "docker.io", prefix: "mirror.corp/dockerhub"},
+		{s: "ghcr.io=mirror.corp:5000/ghcr", registry: "ghcr.io", prefix: "mirror.corp:5000/ghcr"},
+		{s: "docker.io", wantErr: true},
+		{s: "=mirror.corp", wantErr: true},
+		{s: "docker.io=", wantErr: true},
+		{s: "docker.io=Mirror.Corp/UPPER", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			registry, prefix, err := ParseMirror(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidMirrorErr) {
+					t.Errorf("err = %v, want %v", err, InvalidMirrorErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMirror: %v", err)
+			}
+			if registry != tt.registry || prefix != tt.prefix {
+				t.Errorf("ParseMirror = %q, %q, want %q, %q", registry, prefix, tt.registry, tt.prefix)
+			}
+		})
+	}
+}
+
+func TestMirrorRef(t *testing.T) {
+	mirrors := map[string]string{
+		"docker.io": "mirror.corp/dockerhub",
+		"ghcr.io":   "mirror.corp/ghcr",
+	}
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "alpine", want: "mirror.corp/dockerhub/library/alpine"},
+		{ref: "alpine:3.19", want: "mirror.corp/dockerhub/library/alpine:3.19"},
+		{ref: "docker.io/bitnami/redis:7", want: "mirror.corp/dockerhub/bitnami/redis:7"},
+		{ref: "index.docker.io/library/alpine", want: "mirror.corp/dockerhub/library/alpine"},
+		{ref: "alpine@" + digest, want: "mirror.corp/dockerhub/library/alpine@" + digest},
+		{ref: "alpine:3.19@" + digest, want: "mirror.corp/dockerhub/library/alpine:3.19@" + digest},
+		{ref: "ghcr.io/org/tool:1", want: "mirror.corp/ghcr/org/tool:1"},
+		{ref: "quay.io/org/tool:1", want: "quay.io/org/tool:1"},
+		{ref: "Not A Ref", want: "Not A Ref"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := mirrorRef(mirrors, tt.ref); got != tt.want {
+				t.Errorf("mirrorRef = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := mirrorRef(nil, "alpine"); got != "alpine" {
+		t.Errorf("mirrorRef without mirrors = %q, want the ref as is", got)
+	}
+}
+
+func TestWithMirrors(t *testing.T) {
+	var c Client
+	WithMirrors(map[string]string{"docker.io": "mirror.corp/dockerhub"})(&c)
+	if got := c.mirrorImage("alpine:3.19"); got != "mirror.corp/dockerhub/library/alpine:3.19" {
+		t.Errorf("mirrorImage = %q, want the mirror ref", got)
+	}
+
+	labels := map[string]string{}
+	labelOriginalImage(labels, "alpine:3.19", c.mirrorImage("alpine:3.19"))
+	if labels[OriginalImageLabel] != "alpine:3.19" {
+		t.Errorf("labels = %v, want the original image", labels)
+	}
+	labels = map[string]string{}
+	labelOriginalImage(labels, "quay.io/org/tool:1", c.mirrorImage("quay.io/org/tool:1"))
+	if _, ok := labels[OriginalImageLabel]; ok {
+		t.Errorf("labels = %v, want no original image for an untouched ref", labels)
+	}
+}
@@ -0,0 +1,142 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/agext/levenshtein"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+var (
+	InvalidNetworkErr  = errors.New("invalid network")
+	NetworkNotFoundErr = errors.New("network not found")
+)
+
+const (
+	externalNetworkPrefix = "external:"
+	containerNetNSPrefix  = "container:"
+	// maxNetworkSuggestions is the number of close network names
+	// suggested when one isn't found
+	maxNetworkSuggestions = 3
+)
+
+// ParseExternalNetwork parses an external:NAME network, an existing
+// network (e.g. of a compose project) the container joins
+func ParseExternalNetwork(s string) (string, error) {
+	name, ok := strings.CutPrefix(s, externalNetworkPrefix)
+	if !ok || name == "" {
+		return "", fmt.Errorf("%w: %q (expected external:NAME)", InvalidNetworkErr, s)
+	}
+	return name, nil
+}
+
+// ParseNetNS parses a container:NAME network namespace, the container
+// sharing its network stack (and localhost) with the run one
+func ParseNetNS(s string) (string, error) {
+	name, ok := strings.CutPrefix(s, containerNetNSPrefix)
+	if !ok || name == "" {
+		return "", fmt.Errorf("%w: %q (expected container:NAME)", InvalidNetworkErr, s)
+	}
+	return name, nil
+}
+
+// applyRunNetwork sets the network settings of the run options on the
+// container host config, checking the external network or the netns
+// container exist. Teardown only removes the container, which
+// disconnects it: the network itself is never removed.
+func (c Client) applyRunNetwork(ctx context.Context, opts RunOptions, hostCfg *container.HostConfig) (*network.NetworkingConfig, error) {
+	if opts.Network != "" && opts.NetNS != "" {
+		return nil, fmt.Errorf("%w: an external network and a shared netns can't be used together", InvalidNetworkErr)
+	}
+	if opts.NetNS != "" {
+		inspect, err := c.d.ContainerInspect(ctx, opts.NetNS)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				return nil, fmt.Errorf("%w: container %s, whose netns is shared, not found", InvalidNetworkErr, opts.NetNS)
+			}
+			return nil, fmt.Errorf("%w: %w", ContainerRunErr, err)
+		}
+		if inspect.State == nil || !inspect.State.Running {
+			return nil, fmt.Errorf("%w: container %s, whose netns is shared, isn't running", InvalidNetworkErr, opts.NetNS)
+		}
+		hostCfg.NetworkMode = container.NetworkMode(containerNetNSPrefix + opts.NetNS)
+		return nil, nil
+	}
+	if opts.Network == "" {
+		return nil, nil
+	}
+
+	if err := c.checkNetwork(ctx, opts.Network); err != nil {
+		return nil, err
+	}
+	hostCfg.NetworkMode = container.NetworkMode(opts.Network)
+	endpoint := &network.EndpointSettings{}
+	if alias := opts.NetworkAlias; alias != "" {
+		endpoint.Aliases = []string{alias}
+	} else if opts.Name != "" {
+		endpoint.Aliases = []string{opts.Name}
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{opts.Network: endpoint},
+	}, nil
+}
+
+// checkNetwork fails with the close network names if the network
+// doesn't exist
+func (c Client) checkNetwork(ctx context.Context, name string) error {
+	networks, err := c.d.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	names := make([]string, 0, len(networks))
+	for _, n := range networks {
+		if n.Name == name || n.ID == name {
+			return nil
+		}
+		names = append(names, n.Name)
+	}
+	if close := closeNames(name, names, maxNetworkSuggestions); len(close) > 0 {
+		return fmt.Errorf("%w: %s (did you mean %s?)", NetworkNotFoundErr, name, strings.Join(close, ", "))
+	}
+	return fmt.Errorf("%w: %s", NetworkNotFoundErr, name)
+}
+
+// closeNames returns up to max names close to name (containing it, or
+// a few edits away), the closest first
+func closeNames(name string, names []string, max int) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, n := range names {
+		d := levenshtein.Distance(name, n, nil)
+		if strings.Contains(n, name) || strings.Contains(name, n) {
+			d = 0
+		}
+		if d <= len(name)/3+1 {
+			candidates = append(candidates, candidate{name: n, distance: d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	res := make([]string, 0, max)
+	for _, c := range candidates {
+		if len(res) == max {
+			break
+		}
+		res = append(res, c.name)
+	}
+	return res
+}
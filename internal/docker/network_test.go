@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+func TestParseExternalNetwork(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{s: "external:backend", want: "backend"},
+		{s: "external:shop_default", want: "shop_default"},
+		{s: "external:", wantErr: true},
+		{s: "backend", wantErr: true},
+		{s: "container:db", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseExternalNetwork(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidNetworkErr) {
+					t.Errorf("err = %v, want %v", err, InvalidNetworkErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseExternalNetwork = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetNS(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{s: "container:db", want: "db"},
+		{s: "container:", wantErr: true},
+		{s: "db", wantErr: true},
+		{s: "external:backend", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseNetNS(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidNetworkErr) {
+					t.Errorf("err = %v, want %v", err, InvalidNetworkErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseNetNS = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloseNames(t *testing.T) {
+	names := []string{"bridge", "host", "none", "shop_default", "shop_backend", "billing_default", "backend"}
+	tests := []struct {
+		name string
+		max  int
+		want []string
+	}{
+		{name: "backend", max: 3, want: []string{"backend", "shop_backend"}},
+		{name: "shop_defualt", max: 3, want: []string{"shop_default"}},
+		{name: "shop", max: 3, want: []string{"shop_backend", "shop_default"}},
+		{name: "shop", max: 1, want: []string{"shop_backend"}},
+		{name: "brige", max: 3, want: []string{"bridge"}},
+		{name: "frontend", max: 3, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := closeNames(tt.name, names, tt.max); !equalStrings(got, tt.want) {
+				t.Errorf("closeNames = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// networkAPI fakes a daemon with the networks and containers (running
+// if true)
+type networkAPI struct {
+	client.APIClient
+	networks   []string
+	containers map[string]bool
+}
+
+func (f networkAPI) NetworkList(_ context.Context, _ types.NetworkListOptions) ([]types.NetworkResource, error) {
+	res := make([]types.NetworkResource, 0, len(f.networks))
+	for _, n := range f.networks {
+		res = append(res, types.NetworkResource{Name: n, ID: n + "-id"})
+	}
+	return res, nil
+}
+
+func (f networkAPI) ContainerInspect(_ context.Context, name string) (types.ContainerJSON, error) {
+	running, ok := f.containers[name]
+	if !ok {
+		return types.ContainerJSON{}, errdefs.NotFound(errors.New("no such container: " + name))
+	}
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{Running: running}}}, nil
+}
+
+func TestApplyRunNetwork(t *testing.T) {
+	c := newTestClient(networkAPI{
+		networks:   []string{"bridge", "shop_default"},
+		containers: map[string]bool{"db": true, "stopped": false},
+	})
+	tests := []struct {
+		name     string
+		opts     RunOptions
+		mode     string
+		aliases  []string
+		wantErr  error
+		errMatch string
+	}{
+		{name: "default", opts: RunOptions{}},
+		{name: "external", opts: RunOptions{Network: "shop_default", Name: "api"}, mode: "shop_default", aliases: []string{"api"}},
+		{name: "external by ID", opts: RunOptions{Network: "shop_default-id"}, mode: "shop_default-id", aliases: nil},
+		{name: "alias", opts: RunOptions{Network: "shop_default", Name: "api", NetworkAlias: "backend"}, mode: "shop_default", aliases: []string{"backend"}},
+		{name: "missing network", opts: RunOptions{Network: "shop_defualt"}, wantErr: NetworkNotFoundErr, errMatch: "did you mean shop_default?"},
+		{name: "netns", opts: RunOptions{NetNS: "db"}, mode: "container:db"},
+		{name: "netns missing", opts: RunOptions{NetNS: "cache"}, wantErr: InvalidNetworkErr, errMatch: "not found"},
+		{name: "netns stopped", opts: RunOptions{NetNS: "stopped"}, wantErr: InvalidNetworkErr, errMatch: "isn't running"},
+		{name: "both", opts: RunOptions{Network: "shop_default", NetNS: "db"}, wantErr: InvalidNetworkErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostCfg := &container.HostConfig{}
+			netCfg, err := c.applyRunNetwork(context.Background(), tt.opts, hostCfg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.errMatch) {
+					t.Errorf("err = %v, want %v (%s)", err, tt.wantErr, tt.errMatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyRunNetwork: %v", err)
+			}
+			if string(hostCfg.NetworkMode) != tt.mode {
+				t.Errorf("NetworkMode = %q, want %q", hostCfg.NetworkMode, tt.mode)
+			}
+			if tt.opts.Network == "" {
+				if netCfg != nil {
+					t.Errorf("networking config = %+v, want none", netCfg)
+				}
+				return
+			}
+			endpoint := netCfg.EndpointsConfig[tt.opts.Network]
+			if endpoint == nil || !equalStrings(endpoint.Aliases, tt.aliases) {
+				t.Errorf("endpoint = %+v, want the aliases %v", endpoint, tt.aliases)
+			}
+		})
+	}
+}
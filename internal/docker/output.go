@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	InvalidBuildOutputErr = errors.New("invalid build output option")
+)
+
+// BuildOutputMode defines how the build stream is printed
+type BuildOutputMode string
+
+const (
+	// BuildOutputFull prints every line of the build stream
+	BuildOutputFull BuildOutputMode = "full"
+	// BuildOutputFailuresOnly prints a summary line per successful
+	// step and the full output of the failing one
+	BuildOutputFailuresOnly BuildOutputMode = "failures-only"
+	// BuildOutputErrorsWarnings is like BuildOutputFailuresOnly, but
+	// also prints the lines matching the warning patterns
+	BuildOutputErrorsWarnings BuildOutputMode = "errors+warnings"
+)
+
+const (
+	// DefaultOutputContextLines is the number of lines of the step
+	// before a failing one printed in the non full modes
+	DefaultOutputContextLines = 5
+	// DefaultBuildTail is the number of last stream lines printed again
+	// when a build fails
+	DefaultBuildTail = 20
+)
+
+// DefaultWarningPatterns are the patterns used to detect warning
+// lines when none are configured
+var DefaultWarningPatterns = []string{
+	`(?i)\bwarn(ing)?\b`,
+	`(?i)\bdeprecated\b`,
+}
+
+// ParseBuildOutputMode validates the build output mode name
+func ParseBuildOutputMode(s string) (BuildOutputMode, error) {
+	switch m := BuildOutputMode(s); m {
+	case "":
+		return BuildOutputFull, nil
+	case BuildOutputFull, BuildOutputFailuresOnly, BuildOutputErrorsWarnings:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: unknown mode %q (valid modes: %s, %s, %s)", InvalidBuildOutputErr, s, BuildOutputFull, BuildOutputFailuresOnly, BuildOutputErrorsWarnings)
+}
+
+// warningPatterns compiles the configured warning patterns, or the
+// default ones if none is set
+func warningPatterns(opts BuildOptions) ([]*regexp.Regexp, error) {
+	patterns := opts.WarningPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultWarningPatterns
+	}
+	return compilePatterns(patterns)
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad pattern %q: %w", InvalidBuildOutputErr, p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// buildPrinter renders the build events to out
+type buildPrinter struct {
+	out          io.Writer
+	mode         BuildOutputMode
+	contextLines int
+	warnings     []*regexp.Regexp
+	// pending is the step whose outcome is not known yet
+	pending *buildStep
+	// previous is the last step that completed successfully
+	previous *buildStep
+}
+
+func newBuildPrinter(out io.Writer, opts BuildOptions, warnings []*regexp.Regexp) (*buildPrinter, error) {
+	mode, err := ParseBuildOutputMode(string(opts.Output))
+	if err != nil {
+		return nil, err
+	}
+	contextLines := opts.OutputContextLines
+	if contextLines <= 0 {
+		contextLines = DefaultOutputContextLines
+	}
+	return &buildPrinter{
+		out:          out,
+		mode:         mode,
+		contextLines: contextLines,
+		warnings:     warnings,
+	}, nil
+}
+
+// Handle prints (or buffers) the event
+func (p *buildPrinter) Handle(e buildEvent) {
+	if p.mode == BuildOutputFull {
+		switch e.Kind {
+		case eventStepStart, eventLine, eventStatus:
+			_, _ = fmt.Fprintln(p.out, e.Text)
+		case eventError:
+			_, _ = fmt.Fprintln(p.out, "ERROR:", e.Text)
+		}
+		return
+	}
+
+	switch e.Kind {
+	case eventStepStart:
+		p.complete()
+		p.pending = e.Step
+	case eventLine:
+		if p.mode == BuildOutputErrorsWarnings && matchesAny(p.warnings, e.Text) {
+			_, _ = fmt.Fprintln(p.out, e.Text)
+		}
+	case eventError:
+		p.fail(e)
+	}
+}
+
+// Close prints the summary of the last step of a successful build
+func (p *buildPrinter) Close() {
+	if p.mode != BuildOutputFull {
+		p.complete()
+	}
+}
+
+func matchesAny(patterns []*regexp.Regexp, l string) bool {
+	for _, re := range patterns {
+		if re.MatchString(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *buildPrinter) complete() {
+	if p.pending == nil {
+		return
+	}
+	s := p.pending
+	summary := fmt.Sprintf("Step %d/%d ok, %.1fs", s.Number, s.Total, s.Duration.Seconds())
+	if s.Cached {
+		summary += ", cached"
+	}
+	_, _ = fmt.Fprintln(p.out, summary)
+	p.previous = s
+	p.pending = nil
+}
+
+func (p *buildPrinter) fail(e buildEvent) {
+	if p.previous != nil {
+		lines := p.previous.Lines
+		if len(lines) > p.contextLines {
+			lines = lines[len(lines)-p.contextLines:]
+		}
+		if len(lines) > 0 {
+			_, _ = fmt.Fprintf(p.out, "... last lines of %s\n", p.previous.Header())
+			_, _ = fmt.Fprintln(p.out, strings.Join(lines, "\n"))
+		}
+	}
+	if s := p.pending; s != nil {
+		_, _ = fmt.Fprintln(p.out, s.Header())
+		for _, l := range s.Lines {
+			_, _ = fmt.Fprintln(p.out, l)
+		}
+	}
+	_, _ = fmt.Fprintln(p.out, "ERROR:", e.Text)
+	p.pending = nil
+}
+
+// tailBuffer keeps the last lines of the build stream in a ring, to
+// print them again under the failure
+type tailBuffer struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	if n == 0 {
+		n = DefaultBuildTail
+	}
+	if n < 0 {
+		n = 0
+	}
+	return &tailBuffer{lines: make([]string, n)}
+}
+
+// Handle records the printable events
+func (t *tailBuffer) Handle(e buildEvent) {
+	if len(t.lines) == 0 {
+		return
+	}
+	switch e.Kind {
+	case eventStepStart, eventLine, eventStatus:
+		t.add(e.Text)
+	case eventError:
+		t.add("ERROR: " + e.Text)
+	}
+}
+
+func (t *tailBuffer) add(l string) {
+	t.lines[t.next] = l
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Lines returns the recorded lines, oldest first
+func (t *tailBuffer) Lines() []string {
+	if !t.full {
+		return t.lines[:t.next]
+	}
+	return append(append([]string{}, t.lines[t.next:]...), t.lines[:t.next]...)
+}
+
+// Print prints the recorded lines under the failure header
+func (t *tailBuffer) Print(out io.Writer) {
+	lines := t.Lines()
+	if len(lines) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintln(out, "build failed, last output:")
+	for _, l := range lines {
+		_, _ = fmt.Fprintln(out, "  "+l)
+	}
+}
@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// printStream renders the build stream with the printer of opts
+func printStream(t *testing.T, stream string, opts BuildOptions) string {
+	t.Helper()
+	warnings, err := warningPatterns(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	printer, err := newBuildPrinter(&out, opts, warnings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newStreamParser()
+	p.now = func() time.Time {
+		return time.Time{}
+	}
+	_ = p.Parse(strings.NewReader(stream), printer.Handle)
+	printer.Close()
+	return out.String()
+}
+
+func TestParseBuildOutputMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    BuildOutputMode
+		wantErr bool
+	}{
+		{in: "", want: BuildOutputFull},
+		{in: "full", want: BuildOutputFull},
+		{in: "failures-only", want: BuildOutputFailuresOnly},
+		{in: "errors+warnings", want: BuildOutputErrorsWarnings},
+		{in: "quiet", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBuildOutputMode(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBuildOutputMode(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildPrinter(t *testing.T) {
+	// the step 2 output, the context of the failing step 3
+	var step2 []string
+	for i := 1; i <= 8; i++ {
+		step2 = append(step2, "line "+strconv.Itoa(i))
+	}
+	failing := streamMessage(map[string]any{"stream": "Step 1/3 : FROM alpine:3.19\n ---> Using cache\n"}) +
+		streamMessage(map[string]any{"stream": "Step 2/3 : RUN ./configure\n" + strings.Join(step2, "\n") + "\nwarning: old compiler\n"}) +
+		streamMessage(map[string]any{"stream": "Step 3/3 : RUN make\nmake: *** [all] Error 2\n"}) +
+		streamMessage(map[string]any{"error": "returned a non-zero code: 2", "errorDetail": map[string]any{"message": "returned a non-zero code: 2"}})
+	passing := streamMessage(map[string]any{"stream": "Step 1/2 : FROM alpine:3.19\n ---> Using cache\n"}) +
+		streamMessage(map[string]any{"stream": "Step 2/2 : RUN make\nDEPRECATED: flag -x\ndone\n"})
+
+	tests := []struct {
+		name    string
+		stream  string
+		opts    BuildOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "full",
+			stream: failing,
+			want:   []string{"Step 2/3 : RUN ./configure", "line 1", "make: *** [all] Error 2", "ERROR: returned a non-zero code: 2"},
+		},
+		{
+			name:    "failures only, passing",
+			stream:  passing,
+			opts:    BuildOptions{Output: BuildOutputFailuresOnly},
+			want:    []string{"Step 1/2 ok, 0.0s, cached", "Step 2/2 ok, 0.0s"},
+			notWant: []string{"done", "DEPRECATED"},
+		},
+		{
+			name:   "failures only, failing",
+			stream: failing,
+			opts:   BuildOptions{Output: BuildOutputFailuresOnly},
+			want: []string{
+				"Step 1/3 ok, 0.0s, cached",
+				"Step 2/3 ok, 0.0s",
+				"... last lines of Step 2/3 : RUN ./configure",
+				"line 5\nline 6\nline 7\nline 8\nwarning: old compiler",
+				"Step 3/3 : RUN make\nmake: *** [all] Error 2\nERROR: returned a non-zero code: 2",
+			},
+			notWant: []string{"line 4"},
+		},
+		{
+			name:    "context lines",
+			stream:  failing,
+			opts:    BuildOptions{Output: BuildOutputFailuresOnly, OutputContextLines: 2},
+			want:    []string{"line 8\nwarning: old compiler\nStep 3/3"},
+			notWant: []string{"line 7"},
+		},
+		{
+			name:    "errors and warnings",
+			stream:  passing,
+			opts:    BuildOptions{Output: BuildOutputErrorsWarnings},
+			want:    []string{"DEPRECATED: flag -x"},
+			notWant: []string{"done"},
+		},
+		{
+			name:    "custom warning patterns",
+			stream:  passing,
+			opts:    BuildOptions{Output: BuildOutputErrorsWarnings, WarningPatterns: []string{`^done$`}},
+			want:    []string{"done"},
+			notWant: []string{"DEPRECATED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := printStream(t, tt.stream, tt.opts)
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output = %q, missing %q", out, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("output = %q, has %q", out, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPrinterBadPattern(t *testing.T) {
+	_, err := newTestClient(nil).Build(context.Background(), t.TempDir(), BuildOptions{WarningPatterns: []string{"("}})
+	if !errors.Is(err, InvalidBuildOutputErr) {
+		t.Errorf("Build err = %v, want %v", err, InvalidBuildOutputErr)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		lines int
+		want  []string
+	}{
+		{name: "not full", size: 3, lines: 2, want: []string{"line 1", "line 2"}},
+		{name: "exactly full", size: 3, lines: 3, want: []string{"line 1", "line 2", "line 3"}},
+		{name: "wrapped", size: 3, lines: 7, want: []string{"line 5", "line 6", "line 7"}},
+		{name: "disabled", size: -1, lines: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tail := newTailBuffer(tt.size)
+			for i := 1; i <= tt.lines; i++ {
+				tail.Handle(buildEvent{Kind: eventLine, Text: "line " + strconv.Itoa(i)})
+			}
+			if got := tail.Lines(); !equalStrings(got, tt.want) {
+				t.Errorf("Lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTailBufferDefault(t *testing.T) {
+	if got := len(newTailBuffer(0).lines); got != DefaultBuildTail {
+		t.Errorf("size = %d, want %d", got, DefaultBuildTail)
+	}
+}
+
+func TestBuildPrintsTailOnFailure(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nRUN make\n")}}
+	tests := []struct {
+		name    string
+		stream  string
+		want    string
+		notWant string
+	}{
+		{
+			name:   "failed",
+			stream: failedStream("The command '/bin/sh -c make' returned a non-zero code: 2", "FROM alpine:3.19", "RUN make"),
+			want: "build failed, last output:\n" +
+				"  Step 2/2 : RUN make\n" +
+				"   ---> Running in 000000000002\n" +
+				"  ERROR: The command '/bin/sh -c make' returned a non-zero code: 2\n",
+		},
+		{
+			name:    "succeeded",
+			stream:  classicStream(testImageID, "FROM alpine:3.19"),
+			notWant: "build failed, last output:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			_, _ = newTestClient(&buildAPI{stream: tt.stream}).build(context.Background(), fsContextName, fsys, BuildOptions{Tail: 3}, &out, nil)
+			if !strings.HasSuffix(out.String(), tt.want) {
+				t.Errorf("output = %q, want it to end with %q", out.String(), tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(out.String(), tt.notWant) {
+				t.Errorf("output = %q, has %q", out.String(), tt.notWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+)
+
+var (
+	InvalidOverlayErr = errors.New("invalid overlay")
+	OverlayErr        = errors.New("failed to set up overlay")
+	OverlayExportErr  = errors.New("failed to export overlay changes")
+)
+
+// Overlay gives a container a writable copy of a host folder, leaving
+// the host folder untouched
+type Overlay struct {
+	// Source is the host folder
+	Source string
+	// Target is where the container sees it
+	Target string
+}
+
+// ParseOverlay parses a SRC:TARGET overlay
+func ParseOverlay(s string) (Overlay, error) {
+	src, target, ok := strings.Cut(s, ":")
+	if !ok || src == "" || !strings.HasPrefix(target, "/") {
+		return Overlay{}, fmt.Errorf("%w: %q (expected SRC:/container/path)", InvalidOverlayErr, s)
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return Overlay{}, fmt.Errorf("%w: %w", InvalidOverlayErr, err)
+	}
+	return Overlay{Source: abs, Target: target}, nil
+}
+
+// overlayVolume is the volume holding the writable copy of an overlay
+type overlayVolume struct {
+	Overlay
+	Volume string
+}
+
+// prepareOverlay copies the overlay source to a new managed volume. The
+// daemon containers can't mount an overlayfs without privileges, so the
+// copy serves as the upper layer.
+func (c Client) prepareOverlay(ctx context.Context, o Overlay) (overlayVolume, error) {
+	content, err := tarDirectory(o.Source, tarOptions{})
+	if err != nil {
+		return overlayVolume{}, fmt.Errorf("%w: %w", OverlayErr, err)
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+
+	v, err := c.d.VolumeCreate(ctx, volume.CreateOptions{
+		Labels: map[string]string{ManagedLabel: "true"},
+	})
+	if err != nil {
+		return overlayVolume{}, fmt.Errorf("%w: %w", OverlayErr, err)
+	}
+	ov := overlayVolume{Overlay: o, Volume: v.Name}
+
+	helper, cleanup, err := c.volumeHelper(ctx, v.Name)
+	if err != nil {
+		c.removeOverlay(ov)
+		return overlayVolume{}, fmt.Errorf("%w: %w", OverlayErr, err)
+	}
+	err = c.d.CopyToContainer(ctx, helper, volumeMountPoint, content, types.CopyToContainerOptions{})
+	cleanup()
+	if err != nil {
+		c.removeOverlay(ov)
+		return overlayVolume{}, fmt.Errorf("%w: %w", OverlayErr, err)
+	}
+	fmt.Printf("Overlay %s -> %s (copy mode)\n", o.Source, o.Target)
+	return ov, nil
+}
+
+func (o overlayVolume) mount() mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: o.Volume,
+		Target: o.Target,
+	}
+}
+
+// removeOverlay removes the overlay volume, discarding its changes
+func (c Client) removeOverlay(o overlayVolume) {
+	_ = c.d.VolumeRemove(context.Background(), o.Volume, true)
+}
+
+// exportOverlay writes the files added or changed in the overlay (the
+// ones whose content differs from the source folder) to dir, under the
+// overlay target path. Deleted files aren't reported.
+func (c Client) exportOverlay(ctx context.Context, o overlayVolume, dir string) error {
+	helper, cleanup, err := c.volumeHelper(ctx, o.Volume)
+	if err != nil {
+		return fmt.Errorf("%w: %w", OverlayExportErr, err)
+	}
+	defer cleanup()
+
+	rc, _, err := c.d.CopyFromContainer(ctx, helper, volumeMountPoint)
+	if err != nil {
+		return fmt.Errorf("%w: %w", OverlayExportErr, err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	dest := filepath.Join(dir, filepath.FromSlash(o.Target))
+	prefix := volumeMountPoint[1:] + "/"
+	tr := tar.NewReader(rc)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", OverlayExportErr, err)
+		}
+		name, ok := strings.CutPrefix(h.Name, prefix)
+		if !ok || h.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%w: %w", OverlayExportErr, err)
+		}
+		rel := filepath.FromSlash(name)
+		if orig, err := os.ReadFile(filepath.Join(o.Source, rel)); err == nil && bytes.Equal(orig, b) {
+			continue
+		}
+		target := filepath.Join(dest, rel)
+		if r, err := filepath.Rel(dest, target); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fmt.Errorf("%w: %w", OverlayExportErr, err)
+		}
+		if err := os.WriteFile(target, b, os.FileMode(h.Mode)&os.ModePerm); err != nil {
+			return fmt.Errorf("%w: %w", OverlayExportErr, err)
+		}
+	}
+}
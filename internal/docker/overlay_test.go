@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOverlay(t *testing.T) {
+	abs, err := filepath.Abs("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		s       string
+		want    Overlay
+		wantErr bool
+	}{
+		{s: "src:/app", want: Overlay{Source: abs, Target: "/app"}},
+		{s: "./src:/app/src", want: Overlay{Source: abs, Target: "/app/src"}},
+		{s: "src", wantErr: true},
+		{s: ":/app", wantErr: true},
+		{s: "src:app", wantErr: true},
+		{s: "src:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseOverlay(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidOverlayErr) {
+					t.Errorf("err = %v, want %v", err, InvalidOverlayErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOverlay: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseOverlay = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlayCopyAndExport(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"main.go":      "package main\n",
+		"conf/app.yml": "debug: false\n",
+	})
+	api := &volumeAPI{}
+	c := newTestClient(api)
+	ov, err := c.prepareOverlay(context.Background(), Overlay{Source: src, Target: "/app"})
+	if err != nil {
+		t.Fatalf("prepareOverlay: %v", err)
+	}
+	if api.created != 1 {
+		t.Fatalf("%d volumes created, want 1", api.created)
+	}
+	if m := ov.mount(); m.Source != ov.Volume || m.Target != "/app" {
+		t.Errorf("mount = %+v, want the volume at /app", m)
+	}
+
+	// the container changed a file, added one and tried to escape the
+	// export folder
+	api.content = makeTar(t,
+		tarEntry{name: "main.go", mode: 0o644, content: "package main\n"},
+		tarEntry{name: "conf/", mode: 0o755, dir: true},
+		tarEntry{name: "conf/app.yml", mode: 0o644, content: "debug: true\n"},
+		tarEntry{name: "out.log", mode: 0o600, content: "started\n"},
+		tarEntry{name: "../../evil", mode: 0o644, content: "x"},
+	)
+	dir := t.TempDir()
+	if err := c.exportOverlay(context.Background(), ov, dir); err != nil {
+		t.Fatalf("exportOverlay: %v", err)
+	}
+	var got []string
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		got = append(got, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"app/conf/app.yml", "app/out.log"}
+	if !equalStrings(got, want) {
+		t.Errorf("exported = %v, want %v", got, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "app", "conf", "app.yml")); string(b) != "debug: true\n" {
+		t.Errorf("app.yml = %q, want the changed content", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(src, "conf", "app.yml")); string(b) != "debug: false\n" {
+		t.Errorf("the source app.yml changed to %q", b)
+	}
+
+	c.removeOverlay(ov)
+	if api.removed != 1 {
+		t.Errorf("%d volumes removed, want 1", api.removed)
+	}
+}
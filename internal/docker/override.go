@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+var (
+	InvalidCommandOverrideErr = errors.New("invalid command override")
+	CommandOverrideErr        = errors.New("failed to override image command")
+)
+
+// CommandOverride replaces the entrypoint and/or command of the built
+// image, in JSON (["/app", "--flag"]) or shell (/app --flag) form
+type CommandOverride struct {
+	Entrypoint string
+	Cmd        string
+}
+
+// validate checks the overrides parse, so a build doesn't run for
+// nothing
+func (o CommandOverride) validate() error {
+	for _, s := range []string{o.Entrypoint, o.Cmd} {
+		if s == "" {
+			continue
+		}
+		if _, err := parseCommandOverride(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCommandOverride parses a JSON or shell form command. The shell
+// form runs through /bin/sh -c, as in a Dockerfile.
+func parseCommandOverride(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return []string{"/bin/sh", "-c", s}, nil
+	}
+	var cmd []string
+	if err := json.Unmarshal([]byte(s), &cmd); err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", InvalidCommandOverrideErr, s, err)
+	}
+	return cmd, nil
+}
+
+// overrideCommand commits a thin layer over image, with the entrypoint
+// and command replaced, tagged as tag. An entrypoint override alone
+// resets the command. It returns the new image ID.
+func (c Client) overrideCommand(ctx context.Context, image, tag string, o CommandOverride) (string, error) {
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CommandOverrideErr, err)
+	}
+	cfg := &container.Config{}
+	if inspect.Config != nil {
+		*cfg = *inspect.Config
+	}
+	cfg.Image = image
+
+	var changes []string
+	if o.Entrypoint != "" {
+		ep, err := parseCommandOverride(o.Entrypoint)
+		if err != nil {
+			return "", err
+		}
+		cfg.Entrypoint = ep
+		changes = append(changes, "ENTRYPOINT "+jsonArray(ep))
+		if o.Cmd == "" {
+			// like docker run --entrypoint, the image command would be
+			// the args of another program
+			cfg.Cmd = nil
+			changes = append(changes, "CMD []")
+		}
+	}
+	if o.Cmd != "" {
+		cmd, err := parseCommandOverride(o.Cmd)
+		if err != nil {
+			return "", err
+		}
+		cfg.Cmd = cmd
+		changes = append(changes, "CMD "+jsonArray(cmd))
+	}
+
+	// the managed label only marks the temporary container, the
+	// committed config keeps the image labels
+	tmp := *cfg
+	tmp.Labels = map[string]string{ManagedLabel: "true"}
+	for k, v := range cfg.Labels {
+		tmp.Labels[k] = v
+	}
+	created, err := c.d.ContainerCreate(ctx, &tmp, nil, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CommandOverrideErr, err)
+	}
+	defer func() {
+		_ = c.d.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	}()
+
+	committed, err := c.d.ContainerCommit(ctx, created.ID, container.CommitOptions{
+		Reference: tag,
+		Comment:   "docker-runner command override",
+		Changes:   changes,
+		Config:    cfg,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", CommandOverrideErr, err)
+	}
+	return committed.ID, nil
+}
+
+func jsonArray(s []string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// commitAPI fakes the commit of a container of the image config
+type commitAPI struct {
+	client.APIClient
+	config *container.Config
+
+	created []*container.Config
+	commits []container.CommitOptions
+	removed []string
+}
+
+func (f *commitAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: testImageID, Config: f.config}, nil, nil
+}
+
+func (f *commitAPI) ContainerCreate(_ context.Context, cfg *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	f.created = append(f.created, cfg)
+	return container.CreateResponse{ID: testContainerID}, nil
+}
+
+func (f *commitAPI) ContainerCommit(_ context.Context, _ string, opts container.CommitOptions) (types.IDResponse, error) {
+	f.commits = append(f.commits, opts)
+	return types.IDResponse{ID: "sha256:committed"}, nil
+}
+
+func (f *commitAPI) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func TestParseCommandOverride(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: `["/app", "--port", "8080"]`, want: []string{"/app", "--port", "8080"}},
+		{in: `  []`, want: []string{}},
+		{in: "/app --port 8080", want: []string{"/bin/sh", "-c", "/app --port 8080"}},
+		{in: `["/app", `, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCommandOverride(tt.in)
+		if (err != nil) != tt.wantErr || !equalStrings(got, tt.want) {
+			t.Errorf("parseCommandOverride(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if tt.wantErr && !errors.Is(err, InvalidCommandOverrideErr) {
+			t.Errorf("parseCommandOverride(%q) err = %v, want %v", tt.in, err, InvalidCommandOverrideErr)
+		}
+	}
+	if err := (CommandOverride{Cmd: "[oops"}).validate(); !errors.Is(err, InvalidCommandOverrideErr) {
+		t.Errorf("validate = %v, want %v", err, InvalidCommandOverrideErr)
+	}
+}
+
+func TestOverrideCommand(t *testing.T) {
+	image := &container.Config{
+		Entrypoint: []string{"/docker-entrypoint.sh"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+		Labels:     map[string]string{"org.opencontainers.image.version": "1.25"},
+	}
+	tests := []struct {
+		name           string
+		override       CommandOverride
+		wantEntrypoint []string
+		wantCmd        []string
+		wantChanges    []string
+	}{
+		{
+			name:           "entrypoint",
+			override:       CommandOverride{Entrypoint: `["/app"]`},
+			wantEntrypoint: []string{"/app"},
+			wantChanges:    []string{`ENTRYPOINT ["/app"]`, "CMD []"},
+		},
+		{
+			name:           "shell form entrypoint",
+			override:       CommandOverride{Entrypoint: "/app --serve"},
+			wantEntrypoint: []string{"/bin/sh", "-c", "/app --serve"},
+			wantChanges:    []string{`ENTRYPOINT ["/bin/sh","-c","/app --serve"]`, "CMD []"},
+		},
+		{
+			name:           "command",
+			override:       CommandOverride{Cmd: "nginx -T"},
+			wantEntrypoint: []string{"/docker-entrypoint.sh"},
+			wantCmd:        []string{"/bin/sh", "-c", "nginx -T"},
+			wantChanges:    []string{`CMD ["/bin/sh","-c","nginx -T"]`},
+		},
+		{
+			name:           "both",
+			override:       CommandOverride{Entrypoint: `["/app"]`, Cmd: `["--debug"]`},
+			wantEntrypoint: []string{"/app"},
+			wantCmd:        []string{"--debug"},
+			wantChanges:    []string{`ENTRYPOINT ["/app"]`, `CMD ["--debug"]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &commitAPI{config: image}
+			id, err := newTestClient(api).overrideCommand(context.Background(), testImageID, "eldius/app:1", tt.override)
+			if err != nil {
+				t.Fatalf("overrideCommand: %v", err)
+			}
+			if id != "sha256:committed" {
+				t.Errorf("ID = %s, want the committed image", id)
+			}
+			if len(api.commits) != 1 {
+				t.Fatalf("commits = %d, want 1", len(api.commits))
+			}
+			commit := api.commits[0]
+			if commit.Reference != "eldius/app:1" {
+				t.Errorf("commit reference = %s, want eldius/app:1", commit.Reference)
+			}
+			if !equalStrings(commit.Config.Entrypoint, tt.wantEntrypoint) || !equalStrings(commit.Config.Cmd, tt.wantCmd) {
+				t.Errorf("committed entrypoint %q cmd %q, want %q %q", commit.Config.Entrypoint, commit.Config.Cmd, tt.wantEntrypoint, tt.wantCmd)
+			}
+			if !equalStrings(commit.Changes, tt.wantChanges) {
+				t.Errorf("changes = %q, want %q", commit.Changes, tt.wantChanges)
+			}
+			if _, ok := commit.Config.Labels[ManagedLabel]; ok {
+				t.Error("managed label committed to the image")
+			}
+			if len(api.created) != 1 || api.created[0].Labels[ManagedLabel] != "true" {
+				t.Errorf("temporary containers = %+v, want one managed", api.created)
+			}
+			if len(api.removed) != 1 {
+				t.Errorf("temporary container removed %d times, want once", len(api.removed))
+			}
+			// the image config is not modified
+			if image.Cmd[0] != "nginx" || image.Entrypoint[0] != "/docker-entrypoint.sh" {
+				t.Errorf("image config changed: %+v", image)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	InvalidPlatformErr      = errors.New("invalid platform")
+	EmulationUnavailableErr = errors.New("platform emulation is not available")
+	EmulationSetupErr       = errors.New("failed to set up platform emulation")
+	NotNativeErr            = errors.New("image doesn't match the daemon platform")
+)
+
+const (
+	// binfmtImage registers the qemu binfmt handlers on the daemon host
+	binfmtImage = "tonistiigi/binfmt:latest"
+	// binfmtMiscDir is where the kernel lists the registered handlers
+	binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+)
+
+// qemuArchs maps the platform architectures to the qemu binfmt
+// handler names (qemu-<name>)
+var qemuArchs = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i386",
+	"arm64":   "aarch64",
+	"arm":     "arm",
+	"riscv64": "riscv64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"mips64":  "mips64",
+}
+
+// EmulationOptions defines how cross-platform support is checked
+type EmulationOptions struct {
+	// Probe runs a tiny container for the target platform when the
+	// binfmt handlers can't be found, to confirm emulation works
+	Probe bool
+	// Setup registers the qemu binfmt handlers (with a privileged
+	// container) when the platform differs from the daemon one
+	Setup bool
+}
+
+// parsePlatform parses an os/arch[/variant] platform string
+func parsePlatform(s string) (*ocispec.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: %q (expected os/arch[/variant])", InvalidPlatformErr, s)
+	}
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func formatPlatform(p ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ensureEmulation fails early, with instructions, if the platform
+// differs from the daemon one and no emulation is registered for it
+func (c Client) ensureEmulation(ctx context.Context, platform string, opts EmulationOptions) error {
+	if platform == "" {
+		return nil
+	}
+	target, err := parsePlatform(platform)
+	if err != nil {
+		return err
+	}
+	nativeName, err := c.DefaultPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	native, err := parsePlatform(nativeName)
+	if err != nil {
+		return err
+	}
+	if target.OS != native.OS || target.Architecture == native.Architecture {
+		return nil
+	}
+
+	if opts.Setup {
+		if err := c.setupBinfmt(ctx, target.Architecture); err != nil {
+			return err
+		}
+	}
+
+	registered, err := c.binfmtRegistered(ctx, target.Architecture)
+	if err != nil {
+		slog.With("error", err.Error()).Debug("BinfmtCheckFailed")
+	}
+	if registered {
+		return nil
+	}
+	if opts.Probe && c.degrade(ctx, FeaturePlatformOnCreate) {
+		code, out, err := c.runHelper(ctx, &container.Config{
+			Image:      helperImage,
+			Entrypoint: []string{"true"},
+		}, nil, target)
+		if err == nil && code == 0 {
+			return nil
+		}
+		slog.With("exit_code", code, "output", out).Debug("EmulationProbeFailed")
+	}
+
+	return fmt.Errorf(
+		"%w: %s can't run on the %s daemon without qemu binfmt handlers; register them with "+
+			"`docker run --privileged --rm %s --install %s` or retry with --setup-binfmt",
+		EmulationUnavailableErr, platform, nativeName, binfmtImage, target.Architecture,
+	)
+}
+
+// binfmtRegistered looks for the qemu handler of the architecture in
+// the daemon host binfmt_misc folder
+func (c Client) binfmtRegistered(ctx context.Context, arch string) (bool, error) {
+	handler, ok := qemuArchs[arch]
+	if !ok {
+		return false, fmt.Errorf("%w: no known qemu handler for %s", InvalidPlatformErr, arch)
+	}
+	code, out, err := c.runHelper(ctx, &container.Config{
+		Image: helperImage,
+		Cmd:   []string{"ls", "/binfmt"},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:     mount.TypeBind,
+			Source:   binfmtMiscDir,
+			Target:   "/binfmt",
+			ReadOnly: true,
+		}},
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	if code != 0 {
+		return false, fmt.Errorf("%w: listing %s: %s", EmulationUnavailableErr, binfmtMiscDir, strings.TrimSpace(out))
+	}
+	for _, f := range strings.Fields(out) {
+		if f == "qemu-"+handler {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setupBinfmt registers the qemu binfmt handler of the architecture
+func (c Client) setupBinfmt(ctx context.Context, arch string) error {
+	code, out, err := c.runHelper(ctx, &container.Config{
+		Image: binfmtImage,
+		Cmd:   []string{"--install", arch},
+	}, &container.HostConfig{
+		Privileged: true,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", EmulationSetupErr, err)
+	}
+	if code != 0 {
+		return fmt.Errorf("%w: %s", EmulationSetupErr, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// checkNative compares the image platform with the daemon one, warning
+// when the image will run under emulation, or failing if required is
+// set. Images without a platform in their config are left alone.
+func (c Client) checkNative(ctx context.Context, image string, required bool) error {
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ImageInspectErr, err)
+	}
+	if inspect.Os == "" || inspect.Architecture == "" {
+		return nil
+	}
+	imagePlatform := formatPlatform(ocispec.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant})
+	nativeName, err := c.DefaultPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	native, err := parsePlatform(nativeName)
+	if err != nil {
+		return err
+	}
+	if inspect.Os == native.OS && inspect.Architecture == native.Architecture {
+		return nil
+	}
+	if required {
+		return fmt.Errorf("%w: %s is %s, the daemon runs %s", NotNativeErr, image, imagePlatform, nativeName)
+	}
+	slog.With(
+		"image", image,
+		"image_platform", imagePlatform,
+		"daemon_platform", nativeName,
+		"reason", fmt.Sprintf("running %s image under emulation on %s, timings won't be representative", imagePlatform, nativeName),
+	).Warn("EmulatedImage")
+	return nil
+}
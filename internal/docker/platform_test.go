@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "linux/amd64", want: "linux/amd64"},
+		{in: "linux/arm/v7", want: "linux/arm/v7"},
+		{in: "linux", wantErr: true},
+		{in: "linux/", wantErr: true},
+		{in: "/amd64", wantErr: true},
+		{in: "linux/arm/v7/extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := parsePlatform(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, InvalidPlatformErr) {
+				t.Errorf("parsePlatform(%q) err = %v, want %v", tt.in, err, InvalidPlatformErr)
+			}
+			continue
+		}
+		if err != nil || formatPlatform(*p) != tt.want {
+			t.Errorf("parsePlatform(%q) = %v, %v, want %s", tt.in, p, err, tt.want)
+		}
+	}
+}
+
+// binfmtRun answers the binfmt_misc listing with handlers, the probe
+// containers with probeCode and the binfmt setup with setupCode
+func binfmtRun(handlers string, probeCode, setupCode int) func(helperRun) (int, string) {
+	return func(r helperRun) (int, string) {
+		switch {
+		case r.Config.Image == binfmtImage:
+			return setupCode, "installing"
+		case len(r.Config.Cmd) == 2 && r.Config.Cmd[0] == "ls":
+			return 0, handlers
+		}
+		return probeCode, ""
+	}
+}
+
+func TestEnsureEmulation(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform string
+		opts     EmulationOptions
+		run      func(helperRun) (int, string)
+		want     error
+		images   []string
+	}{
+		{name: "no platform"},
+		{name: "native", platform: "linux/amd64"},
+		{
+			name:     "handler registered",
+			platform: "linux/arm64",
+			run:      binfmtRun("qemu-aarch64\nregister\nstatus", 1, 1),
+			images:   []string{helperImage},
+		},
+		{
+			name:     "no handler",
+			platform: "linux/arm64",
+			run:      binfmtRun("qemu-riscv64\nregister\nstatus", 1, 1),
+			want:     EmulationUnavailableErr,
+			images:   []string{helperImage},
+		},
+		{
+			name:     "probe passing",
+			platform: "linux/arm64",
+			opts:     EmulationOptions{Probe: true},
+			run:      binfmtRun("register\nstatus", 0, 1),
+			images:   []string{helperImage, helperImage},
+		},
+		{
+			name:     "probe failing",
+			platform: "linux/arm64",
+			opts:     EmulationOptions{Probe: true},
+			run:      binfmtRun("register\nstatus", 1, 1),
+			want:     EmulationUnavailableErr,
+			images:   []string{helperImage, helperImage},
+		},
+		{
+			name:     "setup",
+			platform: "linux/arm64",
+			opts:     EmulationOptions{Setup: true},
+			run:      binfmtRun("qemu-aarch64", 1, 0),
+			images:   []string{binfmtImage, helperImage},
+		},
+		{
+			name:     "setup failing",
+			platform: "linux/arm64",
+			opts:     EmulationOptions{Setup: true},
+			run:      binfmtRun("", 1, 1),
+			want:     EmulationSetupErr,
+			images:   []string{binfmtImage},
+		},
+		{name: "bad platform", platform: "arm64", want: InvalidPlatformErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &helperAPI{run: tt.run}
+			err := newTestClient(api).ensureEmulation(context.Background(), tt.platform, tt.opts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ensureEmulation err = %v, want %v", err, tt.want)
+			}
+			if errors.Is(err, EmulationUnavailableErr) && !strings.Contains(err.Error(), "--setup-binfmt") {
+				t.Errorf("err = %v, want the setup instructions", err)
+			}
+			if got := api.images(); !equalStrings(got, tt.images) {
+				t.Errorf("containers run = %v, want %v", got, tt.images)
+			}
+			for _, r := range api.runs {
+				if r.Config.Image == binfmtImage && !r.HostConfig.Privileged {
+					t.Error("binfmt setup not privileged")
+				}
+				if len(r.Config.Entrypoint) == 1 && r.Config.Entrypoint[0] == "true" && (r.Platform == nil || r.Platform.Architecture != "arm64") {
+					t.Errorf("probe platform = %v, want arm64", r.Platform)
+				}
+			}
+			if len(api.removed) != len(api.runs) {
+				t.Errorf("removed %d of the %d containers", len(api.removed), len(api.runs))
+			}
+		})
+	}
+}
+
+func TestCheckNative(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    types.ImageInspect
+		required bool
+		wantWarn bool
+		wantErr  bool
+	}{
+		{name: "native", image: types.ImageInspect{Os: "linux", Architecture: "amd64"}},
+		{name: "native variant", image: types.ImageInspect{Os: "linux", Architecture: "amd64", Variant: "v3"}},
+		{name: "no platform", image: types.ImageInspect{}, required: true},
+		{name: "emulated", image: types.ImageInspect{Os: "linux", Architecture: "arm64"}, wantWarn: true},
+		{name: "emulated required", image: types.ImageInspect{Os: "linux", Architecture: "arm64"}, required: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			api := &helperAPI{os: "linux", arch: "x86_64", image: &tt.image}
+			err := newTestClient(api).checkNative(context.Background(), "app:1", tt.required)
+			if tt.wantErr {
+				if !errors.Is(err, NotNativeErr) || !strings.Contains(err.Error(), "linux/arm64") {
+					t.Errorf("err = %v, want %v naming the image platform", err, NotNativeErr)
+				}
+			} else if err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+			warnings := logsWithMsg(logs(), "EmulatedImage")
+			if got := len(warnings) == 1; got != tt.wantWarn {
+				t.Fatalf("EmulatedImage warnings = %v, want %v", warnings, tt.wantWarn)
+			}
+			if tt.wantWarn && (warnings[0]["image_platform"] != "linux/arm64" || warnings[0]["daemon_platform"] != "linux/amd64") {
+				t.Errorf("warning = %v, want linux/arm64 on linux/amd64", warnings[0])
+			}
+		})
+	}
+}
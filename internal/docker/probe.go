@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	ProbeTimeoutErr = errors.New("probe timed out")
+	ProbeFailedErr  = errors.New("probe failed")
+	ProbeFatalErr   = errors.New("probe errored")
+)
+
+// The environment of the probe commands, describing the container
+const (
+	// ProbeEnvContainerID is the container ID
+	ProbeEnvContainerID = "RUNNER_CONTAINER_ID"
+	// ProbeEnvContainerName is the container name
+	ProbeEnvContainerName = "RUNNER_CONTAINER_NAME"
+	// ProbeEnvContainerIP is the container IP address in its first
+	// network (empty for the host network)
+	ProbeEnvContainerIP = "RUNNER_CONTAINER_IP"
+	// ProbeEnvPortPrefix prefixes the container ports published on the
+	// host, e.g. RUNNER_PORT_8080=32768 (RUNNER_PORT_53_UDP for other
+	// protocols than tcp)
+	ProbeEnvPortPrefix = "RUNNER_PORT_"
+)
+
+// The probe exit codes, any other is a fatal error
+const (
+	// ProbeExitPass is ready (wait) or passed (assert)
+	ProbeExitPass = 0
+	// ProbeExitNotYet is not ready yet (wait), retried, or failed
+	// (assert)
+	ProbeExitNotYet = 1
+)
+
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = time.Minute
+	// maxProbeOutput caps the stderr kept from each attempt
+	maxProbeOutput = 4096
+	// probeWaitDelay is how long a canceled probe command still holding
+	// its stderr is waited for
+	probeWaitDelay = 100 * time.Millisecond
+)
+
+// probeAttemptTimeout bounds each attempt of a wait probe, a hung one
+// being retried like ProbeExitNotYet
+var probeAttemptTimeout = 10 * time.Second
+
+// Probe is a host command run against the container, through
+// /bin/sh -c, with the ProbeEnv variables set
+type Probe struct {
+	Cmd string
+	// Interval is the wait between the attempts of a wait probe (1s if
+	// 0)
+	Interval time.Duration
+	// Timeout bounds all the attempts (1m if 0)
+	Timeout time.Duration
+}
+
+func (p Probe) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	return defaultProbeInterval
+}
+
+func (p Probe) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultProbeTimeout
+}
+
+// cappedBuffer keeps the first max bytes written to it
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			_, _ = b.Buffer.Write(p[:room])
+		} else {
+			_, _ = b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// probeEnv returns the ProbeEnv variables of the container
+func (c Client) probeEnv(ctx context.Context, id string) ([]string, error) {
+	inspect, err := c.d.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ProbeFatalErr, err)
+	}
+	env := []string{
+		ProbeEnvContainerID + "=" + inspect.ID,
+		ProbeEnvContainerName + "=" + strings.TrimPrefix(inspect.Name, "/"),
+	}
+	var ip string
+	if ns := inspect.NetworkSettings; ns != nil {
+		ip = ns.IPAddress
+		for _, n := range ns.Networks {
+			if ip == "" && n != nil {
+				ip = n.IPAddress
+			}
+		}
+		for port, bindings := range ns.Ports {
+			if len(bindings) == 0 {
+				continue
+			}
+			name := ProbeEnvPortPrefix + port.Port()
+			if proto := port.Proto(); proto != "tcp" {
+				name += "_" + strings.ToUpper(proto)
+			}
+			env = append(env, name+"="+bindings[0].HostPort)
+		}
+	}
+	return append(env, ProbeEnvContainerIP+"="+ip), nil
+}
+
+// runProbeOnce runs the probe command, bounded by attempt if not 0,
+// failing with ProbeFailedErr for ProbeExitNotYet or the attempt
+// timeout and ProbeFatalErr for a start failure or another exit code,
+// along with its (capped) stderr. It fails with the ctx error if ctx
+// ended first.
+func runProbeOnce(ctx context.Context, p Probe, env []string, attempt time.Duration) error {
+	attemptCtx, cancel := ctx, func() {}
+	if attempt > 0 {
+		attemptCtx, cancel = context.WithTimeout(ctx, attempt)
+	}
+	defer cancel()
+	stderr := &cappedBuffer{max: maxProbeOutput}
+	cmd := exec.CommandContext(attemptCtx, "/bin/sh", "-c", p.Cmd)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = stderr
+	cmd.WaitDelay = probeWaitDelay
+	killProbeGroup(cmd)
+	err := cmd.Run()
+	var out string
+	if s := strings.TrimSpace(stderr.String()); s != "" {
+		out = ": " + s
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%q: %w%s", p.Cmd, ctx.Err(), out)
+	case attemptCtx.Err() != nil:
+		return fmt.Errorf("%w: %q: attempt timed out after %s%s", ProbeFailedErr, p.Cmd, attempt, out)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == ProbeExitNotYet:
+		return fmt.Errorf("%w: %q%s", ProbeFailedErr, p.Cmd, out)
+	case errors.As(err, &exitErr):
+		return fmt.Errorf("%w: %q exited with code %d%s", ProbeFatalErr, p.Cmd, exitErr.ExitCode(), out)
+	}
+	return fmt.Errorf("%w: %q: %w", ProbeFatalErr, p.Cmd, err)
+}
+
+// probeDone returns the error of a probe whose ctx ended: ProbeFatalErr
+// if parent ended first (e.g. the run was canceled), else ProbeTimeoutErr
+// with the last attempt error
+func probeDone(parent context.Context, p Probe, last error) error {
+	if err := parent.Err(); err != nil {
+		return fmt.Errorf("%w: %q: %w", ProbeFatalErr, p.Cmd, err)
+	}
+	return fmt.Errorf("%w after %s: %w", ProbeTimeoutErr, p.timeout(), last)
+}
+
+// waitProbe runs the probe until it passes, retrying on ProbeExitNotYet
+// and the attempt timeouts until the probe timeout
+func waitProbe(parent context.Context, p Probe, env []string) error {
+	ctx, cancel := context.WithTimeout(parent, p.timeout())
+	defer cancel()
+	var last error
+	for {
+		err := runProbeOnce(ctx, p, env, probeAttemptTimeout)
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			if last == nil {
+				last = err
+			}
+			return probeDone(parent, p, last)
+		case !errors.Is(err, ProbeFailedErr):
+			return err
+		}
+		last = err
+		select {
+		case <-ctx.Done():
+			return probeDone(parent, p, last)
+		case <-time.After(p.interval()):
+		}
+	}
+}
+
+// assertProbe runs the probe once, within the probe timeout
+func assertProbe(parent context.Context, p Probe, env []string) error {
+	ctx, cancel := context.WithTimeout(parent, p.timeout())
+	defer cancel()
+	err := runProbeOnce(ctx, p, env, 0)
+	if err != nil && ctx.Err() != nil {
+		return probeDone(parent, p, err)
+	}
+	return err
+}
+
+// runProbes waits for the container to be ready, calling ready once
+// the wait probe passed, then runs the version probes and the
+// assertion, for the probes set, adding extra to the env of the host
+// ones. The assertion outcome is returned if it ran.
+func (c Client) runProbes(ctx context.Context, id string, wait, assert Probe, extra, versions []string, ready func()) (*AssertionResult, error) {
+	env, err := c.probeEnv(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, extra...)
+	if wait.Cmd != "" {
+		if err := waitProbe(ctx, wait, env); err != nil {
+			return nil, err
+		}
+		fmt.Println("Container ready")
+		ready()
+	}
+	printRuntimeVersions(c.probeVersions(ctx, id, versions))
+	if assert.Cmd == "" {
+		return nil, nil
+	}
+	if err := assertProbe(ctx, assert, env); err != nil {
+		return &AssertionResult{Message: err.Error()}, err
+	}
+	fmt.Println("Assertion passed")
+	return &AssertionResult{Passed: true}, nil
+}
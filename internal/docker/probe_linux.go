@@ -0,0 +1,16 @@
+package docker
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProbeGroup runs the probe command in its own process group,
+// killed as a whole once canceled, so the commands it started (e.g. a
+// sleep or curl) don't outlive it
+func killProbeGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
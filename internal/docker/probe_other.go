@@ -0,0 +1,10 @@
+//go:build !linux
+
+package docker
+
+import "os/exec"
+
+// killProbeGroup is only implemented on Linux: elsewhere only the
+// shell is killed once canceled, its children being cut off after
+// probeWaitDelay
+func killProbeGroup(*exec.Cmd) {}
@@ -0,0 +1,293 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// useTestProbeAttemptTimeout shortens the wait probe attempts for the
+// test
+func useTestProbeAttemptTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	saved := probeAttemptTimeout
+	probeAttemptTimeout = d
+	t.Cleanup(func() {
+		probeAttemptTimeout = saved
+	})
+}
+
+func TestRunProbeOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		attempt time.Duration
+		want    error
+		wantMsg string
+	}{
+		{name: "pass", cmd: "exit 0"},
+		{name: "not yet", cmd: "echo starting >&2; exit 1", want: ProbeFailedErr, wantMsg: "starting"},
+		{name: "other exit code", cmd: "exit 2", want: ProbeFatalErr, wantMsg: "exited with code 2"},
+		{name: "command not found", cmd: "no-such-probe-command", want: ProbeFatalErr, wantMsg: "exited with code 127"},
+		{name: "attempt timeout", cmd: "sleep 5", attempt: 50 * time.Millisecond, want: ProbeFailedErr, wantMsg: "attempt timed out"},
+		{name: "env", cmd: `test "$` + ProbeEnvContainerName + `" = db`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runProbeOnce(context.Background(), Probe{Cmd: tt.cmd}, []string{ProbeEnvContainerName + "=db"}, tt.attempt)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("runProbeOnce err = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("runProbeOnce err = %v, want %v", err, tt.want)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("runProbeOnce err = %v, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRunProbeOnceParentDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := runProbeOnce(ctx, Probe{Cmd: "sleep 5"}, nil, time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ProbeFailedErr) || errors.Is(err, ProbeFatalErr) {
+		t.Errorf("runProbeOnce err = %v, want the ctx error only", err)
+	}
+}
+
+func TestWaitProbe(t *testing.T) {
+	useTestProbeAttemptTimeout(t, 100*time.Millisecond)
+	tests := []struct {
+		name    string
+		cmd     func(dir string) string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+		notWant error
+	}{
+		{
+			name: "ready after retries",
+			// not ready the first two attempts
+			cmd: func(dir string) string {
+				f := filepath.Join(dir, "attempts")
+				return "echo x >> " + f + "; test $(wc -l < " + f + ") -ge 3"
+			},
+		},
+		{
+			name: "hung attempt retried",
+			cmd: func(dir string) string {
+				f := filepath.Join(dir, "hung")
+				return "if [ -e " + f + " ]; then exit 0; fi; touch " + f + "; sleep 5"
+			},
+		},
+		{
+			name:    "fatal exit code",
+			cmd:     func(string) string { return "exit 3" },
+			want:    ProbeFatalErr,
+			notWant: ProbeTimeoutErr,
+		},
+		{
+			name:    "never ready",
+			cmd:     func(string) string { return "exit 1" },
+			timeout: 200 * time.Millisecond,
+			want:    ProbeTimeoutErr,
+			notWant: ProbeFatalErr,
+		},
+		{
+			name:    "attempts always hung",
+			cmd:     func(string) string { return "sleep 5" },
+			timeout: 250 * time.Millisecond,
+			want:    ProbeTimeoutErr,
+			notWant: ProbeFatalErr,
+		},
+		{
+			name: "run canceled",
+			cmd:  func(string) string { return "exit 1" },
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			want:    ProbeFatalErr,
+			notWant: ProbeTimeoutErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+			p := Probe{Cmd: tt.cmd(t.TempDir()), Interval: 10 * time.Millisecond, Timeout: tt.timeout}
+			if p.Timeout == 0 {
+				p.Timeout = 5 * time.Second
+			}
+			err := waitProbe(ctx, p, nil)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("waitProbe err = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) || errors.Is(err, tt.notWant) {
+				t.Errorf("waitProbe err = %v, want %v and not %v", err, tt.want, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestAssertProbe(t *testing.T) {
+	useTestProbeAttemptTimeout(t, 50*time.Millisecond)
+	tests := []struct {
+		name    string
+		cmd     string
+		timeout time.Duration
+		want    error
+	}{
+		{name: "pass", cmd: "exit 0"},
+		// the wait probe attempts timeout doesn't apply
+		{name: "slower than an attempt", cmd: "sleep 0.2"},
+		{name: "fail", cmd: "exit 1", want: ProbeFailedErr},
+		{name: "error", cmd: "exit 2", want: ProbeFatalErr},
+		{name: "timeout", cmd: "sleep 5", timeout: 100 * time.Millisecond, want: ProbeTimeoutErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := assertProbe(context.Background(), Probe{Cmd: tt.cmd, Timeout: tt.timeout}, nil)
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Errorf("assertProbe err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// probeAPI fakes the inspection of the probed container
+type probeAPI struct {
+	client.APIClient
+	inspect types.ContainerJSON
+}
+
+func (f probeAPI) ContainerInspect(_ context.Context, _ string) (types.ContainerJSON, error) {
+	return f.inspect, nil
+}
+
+// probedContainer is the inspection of a running container in the
+// network, publishing the ports
+func probedContainer(networkIP string, ports nat.PortMap) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    testContainerID,
+			Name:  "/db",
+			State: &types.ContainerState{Running: true},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{Ports: ports},
+			Networks:            map[string]*network.EndpointSettings{"app": {IPAddress: networkIP}},
+		},
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 5}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v, want the whole input accepted", s, n, err)
+		}
+	}
+	if b.String() != "abcde" {
+		t.Errorf("content = %q, want the first 5 bytes", b.String())
+	}
+}
+
+func TestProbeEnv(t *testing.T) {
+	ports := nat.PortMap{
+		"8080/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+		"53/udp":   {{HostIP: "0.0.0.0", HostPort: "32769"}},
+		"9090/tcp": nil,
+	}
+	env, err := newTestClient(probeAPI{inspect: probedContainer("172.18.0.2", ports)}).probeEnv(context.Background(), testContainerID)
+	if err != nil {
+		t.Fatalf("probeEnv: %v", err)
+	}
+	slices.Sort(env)
+	want := []string{
+		ProbeEnvContainerID + "=" + testContainerID,
+		ProbeEnvContainerIP + "=172.18.0.2",
+		ProbeEnvContainerName + "=db",
+		ProbeEnvPortPrefix + "53_UDP=32769",
+		ProbeEnvPortPrefix + "8080=32768",
+	}
+	slices.Sort(want)
+	if !equalStrings(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+func TestRunProbes(t *testing.T) {
+	api := probeAPI{inspect: probedContainer("172.18.0.2", nil)}
+	tests := []struct {
+		name      string
+		wait      Probe
+		assert    Probe
+		wantReady bool
+		want      *AssertionResult
+		wantErr   error
+	}{
+		{name: "none"},
+		{name: "wait only", wait: Probe{Cmd: "exit 0"}, wantReady: true},
+		{
+			name:      "assertion passed",
+			wait:      Probe{Cmd: "exit 0"},
+			assert:    Probe{Cmd: `test "$` + ProbeEnvContainerIP + `" = 172.18.0.2 && test "$EXTRA" = yes`},
+			wantReady: true,
+			want:      &AssertionResult{Passed: true},
+		},
+		{
+			name:    "assertion failed",
+			assert:  Probe{Cmd: "echo wrong answer >&2; exit 1"},
+			want:    &AssertionResult{},
+			wantErr: ProbeFailedErr,
+		},
+		{
+			name:    "never ready",
+			wait:    Probe{Cmd: "exit 1", Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond},
+			assert:  Probe{Cmd: "exit 0"},
+			wantErr: ProbeTimeoutErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready := false
+			res, err := newTestClient(api).runProbes(context.Background(), testContainerID, tt.wait, tt.assert, []string{"EXTRA=yes"}, nil, func() {
+				ready = true
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if ready != tt.wantReady {
+				t.Errorf("ready = %v, want %v", ready, tt.wantReady)
+			}
+			switch {
+			case tt.want == nil && res != nil:
+				t.Errorf("result = %+v, want none", res)
+			case tt.want != nil && (res == nil || res.Passed != tt.want.Passed):
+				t.Errorf("result = %+v, want passed %v", res, tt.want.Passed)
+			case tt.want != nil && !tt.want.Passed && !strings.Contains(res.Message, "wrong answer"):
+				t.Errorf("message = %q, want the probe stderr", res.Message)
+			}
+		})
+	}
+}
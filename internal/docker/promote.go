@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	ImagePromoteErr          = errors.New("failed to promote image")
+	RequiredLabelErr         = errors.New("image is missing a required label")
+	PromoteDigestMismatchErr = errors.New("pushed digest does not match the source digest")
+)
+
+// PromoteOptions holds the settings of an image promotion
+type PromoteOptions struct {
+	// RequireLabels are the labels (and values) the source image must
+	// have to be promoted
+	RequireLabels map[string]string
+	// Local only tags dst, without pushing it
+	Local bool
+	// UntagSource removes the src tag once dst is tagged (and pushed)
+	UntagSource bool
+}
+
+// PromoteResult holds the digests of a promoted image
+type PromoteResult struct {
+	SourceDigest digest.Digest
+	PushedDigest digest.Digest
+}
+
+// Promote retags the src image (pulled if not local) as dst and pushes
+// it, checking the pushed digest is the same as the source one. The src
+// tag is only removed after dst is in place, so an interrupted promotion
+// leaves both tags instead of none.
+func (c Client) Promote(ctx context.Context, src, dst string, opts PromoteOptions) (PromoteResult, error) {
+	var res PromoteResult
+
+	srcNamed, err := reference.ParseNormalizedNamed(src)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	dstNamed, err := reference.ParseNormalizedNamed(dst)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	if opts.UntagSource {
+		if _, ok := srcNamed.(reference.Canonical); ok {
+			return res, fmt.Errorf("%w: can't untag the digest reference %s", ImagePromoteErr, src)
+		}
+		if reference.TagNameOnly(srcNamed).String() == reference.TagNameOnly(dstNamed).String() {
+			return res, fmt.Errorf("%w: source and destination are the same reference", ImagePromoteErr)
+		}
+	}
+
+	if err := c.ensureImage(ctx, src, "", PullMissing); err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, src)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+
+	for k, v := range opts.RequireLabels {
+		var actual string
+		var ok bool
+		if inspect.Config != nil {
+			actual, ok = inspect.Config.Labels[k]
+		}
+		if !ok || actual != v {
+			return res, fmt.Errorf("%w: %s=%s (found %q)", RequiredLabelErr, k, v, actual)
+		}
+	}
+
+	if opts.Local {
+		if err := c.tagLocal(ctx, src, dst, inspect.ID); err != nil {
+			return res, err
+		}
+		return res, c.untagSource(ctx, src, opts)
+	}
+
+	res.SourceDigest, err = sourceDigest(srcNamed, inspect.RepoDigests)
+	if err != nil {
+		return res, err
+	}
+
+	if err := c.d.ImageTag(ctx, src, dst); err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	res.PushedDigest, err = c.Push(ctx, dst)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	if res.PushedDigest != res.SourceDigest {
+		return res, fmt.Errorf("%w: source %s, pushed %s", PromoteDigestMismatchErr, res.SourceDigest, res.PushedDigest)
+	}
+	return res, c.untagSource(ctx, src, opts)
+}
+
+// tagLocal tags src as dst and checks dst now points to the src image
+func (c Client) tagLocal(ctx context.Context, src, dst, id string) error {
+	if err := c.d.ImageTag(ctx, src, dst); err != nil {
+		return fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	tagged, _, err := c.d.ImageInspectWithRaw(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ImagePromoteErr, err)
+	}
+	if tagged.ID != id {
+		return fmt.Errorf("%w: %s is %s, expected %s", ImagePromoteErr, dst, tagged.ID, id)
+	}
+	return nil
+}
+
+// untagSource removes the src tag, keeping the image (now tagged as
+// dst) and its layers
+func (c Client) untagSource(ctx context.Context, src string, opts PromoteOptions) error {
+	if !opts.UntagSource {
+		return nil
+	}
+	if _, err := c.d.ImageRemove(ctx, src, types.ImageRemoveOptions{}); err != nil {
+		return fmt.Errorf("%w: untagging %s: %w", ImagePromoteErr, src, err)
+	}
+	return nil
+}
+
+// sourceDigest returns the registry digest of the src image: the one
+// in the reference itself, or the repo digest of the same repository
+func sourceDigest(src reference.Named, repoDigests []string) (digest.Digest, error) {
+	if canonical, ok := src.(reference.Canonical); ok {
+		return canonical.Digest(), nil
+	}
+	for _, rd := range repoDigests {
+		named, err := reference.ParseNormalizedNamed(rd)
+		if err != nil {
+			continue
+		}
+		canonical, ok := named.(reference.Canonical)
+		if ok && named.Name() == src.Name() {
+			return canonical.Digest(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: no registry digest for %s (found %s)", ImagePromoteErr, reference.FamiliarString(src), strings.Join(repoDigests, ", "))
+}
@@ -0,0 +1,268 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+// registryDaemon is a fake daemon HTTP API pushing to the registries:
+// the images are inspected as image and the pushes report the digest
+type registryDaemon struct {
+	image  types.ImageInspect
+	pushed digest.Digest
+
+	mu sync.Mutex
+	// calls are the tag and push calls, in order
+	calls []string
+	// auths are the decoded X-Registry-Auth of the pushes
+	auths []registry.AuthConfig
+}
+
+func (d *registryDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if i := strings.Index(path, "/images/"); i >= 0 {
+		path = path[i+len("/images/"):]
+	}
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/json"):
+		_ = json.NewEncoder(w).Encode(d.image)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/tag"):
+		d.record("tag " + r.URL.Query().Get("repo") + ":" + r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/push"):
+		d.record("push " + strings.TrimSuffix(path, "/push") + ":" + r.URL.Query().Get("tag"))
+		auth, err := registry.DecodeAuthConfig(r.Header.Get(registry.AuthHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.mu.Lock()
+		d.auths = append(d.auths, *auth)
+		d.mu.Unlock()
+		_, _ = w.Write([]byte(streamMessage(map[string]any{"status": "Pushed"}) +
+			streamMessage(map[string]any{"aux": types.PushResult{Tag: r.URL.Query().Get("tag"), Digest: d.pushed.String(), Size: 528}})))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *registryDaemon) record(call string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, call)
+}
+
+// newRegistryDaemonClient starts the fake daemon, returning a client of it
+func newRegistryDaemonClient(t *testing.T, d *registryDaemon) Client {
+	t.Helper()
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	api, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestClient(api)
+}
+
+// useDockerConfig points the Docker CLI config to a file holding the
+// auths, keyed by registry
+func useDockerConfig(t *testing.T, auths map[string]string) {
+	t.Helper()
+	cfg := map[string]any{"auths": map[string]any{}}
+	for host, userPass := range auths {
+		cfg["auths"].(map[string]any)[host] = map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte(userPass))}
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfigFile(t, string(b))
+}
+
+func TestPromote(t *testing.T) {
+	useDockerConfig(t, nil)
+	useTestRetryPolicy(t)
+	source := digest.FromString("source manifest")
+	image := types.ImageInspect{
+		ID:          testImageID,
+		RepoDigests: []string{"registry.local/app@" + source.String()},
+		Config:      &container.Config{Labels: map[string]string{"org.opencontainers.image.revision": "abc123"}},
+	}
+	tests := []struct {
+		name      string
+		pushed    digest.Digest
+		opts      PromoteOptions
+		wantErr   error
+		wantCalls []string
+	}{
+		{
+			name:      "digest match",
+			pushed:    source,
+			opts:      PromoteOptions{RequireLabels: map[string]string{"org.opencontainers.image.revision": "abc123"}},
+			wantCalls: []string{"tag registry.prod/app:1.0", "push registry.prod/app:1.0"},
+		},
+		{
+			name:      "digest mismatch",
+			pushed:    digest.FromString("rebuilt manifest"),
+			wantErr:   PromoteDigestMismatchErr,
+			wantCalls: []string{"tag registry.prod/app:1.0", "push registry.prod/app:1.0"},
+		},
+		{
+			name:    "missing required label",
+			pushed:  source,
+			opts:    PromoteOptions{RequireLabels: map[string]string{"org.opencontainers.image.source": "https://github.com/eldius/app"}},
+			wantErr: RequiredLabelErr,
+		},
+		{
+			name:    "label value differs",
+			pushed:  source,
+			opts:    PromoteOptions{RequireLabels: map[string]string{"org.opencontainers.image.revision": "def456"}},
+			wantErr: RequiredLabelErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &registryDaemon{image: image, pushed: tt.pushed}
+			res, err := newRegistryDaemonClient(t, d).Promote(context.Background(), "registry.local/app:1.0", "registry.prod/app:1.0", tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Promote err = %v, want %v", err, tt.wantErr)
+			}
+			if !equalStrings(d.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", d.calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil && (res.SourceDigest != source || res.PushedDigest != source) {
+				t.Errorf("result = %+v, want both digests %s", res, source)
+			}
+		})
+	}
+}
+
+func TestPromoteNoSourceDigest(t *testing.T) {
+	useDockerConfig(t, nil)
+	d := &registryDaemon{image: types.ImageInspect{ID: testImageID}, pushed: digest.FromString("manifest")}
+	_, err := newRegistryDaemonClient(t, d).Promote(context.Background(), "registry.local/app:1.0", "registry.prod/app:1.0", PromoteOptions{})
+	if !errors.Is(err, ImagePromoteErr) || len(d.calls) != 0 {
+		t.Errorf("Promote err = %v after %q, want %v before tagging", err, d.calls, ImagePromoteErr)
+	}
+}
+
+func TestPushAuthHeader(t *testing.T) {
+	useDockerConfig(t, map[string]string{
+		"registry.local":  "ci:local-secret",
+		dockerHubAuthKey:  "eldius:hub-secret",
+		"other.registry":  "other:other-secret",
+		"registry.local2": "nope:nope",
+	})
+	tests := []struct {
+		ref      string
+		wantUser string
+	}{
+		{ref: "registry.local/team/app:1.0", wantUser: "ci"},
+		{ref: "eldius/app:1.0", wantUser: "eldius"},
+		{ref: "docker.io/library/alpine:3.19", wantUser: "eldius"},
+		{ref: "unknown.registry/app:1.0", wantUser: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			d := &registryDaemon{pushed: digest.FromString(tt.ref)}
+			pushed, err := newRegistryDaemonClient(t, d).Push(context.Background(), tt.ref)
+			if err != nil {
+				t.Fatalf("Push: %v", err)
+			}
+			if pushed != d.pushed {
+				t.Errorf("Push = %s, want %s", pushed, d.pushed)
+			}
+			if len(d.auths) != 1 || d.auths[0].Username != tt.wantUser {
+				t.Errorf("push auths = %+v, want user %q", d.auths, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestPromoteUntagSource(t *testing.T) {
+	useDockerConfig(t, nil)
+	useTestRetryPolicy(t)
+	source := digest.FromString("source manifest")
+	image := &types.ImageInspect{ID: testImageID, RepoDigests: []string{"registry.local/app@" + source.String()}}
+	tests := []struct {
+		name      string
+		api       *buildAPI
+		opts      PromoteOptions
+		wantErr   bool
+		wantCalls []string
+	}{
+		{
+			name:      "pushed",
+			api:       &buildAPI{image: image, pushed: source.String()},
+			opts:      PromoteOptions{UntagSource: true},
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0", "push registry.local/app:1.0", "remove registry.local/app:rc"},
+		},
+		{
+			name:      "local",
+			api:       &buildAPI{image: image},
+			opts:      PromoteOptions{Local: true, UntagSource: true},
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0", "remove registry.local/app:rc"},
+		},
+		{
+			name:      "source kept",
+			api:       &buildAPI{image: image, pushed: source.String()},
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0", "push registry.local/app:1.0"},
+		},
+		{
+			name:      "failed tag",
+			api:       &buildAPI{image: image, tagErr: errdefs.System(errors.New("disk full"))},
+			opts:      PromoteOptions{Local: true, UntagSource: true},
+			wantErr:   true,
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0"},
+		},
+		{
+			name:      "failed push",
+			api:       &buildAPI{image: image, pushErr: errors.New("connection reset by peer")},
+			opts:      PromoteOptions{UntagSource: true},
+			wantErr:   true,
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0", "push registry.local/app:1.0", "push registry.local/app:1.0", "push registry.local/app:1.0"},
+		},
+		{
+			name:      "digest mismatch",
+			api:       &buildAPI{image: image, pushed: digest.FromString("rebuilt").String()},
+			opts:      PromoteOptions{UntagSource: true},
+			wantErr:   true,
+			wantCalls: []string{"tag registry.local/app:rc registry.local/app:1.0", "push registry.local/app:1.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestClient(tt.api).Promote(context.Background(), "registry.local/app:rc", "registry.local/app:1.0", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Promote err = %v, want error %v", err, tt.wantErr)
+			}
+			if !equalStrings(tt.api.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", tt.api.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPromoteUntagSourceSameRef(t *testing.T) {
+	for _, src := range []string{"registry.local/app:1.0", "registry.local/app@" + digest.FromString("manifest").String()} {
+		api := &buildAPI{}
+		_, err := newTestClient(api).Promote(context.Background(), src, "registry.local/app:1.0", PromoteOptions{UntagSource: true})
+		if !errors.Is(err, ImagePromoteErr) || len(api.calls) != 0 {
+			t.Errorf("Promote(%s) err = %v after %q, want %v before any call", src, err, api.calls, ImagePromoteErr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+)
+
+var (
+	ImagePullErr         = errors.New("failed to pull image")
+	ImageNotFoundErr     = errors.New("image not found locally")
+	InvalidPullPolicyErr = errors.New("invalid pull policy")
+)
+
+// ImageRefError is a pull or push failure of an image reference. It
+// matches both its Kind (ImagePullErr or ImagePushErr) and Err.
+type ImageRefError struct {
+	Kind error
+	Ref  string
+	Err  error
+}
+
+func (e *ImageRefError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Kind, e.Ref, e.Err)
+}
+
+func (e *ImageRefError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// PullPolicy defines when the image is pulled before a run
+type PullPolicy string
+
+const (
+	// PullNever never pulls, failing if the image is missing
+	PullNever PullPolicy = "never"
+	// PullMissing pulls only if the image is not present locally
+	PullMissing PullPolicy = "missing"
+	// PullAlways pulls before every run
+	PullAlways PullPolicy = "always"
+)
+
+// ParsePullPolicy validates the pull policy name
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch p := PullPolicy(s); p {
+	case "":
+		return PullMissing, nil
+	case PullNever, PullMissing, PullAlways:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %q (valid policies: %s, %s, %s)", InvalidPullPolicyErr, s, PullNever, PullMissing, PullAlways)
+}
+
+// ensureImage makes sure the image is available locally, pulling
+// it (for the platform, if set) according to the policy
+func (c Client) ensureImage(ctx context.Context, image, platform string, policy PullPolicy) error {
+	if policy != PullAlways {
+		_, _, err := c.d.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			return nil
+		}
+		if !client.IsErrNotFound(err) {
+			return fmt.Errorf("%w: %w", ImagePullErr, err)
+		}
+		if policy == PullNever {
+			return fmt.Errorf("%w: %s (pull policy is %s)", ImageNotFoundErr, image, policy)
+		}
+	}
+	return c.pullWithRetry(ctx, image, platform, os.Stdout)
+}
+
+// Pull pulls the image, retrying on failures
+func (c Client) Pull(ctx context.Context, image string) error {
+	return c.pullWithRetry(ctx, image, "", os.Stdout)
+}
+
+// pullWithRetry pulls the image, printing the pull progress to out
+func (c Client) pullWithRetry(ctx context.Context, image, platform string, out io.Writer) error {
+	release, err := acquire(ctx, c.downloads)
+	if err != nil {
+		return &ImageRefError{Kind: ImagePullErr, Ref: image, Err: err}
+	}
+	defer release()
+
+	err = retry(ctx, defaultRetryPolicy, func() error {
+		return c.pull(ctx, image, platform, out)
+	})
+	if err != nil {
+		return &ImageRefError{Kind: ImagePullErr, Ref: image, Err: err}
+	}
+	return nil
+}
+
+func (c Client) pull(ctx context.Context, image, platform string, out io.Writer) error {
+	auth, err := registryAuth(image)
+	if err != nil {
+		return err
+	}
+	rc, err := c.d.ImagePull(ctx, image, types.ImagePullOptions{
+		Platform:     platform,
+		RegistryAuth: auth,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	fd, isTerm := term.GetFdInfo(out)
+	return jsonmessage.DisplayJSONMessagesStream(rc, out, fd, isTerm, nil)
+}
+
+// pullBaseImages pulls the base images of the Dockerfile stages before
+// the build, out of the build stream, all at once within the download
+// limit. The pull progress of each image is printed to out once it's
+// pulled, unless quiet, and the first failure cancels the other pulls.
+func (c Client) pullBaseImages(ctx context.Context, out io.Writer, df *dockerfile, platform string, quiet bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, image := range df.baseImages() {
+		image := image
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the progress of concurrent pulls can't share out
+			var progress bytes.Buffer
+			var w io.Writer = &progress
+			if quiet {
+				w = io.Discard
+			}
+			err := c.pullWithRetry(ctx, image, platform, w)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			if quiet {
+				_, _ = fmt.Fprintln(out, "Pulled base image", image)
+				return
+			}
+			_, _ = out.Write(progress.Bytes())
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
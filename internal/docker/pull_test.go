@@ -0,0 +1,308 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// pullAPI fakes the pulls of a daemon missing every image, failing the
+// first pulls with the errs
+type pullAPI struct {
+	client.APIClient
+	errs  []error
+	pulls int
+}
+
+func (f *pullAPI) ImageInspectWithRaw(_ context.Context, image string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image: " + image))
+}
+
+func (f *pullAPI) ImagePull(_ context.Context, _ string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	f.pulls++
+	if f.pulls <= len(f.errs) {
+		return nil, f.errs[f.pulls-1]
+	}
+	return io.NopCloser(strings.NewReader(`{"status":"Pull complete"}` + "\n")), nil
+}
+
+// useTestRetryPolicy shortens the retry backoff for the test
+func useTestRetryPolicy(t *testing.T) {
+	prev := defaultRetryPolicy
+	defaultRetryPolicy = testRetryPolicy
+	t.Cleanup(func() {
+		defaultRetryPolicy = prev
+	})
+}
+
+func TestParsePullPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PullPolicy
+		wantErr bool
+	}{
+		{in: "", want: PullMissing},
+		{in: "never", want: PullNever},
+		{in: "missing", want: PullMissing},
+		{in: "always", want: PullAlways},
+		{in: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePullPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePullPolicy(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEnsureImageRetries(t *testing.T) {
+	useTestRetryPolicy(t)
+	transient := errors.New("connection reset by peer")
+	tests := []struct {
+		name    string
+		errs    []error
+		pulls   int
+		wantErr error
+	}{
+		{name: "pulled", pulls: 1},
+		{name: "transient failure", errs: []error{transient}, pulls: 2},
+		{name: "attempts exhausted", errs: []error{transient, transient, transient}, pulls: 3, wantErr: ImagePullErr},
+		{name: "missing from the registry", errs: []error{errdefs.NotFound(errors.New("manifest unknown"))}, pulls: 1, wantErr: ImagePullErr},
+		{name: "access denied", errs: []error{errdefs.Unauthorized(errors.New("pull access denied"))}, pulls: 1, wantErr: ImagePullErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &pullAPI{errs: tt.errs}
+			err := newTestClient(api).ensureImage(context.Background(), "alpine:3.19", "", PullMissing)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if api.pulls != tt.pulls {
+				t.Errorf("pulls = %d, want %d", api.pulls, tt.pulls)
+			}
+		})
+	}
+}
+
+func TestEnsureImagePullNever(t *testing.T) {
+	api := &pullAPI{}
+	err := newTestClient(api).ensureImage(context.Background(), "alpine:3.19", "", PullNever)
+	if !errors.Is(err, ImageNotFoundErr) {
+		t.Errorf("err = %v, want %v", err, ImageNotFoundErr)
+	}
+	if api.pulls != 0 {
+		t.Errorf("pulls = %d, want none", api.pulls)
+	}
+}
+
+// basePullAPI fakes the builds of a daemon pulling the base images,
+// failing the pulls with err if set
+type basePullAPI struct {
+	*buildAPI
+	err error
+
+	mu    sync.Mutex
+	pulls []string
+}
+
+func (f *basePullAPI) ImagePull(_ context.Context, ref string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulls = append(f.pulls, ref)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(strings.NewReader(`{"status":"Downloading layer for ` + ref + `"}` + "\n")), nil
+}
+
+func TestBuildPullBaseImages(t *testing.T) {
+	useConfigFile(t, `{}`)
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("ARG GO=1.22\nFROM golang:${GO} AS build\nFROM build AS test\nFROM alpine:3.19\n")}}
+	tests := []struct {
+		name      string
+		opts      BuildOptions
+		wantPulls []string
+		wantOut   []string
+		notOut    []string
+	}{
+		{name: "no pull", opts: BuildOptions{}},
+		{
+			name:      "progress",
+			opts:      BuildOptions{PullBaseImages: true},
+			wantPulls: []string{"golang:1.22", "alpine:3.19"},
+			wantOut:   []string{"Downloading layer for golang:1.22", "Downloading layer for alpine:3.19"},
+			notOut:    []string{"Pulled base image"},
+		},
+		{
+			name:      "quiet",
+			opts:      BuildOptions{PullBaseImages: true, QuietPull: true},
+			wantPulls: []string{"golang:1.22", "alpine:3.19"},
+			wantOut:   []string{"Pulled base image golang:1.22\n", "Pulled base image alpine:3.19\n"},
+			notOut:    []string{"Downloading"},
+		},
+		{
+			name:      "inline",
+			opts:      BuildOptions{PullBaseImages: true, QuietPull: true, Dockerfile: []byte("FROM debian:12\n")},
+			wantPulls: []string{"debian:12"},
+			wantOut:   []string{"Pulled base image debian:12\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &basePullAPI{buildAPI: &buildAPI{}}
+			var out strings.Builder
+			if _, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, &out, nil); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			// the base images are pulled at once
+			if !equalStrings(sortedNames(api.pulls), sortedNames(tt.wantPulls)) {
+				t.Errorf("pulls = %v, want %v", api.pulls, tt.wantPulls)
+			}
+			for _, s := range tt.wantOut {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output = %q, want it to contain %q", out.String(), s)
+				}
+			}
+			for _, s := range tt.notOut {
+				if strings.Contains(out.String(), s) {
+					t.Errorf("output = %q, want it without %q", out.String(), s)
+				}
+			}
+			if api.lastBuild(t).Options.PullParent {
+				t.Errorf("PullParent set for a local context")
+			}
+		})
+	}
+}
+
+func TestBuildPullBaseImagesSkipsResultCache(t *testing.T) {
+	useConfigFile(t, `{}`)
+	dir := t.TempDir()
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	api := &basePullAPI{buildAPI: &buildAPI{}}
+	c := newTestClient(api)
+	opts := BuildOptions{Tag: "app:1", ResultCacheDir: dir, PullBaseImages: true, QuietPull: true}
+	for i := 0; i < 2; i++ {
+		if _, err := c.build(context.Background(), fsContextName, fsys, opts, io.Discard, nil); err != nil {
+			t.Fatalf("build: %v", err)
+		}
+	}
+	if api.builds != 2 || len(api.pulls) != 2 {
+		t.Errorf("%d builds and %d pulls, want both builds sent after pulling", api.builds, len(api.pulls))
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("result cache = %v, %v, want it left alone", entries, err)
+	}
+}
+
+func TestBuildPullBaseImagesFailure(t *testing.T) {
+	useConfigFile(t, `{}`)
+	useTestRetryPolicy(t)
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	api := &basePullAPI{buildAPI: &buildAPI{}, err: errdefs.NotFound(errors.New("manifest unknown"))}
+	_, err := newTestClient(api).build(context.Background(), fsContextName, fsys, BuildOptions{PullBaseImages: true}, io.Discard, nil)
+	if !errors.Is(err, ImageBuildErr) || !errors.Is(err, ImagePullErr) {
+		t.Errorf("err = %v, want it to match %v and %v", err, ImageBuildErr, ImagePullErr)
+	}
+	if api.builds != 0 {
+		t.Errorf("a build was sent to the daemon")
+	}
+}
+
+// transferAPI fakes the pulls and pushes, each one waiting (up to a
+// second) for full transfers to be in flight, and records the most in
+// flight at once
+type transferAPI struct {
+	*buildAPI
+	full int
+
+	mu       sync.Mutex
+	inflight int
+	max      int
+}
+
+func (f *transferAPI) transfer(status string) io.ReadCloser {
+	f.mu.Lock()
+	f.inflight++
+	f.max = max(f.max, f.inflight)
+	f.mu.Unlock()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		f.mu.Lock()
+		reached := f.max >= f.full
+		f.mu.Unlock()
+		if reached {
+			break
+		}
+	}
+	f.mu.Lock()
+	f.inflight--
+	f.mu.Unlock()
+	return io.NopCloser(strings.NewReader(status + "\n"))
+}
+
+func (f *transferAPI) ImagePull(_ context.Context, _ string, _ types.ImagePullOptions) (io.ReadCloser, error) {
+	return f.transfer(`{"status":"Pull complete"}`), nil
+}
+
+func (f *transferAPI) ImagePush(_ context.Context, _ string, _ types.ImagePushOptions) (io.ReadCloser, error) {
+	return f.transfer(`{"aux":{"Digest":"sha256:0123"}}`), nil
+}
+
+func TestPullBaseImagesConcurrency(t *testing.T) {
+	useConfigFile(t, `{}`)
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM golang:1.22\nFROM node:20\nFROM python:3.12\nFROM alpine:3.19\n")}}
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "no limit", want: 4},
+		{name: "limited", limit: 2, want: 2},
+		{name: "one at a time", limit: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &transferAPI{buildAPI: &buildAPI{}, full: tt.want}
+			c := newTestClient(api)
+			WithMaxConcurrentDownloads(tt.limit)(&c)
+			opts := BuildOptions{PullBaseImages: true, QuietPull: true}
+			if _, err := c.build(context.Background(), fsContextName, fsys, opts, io.Discard, nil); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if api.max != tt.want {
+				t.Errorf("%d pulls in flight at most, want %d", api.max, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushConcurrency(t *testing.T) {
+	useConfigFile(t, `{}`)
+	api := &transferAPI{buildAPI: &buildAPI{}, full: 2}
+	c := newTestClient(api)
+	WithMaxConcurrentUploads(2)(&c)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Push(context.Background(), "registry.local/app:1"); err != nil {
+				t.Errorf("Push: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if api.max != 2 {
+		t.Errorf("%d pushes in flight at most, want 2", api.max)
+	}
+}
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	ImagePushErr = errors.New("failed to push image")
+)
+
+// Push pushes the image reference, retrying on failures, and returns
+// the digest of the pushed manifest
+func (c Client) Push(ctx context.Context, ref string) (digest.Digest, error) {
+	release, err := acquire(ctx, c.uploads)
+	if err != nil {
+		return "", &ImageRefError{Kind: ImagePushErr, Ref: ref, Err: err}
+	}
+	defer release()
+
+	var pushed digest.Digest
+	err = retry(ctx, defaultRetryPolicy, func() error {
+		var err error
+		pushed, err = c.push(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return "", &ImageRefError{Kind: ImagePushErr, Ref: ref, Err: err}
+	}
+	return pushed, nil
+}
+
+func (c Client) push(ctx context.Context, ref string) (digest.Digest, error) {
+	auth, err := registryAuth(ref)
+	if err != nil {
+		return "", err
+	}
+	rc, err := c.d.ImagePush(ctx, ref, types.ImagePushOptions{RegistryAuth: auth})
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	var pushed digest.Digest
+	fd, isTerm := term.GetFdInfo(os.Stdout)
+	err = jsonmessage.DisplayJSONMessagesStream(rc, os.Stdout, fd, isTerm, func(msg jsonmessage.JSONMessage) {
+		var res types.PushResult
+		if err := json.Unmarshal(*msg.Aux, &res); err == nil && res.Digest != "" {
+			pushed = digest.Digest(res.Digest)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if pushed == "" {
+		return "", fmt.Errorf("%w: the daemon did not report the pushed digest", ImagePushErr)
+	}
+	return pushed, nil
+}
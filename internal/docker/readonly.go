@@ -0,0 +1,411 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/checkpoint"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	ReadOnlyViolationErr = errors.New("read-only mode forbids changing the daemon state")
+)
+
+// WithReadOnly rejects every call that changes the daemon state
+// (create, start, build, remove, push, prune...) before it reaches the
+// daemon. Reads, logs and stats streams are passed through, as are the
+// GET and HEAD requests of the raw HTTP client.
+func WithReadOnly() ClientOption {
+	return func(c *Client) {
+		c.readOnly = true
+	}
+}
+
+// readOnlyClient is the API client guard of the read-only mode. Its
+// methods reject the mutating calls, the others come from the wrapped
+// client.
+type readOnlyClient struct {
+	client.APIClient
+}
+
+func readOnlyViolation(method string) error {
+	return fmt.Errorf("%w: %s", ReadOnlyViolationErr, method)
+}
+
+func (g readOnlyClient) BuildCachePrune(_ context.Context, _ types.BuildCachePruneOptions) (_ *types.BuildCachePruneReport, err error) {
+	err = readOnlyViolation("BuildCachePrune")
+	return
+}
+
+func (g readOnlyClient) BuildCancel(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("BuildCancel")
+	return
+}
+
+func (g readOnlyClient) CheckpointCreate(_ context.Context, _ string, _ checkpoint.CreateOptions) (err error) {
+	err = readOnlyViolation("CheckpointCreate")
+	return
+}
+
+func (g readOnlyClient) CheckpointDelete(_ context.Context, _ string, _ checkpoint.DeleteOptions) (err error) {
+	err = readOnlyViolation("CheckpointDelete")
+	return
+}
+
+func (g readOnlyClient) ConfigCreate(_ context.Context, _ swarm.ConfigSpec) (_ types.ConfigCreateResponse, err error) {
+	err = readOnlyViolation("ConfigCreate")
+	return
+}
+
+func (g readOnlyClient) ConfigRemove(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("ConfigRemove")
+	return
+}
+
+func (g readOnlyClient) ConfigUpdate(_ context.Context, _ string, _ swarm.Version, _ swarm.ConfigSpec) (err error) {
+	err = readOnlyViolation("ConfigUpdate")
+	return
+}
+
+func (g readOnlyClient) ContainerAttach(_ context.Context, _ string, _ container.AttachOptions) (_ types.HijackedResponse, err error) {
+	err = readOnlyViolation("ContainerAttach")
+	return
+}
+
+func (g readOnlyClient) ContainerCommit(_ context.Context, _ string, _ container.CommitOptions) (_ types.IDResponse, err error) {
+	err = readOnlyViolation("ContainerCommit")
+	return
+}
+
+func (g readOnlyClient) ContainerCreate(_ context.Context, _ *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (_ container.CreateResponse, err error) {
+	err = readOnlyViolation("ContainerCreate")
+	return
+}
+
+func (g readOnlyClient) ContainerExecAttach(_ context.Context, _ string, _ types.ExecStartCheck) (_ types.HijackedResponse, err error) {
+	err = readOnlyViolation("ContainerExecAttach")
+	return
+}
+
+func (g readOnlyClient) ContainerExecCreate(_ context.Context, _ string, _ types.ExecConfig) (_ types.IDResponse, err error) {
+	err = readOnlyViolation("ContainerExecCreate")
+	return
+}
+
+func (g readOnlyClient) ContainerExecResize(_ context.Context, _ string, _ container.ResizeOptions) (err error) {
+	err = readOnlyViolation("ContainerExecResize")
+	return
+}
+
+func (g readOnlyClient) ContainerExecStart(_ context.Context, _ string, _ types.ExecStartCheck) (err error) {
+	err = readOnlyViolation("ContainerExecStart")
+	return
+}
+
+func (g readOnlyClient) ContainerKill(_ context.Context, _ string, _ string) (err error) {
+	err = readOnlyViolation("ContainerKill")
+	return
+}
+
+func (g readOnlyClient) ContainerPause(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("ContainerPause")
+	return
+}
+
+func (g readOnlyClient) ContainerRemove(_ context.Context, _ string, _ container.RemoveOptions) (err error) {
+	err = readOnlyViolation("ContainerRemove")
+	return
+}
+
+func (g readOnlyClient) ContainerRename(_ context.Context, _ string, _ string) (err error) {
+	err = readOnlyViolation("ContainerRename")
+	return
+}
+
+func (g readOnlyClient) ContainerResize(_ context.Context, _ string, _ container.ResizeOptions) (err error) {
+	err = readOnlyViolation("ContainerResize")
+	return
+}
+
+func (g readOnlyClient) ContainerRestart(_ context.Context, _ string, _ container.StopOptions) (err error) {
+	err = readOnlyViolation("ContainerRestart")
+	return
+}
+
+func (g readOnlyClient) ContainerStart(_ context.Context, _ string, _ container.StartOptions) (err error) {
+	err = readOnlyViolation("ContainerStart")
+	return
+}
+
+func (g readOnlyClient) ContainerStop(_ context.Context, _ string, _ container.StopOptions) (err error) {
+	err = readOnlyViolation("ContainerStop")
+	return
+}
+
+func (g readOnlyClient) ContainerUnpause(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("ContainerUnpause")
+	return
+}
+
+func (g readOnlyClient) ContainerUpdate(_ context.Context, _ string, _ container.UpdateConfig) (_ container.ContainerUpdateOKBody, err error) {
+	err = readOnlyViolation("ContainerUpdate")
+	return
+}
+
+func (g readOnlyClient) ContainersPrune(_ context.Context, _ filters.Args) (_ types.ContainersPruneReport, err error) {
+	err = readOnlyViolation("ContainersPrune")
+	return
+}
+
+func (g readOnlyClient) CopyToContainer(_ context.Context, _ string, _ string, _ io.Reader, _ types.CopyToContainerOptions) (err error) {
+	err = readOnlyViolation("CopyToContainer")
+	return
+}
+
+func (g readOnlyClient) DialHijack(_ context.Context, _ string, _ string, _ map[string][]string) (_ net.Conn, err error) {
+	err = readOnlyViolation("DialHijack")
+	return
+}
+
+// Dialer is rejected: its raw connections (BuildKit session, attach)
+// can't be told apart from the mutating calls
+func (g readOnlyClient) Dialer() func(context.Context) (net.Conn, error) {
+	return func(context.Context) (net.Conn, error) {
+		return nil, readOnlyViolation("Dialer")
+	}
+}
+
+// HTTPClient lets the GET and HEAD requests through only
+func (g readOnlyClient) HTTPClient() *http.Client {
+	hc := *g.APIClient.HTTPClient()
+	next := hc.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	hc.Transport = readOnlyTransport{next: next}
+	return &hc
+}
+
+// readOnlyTransport rejects the requests of the HTTP client that may
+// change the daemon state
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, readOnlyViolation("HTTPClient " + req.Method + " " + req.URL.Path)
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (g readOnlyClient) ImageBuild(_ context.Context, _ io.Reader, _ types.ImageBuildOptions) (_ types.ImageBuildResponse, err error) {
+	err = readOnlyViolation("ImageBuild")
+	return
+}
+
+func (g readOnlyClient) ImageCreate(_ context.Context, _ string, _ types.ImageCreateOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("ImageCreate")
+	return
+}
+
+func (g readOnlyClient) ImageImport(_ context.Context, _ types.ImageImportSource, _ string, _ types.ImageImportOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("ImageImport")
+	return
+}
+
+func (g readOnlyClient) ImageLoad(_ context.Context, _ io.Reader, _ bool) (_ types.ImageLoadResponse, err error) {
+	err = readOnlyViolation("ImageLoad")
+	return
+}
+
+func (g readOnlyClient) ImagePull(_ context.Context, _ string, _ types.ImagePullOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("ImagePull")
+	return
+}
+
+func (g readOnlyClient) ImagePush(_ context.Context, _ string, _ types.ImagePushOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("ImagePush")
+	return
+}
+
+func (g readOnlyClient) ImageRemove(_ context.Context, _ string, _ types.ImageRemoveOptions) (_ []image.DeleteResponse, err error) {
+	err = readOnlyViolation("ImageRemove")
+	return
+}
+
+func (g readOnlyClient) ImageTag(_ context.Context, _ string, _ string) (err error) {
+	err = readOnlyViolation("ImageTag")
+	return
+}
+
+func (g readOnlyClient) ImagesPrune(_ context.Context, _ filters.Args) (_ types.ImagesPruneReport, err error) {
+	err = readOnlyViolation("ImagesPrune")
+	return
+}
+
+func (g readOnlyClient) NetworkConnect(_ context.Context, _ string, _ string, _ *network.EndpointSettings) (err error) {
+	err = readOnlyViolation("NetworkConnect")
+	return
+}
+
+func (g readOnlyClient) NetworkCreate(_ context.Context, _ string, _ types.NetworkCreate) (_ types.NetworkCreateResponse, err error) {
+	err = readOnlyViolation("NetworkCreate")
+	return
+}
+
+func (g readOnlyClient) NetworkDisconnect(_ context.Context, _ string, _ string, _ bool) (err error) {
+	err = readOnlyViolation("NetworkDisconnect")
+	return
+}
+
+func (g readOnlyClient) NetworkRemove(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("NetworkRemove")
+	return
+}
+
+func (g readOnlyClient) NetworksPrune(_ context.Context, _ filters.Args) (_ types.NetworksPruneReport, err error) {
+	err = readOnlyViolation("NetworksPrune")
+	return
+}
+
+func (g readOnlyClient) NodeRemove(_ context.Context, _ string, _ types.NodeRemoveOptions) (err error) {
+	err = readOnlyViolation("NodeRemove")
+	return
+}
+
+func (g readOnlyClient) NodeUpdate(_ context.Context, _ string, _ swarm.Version, _ swarm.NodeSpec) (err error) {
+	err = readOnlyViolation("NodeUpdate")
+	return
+}
+
+func (g readOnlyClient) PluginCreate(_ context.Context, _ io.Reader, _ types.PluginCreateOptions) (err error) {
+	err = readOnlyViolation("PluginCreate")
+	return
+}
+
+func (g readOnlyClient) PluginDisable(_ context.Context, _ string, _ types.PluginDisableOptions) (err error) {
+	err = readOnlyViolation("PluginDisable")
+	return
+}
+
+func (g readOnlyClient) PluginEnable(_ context.Context, _ string, _ types.PluginEnableOptions) (err error) {
+	err = readOnlyViolation("PluginEnable")
+	return
+}
+
+func (g readOnlyClient) PluginInstall(_ context.Context, _ string, _ types.PluginInstallOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("PluginInstall")
+	return
+}
+
+func (g readOnlyClient) PluginPush(_ context.Context, _ string, _ string) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("PluginPush")
+	return
+}
+
+func (g readOnlyClient) PluginRemove(_ context.Context, _ string, _ types.PluginRemoveOptions) (err error) {
+	err = readOnlyViolation("PluginRemove")
+	return
+}
+
+func (g readOnlyClient) PluginSet(_ context.Context, _ string, _ []string) (err error) {
+	err = readOnlyViolation("PluginSet")
+	return
+}
+
+func (g readOnlyClient) PluginUpgrade(_ context.Context, _ string, _ types.PluginInstallOptions) (_ io.ReadCloser, err error) {
+	err = readOnlyViolation("PluginUpgrade")
+	return
+}
+
+func (g readOnlyClient) SecretCreate(_ context.Context, _ swarm.SecretSpec) (_ types.SecretCreateResponse, err error) {
+	err = readOnlyViolation("SecretCreate")
+	return
+}
+
+func (g readOnlyClient) SecretRemove(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("SecretRemove")
+	return
+}
+
+func (g readOnlyClient) SecretUpdate(_ context.Context, _ string, _ swarm.Version, _ swarm.SecretSpec) (err error) {
+	err = readOnlyViolation("SecretUpdate")
+	return
+}
+
+func (g readOnlyClient) ServiceCreate(_ context.Context, _ swarm.ServiceSpec, _ types.ServiceCreateOptions) (_ swarm.ServiceCreateResponse, err error) {
+	err = readOnlyViolation("ServiceCreate")
+	return
+}
+
+func (g readOnlyClient) ServiceRemove(_ context.Context, _ string) (err error) {
+	err = readOnlyViolation("ServiceRemove")
+	return
+}
+
+func (g readOnlyClient) ServiceUpdate(_ context.Context, _ string, _ swarm.Version, _ swarm.ServiceSpec, _ types.ServiceUpdateOptions) (_ swarm.ServiceUpdateResponse, err error) {
+	err = readOnlyViolation("ServiceUpdate")
+	return
+}
+
+func (g readOnlyClient) SwarmInit(_ context.Context, _ swarm.InitRequest) (_ string, err error) {
+	err = readOnlyViolation("SwarmInit")
+	return
+}
+
+func (g readOnlyClient) SwarmJoin(_ context.Context, _ swarm.JoinRequest) (err error) {
+	err = readOnlyViolation("SwarmJoin")
+	return
+}
+
+func (g readOnlyClient) SwarmLeave(_ context.Context, _ bool) (err error) {
+	err = readOnlyViolation("SwarmLeave")
+	return
+}
+
+func (g readOnlyClient) SwarmUnlock(_ context.Context, _ swarm.UnlockRequest) (err error) {
+	err = readOnlyViolation("SwarmUnlock")
+	return
+}
+
+func (g readOnlyClient) SwarmUpdate(_ context.Context, _ swarm.Version, _ swarm.Spec, _ swarm.UpdateFlags) (err error) {
+	err = readOnlyViolation("SwarmUpdate")
+	return
+}
+
+func (g readOnlyClient) VolumeCreate(_ context.Context, _ volume.CreateOptions) (_ volume.Volume, err error) {
+	err = readOnlyViolation("VolumeCreate")
+	return
+}
+
+func (g readOnlyClient) VolumeRemove(_ context.Context, _ string, _ bool) (err error) {
+	err = readOnlyViolation("VolumeRemove")
+	return
+}
+
+func (g readOnlyClient) VolumeUpdate(_ context.Context, _ string, _ swarm.Version, _ volume.UpdateOptions) (err error) {
+	err = readOnlyViolation("VolumeUpdate")
+	return
+}
+
+func (g readOnlyClient) VolumesPrune(_ context.Context, _ filters.Args) (_ types.VolumesPruneReport, err error) {
+	err = readOnlyViolation("VolumesPrune")
+	return
+}
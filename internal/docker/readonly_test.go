@@ -0,0 +1,220 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// readOnlyPassThrough are the APIClient methods the read-only guard
+// lets through, as they don't change the daemon state. Any other
+// method, including the ones a docker client upgrade adds, must be
+// rejected by the guard.
+var readOnlyPassThrough = map[string]bool{
+	"CheckpointList":          true,
+	"ClientVersion":           true,
+	"Close":                   true,
+	"ConfigInspectWithRaw":    true,
+	"ConfigList":              true,
+	"ContainerDiff":           true,
+	"ContainerExecInspect":    true,
+	"ContainerExport":         true,
+	"ContainerInspect":        true,
+	"ContainerInspectWithRaw": true,
+	"ContainerList":           true,
+	"ContainerLogs":           true,
+	"ContainerStatPath":       true,
+	"ContainerStats":          true,
+	"ContainerStatsOneShot":   true,
+	"ContainerTop":            true,
+	"ContainerWait":           true,
+	"CopyFromContainer":       true,
+	"DaemonHost":              true,
+	"DiskUsage":               true,
+	"DistributionInspect":     true,
+	"Events":                  true,
+	"ImageHistory":            true,
+	"ImageInspectWithRaw":     true,
+	"ImageList":               true,
+	"ImageSave":               true,
+	"ImageSearch":             true,
+	"Info":                    true,
+	"NegotiateAPIVersion":     true,
+	"NegotiateAPIVersionPing": true,
+	"NetworkInspect":          true,
+	"NetworkInspectWithRaw":   true,
+	"NetworkList":             true,
+	"NodeInspectWithRaw":      true,
+	"NodeList":                true,
+	"Ping":                    true,
+	"PluginInspectWithRaw":    true,
+	"PluginList":              true,
+	"RegistryLogin":           true,
+	"SecretInspectWithRaw":    true,
+	"SecretList":              true,
+	"ServerVersion":           true,
+	"ServiceInspectWithRaw":   true,
+	"ServiceList":             true,
+	"ServiceLogs":             true,
+	"SwarmGetUnlockKey":       true,
+	"SwarmInspect":            true,
+	"TaskInspectWithRaw":      true,
+	"TaskList":                true,
+	"TaskLogs":                true,
+	"VolumeInspect":           true,
+	"VolumeInspectWithRaw":    true,
+	"VolumeList":              true,
+}
+
+// readOnlyWrapped are the APIClient methods the read-only guard
+// restricts without returning an error itself
+var readOnlyWrapped = map[string]bool{
+	"Dialer":     true,
+	"HTTPClient": true,
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// callGuarded calls the method of the guard with zero arguments, the
+// guard wrapping a nil client: a call reaching it panics, which is
+// returned as an error
+func callGuarded(m reflect.Value) (res []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("reached the wrapped client: %v", r)
+		}
+	}()
+	typ := m.Type()
+	args := make([]reflect.Value, typ.NumIn())
+	for i := range args {
+		if in := typ.In(i); in == contextType {
+			args[i] = reflect.ValueOf(context.Background())
+		} else {
+			args[i] = reflect.Zero(in)
+		}
+	}
+	if typ.IsVariadic() {
+		return m.CallSlice(args), nil
+	}
+	return m.Call(args), nil
+}
+
+func TestReadOnlyClientRejectsMutatingMethods(t *testing.T) {
+	api := reflect.TypeOf((*client.APIClient)(nil)).Elem()
+	guard := reflect.ValueOf(readOnlyClient{})
+	for i := 0; i < api.NumMethod(); i++ {
+		name := api.Method(i).Name
+		if readOnlyPassThrough[name] || readOnlyWrapped[name] {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			res, err := callGuarded(guard.MethodByName(name))
+			if err != nil {
+				t.Fatalf("%s isn't guarded: %v", name, err)
+			}
+			if len(res) == 0 || res[len(res)-1].Type() != errorType {
+				t.Fatalf("%s returns no error to reject the call with", name)
+			}
+			got, _ := res[len(res)-1].Interface().(error)
+			if !errors.Is(got, ReadOnlyViolationErr) {
+				t.Errorf("%s err = %v, want %v", name, got, ReadOnlyViolationErr)
+			}
+		})
+	}
+}
+
+func TestReadOnlyPassThroughMethodsExist(t *testing.T) {
+	api := reflect.TypeOf((*client.APIClient)(nil)).Elem()
+	for _, methods := range []map[string]bool{readOnlyPassThrough, readOnlyWrapped} {
+		for name := range methods {
+			if _, ok := api.MethodByName(name); !ok {
+				t.Errorf("%s isn't an APIClient method", name)
+			}
+		}
+	}
+}
+
+func TestReadOnlyClientDialer(t *testing.T) {
+	conn, err := readOnlyClient{}.Dialer()(context.Background())
+	if conn != nil || !errors.Is(err, ReadOnlyViolationErr) {
+		t.Errorf("dial = %v, %v, want %v", conn, err, ReadOnlyViolationErr)
+	}
+}
+
+func TestReadOnlyClientHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	d, err := client.NewClientWithOpts(client.WithHost("tcp://" + srv.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := readOnlyClient{APIClient: d}.HTTPClient()
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete} {
+		t.Run(method, func(t *testing.T) {
+			req, _ := http.NewRequest(method, srv.URL+"/containers/app", nil)
+			resp, err := hc.Do(req)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			read := method == http.MethodGet || method == http.MethodHead
+			if read && err != nil {
+				t.Errorf("%s err = %v, want it passed through", method, err)
+			}
+			if !read && !errors.Is(err, ReadOnlyViolationErr) {
+				t.Errorf("%s err = %v, want %v", method, err, ReadOnlyViolationErr)
+			}
+		})
+	}
+	if d.HTTPClient().Transport == hc.Transport {
+		t.Error("the wrapped client transport was replaced")
+	}
+}
+
+// inspectAPI answers the container inspects
+type inspectAPI struct {
+	client.APIClient
+	calls int
+}
+
+func (f *inspectAPI) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	f.calls++
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id}}, nil
+}
+
+func TestReadOnlyClientPassesReadsThrough(t *testing.T) {
+	api := &inspectAPI{}
+	guard := readOnlyClient{APIClient: api}
+	res, err := guard.ContainerInspect(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("ContainerInspect: %v", err)
+	}
+	if res.ID != "abc" || api.calls != 1 {
+		t.Errorf("ContainerInspect didn't reach the wrapped client")
+	}
+}
+
+func TestNewClientReadOnly(t *testing.T) {
+	c, err := NewClient(WithReadOnly())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, ok := c.d.(readOnlyClient); !ok {
+		t.Errorf("client API is %T, want the read-only guard", c.d)
+	}
+	_, err = c.d.ImageRemove(context.Background(), "alpine", types.ImageRemoveOptions{})
+	if !errors.Is(err, ReadOnlyViolationErr) {
+		t.Errorf("ImageRemove err = %v, want %v", err, ReadOnlyViolationErr)
+	}
+}
@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"github.com/distribution/reference"
+)
+
+// RefValidation is the outcome of an image reference syntax check
+type RefValidation struct {
+	Ref string
+	// Normalized is the fully qualified reference (docker.io/library,
+	// latest tag defaulted) of a valid one
+	Normalized string
+	Valid      bool
+	// Reason tells why an invalid reference was rejected
+	Reason string
+}
+
+// ValidateRef checks the syntax of the image reference ref with the
+// parser the daemon uses, without any registry access
+func ValidateRef(ref string) RefValidation {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return RefValidation{Ref: ref, Reason: err.Error()}
+	}
+	return RefValidation{Ref: ref, Normalized: reference.TagNameOnly(named).String(), Valid: true}
+}
@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRef(t *testing.T) {
+	tests := []struct {
+		ref        string
+		normalized string
+		reason     string
+	}{
+		{ref: "alpine", normalized: "docker.io/library/alpine:latest"},
+		{ref: "alpine:3.19", normalized: "docker.io/library/alpine:3.19"},
+		{ref: "eldius/test-image", normalized: "docker.io/eldius/test-image:latest"},
+		{ref: "registry.local:5000/team/app:v1.2", normalized: "registry.local:5000/team/app:v1.2"},
+		{
+			ref:        "alpine@sha256:" + strings.Repeat("a", 64),
+			normalized: "docker.io/library/alpine@sha256:" + strings.Repeat("a", 64),
+		},
+		{ref: "Alpine", reason: "must be lowercase"},
+		{ref: "alpine:", reason: "invalid reference format"},
+		{ref: "alpine:bad tag", reason: "invalid reference format"},
+		{ref: "", reason: "invalid reference format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got := ValidateRef(tt.ref)
+			if got.Ref != tt.ref {
+				t.Errorf("Ref = %q, want %q", got.Ref, tt.ref)
+			}
+			if tt.reason != "" {
+				if got.Valid || !strings.Contains(got.Reason, tt.reason) {
+					t.Errorf("ValidateRef = %+v, want invalid for %q", got, tt.reason)
+				}
+				return
+			}
+			if !got.Valid || got.Normalized != tt.normalized || got.Reason != "" {
+				t.Errorf("ValidateRef = %+v, want valid %s", got, tt.normalized)
+			}
+		})
+	}
+}
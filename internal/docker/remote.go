@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	RemoteContextErr       = errors.New("failed to fetch remote context")
+	InvalidHostKeyCheckErr = errors.New("invalid host key checking mode")
+)
+
+var (
+	// scpLikeGitRe matches the scp-like SSH form (git@host:org/repo.git)
+	scpLikeGitRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+@[A-Za-z0-9_.-]+:[^/].*$`)
+)
+
+// HostKeyChecking is the SSH StrictHostKeyChecking mode used for
+// git clones over SSH
+type HostKeyChecking string
+
+const (
+	HostKeyCheckingYes       HostKeyChecking = "yes"
+	HostKeyCheckingAcceptNew HostKeyChecking = "accept-new"
+	HostKeyCheckingNo        HostKeyChecking = "no"
+)
+
+// ParseHostKeyChecking validates the host key checking mode
+func ParseHostKeyChecking(s string) (HostKeyChecking, error) {
+	switch m := HostKeyChecking(s); m {
+	case "":
+		return HostKeyCheckingYes, nil
+	case HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: %q (valid modes: %s, %s, %s)", InvalidHostKeyCheckErr, s, HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo)
+}
+
+// GitSSHOptions holds the SSH settings used to clone git contexts
+type GitSSHOptions struct {
+	// KnownHostsFile replaces the user known hosts file
+	KnownHostsFile string
+	// HostKeyChecking is the StrictHostKeyChecking mode (yes if empty)
+	HostKeyChecking HostKeyChecking
+}
+
+type remoteKind int
+
+const (
+	// remoteGitHTTP is a git repository the daemon can fetch
+	remoteGitHTTP remoteKind = iota + 1
+	// remoteGitSSH is a git repository cloned locally, as the
+	// daemon has no access to the user SSH agent
+	remoteGitSSH
+)
+
+// remoteContext is a git build context location
+type remoteContext struct {
+	Kind remoteKind
+	// Repo is the repository URL, without the fragment
+	Repo string
+	// Ref is the branch, tag or commit to check out
+	Ref string
+	// Subdir is the context folder inside the repository
+	Subdir string
+}
+
+// Local tells if the context must be cloned by the runner (true) or
+// can be sent to the daemon as a remote context (false)
+func (r remoteContext) Local() bool {
+	return r.Kind == remoteGitSSH
+}
+
+// String returns the URL in the form the daemon expects
+func (r remoteContext) String() string {
+	s := r.Repo
+	if r.Ref != "" || r.Subdir != "" {
+		s += "#" + r.Ref
+		if r.Subdir != "" {
+			s += ":" + r.Subdir
+		}
+	}
+	return s
+}
+
+// parseRemoteContext recognizes the git context forms
+// (git@host:org/repo.git#ref:subdir, ssh://..., git://...,
+// https://....git). It returns false for local paths.
+func parseRemoteContext(src string) (remoteContext, bool) {
+	repo, fragment, _ := strings.Cut(src, "#")
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	rc := remoteContext{Repo: repo, Ref: ref, Subdir: subdir}
+
+	switch {
+	case strings.HasPrefix(repo, "ssh://"), strings.HasPrefix(repo, "git+ssh://"):
+		rc.Repo = strings.TrimPrefix(repo, "git+")
+		rc.Kind = remoteGitSSH
+	case scpLikeGitRe.MatchString(repo):
+		rc.Kind = remoteGitSSH
+	case strings.HasPrefix(repo, "git://"), strings.HasPrefix(repo, "github.com/"):
+		rc.Kind = remoteGitHTTP
+	case (strings.HasPrefix(repo, "https://") || strings.HasPrefix(repo, "http://")) && strings.HasSuffix(repo, ".git"):
+		rc.Kind = remoteGitHTTP
+	default:
+		return remoteContext{}, false
+	}
+	return rc, true
+}
+
+// gitSSHCommand is the GIT_SSH_COMMAND of the clones, git running it
+// through the shell, so the known hosts file path is single quoted
+func gitSSHCommand(checking HostKeyChecking, knownHostsFile string) string {
+	cmd := "ssh -o StrictHostKeyChecking=" + string(checking)
+	if knownHostsFile != "" {
+		cmd += " -o UserKnownHostsFile='" + strings.ReplaceAll(knownHostsFile, "'", `'\''`) + "'"
+	}
+	return cmd
+}
+
+// cloneRemoteContext clones the repository to a temporary folder,
+// using the user SSH agent, and returns the folder
+func cloneRemoteContext(ctx context.Context, rc remoteContext, opts GitSSHOptions) (string, error) {
+	checking, err := ParseHostKeyChecking(string(opts.HostKeyChecking))
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "docker-runner-context-")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", RemoteContextErr, err)
+	}
+
+	env := append(os.Environ(), "GIT_SSH_COMMAND="+gitSSHCommand(checking, opts.KnownHostsFile), "GIT_TERMINAL_PROMPT=0")
+
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: git %s: %w: %s", RemoteContextErr, args[0], err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	slog.With("repo", rc.Repo, "ref", rc.Ref).Debug("CloningContext")
+	if err := git("init", "--quiet"); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	if err := git("remote", "add", "origin", rc.Repo); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	ref := rc.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := git("fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	if err := git("checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("%w: %w", RemoteContextErr, err)
+	}
+	return dir, nil
+}
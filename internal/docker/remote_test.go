@@ -0,0 +1,193 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRemoteContext(t *testing.T) {
+	tests := []struct {
+		src    string
+		remote bool
+		want   remoteContext
+		local  bool
+	}{
+		{
+			src:    "git@github.com:eldius/app.git#v1.2:services/api",
+			remote: true,
+			want:   remoteContext{Kind: remoteGitSSH, Repo: "git@github.com:eldius/app.git", Ref: "v1.2", Subdir: "services/api"},
+			local:  true,
+		},
+		{
+			src:    "ssh://git@gitlab.local:2222/team/app.git#main",
+			remote: true,
+			want:   remoteContext{Kind: remoteGitSSH, Repo: "ssh://git@gitlab.local:2222/team/app.git", Ref: "main"},
+			local:  true,
+		},
+		{
+			src:    "git+ssh://git@gitlab.local/team/app.git#:docker",
+			remote: true,
+			want:   remoteContext{Kind: remoteGitSSH, Repo: "ssh://git@gitlab.local/team/app.git", Subdir: "docker"},
+			local:  true,
+		},
+		{
+			src:    "https://github.com/eldius/app.git#main:build",
+			remote: true,
+			want:   remoteContext{Kind: remoteGitHTTP, Repo: "https://github.com/eldius/app.git", Ref: "main", Subdir: "build"},
+		},
+		{
+			src:    "git://git.local/app",
+			remote: true,
+			want:   remoteContext{Kind: remoteGitHTTP, Repo: "git://git.local/app"},
+		},
+		{src: "."},
+		{src: "./services/api"},
+		{src: "/home/dev/app"},
+		// not a repository, the daemon would take it as a tarball
+		{src: "https://example.com/context.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, ok := parseRemoteContext(tt.src)
+			if ok != tt.remote {
+				t.Fatalf("parseRemoteContext remote = %v, want %v", ok, tt.remote)
+			}
+			if got != tt.want {
+				t.Errorf("parseRemoteContext = %+v, want %+v", got, tt.want)
+			}
+			if got.Local() != tt.local {
+				t.Errorf("Local() = %v, want %v", got.Local(), tt.local)
+			}
+		})
+	}
+}
+
+func TestRemoteContextString(t *testing.T) {
+	tests := []struct {
+		rc   remoteContext
+		want string
+	}{
+		{remoteContext{Repo: "https://github.com/eldius/app.git"}, "https://github.com/eldius/app.git"},
+		{remoteContext{Repo: "https://github.com/eldius/app.git", Ref: "main"}, "https://github.com/eldius/app.git#main"},
+		{remoteContext{Repo: "https://github.com/eldius/app.git", Subdir: "build"}, "https://github.com/eldius/app.git#:build"},
+		{remoteContext{Repo: "https://github.com/eldius/app.git", Ref: "v1", Subdir: "build"}, "https://github.com/eldius/app.git#v1:build"},
+	}
+	for _, tt := range tests {
+		if got := tt.rc.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseHostKeyChecking(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    HostKeyChecking
+		wantErr bool
+	}{
+		{in: "", want: HostKeyCheckingYes},
+		{in: "yes", want: HostKeyCheckingYes},
+		{in: "accept-new", want: HostKeyCheckingAcceptNew},
+		{in: "no", want: HostKeyCheckingNo},
+		{in: "ask", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHostKeyChecking(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseHostKeyChecking(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// gitRepo creates a repository holding the tree in one commit tagged
+// v1, returning its folder
+func gitRepo(t *testing.T, tree map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, tree)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@local", "commit", "--quiet", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestGitSSHCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		knownHosts string
+		want       []string
+	}{
+		{name: "no known hosts file", want: []string{"ssh", "-o", "StrictHostKeyChecking=yes"}},
+		{
+			name:       "plain path",
+			knownHosts: "/tmp/known_hosts",
+			want:       []string{"ssh", "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/tmp/known_hosts"},
+		},
+		{
+			name:       "spaces and quotes",
+			knownHosts: "/home/me/my hosts/it's $HOME",
+			want:       []string{"ssh", "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/home/me/my hosts/it's $HOME"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the shell splits the command like git does
+			out, err := exec.Command("/bin/sh", "-c", "set -- "+gitSSHCommand(HostKeyCheckingYes, tt.knownHosts)+`; printf '%s\n' "$@"`).Output()
+			if err != nil {
+				t.Fatalf("sh: %v", err)
+			}
+			if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !equalStrings(got, tt.want) {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloneRemoteContext(t *testing.T) {
+	repo := gitRepo(t, map[string]string{
+		"Dockerfile":          "FROM alpine:3.19\n",
+		"services/api/app.go": "package main\n",
+	})
+	dir, err := cloneRemoteContext(context.Background(), remoteContext{Kind: remoteGitSSH, Repo: repo, Ref: "v1"}, GitSSHOptions{})
+	if err != nil {
+		t.Fatalf("cloneRemoteContext: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	for _, name := range []string{"Dockerfile", "services/api/app.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not checked out: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(".git left in the context: %v", err)
+	}
+}
+
+func TestCloneRemoteContextErrors(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"Dockerfile": "FROM alpine:3.19\n"})
+	if _, err := cloneRemoteContext(context.Background(), remoteContext{Kind: remoteGitSSH, Repo: repo, Ref: "missing"}, GitSSHOptions{}); !errors.Is(err, RemoteContextErr) {
+		t.Errorf("missing ref err = %v, want %v", err, RemoteContextErr)
+	}
+	if _, err := cloneRemoteContext(context.Background(), remoteContext{Kind: remoteGitSSH, Repo: repo}, GitSSHOptions{HostKeyChecking: "ask"}); !errors.Is(err, InvalidHostKeyCheckErr) {
+		t.Errorf("bad host key checking err = %v, want %v", err, InvalidHostKeyCheckErr)
+	}
+}
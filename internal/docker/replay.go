@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	BuildStreamRecordErr = errors.New("failed to record build stream")
+	BuildStreamReplayErr = errors.New("failed to replay build stream")
+)
+
+// ReplayBuildStream feeds a build stream recorded with
+// BuildOptions.RecordStream through the output handling of a build,
+// without a daemon: the output mode, tail, warnings and FailOnWarn
+// options apply, and the result gets the image ID and steps the stream
+// reported
+func ReplayBuildStream(path string, opts BuildOptions) (BuildResult, error) {
+	return replayBuildStream(path, opts, os.Stdout, nil)
+}
+
+func replayBuildStream(path string, opts BuildOptions, out io.Writer, handler func(BuildEvent)) (BuildResult, error) {
+	var res BuildResult
+	f, err := os.Open(path)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", BuildStreamReplayErr, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	warnings, err := warningPatterns(opts)
+	if err != nil {
+		return res, err
+	}
+	printer, err := newBuildPrinter(out, opts, warnings)
+	if err != nil {
+		return res, err
+	}
+	collector := newWarningCollector(warnings)
+	if err := readBuildStream(f, out, opts, printer, collector, handler, &res); err != nil {
+		return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+	}
+	if opts.FailOnWarn {
+		if err := collector.Err(); err != nil {
+			return res, fmt.Errorf("%w: %w", ImageBuildErr, err)
+		}
+	}
+	return res, nil
+}
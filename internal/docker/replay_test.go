@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRecordAndReplayBuildStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.stream")
+	stream := classicStream(testImageID, "FROM alpine:3.19", "RUN make")
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\nRUN make\n")}}
+	built, err := newTestClient(&buildAPI{stream: stream}).build(context.Background(), fsContextName, fsys, BuildOptions{RecordStream: path}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	recorded, err := os.ReadFile(path)
+	if err != nil || string(recorded) != stream {
+		t.Fatalf("recorded = %q, %v, want the daemon stream", recorded, err)
+	}
+
+	var out strings.Builder
+	var events []BuildEvent
+	res, err := replayBuildStream(path, BuildOptions{}, &out, func(e BuildEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("replayBuildStream: %v", err)
+	}
+	if res.ImageID != built.ImageID || len(res.Steps) != len(built.Steps) || len(res.Steps) != 2 {
+		t.Errorf("replayed result = %+v, want the built one %+v", res, built)
+	}
+	if !strings.Contains(out.String(), "Step 2/2 : RUN make") || len(events) == 0 {
+		t.Errorf("output = %q with %d events, want the build output", out.String(), len(events))
+	}
+}
+
+func TestReplayBuildStreamFailures(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	warned := classicStream("", "FROM alpine:3.19") + streamMessage(map[string]any{"stream": "WARNING: deprecated flag\n"}) +
+		streamMessage(map[string]any{"aux": map[string]string{"ID": testImageID}})
+	tests := []struct {
+		name    string
+		path    string
+		opts    BuildOptions
+		want    []error
+		wantOut string
+	}{
+		{name: "missing", path: filepath.Join(dir, "missing.stream"), want: []error{BuildStreamReplayErr}},
+		{
+			name:    "failed build",
+			path:    write("failed.stream", failedStream("boom", "FROM alpine:3.19", "RUN make")),
+			opts:    BuildOptions{Tail: 1},
+			want:    []error{ImageBuildErr, BuildStepErr},
+			wantOut: "build failed, last output:\n  ERROR: boom\n",
+		},
+		{
+			name: "fail on warn",
+			path: write("warned.stream", warned),
+			opts: BuildOptions{FailOnWarn: true},
+			want: []error{ImageBuildErr, BuildWarningsErr},
+		},
+		{
+			name: "bad pattern",
+			path: write("ok.stream", classicStream(testImageID, "FROM alpine:3.19")),
+			opts: BuildOptions{WarningPatterns: []string{"("}},
+			want: []error{InvalidBuildOutputErr},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			_, err := replayBuildStream(tt.path, tt.opts, &out, nil)
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("err = %v, want %v", err, want)
+				}
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRecordStreamErr(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	path := filepath.Join(t.TempDir(), "missing", "build.stream")
+	_, err := newTestClient(&buildAPI{}).build(context.Background(), fsContextName, fsys, BuildOptions{RecordStream: path}, io.Discard, nil)
+	if !errors.Is(err, BuildStreamRecordErr) {
+		t.Errorf("err = %v, want %v", err, BuildStreamRecordErr)
+	}
+}
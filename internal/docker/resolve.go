@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+)
+
+// expandedInstructions are the instructions whose arguments the builder
+// expands with the ARG and ENV values in scope. RUN, CMD and ENTRYPOINT
+// are expanded by the container shell instead.
+var expandedInstructions = map[string]bool{
+	"add":        true,
+	"copy":       true,
+	"env":        true,
+	"expose":     true,
+	"from":       true,
+	"label":      true,
+	"stopsignal": true,
+	"user":       true,
+	"volume":     true,
+	"workdir":    true,
+}
+
+// resolveDockerfile returns the Dockerfile of r with the ARG and ENV
+// references replaced by their values where they can be told without
+// building: the ARG defaults, overridden by the build args, and the ENV
+// values of the stage (and of the stages it's based on). Unknown
+// references are left as is. Continuation lines are joined.
+func resolveDockerfile(r io.Reader, args map[string]*string) (string, error) {
+	res, err := parser.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", DockerfileParseErr, err)
+	}
+	escape := byte(res.EscapeToken)
+
+	meta := map[string]string{}
+	// scope holds the ARG and ENV values of the current stage (nil
+	// before the first FROM), env only its ENV values
+	var scope, env map[string]string
+	stageEnv := map[string]map[string]string{}
+	var b strings.Builder
+	for _, node := range res.AST.Children {
+		instr := strings.ToLower(node.Value)
+		vars := scope
+		if scope == nil {
+			vars = meta
+		}
+		line := node.Original
+		switch instr {
+		case "arg":
+			parsed, err := instructions.ParseInstruction(node)
+			if err != nil {
+				return "", fmt.Errorf("%w: %w", DockerfileParseErr, err)
+			}
+			var kvs []string
+			for _, a := range parsed.(*instructions.ArgCommand).Args {
+				v, ok := resolveArg(a, args, meta, vars, escape)
+				if !ok {
+					kvs = append(kvs, a.Key)
+					continue
+				}
+				vars[a.Key] = v
+				kvs = append(kvs, a.Key+"="+quoteValue(v))
+			}
+			line = node.Value + " " + strings.Join(kvs, " ")
+		case "from":
+			line = expandKnown(line, meta, escape)
+			resolved, err := parser.Parse(strings.NewReader(line))
+			if err != nil {
+				return "", fmt.Errorf("%w: %w", DockerfileParseErr, err)
+			}
+			parsed, err := instructions.ParseInstruction(resolved.AST.Children[0])
+			if err != nil {
+				return "", fmt.Errorf("%w: %w", DockerfileParseErr, err)
+			}
+			s := parsed.(*instructions.Stage)
+			env = maps.Clone(stageEnv[strings.ToLower(s.BaseName)])
+			if env == nil {
+				env = map[string]string{}
+			}
+			scope = maps.Clone(env)
+			if s.Name != "" {
+				stageEnv[strings.ToLower(s.Name)] = env
+			}
+		case "env":
+			line = expandKnown(line, vars, escape)
+			parsed, err := instructions.ParseInstruction(node)
+			if err != nil {
+				return "", fmt.Errorf("%w: %w", DockerfileParseErr, err)
+			}
+			for _, kv := range parsed.(*instructions.EnvCommand).Env {
+				v, ok := expandValue(kv.Value, vars, escape)
+				for _, m := range []map[string]string{vars, env} {
+					if m == nil {
+						continue
+					}
+					if ok {
+						m[kv.Key] = v
+					} else {
+						delete(m, kv.Key)
+					}
+				}
+			}
+		default:
+			if expandedInstructions[instr] {
+				line = expandKnown(line, vars, escape)
+			}
+		}
+		_, _ = fmt.Fprintln(&b, line)
+	}
+	return b.String(), nil
+}
+
+// resolveArg returns the value of an ARG: the build arg if given, else
+// its default (expanded), else the one of the global ARG it redeclares
+func resolveArg(a instructions.KeyValuePairOptional, args map[string]*string, meta, vars map[string]string, escape byte) (string, bool) {
+	if v, ok := args[a.Key]; ok && v != nil {
+		return *v, true
+	}
+	if a.Value != nil {
+		return expandValue(*a.Value, vars, escape)
+	}
+	v, ok := meta[a.Key]
+	return v, ok
+}
+
+// expandValue expands a value fully, removing its quotes and escapes
+// as the builder does, telling if every reference it holds is known
+func expandValue(s string, vars map[string]string, escape byte) (string, bool) {
+	res := expandKnown(s, vars, escape)
+	if strings.Contains(strings.ReplaceAll(res, string(escape)+"$", ""), "$") {
+		return res, false
+	}
+	word, err := shell.NewLex(rune(escape)).ProcessWordWithMap(res, nil)
+	if err != nil {
+		return res, false
+	}
+	return word, true
+}
+
+// expandKnown replaces the $VAR, ${VAR}, ${VAR:-default} and
+// ${VAR:+alternative} references of s whose variable is in vars,
+// leaving escaped and single quoted ones untouched
+func expandKnown(s string, vars map[string]string, escape byte) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == escape && i+1 < len(s):
+			b.WriteByte(ch)
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		case ch == '\'':
+			quoted = !quoted
+		case ch == '$' && !quoted:
+			if ref, n, ok := expandRef(s[i:], vars, escape); ok {
+				b.WriteString(ref)
+				i += n - 1
+				continue
+			}
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// expandRef expands the reference at the start of s, returning its
+// value and length, or false if it can't be resolved
+func expandRef(s string, vars map[string]string, escape byte) (string, int, bool) {
+	if len(s) > 1 && s[1] != '{' {
+		n := 1
+		for n < len(s) && isVarChar(s[n]) {
+			n++
+		}
+		v, ok := vars[s[1:n]]
+		return v, n, ok && n > 1
+	}
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return "", 0, false
+	}
+	inner := s[2:end]
+	n := 0
+	for n < len(inner) && isVarChar(inner[n]) {
+		n++
+	}
+	name, modifier := inner[:n], inner[n:]
+	// a variable missing from vars may still be set, by the base image
+	// ENV or the builder (e.g. the TARGETPLATFORM arg), so it's left
+	// as is, even with a default
+	v, set := vars[name]
+	if name == "" || !set {
+		return "", 0, false
+	}
+	empty := v == "" && strings.HasPrefix(modifier, ":")
+	word := strings.TrimPrefix(modifier, ":")
+	switch {
+	case modifier == "":
+		return v, end + 1, true
+	case strings.HasPrefix(word, "-"):
+		if !empty {
+			return v, end + 1, true
+		}
+		def, ok := expandValue(word[1:], vars, escape)
+		return def, end + 1, ok
+	case strings.HasPrefix(word, "+"):
+		if empty {
+			return "", end + 1, true
+		}
+		alt, ok := expandValue(word[1:], vars, escape)
+		return alt, end + 1, ok
+	}
+	return "", 0, false
+}
+
+func isVarChar(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// quoteValue double quotes a value holding spaces or quotes
+func quoteValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\"'") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
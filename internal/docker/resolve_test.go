@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResolveDockerfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		args       map[string]*string
+		want       string
+	}{
+		{
+			name:       "meta arg in from",
+			dockerfile: "ARG BASE=alpine\nARG VERSION=3.19\nFROM ${BASE}:$VERSION\n",
+			want:       "ARG BASE=alpine\nARG VERSION=3.19\nFROM alpine:3.19\n",
+		},
+		{
+			name:       "build arg overrides default",
+			dockerfile: "ARG BASE=alpine\nFROM $BASE\n",
+			args:       map[string]*string{"BASE": strPtr("debian")},
+			want:       "ARG BASE=debian\nFROM debian\n",
+		},
+		{
+			name:       "meta arg redeclared in stage",
+			dockerfile: "ARG VERSION=1.0\nFROM alpine\nARG VERSION\nLABEL version=$VERSION\n",
+			want:       "ARG VERSION=1.0\nFROM alpine\nARG VERSION=1.0\nLABEL version=1.0\n",
+		},
+		{
+			name:       "meta arg not redeclared",
+			dockerfile: "ARG VERSION=1.0\nFROM alpine\nLABEL version=$VERSION\n",
+			want:       "ARG VERSION=1.0\nFROM alpine\nLABEL version=$VERSION\n",
+		},
+		{
+			name:       "env not expanded in run",
+			dockerfile: "FROM alpine\nENV APP=/srv/app\nWORKDIR $APP\nCOPY . ${APP}/src\nRUN ls $APP\n",
+			want:       "FROM alpine\nENV APP=/srv/app\nWORKDIR /srv/app\nCOPY . /srv/app/src\nRUN ls $APP\n",
+		},
+		{
+			name:       "quoted env value",
+			dockerfile: "FROM alpine\nENV APP=\"/srv/my app\"\nWORKDIR $APP\n",
+			want:       "FROM alpine\nENV APP=\"/srv/my app\"\nWORKDIR /srv/my app\n",
+		},
+		{
+			name:       "env inherited from named stage",
+			dockerfile: "FROM alpine AS base\nENV APP=/srv\nFROM base\nWORKDIR $APP\nFROM alpine\nWORKDIR $APP\n",
+			want:       "FROM alpine AS base\nENV APP=/srv\nFROM base\nWORKDIR /srv\nFROM alpine\nWORKDIR $APP\n",
+		},
+		{
+			name:       "unknown refs kept",
+			dockerfile: "FROM alpine\nWORKDIR ${HOME:-/root}\nLABEL platform=$TARGETPLATFORM\n",
+			want:       "FROM alpine\nWORKDIR ${HOME:-/root}\nLABEL platform=$TARGETPLATFORM\n",
+		},
+		{
+			name:       "defaults and alternatives",
+			dockerfile: "FROM alpine\nARG EMPTY=\"\"\nARG NAME=app\nLABEL a=${EMPTY:-none} b=${NAME:+set} c=${EMPTY:+set}\n",
+			want:       "FROM alpine\nARG EMPTY=\"\"\nARG NAME=app\nLABEL a=none b=set c=\n",
+		},
+		{
+			name:       "escaped and single quoted refs",
+			dockerfile: "FROM alpine\nENV NAME=app\nLABEL a=\\$NAME b='$NAME' c=\"$NAME\"\n",
+			want:       "FROM alpine\nENV NAME=app\nLABEL a=\\$NAME b='$NAME' c=\"app\"\n",
+		},
+		{
+			name:       "quoted arg value",
+			dockerfile: "FROM alpine\nARG GREETING=\"hello world\"\n",
+			want:       "FROM alpine\nARG GREETING=\"hello world\"\n",
+		},
+		{
+			name:       "continuation lines joined",
+			dockerfile: "FROM alpine\nENV APP=/srv\nCOPY a \\\n  b \\\n  $APP/\n",
+			want:       "FROM alpine\nENV APP=/srv\nCOPY a   b   /srv/\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDockerfile(strings.NewReader(tt.dockerfile), tt.args)
+			if err != nil {
+				t.Fatalf("resolveDockerfile: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveDockerfile =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDockerfileParseErr(t *testing.T) {
+	if _, err := resolveDockerfile(strings.NewReader("FROM\n"), nil); !errors.Is(err, DockerfileParseErr) {
+		t.Errorf("err = %v, want %v", err, DockerfileParseErr)
+	}
+}
+
+func TestPrintResolvedDockerfile(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("ARG BASE=alpine\nFROM $BASE\n")}}
+	tests := []struct {
+		name   string
+		fsys   fstest.MapFS
+		remote string
+		opts   BuildOptions
+		want   string
+	}{
+		{
+			name: "context",
+			fsys: fsys,
+			want: "Resolved Dockerfile:\nARG BASE=alpine\nFROM alpine\n",
+		},
+		{
+			name: "inline",
+			fsys: fstest.MapFS{},
+			opts: BuildOptions{Dockerfile: []byte("FROM $BASE\n"), BuildArgs: map[string]*string{"BASE": strPtr("debian")}},
+			want: "Resolved Dockerfile:\nFROM $BASE\n",
+		},
+		{
+			name:   "remote",
+			fsys:   fstest.MapFS{},
+			remote: "https://github.com/org/repo.git",
+			want:   "Resolved Dockerfile: not available for remote context https://github.com/org/repo.git\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := printResolvedDockerfile(&out, tt.fsys, tt.remote, tt.opts); err != nil {
+				t.Fatalf("printResolvedDockerfile: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintResolvedDockerfileNotFound(t *testing.T) {
+	err := printResolvedDockerfile(&bytes.Buffer{}, fstest.MapFS{}, "", BuildOptions{})
+	if !errors.Is(err, DockerfileNotFoundErr) {
+		t.Errorf("err = %v, want %v", err, DockerfileNotFoundErr)
+	}
+}
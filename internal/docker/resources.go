@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+var (
+	InvalidResourceLimitErr = errors.New("invalid resource limit")
+)
+
+// minMemoryLimit is the smallest memory limit the daemon accepts
+const minMemoryLimit = 6 * 1024 * 1024
+
+// ResourceLimits bound what a container can use of the host
+type ResourceLimits struct {
+	// Memory is the memory limit in bytes (none if zero)
+	Memory int64
+	// NanoCPUs is the CPU quota in units of 1e-9 CPUs (none if zero)
+	NanoCPUs int64
+	// PidsLimit is the maximum number of processes (none if zero)
+	PidsLimit int64
+	// CPUShares is the relative CPU weight (the daemon default, 1024,
+	// if zero)
+	CPUShares int64
+	// CpusetCpus are the CPUs the container is pinned to (0-3,5), all
+	// of them if empty
+	CpusetCpus string
+	// CpusetMems are the NUMA memory nodes the container allocates
+	// from, all of them if empty
+	CpusetMems string
+}
+
+// ParseMemoryLimit parses a human readable memory limit (512m, 1.5g)
+func ParseMemoryLimit(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(s)
+	if err != nil || size < minMemoryLimit {
+		return 0, fmt.Errorf("%w: memory %q (expected a size of at least 6m, e.g. 512m)", InvalidResourceLimitErr, s)
+	}
+	return size, nil
+}
+
+// ParseCPUs parses a, possibly fractional, number of CPUs (1.5) into
+// nano CPUs
+func ParseCPUs(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	cpus, err := strconv.ParseFloat(s, 64)
+	if err != nil || cpus <= 0 || math.IsInf(cpus, 0) || cpus*1e9 > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: cpus %q (expected a positive number, e.g. 1.5)", InvalidResourceLimitErr, s)
+	}
+	return int64(math.Round(cpus * 1e9)), nil
+}
+
+// ParseCPUSet parses a cpuset list of ids and ranges (0-3,5) into the
+// sorted ids it holds
+func ParseCPUSet(s string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(from)
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(to)
+		}
+		if err != nil || first < 0 || last < first {
+			return nil, fmt.Errorf("%w: cpuset %q (expected ids and ranges, e.g. 0-3,5)", InvalidResourceLimitErr, s)
+		}
+		for i := first; i <= last; i++ {
+			seen[i] = true
+		}
+	}
+	ids := make([]int, 0, len(seen))
+	for i := range seen {
+		ids = append(ids, i)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// checkCPUSet fails if the CpusetCpus list names a CPU the daemon host
+// doesn't have, so a typo fails before creating anything
+func (c Client) checkCPUSet(ctx context.Context, l ResourceLimits) error {
+	if l.CpusetCpus == "" {
+		return nil
+	}
+	ids, err := ParseCPUSet(l.CpusetCpus)
+	if err != nil {
+		return err
+	}
+	info, err := c.d.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", DaemonInfoErr, err)
+	}
+	if last := ids[len(ids)-1]; info.NCPU > 0 && last >= info.NCPU {
+		return fmt.Errorf("%w: cpuset %q names CPU %d, the daemon host has %d (0-%d)", InvalidResourceLimitErr, l.CpusetCpus, last, info.NCPU, info.NCPU-1)
+	}
+	return nil
+}
+
+// apply sets the limits on the host config resources
+func (l ResourceLimits) apply(hostCfg *container.HostConfig) error {
+	if l.PidsLimit < 0 {
+		return fmt.Errorf("%w: pids limit %d", InvalidResourceLimitErr, l.PidsLimit)
+	}
+	if l.CPUShares < 0 {
+		return fmt.Errorf("%w: cpu shares %d", InvalidResourceLimitErr, l.CPUShares)
+	}
+	for _, set := range []string{l.CpusetCpus, l.CpusetMems} {
+		if set == "" {
+			continue
+		}
+		if _, err := ParseCPUSet(set); err != nil {
+			return err
+		}
+	}
+	hostCfg.Memory = l.Memory
+	hostCfg.NanoCPUs = l.NanoCPUs
+	hostCfg.CPUShares = l.CPUShares
+	hostCfg.CpusetCpus = l.CpusetCpus
+	hostCfg.CpusetMems = l.CpusetMems
+	if l.PidsLimit > 0 {
+		pids := l.PidsLimit
+		hostCfg.PidsLimit = &pids
+	}
+	return nil
+}
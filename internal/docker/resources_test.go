@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+)
+
+func TestParseMemoryLimit(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "", want: 0},
+		{s: "512m", want: 512 << 20},
+		{s: "512M", want: 512 << 20},
+		{s: "1.5g", want: 3 << 29},
+		{s: "6m", want: 6 << 20},
+		{s: "1048576000", want: 1048576000},
+		{s: "5m", wantErr: true},
+		{s: "lots", wantErr: true},
+		{s: "-1g", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseMemoryLimit(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidResourceLimitErr) {
+					t.Errorf("err = %v, want %v", err, InvalidResourceLimitErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMemoryLimit = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCPUs(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "", want: 0},
+		{s: "1", want: 1e9},
+		{s: "1.5", want: 15e8},
+		{s: "0.25", want: 25e7},
+		{s: "0.000000001", want: 1},
+		{s: "0", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "two", wantErr: true},
+		{s: "Inf", wantErr: true},
+		{s: "1e10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseCPUs(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidResourceLimitErr) {
+					t.Errorf("err = %v, want %v", err, InvalidResourceLimitErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseCPUs = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceLimitsApply(t *testing.T) {
+	hostCfg := &container.HostConfig{}
+	l := ResourceLimits{Memory: 512 << 20, NanoCPUs: 15e8, PidsLimit: 100}
+	if err := l.apply(hostCfg); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if hostCfg.Memory != l.Memory || hostCfg.NanoCPUs != l.NanoCPUs || hostCfg.PidsLimit == nil || *hostCfg.PidsLimit != 100 {
+		t.Errorf("resources = %+v, want the limits", hostCfg.Resources)
+	}
+
+	hostCfg = &container.HostConfig{}
+	if err := (ResourceLimits{}).apply(hostCfg); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if hostCfg.Memory != 0 || hostCfg.NanoCPUs != 0 || hostCfg.PidsLimit != nil {
+		t.Errorf("resources = %+v, want no limits", hostCfg.Resources)
+	}
+
+	if err := (ResourceLimits{PidsLimit: -1}).apply(&container.HostConfig{}); !errors.Is(err, InvalidResourceLimitErr) {
+		t.Errorf("apply err = %v, want %v", err, InvalidResourceLimitErr)
+	}
+}
+
+func TestBuildCPUShares(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	tests := []struct {
+		name    string
+		shares  int64
+		wantErr bool
+	}{
+		{name: "daemon default", shares: 0},
+		{name: "weight", shares: 512},
+		{name: "negative", shares: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			_, err := newTestClient(api).build(context.Background(), fsContextName, fsys, BuildOptions{CPUShares: tt.shares}, io.Discard, nil)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidResourceLimitErr) || api.builds != 0 {
+					t.Errorf("err = %v after %d builds, want %v before building", err, api.builds, InvalidResourceLimitErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if got := api.lastBuild(t).Options.CPUShares; got != tt.shares {
+				t.Errorf("CPUShares = %d, want %d", got, tt.shares)
+			}
+		})
+	}
+}
+
+func TestParseCPUSet(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{s: "0", want: []int{0}},
+		{s: "0-3,5", want: []int{0, 1, 2, 3, 5}},
+		{s: "5, 1-2 ,2", want: []int{1, 2, 5}},
+		{s: "", wantErr: true},
+		{s: "a", wantErr: true},
+		{s: "3-1", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "0,", wantErr: true},
+		{s: "0-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseCPUSet(tt.s)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidResourceLimitErr) {
+					t.Errorf("err = %v, want %v", err, InvalidResourceLimitErr)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("ParseCPUSet = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// cpuAPI fakes a daemon host with ncpu CPUs
+type cpuAPI struct {
+	client.APIClient
+	ncpu int
+}
+
+func (f cpuAPI) Info(_ context.Context) (system.Info, error) {
+	return system.Info{NCPU: f.ncpu}, nil
+}
+
+func TestCheckCPUSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpus    string
+		ncpu    int
+		wantErr bool
+	}{
+		{name: "unset", cpus: "", ncpu: 2},
+		{name: "in range", cpus: "0-3", ncpu: 4},
+		{name: "unknown host CPUs", cpus: "8", ncpu: 0},
+		{name: "out of range", cpus: "0,4", ncpu: 4, wantErr: true},
+		{name: "invalid", cpus: "x", ncpu: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestClient(cpuAPI{ncpu: tt.ncpu}).checkCPUSet(context.Background(), ResourceLimits{CpusetCpus: tt.cpus})
+			if tt.wantErr && !errors.Is(err, InvalidResourceLimitErr) {
+				t.Errorf("err = %v, want %v", err, InvalidResourceLimitErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+		})
+	}
+}
+
+func TestResourceLimitsApplyCPUSet(t *testing.T) {
+	hostCfg := &container.HostConfig{}
+	l := ResourceLimits{CPUShares: 512, CpusetCpus: "0-1", CpusetMems: "0"}
+	if err := l.apply(hostCfg); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if hostCfg.CPUShares != 512 || hostCfg.CpusetCpus != "0-1" || hostCfg.CpusetMems != "0" {
+		t.Errorf("resources = %+v, want the cpu weight and pinning", hostCfg.Resources)
+	}
+	for _, l := range []ResourceLimits{{CPUShares: -1}, {CpusetCpus: "1-0"}, {CpusetMems: "x"}} {
+		if err := l.apply(&container.HostConfig{}); !errors.Is(err, InvalidResourceLimitErr) {
+			t.Errorf("apply(%+v) err = %v, want %v", l, err, InvalidResourceLimitErr)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+var (
+	ResultCacheErr = errors.New("failed to use build result cache")
+)
+
+// resultCacheFile is the build result cache file name
+const resultCacheFile = "build-results.json"
+
+// DefaultResultCacheDir returns the folder of the build result cache
+// in the user cache folder
+func DefaultResultCacheDir() (string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	return dir, nil
+}
+
+// userCacheDir returns the runner folder in the user cache folder
+func userCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "docker-runner"), nil
+}
+
+// cachedResult is an entry of the build result cache
+type cachedResult struct {
+	ImageID string    `json:"image_id"`
+	Created time.Time `json:"created"`
+}
+
+// resultCacheKeyOptions are the build settings the built image depends
+// on, besides the context content
+type resultCacheKeyOptions struct {
+	Tag       string            `json:"tag"`
+	Platform  string            `json:"platform"`
+	BuildKit  bool              `json:"buildkit"`
+	BuildArgs map[string]string `json:"build_args"`
+	Override  CommandOverride   `json:"override"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// resultCacheKey returns the cache key of a build of the context fsys:
+// its content digest (once the ignore rules are applied) and the hash
+// of the build settings
+func resultCacheKey(fsys fs.FS, opts BuildOptions, tag string, args map[string]string) (string, error) {
+	tarOpts := buildContextTarOptions(opts)
+	tarOpts.Deterministic = true
+	tarOpts.ExplainIgnore = false
+	content, err := tarFS(fsys, tarOpts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+	dgst, err := digestTar(content, "")
+	if err != nil {
+		return "", err
+	}
+	settings, err := json.Marshal(resultCacheKeyOptions{
+		Tag:       tag,
+		Platform:  opts.Platform,
+		BuildKit:  opts.BuildKit,
+		BuildArgs: args,
+		Override:  opts.Override,
+		Labels:    opts.Labels,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\n%s", dgst, settings)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resultCache maps the build cache keys to the built image IDs
+type resultCache struct {
+	path    string
+	Entries map[string]cachedResult `json:"entries"`
+}
+
+// openResultCache loads the build result cache of dir (empty if it
+// doesn't exist yet)
+func openResultCache(dir string) (*resultCache, error) {
+	c := &resultCache{path: filepath.Join(dir, resultCacheFile), Entries: map[string]cachedResult{}}
+	b, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ResultCacheErr, c.path, err)
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]cachedResult{}
+	}
+	return c, nil
+}
+
+// Put records the image built for the key and saves the cache,
+// replacing the file so a concurrent reader never sees it half written
+func (c *resultCache) Put(key, imageID string) error {
+	c.Entries[key] = cachedResult{ImageID: imageID, Created: time.Now()}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), resultCacheFile+".*")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	return nil
+}
+
+// cachedImage returns the image cached for the key if it still exists,
+// tagging it again if the tag was moved
+func (c Client) cachedImage(ctx context.Context, cache *resultCache, key, tag string) (string, bool, error) {
+	entry, ok := cache.Entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, entry.ImageID)
+	if client.IsErrNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %w", ResultCacheErr, err)
+	}
+	if !slices.Contains(inspect.RepoTags, tag) {
+		if err := c.d.ImageTag(ctx, entry.ImageID, tag); err != nil {
+			return "", false, fmt.Errorf("%w: %w", ResultCacheErr, err)
+		}
+	}
+	return entry.ImageID, true, nil
+}
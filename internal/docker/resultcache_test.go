@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestResultCacheKey(t *testing.T) {
+	fsys := func(main string, mod time.Time) fstest.MapFS {
+		return fstest.MapFS{
+			dockerfileName: {Data: []byte("FROM alpine:3.19\nCOPY . /app\n"), ModTime: mod},
+			"main.go":      {Data: []byte(main), ModTime: mod},
+			"debug.log":    {Data: []byte(mod.String()), ModTime: mod},
+		}
+	}
+	now := time.Now()
+	opts := BuildOptions{IgnorePatterns: []string{"*.log"}}
+	key := func(f fstest.MapFS, opts BuildOptions, tag string, args map[string]string) string {
+		t.Helper()
+		k, err := resultCacheKey(f, opts, tag, args)
+		if err != nil {
+			t.Fatalf("resultCacheKey: %v", err)
+		}
+		return k
+	}
+	base := key(fsys("package main\n", now), opts, "app:1", nil)
+
+	// the times and the ignored files don't change the key
+	if k := key(fsys("package main\n", now.Add(time.Hour)), opts, "app:1", nil); k != base {
+		t.Errorf("key changed with the modification times")
+	}
+	for name, k := range map[string]string{
+		"content":   key(fsys("package main // changed\n", now), opts, "app:1", nil),
+		"tag":       key(fsys("package main\n", now), opts, "app:2", nil),
+		"build arg": key(fsys("package main\n", now), opts, "app:1", map[string]string{"VERSION": "2"}),
+		"platform":  key(fsys("package main\n", now), BuildOptions{IgnorePatterns: opts.IgnorePatterns, Platform: "linux/arm64"}, "app:1", nil),
+	} {
+		if k == base {
+			t.Errorf("key unchanged with another %s", name)
+		}
+	}
+}
+
+func TestBuildResultCache(t *testing.T) {
+	dir := t.TempDir()
+	fsys := fstest.MapFS{
+		dockerfileName: {Data: []byte("FROM alpine:3.19\nCOPY . /app\n")},
+		"main.go":      {Data: []byte("package main\n")},
+	}
+	api := &buildAPI{image: &types.ImageInspect{RepoTags: []string{buildTag}}}
+	c := newTestClient(api)
+	opts := BuildOptions{ResultCacheDir: dir}
+	build := func(opts BuildOptions) BuildResult {
+		t.Helper()
+		var res BuildResult
+		var err error
+		captureStdout(t, func() {
+			res, err = c.BuildFS(context.Background(), fsys, opts)
+		})
+		if err != nil {
+			t.Fatalf("BuildFS: %v", err)
+		}
+		return res
+	}
+
+	build(opts)
+	res := build(opts)
+	if api.builds != 1 {
+		t.Fatalf("%d builds sent, want the second one served from the cache", api.builds)
+	}
+	if res.ImageID != testImageID {
+		t.Errorf("cached ImageID = %s, want %s", res.ImageID, testImageID)
+	}
+	if len(api.calls) != 0 {
+		t.Errorf("calls %q, want no tag as the image still has it", api.calls)
+	}
+
+	// the tag moved to another image
+	api.image = &types.ImageInspect{}
+	build(opts)
+	if want := []string{"tag " + testImageID + " " + buildTag}; api.builds != 1 || !equalStrings(api.calls, want) {
+		t.Errorf("%d builds, calls %q, want the cached image tagged again", api.builds, api.calls)
+	}
+
+	build(BuildOptions{ResultCacheDir: dir, BuildArgs: map[string]*string{"VERSION": strPtr("2")}})
+	if api.builds != 2 {
+		t.Errorf("%d builds sent, want one more for other build args", api.builds)
+	}
+
+	// the cached image was removed
+	rebuiltID := "sha256:" + strings.Repeat("1", 64)
+	api.gone = []string{testImageID}
+	api.stream = classicStream(rebuiltID, "FROM alpine:3.19", "COPY . /app")
+	if res := build(opts); api.builds != 3 || res.ImageID != rebuiltID {
+		t.Errorf("%d builds sent, ImageID = %s, want %s rebuilt for the removed image", api.builds, res.ImageID, rebuiltID)
+	}
+}
+
+func TestOpenResultCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := openResultCache(dir)
+	if err != nil || len(c.Entries) != 0 {
+		t.Fatalf("openResultCache = %+v, %v, want an empty cache", c, err)
+	}
+	if err := c.Put("key", testImageID); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	c, err = openResultCache(dir)
+	if err != nil || c.Entries["key"].ImageID != testImageID {
+		t.Fatalf("reopened cache = %+v, %v, want the entry", c, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, resultCacheFile+".*"))
+	if len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, resultCacheFile), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openResultCache(dir); !errors.Is(err, ResultCacheErr) {
+		t.Errorf("err = %v, want %v", err, ResultCacheErr)
+	}
+}
@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = time.Second
+)
+
+// retryPolicy is the number of attempts and the initial wait
+// between them, doubled on each retry
+type retryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	Attempts: defaultRetryAttempts,
+	Backoff:  defaultRetryBackoff,
+}
+
+// retryable tells if the error may go away on a retry, which isn't the
+// case of a missing image, denied credentials or an invalid reference
+func retryable(err error) bool {
+	switch {
+	case errdefs.IsNotFound(err), errdefs.IsUnauthorized(err), errdefs.IsInvalidParameter(err):
+		return false
+	case errors.Is(err, RegistryAuthErr):
+		return false
+	}
+	return true
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// retryable, the attempts are exhausted or ctx is done, returning the
+// last error
+func retry(ctx context.Context, p retryPolicy, fn func() error) error {
+	wait := p.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+		slog.With("attempt", attempt, "wait", wait.String(), "error", err.Error()).Debug("Retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
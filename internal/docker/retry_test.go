@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+var testRetryPolicy = retryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+func TestRetry(t *testing.T) {
+	transient := errors.New("connection reset by peer")
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}{
+		{name: "success", errs: nil, attempts: 1},
+		{name: "success after transient failures", errs: []error{transient, transient}, attempts: 3},
+		{name: "transient failures exhaust attempts", errs: []error{transient, transient, transient, transient}, attempts: 3, wantErr: true},
+		{name: "not found", errs: []error{errdefs.NotFound(errors.New("no such image"))}, attempts: 1, wantErr: true},
+		{name: "unauthorized", errs: []error{errdefs.Unauthorized(errors.New("denied"))}, attempts: 1, wantErr: true},
+		{name: "invalid parameter", errs: []error{errdefs.InvalidParameter(errors.New("invalid reference format"))}, attempts: 1, wantErr: true},
+		{name: "registry auth", errs: []error{fmt.Errorf("%w: %w", RegistryAuthErr, errors.New("no credentials"))}, attempts: 1, wantErr: true},
+		{name: "not found after transient failure", errs: []error{transient, errdefs.NotFound(errors.New("no such image"))}, attempts: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), testRetryPolicy, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts := 0
+	err := retry(ctx, retryPolicy{Attempts: 3, Backoff: time.Hour}, func() error {
+		attempts++
+		return errors.New("connection reset by peer")
+	})
+	if err == nil {
+		t.Fatal("err = nil, want the last attempt error")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
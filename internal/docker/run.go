@@ -0,0 +1,382 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RunOptions holds the settings of a container run
+type RunOptions struct {
+	// Name is the container name (generated by the daemon if empty)
+	Name string
+	// Cmd overrides the image command
+	Cmd []string
+	// Labels are set on the container (the docker-runner ones take
+	// precedence)
+	Labels map[string]string
+	// Pull is the pull policy (PullMissing if empty)
+	Pull PullPolicy
+	// Keep keeps the container after it exits (same as TeardownNever)
+	Keep bool
+	// Teardown tells when the container and its volumes are removed
+	// (TeardownAlways if empty)
+	Teardown TeardownPolicy
+	// Platform is the os/arch[/variant] of the image to run
+	Platform string
+	// Emulation defines how cross-platform support is checked
+	Emulation EmulationOptions
+	// RequireNative fails the run if the image platform differs from
+	// the daemon one, instead of warning about the emulation
+	RequireNative bool
+	// Capture saves the container stdout and stderr to files
+	Capture CaptureOptions
+	// OnEvent is called when the container starts, is ready (the Wait
+	// probe passed) and exits
+	OnEvent func(RunEvent)
+	// Overlays are host folders the container gets a writable copy of,
+	// discarded on teardown
+	Overlays []Overlay
+	// OverlayExport is the folder the files changed in the overlays are
+	// written to on teardown (none if empty)
+	OverlayExport string
+	// Init are one-shot containers run in order, each having to exit
+	// with 0, before the main container starts
+	Init []InitContainer
+	// Shared are the container paths of anonymous volumes mounted in
+	// the init containers and the main one
+	Shared []string
+	// Network is an existing network the container joins (see
+	// ParseExternalNetwork), left untouched on teardown
+	Network string
+	// NetworkAlias is the container alias in Network (its name if
+	// empty)
+	NetworkAlias string
+	// NetNS is a running container whose network namespace is shared
+	// (see ParseNetNS)
+	NetNS string
+	// Resources are the memory, CPU and processes limits
+	Resources ResourceLimits
+	// Wait is run until the container is ready (none if its Cmd is
+	// empty)
+	Wait Probe
+	// Assert is run once the container is ready, then the container is
+	// stopped: the run fails if it fails, else exits with 0 (none if its
+	// Cmd is empty)
+	Assert Probe
+	// VersionProbes are command lines exec'ed in the container once it
+	// is ready (after Wait), whose first output line is printed as the
+	// runtime version, e.g. "node --version"
+	VersionProbes []string
+	// TLS is the throwaway CA and certificate copied to the container
+	// before it starts (none if it has no names)
+	TLS TLSSpec
+	// TLSCAFile is the host file the generated CA certificate is written
+	// to, for the test clients (a temporary file removed on exit if
+	// empty, only seen by the probes)
+	TLSCAFile string
+}
+
+// RunEventType is the kind of a container lifecycle event
+type RunEventType string
+
+const (
+	RunEventStart RunEventType = "start"
+	RunEventReady RunEventType = "ready"
+	RunEventExit  RunEventType = "exit"
+)
+
+// RunEvent is a container lifecycle event of a run
+type RunEvent struct {
+	Type          RunEventType
+	ContainerID   string
+	ContainerName string
+	Image         string
+	Time          time.Time
+	// ExitCode is set for exit events without Err
+	ExitCode int
+	// Err is set for exit events when waiting for the container failed,
+	// its exit code being unknown
+	Err error
+	// Assertion is the Assert probe outcome, for the exit events of the
+	// runs whose assertion ran
+	Assertion *AssertionResult
+}
+
+// AssertionResult is the outcome of the Assert probe
+type AssertionResult struct {
+	Passed bool
+	// Message is the failure reason
+	Message string
+}
+
+// Run runs a container from image, printing its output, and returns
+// the container exit code
+func (c Client) Run(ctx context.Context, image string, opts RunOptions) (code int, err error) {
+	policy, err := ParsePullPolicy(string(opts.Pull))
+	if err != nil {
+		return 0, err
+	}
+	teardown, err := ParseTeardownPolicy(string(opts.Teardown))
+	if err != nil {
+		return 0, err
+	}
+	if opts.Keep {
+		teardown = TeardownNever
+	}
+	// decided once the run ended, by the deferred removals
+	keep := func() bool {
+		return teardown.keep(code, err)
+	}
+	var platform *ocispec.Platform
+	if opts.Platform != "" {
+		platform, err = parsePlatform(opts.Platform)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.Require(ctx, FeaturePlatformOnCreate); err != nil {
+			return 0, err
+		}
+		if err := c.ensureEmulation(ctx, opts.Platform, opts.Emulation); err != nil {
+			return 0, err
+		}
+	}
+	original := image
+	image = c.mirrorImage(image)
+	if err := c.ensureImage(ctx, image, opts.Platform, policy); err != nil {
+		return 0, err
+	}
+	if err := c.checkNative(ctx, image, opts.RequireNative); err != nil {
+		return 0, err
+	}
+	c.logEffectiveCommand(ctx, image, opts.Cmd)
+
+	cfg, hostCfg := runContainerConfig(image, opts)
+	labelOriginalImage(cfg.Labels, original, image)
+	netCfg, err := c.applyRunNetwork(ctx, opts, hostCfg)
+	if err != nil {
+		return 0, err
+	}
+	if err := opts.Resources.apply(hostCfg); err != nil {
+		return 0, err
+	}
+	if err := c.checkCPUSet(ctx, opts.Resources); err != nil {
+		return 0, err
+	}
+	var overlays []overlayVolume
+	var shared []mount.Mount
+	// registered before the container removal so it runs after it, as
+	// the volumes can't be removed while in use
+	defer func() {
+		if !keep() {
+			c.removeVolumes(shared)
+		}
+		for _, o := range overlays {
+			if opts.OverlayExport != "" {
+				if err := c.exportOverlay(context.Background(), o, opts.OverlayExport); err != nil {
+					slog.With("overlay", o.Target, "error", err.Error()).Warn("OverlayExportFailed")
+				}
+			}
+			if !keep() {
+				c.removeOverlay(o)
+			}
+		}
+	}()
+	for _, o := range opts.Overlays {
+		ov, err := c.prepareOverlay(ctx, o)
+		if err != nil {
+			return 0, err
+		}
+		overlays = append(overlays, ov)
+		hostCfg.Mounts = append(hostCfg.Mounts, ov.mount())
+	}
+	shared, err = c.createSharedVolumes(ctx, opts.Shared)
+	if err != nil {
+		return 0, err
+	}
+	hostCfg.Mounts = append(hostCfg.Mounts, shared...)
+	if err := c.runInitContainers(ctx, opts.Init, hostCfg.Mounts, platform); err != nil {
+		return 0, err
+	}
+
+	created, err := c.d.ContainerCreate(ctx, cfg, hostCfg, netCfg, platform, opts.Name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer func() {
+		if !keep() {
+			_ = c.d.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+			return
+		}
+		var volumes []string
+		for _, m := range shared {
+			volumes = append(volumes, m.Source)
+		}
+		for _, o := range overlays {
+			volumes = append(volumes, o.Volume)
+		}
+		name := opts.Name
+		if name == "" {
+			name = created.ID[:12]
+		}
+		printKept(os.Stdout, name, volumes)
+	}()
+
+	var extraProbeEnv []string
+	if opts.TLS.enabled() {
+		m, err := generateTLS(opts.TLS)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.copyTLS(ctx, created.ID, opts.TLS, m); err != nil {
+			return 0, err
+		}
+		ca, cleanup, err := writeTLSCA(opts.TLSCAFile, m.CA)
+		if err != nil {
+			return 0, err
+		}
+		defer cleanup()
+		extraProbeEnv = append(extraProbeEnv, ProbeEnvTLSCA+"="+ca)
+	}
+
+	attach, err := c.d.ContainerAttach(ctx, created.ID, container.AttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer attach.Close()
+
+	name := opts.Name
+	if name == "" {
+		name = created.ID[:12]
+	}
+	notify := func(e RunEvent) {
+		if opts.OnEvent != nil {
+			e.ContainerID, e.ContainerName, e.Image, e.Time = created.ID, name, original, time.Now()
+			opts.OnEvent(e)
+		}
+	}
+
+	waitC, waitErrC := c.d.ContainerWait(ctx, created.ID, container.WaitConditionNextExit)
+	if err := c.d.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	notify(RunEvent{Type: RunEventStart})
+
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if opts.Capture.Dir != "" {
+		capture, err := newOutputCapture(name, opts.Capture)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = capture.Close()
+		}()
+		// the streams are demuxed before reaching the terminal, so the
+		// files get the raw container output
+		stdout = io.MultiWriter(capture.Stdout, os.Stdout)
+		stderr = io.MultiWriter(capture.Stderr, os.Stderr)
+	}
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, attach.Reader)
+		outputDone <- err
+	}()
+
+	// probesDone stays nil, never ready, without probes. The ready
+	// event is handed over through readyC, so OnEvent is only called
+	// from this goroutine.
+	var probesDone chan error
+	var assertion *AssertionResult
+	readyC := make(chan struct{}, 1)
+	if opts.Wait.Cmd != "" || opts.Assert.Cmd != "" || len(opts.VersionProbes) > 0 {
+		probesDone = make(chan error, 1)
+		probeCtx, cancelProbes := context.WithCancel(ctx)
+		defer cancelProbes()
+		go func() {
+			var err error
+			// only read once probesDone is received
+			assertion, err = c.runProbes(probeCtx, created.ID, opts.Wait, opts.Assert, extraProbeEnv, opts.VersionProbes, func() {
+				readyC <- struct{}{}
+			})
+			probesDone <- err
+		}()
+	}
+	notifyReady := func() {
+		select {
+		case <-readyC:
+			notify(RunEvent{Type: RunEventReady})
+		default:
+		}
+	}
+
+	for {
+		select {
+		case res := <-waitC:
+			<-outputDone
+			notifyReady()
+			notify(RunEvent{Type: RunEventExit, ExitCode: int(res.StatusCode)})
+			if res.Error != nil {
+				return int(res.StatusCode), fmt.Errorf("%w: %s", ContainerRunErr, res.Error.Message)
+			}
+			return int(res.StatusCode), nil
+		case err := <-waitErrC:
+			notifyReady()
+			notify(RunEvent{Type: RunEventExit, Err: err})
+			return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+		case <-readyC:
+			notify(RunEvent{Type: RunEventReady})
+		case err := <-probesDone:
+			notifyReady()
+			if err == nil && opts.Assert.Cmd == "" {
+				probesDone = nil
+				continue
+			}
+			// the container is stopped once asserted, or a probe failed
+			if err := c.d.ContainerStop(ctx, created.ID, container.StopOptions{}); err != nil {
+				notify(RunEvent{Type: RunEventExit, Err: err, Assertion: assertion})
+				return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+			}
+			select {
+			case res := <-waitC:
+				<-outputDone
+				notify(RunEvent{Type: RunEventExit, ExitCode: int(res.StatusCode), Assertion: assertion})
+			case waitErr := <-waitErrC:
+				notify(RunEvent{Type: RunEventExit, Err: waitErr, Assertion: assertion})
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+			}
+			return 0, nil
+		}
+	}
+}
+
+func runContainerConfig(image string, opts RunOptions) (*container.Config, *container.HostConfig) {
+	cfg := &container.Config{
+		Image:        image,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels:       map[string]string{},
+	}
+	for k, v := range opts.Labels {
+		cfg.Labels[k] = v
+	}
+	cfg.Labels[ManagedLabel] = "true"
+	if len(opts.Cmd) > 0 {
+		cfg.Cmd = opts.Cmd
+	}
+	return cfg, &container.HostConfig{}
+}
@@ -0,0 +1,231 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// runAPI fakes a daemon running a container without output, exiting
+// with exitCode once started (or once stopped if runUntilStop), or
+// failing the wait with waitErr
+type runAPI struct {
+	client.APIClient
+	exitCode     int64
+	runUntilStop bool
+	waitErr      error
+
+	mu      sync.Mutex
+	waitC   chan container.WaitResponse
+	errC    chan error
+	removed bool
+	stopped bool
+}
+
+func (f *runAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, nil
+}
+
+func (f *runAPI) ContainerCreate(_ context.Context, _ *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	return container.CreateResponse{ID: testContainerID}, nil
+}
+
+func (f *runAPI) ContainerAttach(_ context.Context, _ string, _ container.AttachOptions) (types.HijackedResponse, error) {
+	conn, daemon := net.Pipe()
+	_ = daemon.Close()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+func (f *runAPI) ContainerWait(_ context.Context, _ string, _ container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waitC, f.errC = make(chan container.WaitResponse, 1), make(chan error, 1)
+	return f.waitC, f.errC
+}
+
+func (f *runAPI) ContainerStart(_ context.Context, _ string, _ container.StartOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.waitErr != nil:
+		f.errC <- f.waitErr
+	case !f.runUntilStop:
+		f.waitC <- container.WaitResponse{StatusCode: f.exitCode}
+	}
+	return nil
+}
+
+func (f *runAPI) ContainerStop(_ context.Context, _ string, _ container.StopOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	f.waitC <- container.WaitResponse{StatusCode: f.exitCode}
+	return nil
+}
+
+func (f *runAPI) ContainerRemove(_ context.Context, _ string, _ container.RemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = true
+	return nil
+}
+
+func (f *runAPI) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id, Name: "/test"}}, nil
+}
+
+// recordEvents returns an OnEvent recording the events in events
+func recordEvents(events *[]RunEvent) func(RunEvent) {
+	return func(e RunEvent) {
+		*events = append(*events, e)
+	}
+}
+
+func eventTypes(events []RunEvent) []RunEventType {
+	res := make([]RunEventType, 0, len(events))
+	for _, e := range events {
+		res = append(res, e.Type)
+	}
+	return res
+}
+
+func equalEventTypes(a, b []RunEventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunEvents(t *testing.T) {
+	waitErr := errors.New("daemon connection lost")
+	tests := []struct {
+		name      string
+		api       *runAPI
+		wait      string
+		assert    string
+		wantCode  int
+		wantErr   bool
+		want      []RunEventType
+		exitErr   bool
+		assertion *AssertionResult
+	}{
+		{
+			name:     "exit code",
+			api:      &runAPI{exitCode: 3},
+			wantCode: 3,
+			want:     []RunEventType{RunEventStart, RunEventExit},
+		},
+		{
+			name:    "wait failure",
+			api:     &runAPI{waitErr: waitErr},
+			wantErr: true,
+			want:    []RunEventType{RunEventStart, RunEventExit},
+			exitErr: true,
+		},
+		{
+			name: "ready",
+			api:  &runAPI{runUntilStop: true},
+			wait: "true",
+			// the assertion stops the container
+			assert:    "true",
+			want:      []RunEventType{RunEventStart, RunEventReady, RunEventExit},
+			assertion: &AssertionResult{Passed: true},
+		},
+		{
+			name:      "assertion failure",
+			api:       &runAPI{runUntilStop: true},
+			wait:      "true",
+			assert:    "echo boom >&2; exit 1",
+			wantErr:   true,
+			want:      []RunEventType{RunEventStart, RunEventReady, RunEventExit},
+			assertion: &AssertionResult{Passed: false},
+		},
+		{
+			name:    "wait probe failure",
+			api:     &runAPI{runUntilStop: true},
+			wait:    "exit 2",
+			wantErr: true,
+			want:    []RunEventType{RunEventStart, RunEventExit},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []RunEvent
+			c := newTestClient(tt.api)
+			code, err := c.Run(context.Background(), "alpine:3.19", RunOptions{
+				OnEvent: recordEvents(&events),
+				Wait:    Probe{Cmd: tt.wait},
+				Assert:  Probe{Cmd: tt.assert},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run err = %v, want error %v", err, tt.wantErr)
+			}
+			if code != tt.wantCode {
+				t.Errorf("Run code = %d, want %d", code, tt.wantCode)
+			}
+			if got := eventTypes(events); !equalEventTypes(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			exit := events[len(events)-1]
+			if (exit.Err != nil) != tt.exitErr {
+				t.Errorf("exit event Err = %v, want error %v", exit.Err, tt.exitErr)
+			}
+			if tt.exitErr && !errors.Is(exit.Err, waitErr) {
+				t.Errorf("exit event Err = %v, want %v", exit.Err, waitErr)
+			}
+			if !tt.exitErr && exit.ExitCode != tt.wantCode {
+				t.Errorf("exit event ExitCode = %d, want %d", exit.ExitCode, tt.wantCode)
+			}
+			switch {
+			case tt.assertion == nil && exit.Assertion != nil:
+				t.Errorf("exit event Assertion = %+v, want none", exit.Assertion)
+			case tt.assertion != nil && exit.Assertion == nil:
+				t.Errorf("exit event Assertion = nil, want %+v", tt.assertion)
+			case tt.assertion != nil && exit.Assertion.Passed != tt.assertion.Passed:
+				t.Errorf("exit event Assertion = %+v, want passed %v", exit.Assertion, tt.assertion.Passed)
+			case tt.assertion != nil && !tt.assertion.Passed && exit.Assertion.Message == "":
+				t.Error("failed assertion without message")
+			}
+			for _, e := range events {
+				if e.ContainerID != testContainerID || e.Image != "alpine:3.19" {
+					t.Errorf("event %s = %+v, want the container and image set", e.Type, e)
+				}
+			}
+			if !tt.api.removed {
+				t.Error("container not removed")
+			}
+		})
+	}
+}
+
+func TestRunContainerConfigLabels(t *testing.T) {
+	cfg, _ := runContainerConfig("app:1", RunOptions{Labels: map[string]string{
+		"team":       "platform",
+		ManagedLabel: "false",
+	}})
+	want := map[string]string{"team": "platform", ManagedLabel: "true"}
+	for k, v := range want {
+		if cfg.Labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, cfg.Labels[k], v)
+		}
+	}
+
+	cfg, _ = runContainerConfig("app:1", RunOptions{})
+	if cfg.Labels[ManagedLabel] != "true" {
+		t.Errorf("labels = %v, want the managed label", cfg.Labels)
+	}
+}
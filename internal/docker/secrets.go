@@ -0,0 +1,298 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/eldius/docker-runner/internal/sarif"
+)
+
+var (
+	SecretLeakErr = errors.New("image embeds secrets")
+	SecretScanErr = errors.New("failed to scan image for secrets")
+)
+
+// DefaultSecretMaxFileSize is the size of the biggest layer file
+// scanned by default
+const DefaultSecretMaxFileSize = 1 << 20
+
+// secretRules are the token formats looked for, by name
+var secretRules = map[string]*regexp.Regexp{
+	"aws-access-key": regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	"github-token":   regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	"private-key":    regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+}
+
+// secretRuleDescriptions describe the secret rules in SARIF reports,
+// build-arg standing for the sensitive build arg values
+var secretRuleDescriptions = map[string]string{
+	"aws-access-key": "AWS access key ID",
+	"github-token":   "GitHub token",
+	"private-key":    "PEM private key",
+	"build-arg":      "Sensitive build arg value",
+}
+
+// sensitiveArgRe matches the names of the build args holding secrets
+var sensitiveArgRe = regexp.MustCompile(`(?i)token|secret|passw(or)?d|api_?key|private_?key|credential|auth`)
+
+// minSecretValueLen is the length under which sensitive build arg
+// values aren't looked for, as they'd match everywhere
+const minSecretValueLen = 6
+
+// SecretFinding is a secret found in an image
+type SecretFinding struct {
+	// Source is where it was found: history, env or file
+	Source string
+	// Layer is the layer ID (history) or layer archive (file)
+	Layer string
+	// Location is the instruction (history), variable (env) or file
+	// path (file) embedding it
+	Location string
+	// Rule is the token format or build arg that matched
+	Rule string
+	// Match is the redacted matched value
+	Match string
+}
+
+func (f SecretFinding) String() string {
+	s := fmt.Sprintf("%s %s: %s", f.Source, f.Rule, f.Match)
+	if f.Layer != "" {
+		s += " in layer " + f.Layer
+	}
+	return s + ": " + f.Location
+}
+
+// SecretScanOptions holds what an image secret scan looks for
+type SecretScanOptions struct {
+	// Values are the secret values looked for literally (e.g. the
+	// sensitive build args), by name
+	Values map[string]string
+	// Files scans the text files of the image layers too
+	Files bool
+	// MaxFileSize is the size of the biggest layer file scanned
+	// (1MiB if zero)
+	MaxFileSize int64
+}
+
+// SensitiveBuildArgs returns the build args whose name looks like a
+// secret, long enough to be looked for
+func SensitiveBuildArgs(args map[string]string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range args {
+		if sensitiveArgRe.MatchString(k) && len(v) >= minSecretValueLen {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// ScanImageSecrets looks for the secret values and the known token
+// formats in the history and config env of the local image, and in its
+// layer files if asked for
+func (c Client) ScanImageSecrets(ctx context.Context, image string, opts SecretScanOptions) ([]SecretFinding, error) {
+	s := secretScanner{values: opts.Values}
+	history, err := c.d.ImageHistory(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", SecretScanErr, err)
+	}
+	for _, h := range history {
+		s.scan("history", h.ID, h.CreatedBy, h.CreatedBy)
+	}
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", SecretScanErr, err)
+	}
+	if inspect.Config != nil {
+		for _, e := range inspect.Config.Env {
+			name, _, _ := strings.Cut(e, "=")
+			s.scan("env", "", name, e)
+		}
+	}
+	if opts.Files {
+		max := opts.MaxFileSize
+		if max <= 0 {
+			max = DefaultSecretMaxFileSize
+		}
+		rc, err := c.d.ImageSave(ctx, []string{image})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", SecretScanErr, err)
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		if err := s.scanImageArchive(rc, max); err != nil {
+			return nil, fmt.Errorf("%w: %w", SecretScanErr, err)
+		}
+	}
+	return s.findings, nil
+}
+
+// secretScanner collects the secrets found in texts
+type secretScanner struct {
+	values   map[string]string
+	findings []SecretFinding
+}
+
+// scan records the secrets of text found at location
+func (s *secretScanner) scan(source, layer, location, text string) {
+	names := make([]string, 0, len(s.values))
+	for k := range s.values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if strings.Contains(text, s.values[k]) {
+			s.add(source, layer, location, "build-arg "+k, s.values[k])
+		}
+	}
+	rules := make([]string, 0, len(secretRules))
+	for k := range secretRules {
+		rules = append(rules, k)
+	}
+	sort.Strings(rules)
+	for _, r := range rules {
+		if m := secretRules[r].FindString(text); m != "" {
+			s.add(source, layer, location, r, m)
+		}
+	}
+}
+
+// add records a finding, redacting the secret from the location too
+// (instructions embed it)
+func (s *secretScanner) add(source, layer, location, rule, match string) {
+	s.findings = append(s.findings, SecretFinding{
+		Source:   source,
+		Layer:    layer,
+		Location: strings.ReplaceAll(location, match, redactSecret(match)),
+		Rule:     rule,
+		Match:    redactSecret(match),
+	})
+}
+
+// scanImageArchive scans the layers of a docker save archive: each
+// entry that is itself a tar is taken for a layer
+func (s *secretScanner) scanImageArchive(r io.Reader, max int64) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg {
+			s.scanLayer(h.Name, tar.NewReader(tr), max)
+		}
+	}
+}
+
+// scanLayer scans the regular text files of the layer up to max
+// bytes. Entries that aren't a layer stop at the first header.
+func (s *secretScanner) scanLayer(layer string, tr *tar.Reader, max int64) {
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return
+		}
+		if h.Typeflag != tar.TypeReg || h.Size == 0 || h.Size > max {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return
+		}
+		if !isText(b) {
+			continue
+		}
+		s.scan("file", layer, "/"+strings.TrimPrefix(h.Name, "./"), string(b))
+	}
+}
+
+// isText tells if the content looks like text (no NUL byte in its
+// beginning)
+func isText(b []byte) bool {
+	head := b
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return bytes.IndexByte(head, 0) < 0
+}
+
+// redactSecret keeps the first characters of a secret only
+func redactSecret(s string) string {
+	if len(s) <= 4 {
+		return "****"
+	}
+	return s[:4] + "****"
+}
+
+// printSecretFindings prints the findings as a warning block
+func printSecretFindings(out io.Writer, image string, findings []SecretFinding) {
+	w := bufio.NewWriter(out)
+	_, _ = fmt.Fprintf(w, "WARNING: %s embeds %d secret(s), visible with docker history/inspect:\n", image, len(findings))
+	for _, f := range findings {
+		_, _ = fmt.Fprintln(w, "  "+f.String())
+	}
+	_, _ = fmt.Fprintln(w, "Use BuildKit secrets (RUN --mount=type=secret) instead of build args.")
+	_ = w.Flush()
+}
+
+// checkSecrets scans the built image for the sensitive build args and
+// known token formats, warning about them (failing if strict)
+func (c Client) checkSecrets(ctx context.Context, out io.Writer, image string, args map[string]string, strict bool) error {
+	findings, err := c.ScanImageSecrets(ctx, image, SecretScanOptions{Values: SensitiveBuildArgs(args)})
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	printSecretFindings(out, image, findings)
+	if strict {
+		return fmt.Errorf("%w: %d found in %s", SecretLeakErr, len(findings), image)
+	}
+	return nil
+}
+
+// SecretsSARIF returns the findings of the image scan as a SARIF run,
+// located by image ref and layer
+func SecretsSARIF(image string, findings []SecretFinding) sarif.Run {
+	ids := make([]string, 0, len(secretRuleDescriptions))
+	for k := range secretRuleDescriptions {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+	rules := make([]sarif.Rule, 0, len(ids))
+	for _, id := range ids {
+		rules = append(rules, sarif.Rule{
+			ID:                   id,
+			ShortDescription:     sarif.Message{Text: secretRuleDescriptions[id]},
+			DefaultConfiguration: sarif.Configuration{Level: sarif.LevelError},
+		})
+	}
+	run := sarif.NewRun("docker-runner check-secrets", rules)
+	for _, f := range findings {
+		rule, _, _ := strings.Cut(f.Rule, " ")
+		locations := []sarif.LogicalLocation{{Name: image, Kind: "image"}}
+		if f.Layer != "" {
+			locations = append(locations, sarif.LogicalLocation{Name: f.Layer, FullyQualifiedName: image + "/" + f.Layer, Kind: "layer"})
+		}
+		locations = append(locations, sarif.LogicalLocation{Name: f.Location, Kind: f.Source})
+		run.Results = append(run.Results, sarif.Result{
+			RuleID:    rule,
+			Level:     sarif.LevelError,
+			Message:   sarif.Message{Text: f.String()},
+			Locations: []sarif.Location{{LogicalLocations: locations}},
+		})
+	}
+	return run
+}
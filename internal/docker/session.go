@@ -0,0 +1,243 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+var (
+	InvalidSessionErr = errors.New("invalid session name")
+	SessionErr        = errors.New("failed to manage session")
+)
+
+const (
+	// SessionLabel holds the session name of a session container
+	SessionLabel = "docker-runner.session"
+	// sessionIdleTimeoutLabel holds the idle time after which session
+	// rm --idle removes the session
+	sessionIdleTimeoutLabel = "docker-runner.session.idle-timeout"
+	// sessionContainerPrefix prefixes the session container names
+	sessionContainerPrefix = "docker-runner-session-"
+	// sessionKeepAlive keeps the session container running between
+	// shells, exiting on docker stop
+	sessionKeepAlive = "trap 'exit 0' TERM; while :; do sleep 3600 & wait; done"
+)
+
+// Session is a persistent shell container
+type Session struct {
+	Name        string
+	ContainerID string
+	Image       string
+	State       string
+	// IdleTimeout is the idle time after which RemoveIdleSessions
+	// removes it (never if 0)
+	IdleTimeout time.Duration
+	// LastUsed is when a shell last attached or detached (zero if
+	// unknown, e.g. used from another host)
+	LastUsed time.Time
+}
+
+// Idle tells if the session wasn't used for longer than its timeout
+func (s Session) Idle(now time.Time) bool {
+	return s.IdleTimeout > 0 && !s.LastUsed.IsZero() && now.Sub(s.LastUsed) > s.IdleTimeout
+}
+
+// ValidateSessionName fails for names unusable in a container name
+func ValidateSessionName(name string) error {
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-") != "" {
+		return fmt.Errorf("%w: %q (letters, digits, '_', '.' and '-' only)", InvalidSessionErr, name)
+	}
+	return nil
+}
+
+// sessionShell runs the shell as an exec in the session container,
+// creating it if needed, so the container and its filesystem outlive
+// the shell (and a dropped connection)
+func (c Client) sessionShell(ctx context.Context, image, shell string, opts ShellOptions) (int, error) {
+	if err := ValidateSessionName(opts.Session); err != nil {
+		return 0, err
+	}
+	id, err := c.ensureSession(ctx, image, shell, opts)
+	if err != nil {
+		return 0, err
+	}
+	touchSession(opts.Session)
+	defer touchSession(opts.Session)
+
+	code, err := c.execShell(ctx, id, types.ExecConfig{
+		User:       shellUser(opts),
+		WorkingDir: shellSourceDir,
+		Cmd:        append([]string{shell}, opts.Args...),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", SessionErr, err)
+	}
+	return code, nil
+}
+
+// ensureSession returns the running session container, creating or
+// restarting it if needed
+func (c Client) ensureSession(ctx context.Context, image, shell string, opts ShellOptions) (string, error) {
+	name := sessionContainerPrefix + opts.Session
+	inspect, err := c.d.ContainerInspect(ctx, name)
+	switch {
+	case client.IsErrNotFound(err):
+		cfg, hostCfg := shellContainerConfig(image, shell, false, opts)
+		cfg.Entrypoint = []string{shell, "-c", sessionKeepAlive}
+		cfg.Cmd = nil
+		cfg.OpenStdin, cfg.StdinOnce, cfg.AttachStdin = false, false, false
+		cfg.AttachStdout, cfg.AttachStderr = false, false
+		cfg.Labels[SessionLabel] = opts.Session
+		if opts.IdleTimeout > 0 {
+			cfg.Labels[sessionIdleTimeoutLabel] = opts.IdleTimeout.String()
+		}
+		hostCfg.AutoRemove = false
+		created, err := c.d.ContainerCreate(ctx, cfg, hostCfg, nil, nil, name)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", SessionErr, err)
+		}
+		if err := c.d.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+			return "", fmt.Errorf("%w: %w", SessionErr, err)
+		}
+		fmt.Printf("Session %s started (%s)\n", opts.Session, created.ID[:12])
+		return created.ID, nil
+	case err != nil:
+		return "", fmt.Errorf("%w: %w", SessionErr, err)
+	}
+	if inspect.Config.Labels[SessionLabel] != opts.Session {
+		return "", fmt.Errorf("%w: container %s isn't a session", SessionErr, name)
+	}
+	if !inspect.State.Running {
+		if err := c.d.ContainerStart(ctx, inspect.ID, container.StartOptions{}); err != nil {
+			return "", fmt.Errorf("%w: %w", SessionErr, err)
+		}
+	}
+	fmt.Printf("Session %s reattached (%s)\n", opts.Session, inspect.ID[:12])
+	return inspect.ID, nil
+}
+
+// Sessions lists the session containers, sorted by name
+func (c Client) Sessions(ctx context.Context) ([]Session, error) {
+	list, err := c.d.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", SessionLabel)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", SessionErr, err)
+	}
+	res := make([]Session, 0, len(list))
+	for _, ctr := range list {
+		s := Session{
+			Name:        ctr.Labels[SessionLabel],
+			ContainerID: ctr.ID,
+			Image:       ctr.Image,
+			State:       ctr.State,
+			LastUsed:    sessionLastUsed(ctr.Labels[SessionLabel]),
+		}
+		if v, ok := ctr.Labels[sessionIdleTimeoutLabel]; ok {
+			if d, err := time.ParseDuration(v); err == nil {
+				s.IdleTimeout = d
+			}
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// RemoveSession removes the session container and its bookkeeping
+func (c Client) RemoveSession(ctx context.Context, name string) error {
+	if err := ValidateSessionName(name); err != nil {
+		return err
+	}
+	inspect, err := c.d.ContainerInspect(ctx, sessionContainerPrefix+name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", SessionErr, err)
+	}
+	if inspect.Config.Labels[SessionLabel] != name {
+		return fmt.Errorf("%w: container %s isn't a session", SessionErr, inspect.Name)
+	}
+	if err := c.d.ContainerRemove(ctx, inspect.ID, container.RemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("%w: %w", SessionErr, err)
+	}
+	if path, err := sessionFile(name); err == nil {
+		_ = os.Remove(path)
+	}
+	return nil
+}
+
+// RemoveIdleSessions removes the sessions idle for longer than their
+// timeout, returning their names
+func (c Client) RemoveIdleSessions(ctx context.Context) ([]string, error) {
+	sessions, err := c.Sessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	now := time.Now()
+	for _, s := range sessions {
+		if !s.Idle(now) {
+			continue
+		}
+		if err := c.RemoveSession(ctx, s.Name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, s.Name)
+	}
+	return removed, nil
+}
+
+// sessionFile is the file whose modification time records the last use
+// of the session, in the user cache folder
+func sessionFile(name string) (string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", name), nil
+}
+
+// touchSession records the session was just used. Failures are logged.
+func touchSession(name string) {
+	path, err := sessionFile(name)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(path, nil, 0o644)
+	}
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(path, now, now)
+	}
+	if err != nil {
+		slog.With("session", name, "error", err.Error()).Warn("SessionTouchFailed")
+	}
+}
+
+// sessionLastUsed returns the last use of the session (zero if unknown)
+func sessionLastUsed(name string) time.Time {
+	path, err := sessionFile(name)
+	if err != nil {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
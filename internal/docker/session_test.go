@@ -0,0 +1,278 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// sessionAPI fakes the session containers, by name
+type sessionAPI struct {
+	client.APIClient
+	containers map[string]types.ContainerJSON
+
+	created []*container.Config
+	hosts   []*container.HostConfig
+	started []string
+	removed []string
+}
+
+func (f *sessionAPI) ContainerInspect(_ context.Context, name string) (types.ContainerJSON, error) {
+	ctr, ok := f.containers[name]
+	if !ok {
+		return types.ContainerJSON{}, errdefs.NotFound(errors.New("no such container: " + name))
+	}
+	return ctr, nil
+}
+
+func (f *sessionAPI) ContainerCreate(_ context.Context, cfg *container.Config, hostCfg *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	f.created = append(f.created, cfg)
+	f.hosts = append(f.hosts, hostCfg)
+	return container.CreateResponse{ID: testContainerID}, nil
+}
+
+func (f *sessionAPI) ContainerStart(_ context.Context, id string, _ container.StartOptions) error {
+	f.started = append(f.started, id)
+	return nil
+}
+
+func (f *sessionAPI) ContainerList(_ context.Context, _ container.ListOptions) ([]types.Container, error) {
+	var res []types.Container
+	for _, ctr := range f.containers {
+		res = append(res, types.Container{
+			ID:     ctr.ID,
+			Image:  ctr.Config.Image,
+			State:  ctr.State.Status,
+			Labels: ctr.Config.Labels,
+		})
+	}
+	return res, nil
+}
+
+func (f *sessionAPI) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+// sessionContainer returns the container of the named session
+func sessionContainer(id, name string, running bool, labels map[string]string) types.ContainerJSON {
+	all := map[string]string{SessionLabel: name}
+	for k, v := range labels {
+		all[k] = v
+	}
+	status := "exited"
+	if running {
+		status = "running"
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    id,
+			Name:  "/" + sessionContainerPrefix + name,
+			State: &types.ContainerState{Running: running, Status: status},
+		},
+		Config: &container.Config{Image: "alpine:3.19", Labels: all},
+	}
+}
+
+// useTestCacheDir points the user cache folder to a temporary one
+func useTestCacheDir(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+}
+
+func TestValidateSessionName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "debug"},
+		{name: "my-session_1.0"},
+		{name: "", wantErr: true},
+		{name: "with space", wantErr: true},
+		{name: "../escape", wantErr: true},
+		{name: "a:b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSessionName(tt.name)
+			if tt.wantErr && !errors.Is(err, InvalidSessionErr) {
+				t.Errorf("err = %v, want %v", err, InvalidSessionErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+		})
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		session Session
+		want    bool
+	}{
+		{name: "no timeout", session: Session{LastUsed: now.Add(-24 * time.Hour)}},
+		{name: "never used", session: Session{IdleTimeout: time.Hour}},
+		{name: "recent", session: Session{IdleTimeout: time.Hour, LastUsed: now.Add(-time.Minute)}},
+		{name: "idle", session: Session{IdleTimeout: time.Hour, LastUsed: now.Add(-2 * time.Hour)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.Idle(now); got != tt.want {
+				t.Errorf("Idle = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureSessionCreates(t *testing.T) {
+	api := &sessionAPI{}
+	id, err := newTestClient(api).ensureSession(context.Background(), "alpine:3.19", "/bin/sh", ShellOptions{
+		Session:     "debug",
+		IdleTimeout: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("ensureSession: %v", err)
+	}
+	if id != testContainerID || !slices.Equal(api.started, []string{testContainerID}) {
+		t.Errorf("id = %q, started = %v, want %q started", id, api.started, testContainerID)
+	}
+	if len(api.created) != 1 {
+		t.Fatalf("created %d containers, want 1", len(api.created))
+	}
+	cfg, hostCfg := api.created[0], api.hosts[0]
+	if cfg.Labels[SessionLabel] != "debug" || cfg.Labels[sessionIdleTimeoutLabel] != "1h0m0s" {
+		t.Errorf("labels = %v, want the session and its idle timeout", cfg.Labels)
+	}
+	if !slices.Equal(cfg.Entrypoint, []string{"/bin/sh", "-c", sessionKeepAlive}) || cfg.Cmd != nil {
+		t.Errorf("entrypoint = %v, cmd = %v, want the keep-alive process", cfg.Entrypoint, cfg.Cmd)
+	}
+	if cfg.OpenStdin || cfg.AttachStdin || hostCfg.AutoRemove {
+		t.Errorf("the session container is attached or removed on exit")
+	}
+}
+
+func TestEnsureSessionReattaches(t *testing.T) {
+	tests := []struct {
+		name        string
+		running     bool
+		wantStarted bool
+	}{
+		{name: "running", running: true},
+		{name: "stopped", running: false, wantStarted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &sessionAPI{containers: map[string]types.ContainerJSON{
+				sessionContainerPrefix + "debug": sessionContainer(testContainerID, "debug", tt.running, nil),
+			}}
+			id, err := newTestClient(api).ensureSession(context.Background(), "alpine:3.19", "/bin/sh", ShellOptions{Session: "debug"})
+			if err != nil {
+				t.Fatalf("ensureSession: %v", err)
+			}
+			if id != testContainerID || len(api.created) != 0 {
+				t.Errorf("id = %q, created %d, want the existing container", id, len(api.created))
+			}
+			if started := len(api.started) == 1; started != tt.wantStarted {
+				t.Errorf("started = %v, want %v", api.started, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func TestEnsureSessionNotASession(t *testing.T) {
+	ctr := sessionContainer(testContainerID, "other", true, nil)
+	api := &sessionAPI{containers: map[string]types.ContainerJSON{sessionContainerPrefix + "debug": ctr}}
+	_, err := newTestClient(api).ensureSession(context.Background(), "alpine:3.19", "/bin/sh", ShellOptions{Session: "debug"})
+	if !errors.Is(err, SessionErr) {
+		t.Errorf("err = %v, want %v", err, SessionErr)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	useTestCacheDir(t)
+	touchSession("b")
+	api := &sessionAPI{containers: map[string]types.ContainerJSON{
+		sessionContainerPrefix + "b": sessionContainer("id-b", "b", true, map[string]string{sessionIdleTimeoutLabel: "30m"}),
+		sessionContainerPrefix + "a": sessionContainer("id-a", "a", false, map[string]string{sessionIdleTimeoutLabel: "bad"}),
+	}}
+	sessions, err := newTestClient(api).Sessions(context.Background())
+	if err != nil {
+		t.Fatalf("Sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Name != "a" || sessions[1].Name != "b" {
+		t.Fatalf("sessions = %+v, want a and b", sessions)
+	}
+	a, b := sessions[0], sessions[1]
+	if a.State != "exited" || a.IdleTimeout != 0 || !a.LastUsed.IsZero() {
+		t.Errorf("a = %+v, want exited, no timeout, never used", a)
+	}
+	if b.State != "running" || b.IdleTimeout != 30*time.Minute || b.LastUsed.IsZero() {
+		t.Errorf("b = %+v, want running, 30m timeout, used", b)
+	}
+}
+
+func TestRemoveSession(t *testing.T) {
+	useTestCacheDir(t)
+	touchSession("debug")
+	api := &sessionAPI{containers: map[string]types.ContainerJSON{
+		sessionContainerPrefix + "debug": sessionContainer(testContainerID, "debug", true, nil),
+		sessionContainerPrefix + "other": sessionContainer("id-other", "mismatch", true, nil),
+	}}
+	c := newTestClient(api)
+	if err := c.RemoveSession(context.Background(), "debug"); err != nil {
+		t.Fatalf("RemoveSession: %v", err)
+	}
+	if !slices.Equal(api.removed, []string{testContainerID}) {
+		t.Errorf("removed = %v, want %s", api.removed, testContainerID)
+	}
+	if !sessionLastUsed("debug").IsZero() {
+		t.Errorf("the session bookkeeping was kept")
+	}
+	for _, name := range []string{"missing", "other"} {
+		if err := c.RemoveSession(context.Background(), name); !errors.Is(err, SessionErr) {
+			t.Errorf("RemoveSession(%s) err = %v, want %v", name, err, SessionErr)
+		}
+	}
+	if err := c.RemoveSession(context.Background(), "../x"); !errors.Is(err, InvalidSessionErr) {
+		t.Errorf("err = %v, want %v", err, InvalidSessionErr)
+	}
+}
+
+func TestRemoveIdleSessions(t *testing.T) {
+	useTestCacheDir(t)
+	for _, name := range []string{"idle", "recent", "forever"} {
+		touchSession(name)
+	}
+	path, err := sessionFile("idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	timeout := map[string]string{sessionIdleTimeoutLabel: "1h"}
+	api := &sessionAPI{containers: map[string]types.ContainerJSON{
+		sessionContainerPrefix + "idle":    sessionContainer("id-idle", "idle", true, timeout),
+		sessionContainerPrefix + "recent":  sessionContainer("id-recent", "recent", true, timeout),
+		sessionContainerPrefix + "forever": sessionContainer("id-forever", "forever", true, nil),
+	}}
+	removed, err := newTestClient(api).RemoveIdleSessions(context.Background())
+	if err != nil {
+		t.Fatalf("RemoveIdleSessions: %v", err)
+	}
+	if !slices.Equal(removed, []string{"idle"}) || !slices.Equal(api.removed, []string{"id-idle"}) {
+		t.Errorf("removed = %v (%v), want idle", removed, api.removed)
+	}
+}
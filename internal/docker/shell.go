@@ -0,0 +1,278 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/term"
+)
+
+var (
+	ShellNotFoundErr = errors.New("no shell found in image")
+	ContainerRunErr  = errors.New("failed to run container")
+)
+
+const (
+	// ManagedLabel marks the containers created by the runner, so
+	// they can be found for cleanup
+	ManagedLabel = "docker-runner.managed"
+	// shellSourceDir is where the current folder is mounted
+	shellSourceDir = "/src"
+)
+
+// shellCandidates are the shells tried, in order
+var shellCandidates = []string{"/bin/bash", "/bin/sh"}
+
+// ShellOptions holds the settings of a debug shell container
+type ShellOptions struct {
+	// SourceDir is the host folder mounted read-only at /src
+	SourceDir string
+	// Root forces the shell to run as user 0
+	Root bool
+	// Network is the container network mode (e.g. host)
+	Network string
+	// Args are passed to the shell (e.g. -c 'ls /src')
+	Args []string
+	// Session runs the shell in the named persistent container,
+	// reattached by the next shell of the same session (see
+	// ValidateSessionName)
+	Session string
+	// IdleTimeout is the idle time after which RemoveIdleSessions
+	// removes a new session (never if 0)
+	IdleTimeout time.Duration
+}
+
+// Shell starts a throwaway container from image running a shell,
+// attached to the current terminal, and returns its exit code
+func (c Client) Shell(ctx context.Context, image string, opts ShellOptions) (int, error) {
+	original := image
+	image = c.mirrorImage(image)
+	shell, err := c.detectShell(ctx, image)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Session != "" {
+		return c.sessionShell(ctx, image, shell, opts)
+	}
+
+	stdinFd, tty := term.GetFdInfo(os.Stdin)
+	cfg, hostCfg := shellContainerConfig(image, shell, tty, opts)
+	labelOriginalImage(cfg.Labels, original, image)
+
+	created, err := c.d.ContainerCreate(ctx, cfg, hostCfg, nil, nil, "")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer func() {
+		_ = c.d.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	}()
+
+	attach, err := c.d.ContainerAttach(ctx, created.ID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer attach.Close()
+
+	waitC, waitErrC := c.d.ContainerWait(ctx, created.ID, container.WaitConditionRemoved)
+
+	if tty {
+		state, err := term.SetRawTerminal(stdinFd)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+		}
+		defer func() {
+			_ = term.RestoreTerminal(stdinFd, state)
+		}()
+	}
+
+	if err := c.d.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	if tty {
+		if ws, err := term.GetWinsize(stdinFd); err == nil {
+			_ = c.d.ContainerResize(ctx, created.ID, container.ResizeOptions{Height: uint(ws.Height), Width: uint(ws.Width)})
+		}
+	}
+
+	outputDone := pipeTerminal(attach, tty)
+
+	select {
+	case res := <-waitC:
+		<-outputDone
+		if res.Error != nil {
+			return int(res.StatusCode), fmt.Errorf("%w: %s", ContainerRunErr, res.Error.Message)
+		}
+		return int(res.StatusCode), nil
+	case err := <-waitErrC:
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+}
+
+// AttachShell starts a debug shell in the container, e.g. one a run
+// kept: an exec in it while running, else a throwaway shell container
+// from a commit of its filesystem, removed on exit
+func (c Client) AttachShell(ctx context.Context, name string, opts ShellOptions) (int, error) {
+	inspect, err := c.d.ContainerInspect(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	if !inspect.State.Running {
+		committed, err := c.d.ContainerCommit(ctx, inspect.ID, container.CommitOptions{})
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+		}
+		defer func() {
+			_, _ = c.d.ImageRemove(context.Background(), committed.ID, types.ImageRemoveOptions{Force: true})
+		}()
+		return c.Shell(ctx, committed.ID, opts)
+	}
+	shell, err := c.detectShell(ctx, inspect.Image)
+	if err != nil {
+		return 0, err
+	}
+	code, err := c.execShell(ctx, inspect.ID, types.ExecConfig{
+		User: shellUser(opts),
+		Cmd:  append([]string{shell}, opts.Args...),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	return code, nil
+}
+
+// execShell runs the exec of cfg in the running container, attached to
+// the current terminal, and returns its exit code
+func (c Client) execShell(ctx context.Context, id string, cfg types.ExecConfig) (int, error) {
+	stdinFd, tty := term.GetFdInfo(os.Stdin)
+	cfg.Tty = tty
+	cfg.AttachStdin, cfg.AttachStdout, cfg.AttachStderr = true, true, true
+	exec, err := c.d.ContainerExecCreate(ctx, id, cfg)
+	if err != nil {
+		return 0, err
+	}
+	attach, err := c.d.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: tty})
+	if err != nil {
+		return 0, err
+	}
+	defer attach.Close()
+
+	if tty {
+		state, err := term.SetRawTerminal(stdinFd)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = term.RestoreTerminal(stdinFd, state)
+		}()
+		if ws, err := term.GetWinsize(stdinFd); err == nil {
+			_ = c.d.ContainerExecResize(ctx, exec.ID, container.ResizeOptions{Height: uint(ws.Height), Width: uint(ws.Width)})
+		}
+	}
+
+	<-pipeTerminal(attach, tty)
+	inspect, err := c.d.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
+
+// pipeTerminal copies the terminal input to the attached stream and
+// its output to the terminal, returning a channel fed once the output
+// ends
+func pipeTerminal(attach types.HijackedResponse, tty bool) <-chan error {
+	go func() {
+		_, _ = io.Copy(attach.Conn, os.Stdin)
+		_ = attach.CloseWrite()
+	}()
+	outputDone := make(chan error, 1)
+	go func() {
+		var err error
+		if tty {
+			_, err = io.Copy(os.Stdout, attach.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attach.Reader)
+		}
+		outputDone <- err
+	}()
+	return outputDone
+}
+
+// shellUser is the user of the shell, the image one unless forced to
+// root
+func shellUser(opts ShellOptions) string {
+	if opts.Root {
+		return "0"
+	}
+	return ""
+}
+
+// detectShell creates a (never started) probe container from the
+// image and looks for the first available shell in its filesystem
+func (c Client) detectShell(ctx context.Context, image string) (string, error) {
+	probe, err := c.d.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Entrypoint: []string{shellCandidates[len(shellCandidates)-1]},
+		Labels:     map[string]string{ManagedLabel: "true"},
+	}, nil, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ContainerRunErr, err)
+	}
+	defer func() {
+		_ = c.d.ContainerRemove(context.Background(), probe.ID, container.RemoveOptions{Force: true})
+	}()
+
+	for _, shell := range shellCandidates {
+		if _, err := c.d.ContainerStatPath(ctx, probe.ID, shell); err == nil {
+			return shell, nil
+		}
+	}
+	return "", fmt.Errorf("%w: tried %v in %s", ShellNotFoundErr, shellCandidates, image)
+}
+
+// shellContainerConfig assembles the container settings for a
+// debug shell: entrypoint replaced by the shell, current folder
+// mounted read-only and removed on exit
+func shellContainerConfig(image, shell string, tty bool, opts ShellOptions) (*container.Config, *container.HostConfig) {
+	cfg := &container.Config{
+		Image:        image,
+		Entrypoint:   []string{shell},
+		Cmd:          opts.Args,
+		WorkingDir:   shellSourceDir,
+		Tty:          tty,
+		OpenStdin:    true,
+		StdinOnce:    true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels:       map[string]string{ManagedLabel: "true"},
+	}
+	cfg.User = shellUser(opts)
+
+	hostCfg := &container.HostConfig{
+		AutoRemove:  true,
+		NetworkMode: container.NetworkMode(opts.Network),
+	}
+	if opts.SourceDir != "" {
+		hostCfg.Mounts = []mount.Mount{{
+			Type:     mount.TypeBind,
+			Source:   opts.SourceDir,
+			Target:   shellSourceDir,
+			ReadOnly: true,
+		}}
+	}
+	return cfg, hostCfg
+}
@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// shellAPI fakes the filesystem of the image probed for its shell,
+// holding the files
+type shellAPI struct {
+	client.APIClient
+	files map[string]bool
+
+	created []*container.Config
+	removed []string
+}
+
+func (f *shellAPI) ContainerCreate(_ context.Context, cfg *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	f.created = append(f.created, cfg)
+	return container.CreateResponse{ID: testContainerID}, nil
+}
+
+func (f *shellAPI) ContainerStatPath(_ context.Context, _, path string) (types.ContainerPathStat, error) {
+	if !f.files[path] {
+		return types.ContainerPathStat{}, errdefs.NotFound(errors.New("no such file: " + path))
+	}
+	return types.ContainerPathStat{Name: path}, nil
+}
+
+func (f *shellAPI) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func TestDetectShell(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    string
+		wantErr error
+	}{
+		{name: "bash preferred", files: []string{"/bin/bash", "/bin/sh"}, want: "/bin/bash"},
+		{name: "sh fallback", files: []string{"/bin/sh"}, want: "/bin/sh"},
+		{name: "distroless", wantErr: ShellNotFoundErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &shellAPI{files: map[string]bool{}}
+			for _, f := range tt.files {
+				api.files[f] = true
+			}
+			got, err := newTestClient(api).detectShell(context.Background(), "alpine:3.19")
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("detectShell = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if len(api.created) != 1 || api.created[0].Image != "alpine:3.19" || api.created[0].Labels[ManagedLabel] != "true" {
+				t.Errorf("probe containers = %+v, want one managed of the image", api.created)
+			}
+			if len(api.removed) != 1 {
+				t.Errorf("probe container removed %d times, want once", len(api.removed))
+			}
+		})
+	}
+}
+
+func TestShellContainerConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     ShellOptions
+		tty      bool
+		wantUser string
+		wantSrc  bool
+	}{
+		{name: "defaults", opts: ShellOptions{SourceDir: "/home/dev/app"}, tty: true, wantSrc: true},
+		{name: "root", opts: ShellOptions{Root: true}, wantUser: "0"},
+		{name: "args and network", opts: ShellOptions{Args: []string{"-c", "ls /src"}, Network: "host"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, hostCfg := shellContainerConfig("alpine:3.19", "/bin/sh", tt.tty, tt.opts)
+			if len(cfg.Entrypoint) != 1 || cfg.Entrypoint[0] != "/bin/sh" {
+				t.Errorf("Entrypoint = %v, want the shell", cfg.Entrypoint)
+			}
+			if !equalStrings(cfg.Cmd, tt.opts.Args) {
+				t.Errorf("Cmd = %v, want %v", cfg.Cmd, tt.opts.Args)
+			}
+			if cfg.User != tt.wantUser || cfg.Tty != tt.tty || cfg.WorkingDir != shellSourceDir {
+				t.Errorf("config = user %q tty %v dir %q", cfg.User, cfg.Tty, cfg.WorkingDir)
+			}
+			if !cfg.OpenStdin || !cfg.StdinOnce || !cfg.AttachStdin {
+				t.Error("stdin not attached")
+			}
+			if !hostCfg.AutoRemove || string(hostCfg.NetworkMode) != tt.opts.Network {
+				t.Errorf("host config = auto remove %v network %q", hostCfg.AutoRemove, hostCfg.NetworkMode)
+			}
+			if !tt.wantSrc {
+				if len(hostCfg.Mounts) != 0 {
+					t.Errorf("Mounts = %+v, want none", hostCfg.Mounts)
+				}
+				return
+			}
+			if len(hostCfg.Mounts) != 1 {
+				t.Fatalf("Mounts = %+v, want the source folder", hostCfg.Mounts)
+			}
+			if m := hostCfg.Mounts[0]; m.Source != tt.opts.SourceDir || m.Target != shellSourceDir || !m.ReadOnly {
+				t.Errorf("source mount = %+v, want %s read-only at %s", m, tt.opts.SourceDir, shellSourceDir)
+			}
+		})
+	}
+}
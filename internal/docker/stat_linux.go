@@ -0,0 +1,20 @@
+package docker
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// statFile returns the identity, link count and allocated size of the
+// file
+func statFile(i fs.FileInfo) (fileStat, bool) {
+	st, ok := i.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		id:        fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)},
+		links:     uint64(st.Nlink),
+		allocated: int64(st.Blocks) * 512,
+	}, true
+}
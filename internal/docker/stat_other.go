@@ -0,0 +1,11 @@
+//go:build !linux
+
+package docker
+
+import "io/fs"
+
+// statFile is only implemented on Linux: elsewhere hardlinks are
+// archived as regular files and sparse files aren't detected
+func statFile(fs.FileInfo) (fileStat, bool) {
+	return fileStat{}, false
+}
@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	ProfileErr = errors.New("failed to profile container")
+)
+
+// liveSamplesBuffer is the number of samples waiting to be rendered
+// after which the new ones are dropped from the rendering
+const liveSamplesBuffer = 64
+
+// StatsSample is a container resource usage sample
+type StatsSample struct {
+	Time       time.Time `json:"time"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemUsed    uint64    `json:"mem_used"`
+	MemLimit   uint64    `json:"mem_limit"`
+	// NetRx, NetTx, BlkRead and BlkWrite are the bytes since the
+	// previous sample
+	NetRx    uint64 `json:"net_rx"`
+	NetTx    uint64 `json:"net_tx"`
+	BlkRead  uint64 `json:"blk_read"`
+	BlkWrite uint64 `json:"blk_write"`
+}
+
+// ProfileSummary sums up the samples of a profiling session
+type ProfileSummary struct {
+	Container string        `json:"container"`
+	Samples   int           `json:"samples"`
+	Duration  time.Duration `json:"duration"`
+	CPUAvg    float64       `json:"cpu_avg"`
+	CPUMax    float64       `json:"cpu_max"`
+	MemMax    uint64        `json:"mem_max"`
+	MemLimit  uint64        `json:"mem_limit"`
+	NetRx     uint64        `json:"net_rx"`
+	NetTx     uint64        `json:"net_tx"`
+	BlkRead   uint64        `json:"blk_read"`
+	BlkWrite  uint64        `json:"blk_write"`
+	// Dropped is the number of samples not rendered live, the renderer
+	// being too slow (they're still in the summary)
+	Dropped int `json:"dropped"`
+}
+
+// ProfileOptions defines a profiling session
+type ProfileOptions struct {
+	// OnSample renders each sample as it's collected (none if nil). It
+	// runs apart from the sampling: the samples it can't keep up with
+	// are dropped from the rendering, never from the summary.
+	OnSample func(StatsSample)
+}
+
+// Profile samples the container resource usage until it stops or ctx
+// is done, which ends the session gracefully with the summary of the
+// samples gathered so far
+func (c Client) Profile(ctx context.Context, id string, opts ProfileOptions) (ProfileSummary, error) {
+	inspect, err := c.d.ContainerInspect(ctx, id)
+	if err != nil {
+		return ProfileSummary{}, fmt.Errorf("%w: %w", ProfileErr, err)
+	}
+	stats, err := c.d.ContainerStats(ctx, inspect.ID, true)
+	if err != nil {
+		return ProfileSummary{}, fmt.Errorf("%w: %w", ProfileErr, err)
+	}
+	defer func() {
+		_ = stats.Body.Close()
+	}()
+
+	acc := profileAccumulator{summary: ProfileSummary{Container: strings.TrimPrefix(inspect.Name, "/")}}
+	live := newLiveSamples(opts.OnSample)
+	err = readStats(stats.Body, func(s StatsSample) {
+		acc.Add(s)
+		live.Send(s)
+	})
+	live.Close()
+	res := acc.summary
+	res.Dropped = live.Dropped()
+	if err != nil && ctx.Err() == nil {
+		return res, fmt.Errorf("%w: %w", ProfileErr, err)
+	}
+	return res, nil
+}
+
+// readStats decodes the stats stream into samples until it ends, the
+// container having stopped
+func readStats(r io.Reader, handle func(StatsSample)) error {
+	dec := json.NewDecoder(r)
+	var prev *types.StatsJSON
+	for {
+		var s types.StatsJSON
+		if err := dec.Decode(&s); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		// a stopped container reports empty stats
+		if s.Read.IsZero() {
+			return nil
+		}
+		handle(statsSample(prev, &s))
+		prev = &s
+	}
+}
+
+// statsSample computes a sample as docker stats does, the deltas
+// being taken from the previous stats (none for the first ones)
+func statsSample(prev, s *types.StatsJSON) StatsSample {
+	res := StatsSample{
+		Time:     s.Read,
+		MemUsed:  memUsed(s.MemoryStats),
+		MemLimit: s.MemoryStats.Limit,
+	}
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		res.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+	if prev == nil {
+		return res
+	}
+	rx, tx := netBytes(s)
+	prevRx, prevTx := netBytes(prev)
+	read, write := blkBytes(s)
+	prevRead, prevWrite := blkBytes(prev)
+	res.NetRx, res.NetTx = delta(rx, prevRx), delta(tx, prevTx)
+	res.BlkRead, res.BlkWrite = delta(read, prevRead), delta(write, prevWrite)
+	return res
+}
+
+// memUsed leaves the page cache out of the usage, as docker stats does
+// (inactive_file on cgroup v2, total_inactive_file on v1)
+func memUsed(m types.MemoryStats) uint64 {
+	cache, ok := m.Stats["inactive_file"]
+	if !ok {
+		cache = m.Stats["total_inactive_file"]
+	}
+	return delta(m.Usage, cache)
+}
+
+func netBytes(s *types.StatsJSON) (rx, tx uint64) {
+	for _, n := range s.Networks {
+		rx += n.RxBytes
+		tx += n.TxBytes
+	}
+	return rx, tx
+}
+
+func blkBytes(s *types.StatsJSON) (read, write uint64) {
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			read += e.Value
+		case "write":
+			write += e.Value
+		}
+	}
+	return read, write
+}
+
+// delta returns a - b, or 0 if the counter was reset
+func delta(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+// profileAccumulator computes the summary of the samples
+type profileAccumulator struct {
+	summary ProfileSummary
+	first   time.Time
+	cpuSum  float64
+}
+
+func (a *profileAccumulator) Add(s StatsSample) {
+	if a.summary.Samples == 0 {
+		a.first = s.Time
+	}
+	a.summary.Samples++
+	a.summary.Duration = s.Time.Sub(a.first)
+	a.cpuSum += s.CPUPercent
+	a.summary.CPUAvg = a.cpuSum / float64(a.summary.Samples)
+	a.summary.CPUMax = max(a.summary.CPUMax, s.CPUPercent)
+	a.summary.MemMax = max(a.summary.MemMax, s.MemUsed)
+	a.summary.MemLimit = s.MemLimit
+	a.summary.NetRx += s.NetRx
+	a.summary.NetTx += s.NetTx
+	a.summary.BlkRead += s.BlkRead
+	a.summary.BlkWrite += s.BlkWrite
+}
+
+// liveSamples hands the samples to the renderer without ever blocking
+// the sampling: the ones arriving while its buffer is full are dropped
+type liveSamples struct {
+	c       chan StatsSample
+	done    chan struct{}
+	once    sync.Once
+	dropped int
+}
+
+func newLiveSamples(render func(StatsSample)) *liveSamples {
+	if render == nil {
+		return nil
+	}
+	l := &liveSamples{c: make(chan StatsSample, liveSamplesBuffer), done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		for s := range l.c {
+			render(s)
+		}
+	}()
+	return l
+}
+
+// Send queues the sample for rendering, dropping it if the renderer is
+// behind
+func (l *liveSamples) Send(s StatsSample) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.c <- s:
+	default:
+		l.dropped++
+	}
+}
+
+// Close waits for the queued samples to be rendered
+func (l *liveSamples) Close() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.c)
+	})
+	<-l.done
+}
+
+// Dropped is the number of samples not rendered, read by the sampling
+// goroutine
+func (l *liveSamples) Dropped() int {
+	if l == nil {
+		return 0
+	}
+	return l.dropped
+}
@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// statsAPI fakes a container whose stats stream is the stats
+type statsAPI struct {
+	client.APIClient
+	stats []types.StatsJSON
+	// tail is appended to the stream, after the stats
+	tail string
+}
+
+func (f *statsAPI) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id + "-id", Name: "/" + id}}, nil
+}
+
+func (f *statsAPI) ContainerStats(_ context.Context, _ string, _ bool) (types.ContainerStats, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, s := range f.stats {
+		_ = enc.Encode(s)
+	}
+	b.WriteString(f.tail)
+	return types.ContainerStats{Body: io.NopCloser(strings.NewReader(b.String()))}, nil
+}
+
+// testStats returns the stats read at the time, the counters being
+// the totals so far
+func testStats(at time.Time, cpu, preCPU, system, preSystem, mem, rx, read uint64) types.StatsJSON {
+	var s types.StatsJSON
+	s.Read = at
+	s.CPUStats.CPUUsage.TotalUsage = cpu
+	s.CPUStats.SystemUsage = system
+	s.CPUStats.OnlineCPUs = 2
+	s.PreCPUStats.CPUUsage.TotalUsage = preCPU
+	s.PreCPUStats.SystemUsage = preSystem
+	s.MemoryStats = types.MemoryStats{Usage: mem, Limit: 1 << 30, Stats: map[string]uint64{"inactive_file": 100}}
+	s.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: rx, TxBytes: rx / 2}}
+	s.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{{Op: "Read", Value: read}, {Op: "Write", Value: read / 4}}
+	return s
+}
+
+func TestStatsSample(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	prev := testStats(at, 100, 0, 1000, 0, 600, 1000, 400)
+	s := testStats(at.Add(time.Second), 300, 100, 2000, 1000, 1100, 3000, 800)
+	got := statsSample(&prev, &s)
+	want := StatsSample{
+		Time:       at.Add(time.Second),
+		CPUPercent: 40,
+		MemUsed:    1000,
+		MemLimit:   1 << 30,
+		NetRx:      2000,
+		NetTx:      1000,
+		BlkRead:    400,
+		BlkWrite:   100,
+	}
+	if got != want {
+		t.Errorf("statsSample = %+v, want %+v", got, want)
+	}
+	if first := statsSample(nil, &prev); first.NetRx != 0 || first.BlkRead != 0 || first.MemUsed != 500 {
+		t.Errorf("first sample = %+v, want no I/O deltas", first)
+	}
+}
+
+func TestStatsSampleEdgeCases(t *testing.T) {
+	var s types.StatsJSON
+	s.CPUStats.CPUUsage.TotalUsage = 200
+	s.CPUStats.CPUUsage.PercpuUsage = []uint64{1, 2, 3, 4}
+	s.CPUStats.SystemUsage = 1000
+	if got := statsSample(nil, &s).CPUPercent; got != 80 {
+		t.Errorf("CPUPercent = %v, want 80 using the per CPU usage count", got)
+	}
+	s.PreCPUStats.SystemUsage = 1000
+	if got := statsSample(nil, &s).CPUPercent; got != 0 {
+		t.Errorf("CPUPercent = %v, want 0 without a system delta", got)
+	}
+	tests := []struct {
+		name  string
+		stats map[string]uint64
+		want  uint64
+	}{
+		{name: "cgroup v2", stats: map[string]uint64{"inactive_file": 300}, want: 700},
+		{name: "cgroup v1", stats: map[string]uint64{"total_inactive_file": 200}, want: 800},
+		{name: "no cache stats", want: 1000},
+		{name: "cache above the usage", stats: map[string]uint64{"inactive_file": 2000}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memUsed(types.MemoryStats{Usage: 1000, Stats: tt.stats}); got != tt.want {
+				t.Errorf("memUsed = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	prev := testStats(time.Now(), 0, 0, 0, 0, 0, 5000, 0)
+	reset := testStats(time.Now(), 0, 0, 0, 0, 0, 100, 0)
+	if got := statsSample(&prev, &reset); got.NetRx != 0 {
+		t.Errorf("NetRx = %d, want 0 after a counter reset", got.NetRx)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	api := &statsAPI{
+		stats: []types.StatsJSON{
+			testStats(at, 100, 0, 1000, 0, 600, 1000, 400),
+			testStats(at.Add(time.Second), 300, 100, 2000, 1000, 1100, 3000, 800),
+			testStats(at.Add(2*time.Second), 400, 300, 3000, 2000, 300, 3500, 800),
+			// a stopped container reports empty stats
+			{},
+		},
+		tail: "not read\n",
+	}
+	var mu sync.Mutex
+	var rendered []StatsSample
+	got, err := newTestClient(api).Profile(context.Background(), "app", ProfileOptions{OnSample: func(s StatsSample) {
+		mu.Lock()
+		defer mu.Unlock()
+		rendered = append(rendered, s)
+	}})
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	want := ProfileSummary{
+		Container: "app",
+		Samples:   3,
+		Duration:  2 * time.Second,
+		CPUAvg:    (20 + 40 + 20) / 3.0,
+		CPUMax:    40,
+		MemMax:    1000,
+		MemLimit:  1 << 30,
+		NetRx:     2500,
+		NetTx:     1250,
+		BlkRead:   400,
+		BlkWrite:  100,
+	}
+	if got != want {
+		t.Errorf("Profile =\n%+v\nwant\n%+v", got, want)
+	}
+	if len(rendered) != 3 {
+		t.Errorf("rendered %d samples, want 3", len(rendered))
+	}
+}
+
+func TestProfileBrokenStream(t *testing.T) {
+	api := &statsAPI{stats: []types.StatsJSON{testStats(time.Now(), 0, 0, 0, 0, 0, 0, 0)}, tail: "{broken"}
+	got, err := newTestClient(api).Profile(context.Background(), "app", ProfileOptions{})
+	if !errors.Is(err, ProfileErr) {
+		t.Errorf("err = %v, want %v", err, ProfileErr)
+	}
+	if got.Samples != 1 {
+		t.Errorf("Samples = %d, want the samples read before the error", got.Samples)
+	}
+}
+
+func TestLiveSamplesDrops(t *testing.T) {
+	release := make(chan struct{})
+	var rendered int
+	l := newLiveSamples(func(StatsSample) {
+		<-release
+		rendered++
+	})
+	total := liveSamplesBuffer + 10
+	for i := 0; i < total; i++ {
+		l.Send(StatsSample{})
+	}
+	close(release)
+	l.Close()
+	if l.Dropped() == 0 || rendered+l.Dropped() != total {
+		t.Errorf("rendered %d and dropped %d samples, want %d in all with some dropped", rendered, l.Dropped(), total)
+	}
+
+	var none *liveSamples
+	none.Send(StatsSample{})
+	none.Close()
+	if none.Dropped() != 0 || newLiveSamples(nil) != nil {
+		t.Errorf("a nil renderer must not queue samples")
+	}
+}
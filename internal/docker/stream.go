@@ -0,0 +1,263 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	BuildStreamReadErr = errors.New("failed to read build stream")
+	BuildStepErr       = errors.New("build step failed")
+)
+
+// BuildStepError is the error the daemon reported for a build, with
+// the step being executed (zero Step if it failed before the first
+// one). It matches BuildStepErr.
+type BuildStepError struct {
+	Step        int
+	Total       int
+	Instruction string
+	Message     string
+}
+
+func (e *BuildStepError) Error() string {
+	if e.Step == 0 {
+		return fmt.Sprintf("%s: %s", BuildStepErr, e.Message)
+	}
+	return fmt.Sprintf("%s: step %d/%d (%s): %s", BuildStepErr, e.Step, e.Total, e.Instruction, e.Message)
+}
+
+func (e *BuildStepError) Unwrap() error {
+	return BuildStepErr
+}
+
+// stepError builds the BuildStepError of the current step
+func (p *streamParser) stepError(msg string) error {
+	e := &BuildStepError{Message: msg}
+	if p.current != nil {
+		e.Step = p.current.Number
+		e.Total = p.current.Total
+		e.Instruction = p.current.Instruction
+	}
+	return e
+}
+
+var (
+	stepLineRe   = regexp.MustCompile(`^Step (\d+)/(\d+) : (.*)$`)
+	vertexStepRe = regexp.MustCompile(`^\[(?:[^\]]+ )?(\d+)/(\d+)\] (.*)$`)
+	usingCacheRe = regexp.MustCompile(`^\s*---> Using cache`)
+)
+
+type buildEventKind int
+
+const (
+	eventStepStart buildEventKind = iota
+	eventLine
+	eventStatus
+	eventAux
+	eventError
+)
+
+// buildStep is a Dockerfile instruction being executed by the
+// classic builder ("Step N/M : INSTRUCTION")
+type buildStep struct {
+	Number      int
+	Total       int
+	Instruction string
+	Cached      bool
+	Lines       []string
+	Start       time.Time
+	Duration    time.Duration
+}
+
+// Header returns the step line as printed by the daemon
+func (s *buildStep) Header() string {
+	return fmt.Sprintf("Step %d/%d : %s", s.Number, s.Total, s.Instruction)
+}
+
+// buildEvent is a parsed message of the build stream
+type buildEvent struct {
+	Kind buildEventKind
+	// Step is the step being executed, nil before the first one
+	Step *buildStep
+	// Text is the output line, status or error message
+	Text string
+	// Aux is the raw aux payload for aux events
+	Aux *json.RawMessage
+}
+
+const (
+	buildKitTraceID = "moby.buildkit.trace"
+)
+
+// streamParser splits the daemon build stream into lines and
+// keeps track of the step being executed
+type streamParser struct {
+	now     func() time.Time
+	current *buildStep
+	partial string
+	// vertexes are the BuildKit vertexes already seen, with the
+	// step they map to (nil for internal ones)
+	vertexes map[digest.Digest]*buildStep
+}
+
+func newStreamParser() *streamParser {
+	return &streamParser{
+		now:      time.Now,
+		vertexes: map[digest.Digest]*buildStep{},
+	}
+}
+
+// Parse reads the build stream from r, calling handle for each
+// event. It returns a BuildStepError if the daemon reports an error.
+func (p *streamParser) Parse(r io.Reader, handle func(buildEvent)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("%w: %w", BuildStreamReadErr, err)
+		}
+
+		if msg.Stream != "" {
+			p.stream(msg.Stream, handle)
+		}
+		if msg.Status != "" {
+			text := msg.Status
+			if msg.ID != "" {
+				text = msg.ID + ": " + text
+			}
+			handle(buildEvent{Kind: eventStatus, Step: p.current, Text: text})
+		}
+		if msg.Aux != nil {
+			if msg.ID == buildKitTraceID {
+				if err := p.trace(*msg.Aux, handle); err != nil {
+					return err
+				}
+			} else {
+				handle(buildEvent{Kind: eventAux, Step: p.current, Aux: msg.Aux})
+			}
+		}
+		if msg.Error != nil {
+			p.flush(handle)
+			p.finish()
+			handle(buildEvent{Kind: eventError, Step: p.current, Text: msg.Error.Message})
+			return p.stepError(msg.Error.Message)
+		}
+	}
+	p.flush(handle)
+	p.finish()
+	return nil
+}
+
+func (p *streamParser) stream(s string, handle func(buildEvent)) {
+	s = p.partial + s
+	lines := strings.Split(s, "\n")
+	p.partial = lines[len(lines)-1]
+	for _, l := range lines[:len(lines)-1] {
+		p.line(strings.TrimSuffix(l, "\r"), handle)
+	}
+}
+
+func (p *streamParser) flush(handle func(buildEvent)) {
+	if p.partial != "" {
+		p.line(p.partial, handle)
+		p.partial = ""
+	}
+}
+
+func (p *streamParser) line(l string, handle func(buildEvent)) {
+	if m := stepLineRe.FindStringSubmatch(l); m != nil {
+		p.finish()
+		number, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		p.current = &buildStep{
+			Number:      number,
+			Total:       total,
+			Instruction: m[3],
+			Start:       p.now(),
+		}
+		handle(buildEvent{Kind: eventStepStart, Step: p.current, Text: l})
+		return
+	}
+	if p.current != nil {
+		p.current.Lines = append(p.current.Lines, l)
+		if usingCacheRe.MatchString(l) {
+			p.current.Cached = true
+		}
+	}
+	handle(buildEvent{Kind: eventLine, Step: p.current, Text: l})
+}
+
+// finish sets the duration of the current step
+func (p *streamParser) finish() {
+	if p.current != nil && p.current.Duration == 0 {
+		p.current.Duration = p.now().Sub(p.current.Start)
+	}
+}
+
+// trace handles a BuildKit status message, mapping the Dockerfile
+// vertexes ("[2/5] RUN ...") to steps
+func (p *streamParser) trace(aux json.RawMessage, handle func(buildEvent)) error {
+	var b []byte
+	if err := json.Unmarshal(aux, &b); err != nil {
+		return fmt.Errorf("%w: %w", BuildStreamReadErr, err)
+	}
+	var resp controlapi.StatusResponse
+	if err := resp.Unmarshal(b); err != nil {
+		return fmt.Errorf("%w: %w", BuildStreamReadErr, err)
+	}
+
+	for _, v := range resp.Vertexes {
+		step, seen := p.vertexes[v.Digest]
+		if !seen && v.Started != nil {
+			if m := vertexStepRe.FindStringSubmatch(v.Name); m != nil {
+				p.finish()
+				number, _ := strconv.Atoi(m[1])
+				total, _ := strconv.Atoi(m[2])
+				step = &buildStep{
+					Number:      number,
+					Total:       total,
+					Instruction: m[3],
+					Start:       *v.Started,
+				}
+				p.current = step
+				handle(buildEvent{Kind: eventStepStart, Step: step, Text: step.Header()})
+			} else {
+				handle(buildEvent{Kind: eventLine, Step: p.current, Text: v.Name})
+			}
+			p.vertexes[v.Digest] = step
+		}
+		if step != nil {
+			step.Cached = step.Cached || v.Cached
+			if v.Completed != nil && v.Started != nil {
+				step.Duration = v.Completed.Sub(*v.Started)
+			}
+		}
+	}
+	for _, l := range resp.Logs {
+		step := p.vertexes[l.Vertex]
+		for _, line := range strings.Split(strings.TrimRight(string(l.Msg), "\n"), "\n") {
+			if step != nil {
+				step.Lines = append(step.Lines, line)
+			}
+			handle(buildEvent{Kind: eventLine, Step: step, Text: line})
+		}
+	}
+	for _, w := range resp.Warnings {
+		handle(buildEvent{Kind: eventLine, Step: p.vertexes[w.Vertex], Text: "WARNING: " + string(w.Short)})
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+)
+
+// parseStream parses the build stream, returning its events
+func parseStream(t *testing.T, stream string) ([]buildEvent, error) {
+	t.Helper()
+	var events []buildEvent
+	err := newStreamParser().Parse(strings.NewReader(stream), func(e buildEvent) {
+		events = append(events, e)
+	})
+	return events, err
+}
+
+// traceMessage encodes a BuildKit status as a build stream message
+func traceMessage(t *testing.T, resp *controlapi.StatusResponse) string {
+	t.Helper()
+	b, err := resp.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return streamMessage(map[string]any{"id": buildKitTraceID, "aux": b})
+}
+
+func TestParseClassicStream(t *testing.T) {
+	stream := streamMessage(map[string]any{"stream": "Step 1/3 : FROM alpine:3.19\n"}) +
+		streamMessage(map[string]any{"stream": " ---> 05455a08881e\n"}) +
+		streamMessage(map[string]any{"stream": "Step 2/3 : RUN apk add curl\n ---> Using cache\n"}) +
+		// a line split between two messages
+		streamMessage(map[string]any{"stream": "Step 3/3 : RUN make\n ---> Running in 0"}) +
+		streamMessage(map[string]any{"stream": "123456789ab\r\nbuilding\n"}) +
+		streamMessage(map[string]any{"status": "Downloading", "id": "layer1"}) +
+		streamMessage(map[string]any{"aux": map[string]string{"ID": testImageID}})
+	events, err := parseStream(t, stream)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var steps []*buildStep
+	var lines, statuses []string
+	var aux int
+	for _, e := range events {
+		switch e.Kind {
+		case eventStepStart:
+			steps = append(steps, e.Step)
+		case eventLine:
+			lines = append(lines, e.Text)
+		case eventStatus:
+			statuses = append(statuses, e.Text)
+		case eventAux:
+			aux++
+		}
+	}
+	if len(steps) != 3 {
+		t.Fatalf("steps = %d, want 3", len(steps))
+	}
+	for i, want := range []string{"FROM alpine:3.19", "RUN apk add curl", "RUN make"} {
+		if s := steps[i]; s.Number != i+1 || s.Total != 3 || s.Instruction != want {
+			t.Errorf("step %d = %d/%d %q, want %d/3 %q", i, s.Number, s.Total, s.Instruction, i+1, want)
+		}
+	}
+	if steps[0].Cached || !steps[1].Cached || steps[2].Cached {
+		t.Errorf("cached = %v %v %v, want only step 2", steps[0].Cached, steps[1].Cached, steps[2].Cached)
+	}
+	if got := strings.Join(steps[2].Lines, "|"); got != " ---> Running in 0123456789ab|building" {
+		t.Errorf("step 3 lines = %q", got)
+	}
+	if len(lines) != 4 {
+		t.Errorf("lines = %q, want 4", lines)
+	}
+	if len(statuses) != 1 || statuses[0] != "layer1: Downloading" {
+		t.Errorf("statuses = %q, want layer1: Downloading", statuses)
+	}
+	if aux != 1 {
+		t.Errorf("aux events = %d, want 1", aux)
+	}
+}
+
+func TestParseStreamErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		wantStep string
+		wantMsg  string
+	}{
+		{
+			name:     "failing step",
+			stream:   failedStream("returned a non-zero code: 1", "FROM alpine:3.19", "COPY . .", "RUN make test"),
+			wantStep: "RUN make test",
+			wantMsg:  "returned a non-zero code: 1",
+		},
+		{
+			name:    "before the first step",
+			stream:  failedStream("failed to read dockerfile"),
+			wantMsg: "failed to read dockerfile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := parseStream(t, tt.stream)
+			if !errors.Is(err, BuildStepErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Fatalf("Parse err = %v, want %v with %q", err, BuildStepErr, tt.wantMsg)
+			}
+			last := events[len(events)-1]
+			if last.Kind != eventError || last.Text != tt.wantMsg {
+				t.Errorf("last event = %+v, want the error", last)
+			}
+			var step string
+			if last.Step != nil {
+				step = last.Step.Instruction
+			}
+			if step != tt.wantStep {
+				t.Errorf("error step = %q, want %q", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestParseStreamMalformed(t *testing.T) {
+	_, err := parseStream(t, `{"stream": "Step 1/1 : FROM alpine"}`+"\n{not json")
+	if !errors.Is(err, BuildStreamReadErr) {
+		t.Errorf("Parse err = %v, want %v", err, BuildStreamReadErr)
+	}
+}
+
+func TestParseBuildKitTrace(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(1500 * time.Millisecond)
+	internal := digest.FromString("internal")
+	from := digest.FromString("from")
+	run := digest.FromString("run")
+	stream := traceMessage(t, &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			{Digest: internal, Name: "[internal] load build definition from Dockerfile", Started: &start, Completed: &start},
+			{Digest: from, Name: "[1/2] FROM docker.io/library/alpine:3.19", Started: &start, Completed: &start, Cached: true},
+		},
+	}) + traceMessage(t, &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			{Digest: run, Name: "[stage-0 2/2] RUN make", Started: &start},
+		},
+		Logs: []*controlapi.VertexLog{
+			{Vertex: run, Msg: []byte("compiling\nlinking\n")},
+		},
+		Warnings: []*controlapi.VertexWarning{
+			{Vertex: run, Short: []byte("FromAsCasing: 'as' and 'FROM' keywords' casing do not match")},
+		},
+	}) + traceMessage(t, &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			{Digest: run, Name: "[stage-0 2/2] RUN make", Started: &start, Completed: &end},
+		},
+	})
+	events, err := parseStream(t, stream)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var steps []*buildStep
+	var lines []string
+	for _, e := range events {
+		switch e.Kind {
+		case eventStepStart:
+			steps = append(steps, e.Step)
+		case eventLine:
+			lines = append(lines, e.Text)
+		}
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps = %d, want 2", len(steps))
+	}
+	if s := steps[0]; s.Number != 1 || s.Total != 2 || s.Instruction != "FROM docker.io/library/alpine:3.19" || !s.Cached {
+		t.Errorf("step 1 = %+v", *s)
+	}
+	if s := steps[1]; s.Number != 2 || s.Instruction != "RUN make" || s.Cached || s.Duration != 1500*time.Millisecond {
+		t.Errorf("step 2 = %+v", *s)
+	}
+	if got := strings.Join(steps[1].Lines, "|"); got != "compiling|linking" {
+		t.Errorf("step 2 lines = %q, want compiling|linking", got)
+	}
+	want := []string{
+		"[internal] load build definition from Dockerfile",
+		"compiling",
+		"linking",
+		"WARNING: FromAsCasing: 'as' and 'FROM' keywords' casing do not match",
+	}
+	if !equalStrings(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+var (
+	ImageInspectErr = errors.New("failed to inspect image")
+)
+
+// ImageSummary holds the runtime settings of an image config
+type ImageSummary struct {
+	Ref          string   `json:"ref"`
+	ID           string   `json:"id"`
+	Platform     string   `json:"platform"`
+	User         string   `json:"user"`
+	WorkingDir   string   `json:"workdir"`
+	Entrypoint   []string `json:"entrypoint"`
+	Cmd          []string `json:"cmd"`
+	ExposedPorts []string `json:"exposed_ports"`
+	Env          []string `json:"env"`
+}
+
+// ImageSummary inspects the local image ref and summarizes its config
+func (c Client) ImageSummary(ctx context.Context, ref string) (ImageSummary, error) {
+	inspect, _, err := c.d.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return ImageSummary{}, fmt.Errorf("%w: %s", ImageNotFoundErr, ref)
+		}
+		return ImageSummary{}, fmt.Errorf("%w: %w", ImageInspectErr, err)
+	}
+	return summarizeImage(ref, inspect), nil
+}
+
+// summarizeImage extracts the summary fields of an image inspect, with
+// the ports sorted
+func summarizeImage(ref string, inspect types.ImageInspect) ImageSummary {
+	s := ImageSummary{
+		Ref:      ref,
+		ID:       inspect.ID,
+		Platform: inspect.Os + "/" + inspect.Architecture,
+	}
+	if inspect.Variant != "" {
+		s.Platform += "/" + inspect.Variant
+	}
+	cfg := inspect.Config
+	if cfg == nil {
+		return s
+	}
+	s.User = cfg.User
+	s.WorkingDir = cfg.WorkingDir
+	s.Entrypoint = cfg.Entrypoint
+	s.Cmd = cfg.Cmd
+	s.Env = cfg.Env
+	for p := range cfg.ExposedPorts {
+		s.ExposedPorts = append(s.ExposedPorts, string(p))
+	}
+	sort.Strings(s.ExposedPorts)
+	return s
+}
+
+// Write prints the summary in a compact key: value form
+func (s ImageSummary) Write(w io.Writer) {
+	orNone := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+	_, _ = fmt.Fprintln(w, "Image:     ", s.Ref)
+	_, _ = fmt.Fprintln(w, "ID:        ", s.ID)
+	_, _ = fmt.Fprintln(w, "Platform:  ", s.Platform)
+	_, _ = fmt.Fprintln(w, "User:      ", orNone(s.User))
+	_, _ = fmt.Fprintln(w, "Workdir:   ", orNone(s.WorkingDir))
+	_, _ = fmt.Fprintln(w, "Entrypoint:", orNone(jsonArrayOrEmpty(s.Entrypoint)))
+	_, _ = fmt.Fprintln(w, "Cmd:       ", orNone(jsonArrayOrEmpty(s.Cmd)))
+	_, _ = fmt.Fprintln(w, "Ports:     ", orNone(strings.Join(s.ExposedPorts, ", ")))
+	if len(s.Env) == 0 {
+		_, _ = fmt.Fprintln(w, "Env:        -")
+		return
+	}
+	_, _ = fmt.Fprintln(w, "Env:")
+	for _, e := range s.Env {
+		_, _ = fmt.Fprintln(w, "  "+e)
+	}
+}
+
+func jsonArrayOrEmpty(a []string) string {
+	if len(a) == 0 {
+		return ""
+	}
+	return jsonArray(a)
+}
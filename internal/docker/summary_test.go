@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// missingImageAPI fakes a daemon without any image
+type missingImageAPI struct {
+	client.APIClient
+}
+
+func (missingImageAPI) ImageInspectWithRaw(_ context.Context, ref string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("No such image: " + ref))
+}
+
+func TestImageSummaryWrite(t *testing.T) {
+	inspect := types.ImageInspect{
+		ID:           testImageID,
+		Os:           "linux",
+		Architecture: "arm64",
+		Variant:      "v8",
+		Config: &container.Config{
+			User:       "app",
+			WorkingDir: "/srv",
+			Entrypoint: []string{"/docker-entrypoint.sh"},
+			Cmd:        []string{"nginx", "-g", "daemon off;"},
+			Env:        []string{"PATH=/usr/bin", "NGINX_VERSION=1.25"},
+			ExposedPorts: nat.PortSet{
+				"8443/tcp": {},
+				"80/tcp":   {},
+			},
+		},
+	}
+	var b bytes.Buffer
+	summarizeImage("nginx:1.25", inspect).Write(&b)
+	want := `Image:      nginx:1.25
+ID:         ` + testImageID + `
+Platform:   linux/arm64/v8
+User:       app
+Workdir:    /srv
+Entrypoint: ["/docker-entrypoint.sh"]
+Cmd:        ["nginx","-g","daemon off;"]
+Ports:      80/tcp, 8443/tcp
+Env:
+  PATH=/usr/bin
+  NGINX_VERSION=1.25
+`
+	if b.String() != want {
+		t.Errorf("summary =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestImageSummaryWriteNoConfig(t *testing.T) {
+	var b bytes.Buffer
+	summarizeImage("scratch-app", types.ImageInspect{ID: testImageID, Os: "linux", Architecture: "amd64"}).Write(&b)
+	want := `Image:      scratch-app
+ID:         ` + testImageID + `
+Platform:   linux/amd64
+User:       -
+Workdir:    -
+Entrypoint: -
+Cmd:        -
+Ports:      -
+Env:        -
+`
+	if b.String() != want {
+		t.Errorf("summary =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestImageSummaryNotFound(t *testing.T) {
+	_, err := newTestClient(missingImageAPI{}).ImageSummary(context.Background(), "nginx:1.25")
+	if !errors.Is(err, ImageNotFoundErr) {
+		t.Errorf("ImageSummary err = %v, want %v", err, ImageNotFoundErr)
+	}
+}
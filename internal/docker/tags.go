@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+var (
+	InvalidTagErr = errors.New("invalid image tag")
+	ImageTagErr   = errors.New("failed to tag image")
+)
+
+// isBareTag tells if t is a tag alone, without repository
+func isBareTag(t string) bool {
+	return !strings.ContainsAny(t, "/:@")
+}
+
+// buildTags resolves the tag of the built image (the default one, with
+// the target stage as tag, if opts.Tag is empty) and its extra tags,
+// a bare tag taking the repository of the main one
+func buildTags(opts BuildOptions) (string, []string, error) {
+	tag := opts.Tag
+	switch {
+	case tag == "" && opts.Target != "":
+		tag = buildTag + ":" + opts.Target
+	case tag == "":
+		tag = buildTag
+	case isBareTag(tag):
+		tag = buildTag + ":" + tag
+	}
+	named, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %q: %w", InvalidTagErr, tag, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", nil, fmt.Errorf("%w: %q has a digest", InvalidTagErr, tag)
+	}
+	repo := reference.FamiliarName(named)
+	var also []string
+	for _, t := range opts.AlsoTags {
+		if isBareTag(t) {
+			t = repo + ":" + t
+		}
+		n, err := reference.ParseNormalizedNamed(t)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %q: %w", InvalidTagErr, t, err)
+		}
+		if _, ok := n.(reference.Digested); ok {
+			return "", nil, fmt.Errorf("%w: %q has a digest", InvalidTagErr, t)
+		}
+		if t != tag && !slices.Contains(also, t) {
+			also = append(also, t)
+		}
+	}
+	return tag, also, nil
+}
+
+// tagAlso applies the extra tags to the built image, which isn't
+// rebuilt
+func (c Client) tagAlso(ctx context.Context, out io.Writer, res *BuildResult, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if res.ImageID == "" {
+		return fmt.Errorf("%w: the built image ID is unknown", ImageTagErr)
+	}
+	for _, t := range tags {
+		if err := c.d.ImageTag(ctx, res.ImageID, t); err != nil {
+			return fmt.Errorf("%w: %s: %w", ImageTagErr, t, err)
+		}
+		_, _ = fmt.Fprintln(out, "Tagged", t)
+	}
+	res.AlsoTags = tags
+	return nil
+}
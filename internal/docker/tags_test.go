@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     BuildOptions
+		wantTag  string
+		wantAlso []string
+		wantErr  bool
+	}{
+		{name: "default", wantTag: buildTag},
+		{name: "default with target", opts: BuildOptions{Target: "build"}, wantTag: buildTag + ":build"},
+		{name: "bare tag", opts: BuildOptions{Tag: "1.2.3"}, wantTag: buildTag + ":1.2.3"},
+		{name: "tag over target", opts: BuildOptions{Tag: "app:1", Target: "build"}, wantTag: "app:1"},
+		{
+			name:     "also tags",
+			opts:     BuildOptions{Tag: "registry.local:5000/app:1.2.3", AlsoTags: []string{"1.2", "latest", "other/app:stable"}},
+			wantTag:  "registry.local:5000/app:1.2.3",
+			wantAlso: []string{"registry.local:5000/app:1.2", "registry.local:5000/app:latest", "other/app:stable"},
+		},
+		{
+			name:     "bare also tag on the default repository",
+			opts:     BuildOptions{AlsoTags: []string{"latest"}},
+			wantTag:  buildTag,
+			wantAlso: []string{buildTag + ":latest"},
+		},
+		{
+			name:     "duplicates",
+			opts:     BuildOptions{Tag: "app:1", AlsoTags: []string{"1", "latest", "app:latest"}},
+			wantTag:  "app:1",
+			wantAlso: []string{"app:latest"},
+		},
+		{name: "invalid tag", opts: BuildOptions{Tag: "App:1"}, wantErr: true},
+		{name: "digest", opts: BuildOptions{Tag: "app@sha256:" + strings.Repeat("a", 64)}, wantErr: true},
+		{name: "invalid also tag", opts: BuildOptions{Tag: "app:1", AlsoTags: []string{"bad tag"}}, wantErr: true},
+		{name: "also tag digest", opts: BuildOptions{AlsoTags: []string{"app@sha256:" + strings.Repeat("a", 64)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, also, err := buildTags(tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidTagErr) {
+					t.Errorf("err = %v, want %v", err, InvalidTagErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildTags: %v", err)
+			}
+			if tag != tt.wantTag || !equalStrings(also, tt.wantAlso) {
+				t.Errorf("buildTags = %q, %q, want %q, %q", tag, also, tt.wantTag, tt.wantAlso)
+			}
+		})
+	}
+}
+
+func TestBuildAlsoTags(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM golang:1.22 AS build\nFROM alpine:3.19\n")}}
+	tests := []struct {
+		name       string
+		opts       BuildOptions
+		wantBuilt  [][]string
+		wantTagged []string
+	}{
+		{
+			name:       "tagged",
+			opts:       BuildOptions{Tag: "app:1.2.3", AlsoTags: []string{"1.2", "latest"}},
+			wantBuilt:  [][]string{{"app:1.2.3"}},
+			wantTagged: []string{"app:1.2", "app:latest"},
+		},
+		{
+			name:       "also final",
+			opts:       BuildOptions{Tag: "app:1.2.3", AlsoTags: []string{"latest"}, Target: "build", AlsoFinal: true},
+			wantBuilt:  [][]string{{buildTag + ":build"}, {"app:1.2.3"}},
+			wantTagged: []string{"app:latest"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{}
+			var out strings.Builder
+			res, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, &out, nil)
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if len(api.options) != len(tt.wantBuilt) {
+				t.Fatalf("%d builds, want %d", len(api.options), len(tt.wantBuilt))
+			}
+			for i, o := range api.options {
+				if !equalStrings(o.Tags, tt.wantBuilt[i]) {
+					t.Errorf("build %d tags = %v, want %v", i, o.Tags, tt.wantBuilt[i])
+				}
+			}
+			if !equalStrings(api.tagged(), tt.wantTagged) || !equalStrings(res.AlsoTags, tt.wantTagged) {
+				t.Errorf("tagged %v (AlsoTags %v), want %v", api.tagged(), res.AlsoTags, tt.wantTagged)
+			}
+			for _, tag := range tt.wantTagged {
+				if !strings.Contains(out.String(), "Tagged "+tag+"\n") {
+					t.Errorf("output = %q, want it to report %s", out.String(), tag)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTagErrors(t *testing.T) {
+	fsys := fstest.MapFS{dockerfileName: {Data: []byte("FROM alpine:3.19\n")}}
+	tests := []struct {
+		name    string
+		opts    BuildOptions
+		stream  string
+		wantErr error
+	}{
+		{name: "invalid", opts: BuildOptions{Tag: "App:1"}, wantErr: InvalidTagErr},
+		{name: "ephemeral", opts: BuildOptions{Ephemeral: true, AlsoTags: []string{"latest"}}, wantErr: InvalidTagErr},
+		{name: "image ID unknown", opts: BuildOptions{AlsoTags: []string{"latest"}}, stream: streamMessage(map[string]any{"stream": "exported to the registry\n"}), wantErr: ImageTagErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &buildAPI{stream: tt.stream}
+			_, err := newTestClient(api).build(context.Background(), fsContextName, fsys, tt.opts, io.Discard, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(api.tagged()) != 0 {
+				t.Errorf("tagged %v, want none", api.tagged())
+			}
+		})
+	}
+}
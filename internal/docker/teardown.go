@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	InvalidTeardownPolicyErr = errors.New("invalid teardown policy")
+)
+
+// TeardownPolicy tells when the run containers and volumes are removed
+type TeardownPolicy string
+
+const (
+	// TeardownAlways removes them once the run ends
+	TeardownAlways TeardownPolicy = "always"
+	// TeardownOnSuccess keeps them when the container exits with a non
+	// zero code or the run fails, for inspection
+	TeardownOnSuccess TeardownPolicy = "on-success"
+	// TeardownNever keeps them
+	TeardownNever TeardownPolicy = "never"
+)
+
+// ParseTeardownPolicy validates a teardown policy, defaulting to
+// TeardownAlways
+func ParseTeardownPolicy(s string) (TeardownPolicy, error) {
+	switch p := TeardownPolicy(s); p {
+	case "":
+		return TeardownAlways, nil
+	case TeardownAlways, TeardownOnSuccess, TeardownNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %q (expected %s, %s or %s)", InvalidTeardownPolicyErr, s, TeardownAlways, TeardownOnSuccess, TeardownNever)
+}
+
+// keep tells if the policy keeps the resources of a run ending with
+// the exit code and error
+func (p TeardownPolicy) keep(code int, err error) bool {
+	switch p {
+	case TeardownNever:
+		return true
+	case TeardownOnSuccess:
+		return code != 0 || err != nil
+	}
+	return false
+}
+
+// printKept prints to out the commands to inspect and remove the kept
+// container and volumes
+func printKept(out io.Writer, container string, volumes []string) {
+	_, _ = fmt.Fprintln(out, "Kept container", container, "for inspection:")
+	_, _ = fmt.Fprintln(out, "  docker logs", container)
+	_, _ = fmt.Fprintln(out, "  docker-runner shell --attach", container)
+	_, _ = fmt.Fprintln(out, "  docker start -ai", container)
+	_, _ = fmt.Fprintln(out, "  docker rm -f", container)
+	if len(volumes) > 0 {
+		_, _ = fmt.Fprintln(out, "  docker volume rm", strings.Join(volumes, " "))
+	}
+}
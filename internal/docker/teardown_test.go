@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTeardownPolicyKeep(t *testing.T) {
+	runErr := errors.New("assertion failed")
+	tests := []struct {
+		policy TeardownPolicy
+		code   int
+		err    error
+		want   bool
+	}{
+		{TeardownAlways, 0, nil, false},
+		{TeardownAlways, 1, nil, false},
+		{TeardownAlways, 0, runErr, false},
+		{TeardownOnSuccess, 0, nil, false},
+		{TeardownOnSuccess, 1, nil, true},
+		{TeardownOnSuccess, 0, runErr, true},
+		{TeardownNever, 0, nil, true},
+		{TeardownNever, 1, nil, true},
+		{TeardownNever, 0, runErr, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.keep(tt.code, tt.err); got != tt.want {
+			t.Errorf("%s keep(%d, %v) = %v, want %v", tt.policy, tt.code, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseTeardownPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TeardownPolicy
+		wantErr bool
+	}{
+		{in: "", want: TeardownAlways},
+		{in: "always", want: TeardownAlways},
+		{in: "on-success", want: TeardownOnSuccess},
+		{in: "never", want: TeardownNever},
+		{in: "on-failure", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTeardownPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTeardownPolicy(%q) = %q, %v, want %q (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRunTeardown(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TeardownPolicy
+		keep   bool
+		code   int64
+		want   bool
+	}{
+		{name: "always passing", policy: TeardownAlways, code: 0, want: false},
+		{name: "always failing", policy: TeardownAlways, code: 2, want: false},
+		{name: "on-success passing", policy: TeardownOnSuccess, code: 0, want: false},
+		{name: "on-success failing", policy: TeardownOnSuccess, code: 2, want: true},
+		{name: "never passing", policy: TeardownNever, code: 0, want: true},
+		{name: "never failing", policy: TeardownNever, code: 2, want: true},
+		{name: "keep", policy: TeardownAlways, keep: true, code: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &runAPI{exitCode: tt.code}
+			code, err := Client{d: api}.Run(context.Background(), "alpine:3.19", RunOptions{Teardown: tt.policy, Keep: tt.keep})
+			if err != nil || code != int(tt.code) {
+				t.Fatalf("Run = %d, %v, want %d", code, err, tt.code)
+			}
+			if kept := !api.removed; kept != tt.want {
+				t.Errorf("container kept = %v, want %v", kept, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintKept(t *testing.T) {
+	var out bytes.Buffer
+	printKept(&out, "api-test", []string{"vol1", "vol2"})
+	for _, want := range []string{
+		"docker logs api-test",
+		"docker-runner shell --attach api-test",
+		"docker rm -f api-test",
+		"docker volume rm vol1 vol2",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printKept output = %q, missing %q", out.String(), want)
+		}
+	}
+}
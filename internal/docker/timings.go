@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	TimingsWriteErr = errors.New("failed to write timings chart")
+)
+
+const (
+	timingsWidth     = 960
+	timingsLabelSize = 320
+	timingsRowHeight = 22
+	timingsBarHeight = 16
+)
+
+// WriteTimingsSVG renders the step durations as an SVG timeline: one
+// bar per step, starting where the previous one ended, with a width
+// proportional to its share of the whole build
+func WriteTimingsSVG(w io.Writer, steps []BuildStep) error {
+	var total time.Duration
+	for _, s := range steps {
+		total += s.Duration
+	}
+	chart := float64(timingsWidth - timingsLabelSize)
+	height := timingsRowHeight*len(steps) + timingsRowHeight
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", timingsWidth, height)
+	fmt.Fprintf(&b, `<text x="4" y="%d">total %s</text>`+"\n", timingsRowHeight-6, total.Round(time.Millisecond))
+	var x float64
+	for i, s := range steps {
+		var width float64
+		if total > 0 {
+			width = chart * float64(s.Duration) / float64(total)
+		}
+		y := timingsRowHeight * (i + 1)
+		color := "#e8743b"
+		if s.Cached {
+			color = "#9ab8d4"
+		}
+		label := fmt.Sprintf("%d/%d %s", s.Number, s.Total, s.Instruction)
+		if r := []rune(label); len(r) > 40 {
+			label = string(r[:39]) + "…"
+		}
+		fmt.Fprintf(&b, `<text x="4" y="%d">%s</text>`+"\n", y+timingsBarHeight-4, xmlEscape(label))
+		fmt.Fprintf(&b, `<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s"><title>%s (%s)</title></rect>`+"\n",
+			float64(timingsLabelSize)+x, y, width, timingsBarHeight, color, xmlEscape(s.Instruction), s.Duration.Round(time.Millisecond))
+		x += width
+	}
+	b.WriteString("</svg>\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("%w: %w", TimingsWriteErr, err)
+	}
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
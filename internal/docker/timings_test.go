@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// timingsChart is the part of the timings SVG the tests look at
+type timingsChart struct {
+	Width int      `xml:"width,attr"`
+	Texts []string `xml:"text"`
+	Rects []struct {
+		X     string `xml:"x,attr"`
+		Width string `xml:"width,attr"`
+		Fill  string `xml:"fill,attr"`
+		Title string `xml:"title"`
+	} `xml:"rect"`
+}
+
+func TestWriteTimingsSVG(t *testing.T) {
+	steps := []BuildStep{
+		{Number: 1, Total: 3, Instruction: "FROM golang:1.22", Cached: true, Duration: 100 * time.Millisecond},
+		{Number: 2, Total: 3, Instruction: "RUN go build -o /app ./cmd/app", Duration: 2700 * time.Millisecond},
+		{Number: 3, Total: 3, Instruction: `COPY <config> "/etc/app"`, Duration: 200 * time.Millisecond},
+	}
+	var b bytes.Buffer
+	if err := WriteTimingsSVG(&b, steps); err != nil {
+		t.Fatalf("WriteTimingsSVG: %v", err)
+	}
+	var chart timingsChart
+	if err := xml.Unmarshal(b.Bytes(), &chart); err != nil {
+		t.Fatalf("parsing the SVG: %v\n%s", err, b.String())
+	}
+	if chart.Width != timingsWidth {
+		t.Errorf("width = %d, want %d", chart.Width, timingsWidth)
+	}
+	if len(chart.Rects) != len(steps) {
+		t.Fatalf("%d bars, want %d", len(chart.Rects), len(steps))
+	}
+	if len(chart.Texts) != len(steps)+1 || chart.Texts[0] != "total 3s" {
+		t.Errorf("texts = %q, want the total and a label per step", chart.Texts)
+	}
+
+	parse := func(s string) float64 {
+		t.Helper()
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", s, err)
+		}
+		return f
+	}
+	chartWidth := float64(timingsWidth - timingsLabelSize)
+	x := float64(timingsLabelSize)
+	for i, r := range chart.Rects {
+		want := chartWidth * float64(steps[i].Duration) / float64(3*time.Second)
+		if w := parse(r.Width); math.Abs(w-want) > 0.01 {
+			t.Errorf("bar %d width = %.2f, want %.2f", i, w, want)
+		}
+		// each bar starts where the previous one ended
+		if got := parse(r.X); math.Abs(got-x) > 0.01 {
+			t.Errorf("bar %d x = %.2f, want %.2f", i, got, x)
+		}
+		x += want
+	}
+	if chart.Rects[0].Fill == chart.Rects[1].Fill {
+		t.Errorf("cached and executed bars share the %s fill", chart.Rects[0].Fill)
+	}
+	if chart.Rects[2].Title != `COPY <config> "/etc/app" (200ms)` {
+		t.Errorf("title = %q, want the escaped instruction and duration", chart.Rects[2].Title)
+	}
+}
+
+func TestWriteTimingsSVGNoDuration(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteTimingsSVG(&b, []BuildStep{{Number: 1, Total: 1, Instruction: "FROM scratch"}}); err != nil {
+		t.Fatalf("WriteTimingsSVG: %v", err)
+	}
+	var chart timingsChart
+	if err := xml.Unmarshal(b.Bytes(), &chart); err != nil {
+		t.Fatalf("parsing the SVG: %v", err)
+	}
+	if len(chart.Rects) != 1 || chart.Rects[0].Width != "0.00" {
+		t.Errorf("bars = %+v, want one empty bar", chart.Rects)
+	}
+}
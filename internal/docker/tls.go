@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+var (
+	InvalidTLSSpecErr = errors.New("invalid TLS spec")
+	TLSGenErr         = errors.New("failed to generate TLS certificates")
+)
+
+const (
+	// DefaultTLSDir is the container folder of the generated files
+	DefaultTLSDir = "/run/runner-tls"
+	// ProbeEnvTLSCA is the probe env var holding the host path of the
+	// generated CA certificate
+	ProbeEnvTLSCA = "RUNNER_TLS_CA"
+
+	tlsCAFile   = "ca.crt"
+	tlsCertFile = "tls.crt"
+	tlsKeyFile  = "tls.key"
+	// tlsValidity is long enough for any test run, the certificates
+	// being thrown away with the container
+	tlsValidity = 24 * time.Hour
+)
+
+// TLSSpec defines the throwaway CA and leaf certificate generated for
+// a run
+type TLSSpec struct {
+	// CN is the leaf common name, also added as a DNS SAN
+	CN string
+	// SANs are the leaf DNS names and IP addresses
+	SANs []string
+	// Dir is the container folder of ca.crt, tls.crt and tls.key
+	// (DefaultTLSDir if empty)
+	Dir string
+}
+
+// ParseTLSSpec parses a cn=NAME,san=NAME,...,dir=/path spec, where san
+// can be repeated
+func ParseTLSSpec(s string) (TLSSpec, error) {
+	var spec TLSSpec
+	for _, field := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || v == "" {
+			return TLSSpec{}, fmt.Errorf("%w: %q is not a key=value pair", InvalidTLSSpecErr, field)
+		}
+		switch k {
+		case "cn":
+			spec.CN = v
+		case "san":
+			spec.SANs = append(spec.SANs, v)
+		case "dir":
+			if !strings.HasPrefix(v, "/") {
+				return TLSSpec{}, fmt.Errorf("%w: dir %q isn't an absolute container path", InvalidTLSSpecErr, v)
+			}
+			spec.Dir = path.Clean(v)
+		default:
+			return TLSSpec{}, fmt.Errorf("%w: unknown key %q (expected cn, san or dir)", InvalidTLSSpecErr, k)
+		}
+	}
+	if !spec.enabled() {
+		return TLSSpec{}, fmt.Errorf("%w: %q has no cn nor san", InvalidTLSSpecErr, s)
+	}
+	return spec, nil
+}
+
+// enabled tells if the spec names anything to generate a certificate
+// for
+func (s TLSSpec) enabled() bool {
+	return s.CN != "" || len(s.SANs) > 0
+}
+
+func (s TLSSpec) dir() string {
+	if s.Dir == "" {
+		return DefaultTLSDir
+	}
+	return s.Dir
+}
+
+// tlsMaterial holds the PEM encoded CA certificate and leaf pair. The
+// CA key only lives while signing the leaf.
+type tlsMaterial struct {
+	CA   []byte
+	Cert []byte
+	Key  []byte
+}
+
+// generateTLS creates a CA and a leaf certificate for the spec names,
+// both with ECDSA P-256 keys, in memory
+func generateTLS(spec TLSSpec) (tlsMaterial, error) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          tlsSerial(),
+		Subject:               pkix.Name{CommonName: "docker-runner test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(tlsValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: tlsSerial(),
+		Subject:      pkix.Name{CommonName: spec.CN},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(tlsValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	names := spec.SANs
+	if spec.CN != "" {
+		names = append([]string{spec.CN}, names...)
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tlsMaterial{}, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	return tlsMaterial{
+		CA:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func tlsSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return n
+}
+
+// copyTLS copies the generated files to the created container, without
+// going through the host file system. The key is world readable as the
+// image user isn't known, it's thrown away with the container anyway.
+func (c Client) copyTLS(ctx context.Context, id string, spec TLSSpec, m tlsMaterial) error {
+	dir := strings.TrimPrefix(spec.dir(), "/")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o755}); err != nil {
+		return fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{tlsCAFile, m.CA},
+		{tlsCertFile, m.Cert},
+		{tlsKeyFile, m.Key},
+	}
+	for _, f := range files {
+		h := &tar.Header{Typeflag: tar.TypeReg, Name: path.Join(dir, f.name), Mode: 0o644, Size: int64(len(f.content))}
+		if err := tw.WriteHeader(h); err != nil {
+			return fmt.Errorf("%w: %w", TLSGenErr, err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return fmt.Errorf("%w: %w", TLSGenErr, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	if err := c.d.CopyToContainer(ctx, id, "/", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	fmt.Printf("TLS certificates in %s (%s, %s, %s)\n", spec.dir(), tlsCAFile, tlsCertFile, tlsKeyFile)
+	return nil
+}
+
+// writeTLSCA writes the CA certificate to the host file path, or to a
+// new temporary file if empty, returning its path and a cleanup
+// removing the temporary file
+func writeTLSCA(path string, ca []byte) (string, func(), error) {
+	if path != "" {
+		if err := os.WriteFile(path, ca, 0o644); err != nil {
+			return "", nil, fmt.Errorf("%w: %w", TLSGenErr, err)
+		}
+		return path, func() {}, nil
+	}
+	f, err := os.CreateTemp("", "docker-runner-ca-*.crt")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	_, err = f.Write(ca)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	cleanup := func() {
+		_ = os.Remove(f.Name())
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("%w: %w", TLSGenErr, err)
+	}
+	return f.Name(), cleanup, nil
+}
@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTLSSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    TLSSpec
+		wantErr bool
+	}{
+		{spec: "cn=api.test", want: TLSSpec{CN: "api.test"}},
+		{spec: "cn=api.test, san=localhost,san=127.0.0.1", want: TLSSpec{CN: "api.test", SANs: []string{"localhost", "127.0.0.1"}}},
+		{spec: "san=localhost,dir=/etc/certs/", want: TLSSpec{SANs: []string{"localhost"}, Dir: "/etc/certs"}},
+		{spec: "dir=/etc/certs", wantErr: true},
+		{spec: "cn=api.test,dir=certs", wantErr: true},
+		{spec: "cn=api.test,key=rsa", wantErr: true},
+		{spec: "cn=", wantErr: true},
+		{spec: "api.test", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseTLSSpec(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, InvalidTLSSpecErr) {
+					t.Errorf("err = %v, want %v", err, InvalidTLSSpecErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTLSSpec: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTLSSpec = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// parseCert parses the PEM encoded certificate
+func parseCert(t *testing.T, b []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("%q isn't a PEM certificate", b)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGenerateTLS(t *testing.T) {
+	m, err := generateTLS(TLSSpec{CN: "api.test", SANs: []string{"localhost", "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("generateTLS: %v", err)
+	}
+	ca, leaf := parseCert(t, m.CA), parseCert(t, m.Cert)
+	if !ca.IsCA || leaf.IsCA {
+		t.Errorf("IsCA = %v, %v, want only the CA to be one", ca.IsCA, leaf.IsCA)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, name := range []string{"api.test", "localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: name}); err != nil {
+			t.Errorf("Verify(%s): %v", name, err)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "other.test"}); err == nil {
+		t.Errorf("Verify(other.test) passed, want it to fail")
+	}
+
+	block, _ := pem.Decode(m.Key)
+	if block == nil {
+		t.Fatalf("%q isn't a PEM key", m.Key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePKCS8PrivateKey: %v", err)
+	}
+	if ec, ok := key.(*ecdsa.PrivateKey); !ok || !ec.PublicKey.Equal(leaf.PublicKey) {
+		t.Errorf("the key doesn't match the certificate")
+	}
+}
+
+func TestCopyTLS(t *testing.T) {
+	m := tlsMaterial{CA: []byte("ca"), Cert: []byte("cert"), Key: []byte("key")}
+	api := &volumeAPI{}
+	if err := newTestClient(api).copyTLS(context.Background(), testContainerID, TLSSpec{CN: "api.test"}, m); err != nil {
+		t.Fatalf("copyTLS: %v", err)
+	}
+	req := readTar(t, io.NopCloser(bytes.NewReader(api.content)))
+	want := map[string]string{
+		"run/runner-tls/":        "",
+		"run/runner-tls/ca.crt":  "ca",
+		"run/runner-tls/tls.crt": "cert",
+		"run/runner-tls/tls.key": "key",
+	}
+	if !reflect.DeepEqual(req.Files, want) {
+		t.Errorf("copied files = %v, want %v", req.Files, want)
+	}
+}
+
+func TestWriteTLSCA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	got, cleanup, err := writeTLSCA(path, []byte("ca"))
+	if err != nil {
+		t.Fatalf("writeTLSCA: %v", err)
+	}
+	cleanup()
+	if b, err := os.ReadFile(path); got != path || err != nil || string(b) != "ca" {
+		t.Errorf("writeTLSCA = %q, content %q, %v, want the CA kept in %s", got, b, err, path)
+	}
+
+	tmp, cleanup, err := writeTLSCA("", []byte("ca"))
+	if err != nil {
+		t.Fatalf("writeTLSCA: %v", err)
+	}
+	if b, err := os.ReadFile(tmp); err != nil || string(b) != "ca" {
+		t.Errorf("temporary CA content = %q, %v, want ca", b, err)
+	}
+	cleanup()
+	if _, err := os.Stat(tmp); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary CA stat err = %v, want it removed", err)
+	}
+}
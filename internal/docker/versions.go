@@ -0,0 +1,126 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// versionProbeTimeout bounds each version probe, which is expected
+	// to print and exit at once
+	versionProbeTimeout = 5 * time.Second
+	// versionUnavailable is the version of a probe whose binary is
+	// missing or that failed
+	versionUnavailable = "unavailable"
+)
+
+// RuntimeVersion is the outcome of a version probe
+type RuntimeVersion struct {
+	// Cmd is the probe command line
+	Cmd string `json:"cmd"`
+	// Version is the first output line (versionUnavailable if the
+	// probe failed)
+	Version string `json:"version"`
+	// Reason tells why the version is unavailable
+	Reason string `json:"reason,omitempty"`
+}
+
+// probeVersions execs each version probe command line (split on spaces,
+// without shell as the image may have none) in the container, if it's
+// running, and returns the first line each one prints
+func (c Client) probeVersions(ctx context.Context, id string, cmds []string) []RuntimeVersion {
+	if len(cmds) == 0 {
+		return nil
+	}
+	inspect, err := c.d.ContainerInspect(ctx, id)
+	if err != nil || inspect.State == nil || !inspect.State.Running || inspect.State.Paused || inspect.State.Restarting {
+		return nil
+	}
+	res := make([]RuntimeVersion, 0, len(cmds))
+	for _, cmd := range cmds {
+		argv := strings.Fields(cmd)
+		if len(argv) == 0 {
+			continue
+		}
+		v, err := c.probeVersion(ctx, id, argv)
+		if err != nil {
+			res = append(res, RuntimeVersion{Cmd: cmd, Version: versionUnavailable, Reason: err.Error()})
+			continue
+		}
+		res = append(res, RuntimeVersion{Cmd: cmd, Version: v})
+	}
+	return res
+}
+
+// probeVersion runs argv in the container, failing if it isn't found,
+// exits with an error or runs for longer than versionProbeTimeout
+func (c Client) probeVersion(ctx context.Context, id string, argv []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionProbeTimeout)
+	defer cancel()
+	exec, err := c.d.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          argv,
+	})
+	if err != nil {
+		return "", err
+	}
+	attach, err := c.d.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", err
+	}
+	defer attach.Close()
+	// the hijacked connection ignores the context
+	stop := context.AfterFunc(ctx, attach.Close)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	_, _ = stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("timed out after %s", versionProbeTimeout)
+	}
+	inspect, err := c.d.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case inspect.ExitCode == 126 || inspect.ExitCode == 127:
+		return "", fmt.Errorf("%s not found", argv[0])
+	case inspect.ExitCode != 0:
+		return "", fmt.Errorf("exited with code %d", inspect.ExitCode)
+	}
+	// some tools print their version to stderr
+	out := stdout.String()
+	if strings.TrimSpace(out) == "" {
+		out = stderr.String()
+	}
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("printed nothing")
+}
+
+// printRuntimeVersions prints the version probes outcome
+func printRuntimeVersions(versions []RuntimeVersion) {
+	if len(versions) == 0 {
+		return
+	}
+	fmt.Println("Runtime versions:")
+	for _, v := range versions {
+		if v.Reason != "" {
+			fmt.Printf("  %s: %s (%s)\n", v.Cmd, v.Version, v.Reason)
+			continue
+		}
+		fmt.Printf("  %s: %s\n", v.Cmd, v.Version)
+	}
+}
@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// versionExec is the outcome of an exec'ed version probe
+type versionExec struct {
+	stdout, stderr string
+	code           int
+	// hang keeps the output stream open until the probe gives up
+	hang bool
+}
+
+// versionsAPI fakes a container whose execs, by program, end as the
+// execs (127 for the unknown ones)
+type versionsAPI struct {
+	client.APIClient
+	state *types.ContainerState
+	execs map[string]versionExec
+	ran   [][]string
+}
+
+func (f *versionsAPI) ContainerInspect(_ context.Context, id string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id, State: f.state}}, nil
+}
+
+func (f *versionsAPI) ContainerExecCreate(_ context.Context, _ string, cfg types.ExecConfig) (types.IDResponse, error) {
+	f.ran = append(f.ran, cfg.Cmd)
+	return types.IDResponse{ID: cfg.Cmd[0]}, nil
+}
+
+func (f *versionsAPI) ContainerExecAttach(_ context.Context, id string, _ types.ExecStartCheck) (types.HijackedResponse, error) {
+	conn, daemon := net.Pipe()
+	e := f.execs[id]
+	go func() {
+		if e.hang {
+			return
+		}
+		_, _ = stdcopy.NewStdWriter(daemon, stdcopy.Stdout).Write([]byte(e.stdout))
+		_, _ = stdcopy.NewStdWriter(daemon, stdcopy.Stderr).Write([]byte(e.stderr))
+		_ = daemon.Close()
+	}()
+	return types.NewHijackedResponse(conn, ""), nil
+}
+
+func (f *versionsAPI) ContainerExecInspect(_ context.Context, id string) (types.ContainerExecInspect, error) {
+	e, ok := f.execs[id]
+	if !ok {
+		return types.ContainerExecInspect{ExecID: id, ExitCode: 127}, nil
+	}
+	return types.ContainerExecInspect{ExecID: id, ExitCode: e.code}, nil
+}
+
+func TestProbeVersions(t *testing.T) {
+	api := &versionsAPI{
+		state: &types.ContainerState{Running: true},
+		execs: map[string]versionExec{
+			"node":   {stdout: "v20.11.0\n"},
+			"java":   {stderr: "\nopenjdk version \"21.0.2\" 2024-01-16\nOpenJDK Runtime Environment\n"},
+			"python": {stdout: "\n  Python 3.12.1  \n"},
+			"go":     {stderr: "unknown flag\n", code: 2},
+			"true":   {},
+			"sh":     {code: 126},
+		},
+	}
+	got := newTestClient(api).probeVersions(context.Background(), "app", []string{
+		"node --version",
+		"java -version",
+		"python  -V",
+		"go --version",
+		"true",
+		"sh -c version",
+		"ruby --version",
+		"   ",
+	})
+	want := []RuntimeVersion{
+		{Cmd: "node --version", Version: "v20.11.0"},
+		{Cmd: "java -version", Version: `openjdk version "21.0.2" 2024-01-16`},
+		{Cmd: "python  -V", Version: "Python 3.12.1"},
+		{Cmd: "go --version", Version: versionUnavailable, Reason: "exited with code 2"},
+		{Cmd: "true", Version: versionUnavailable, Reason: "printed nothing"},
+		{Cmd: "sh -c version", Version: versionUnavailable, Reason: "sh not found"},
+		{Cmd: "ruby --version", Version: versionUnavailable, Reason: "ruby not found"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("probeVersions =\n%+v\nwant\n%+v", got, want)
+	}
+	if !reflect.DeepEqual(api.ran[2], []string{"python", "-V"}) {
+		t.Errorf("exec'ed %q, want the command line split without a shell", api.ran[2])
+	}
+}
+
+func TestProbeVersionsNotRunning(t *testing.T) {
+	tests := []struct {
+		name  string
+		state *types.ContainerState
+	}{
+		{name: "no state"},
+		{name: "exited", state: &types.ContainerState{Status: "exited"}},
+		{name: "paused", state: &types.ContainerState{Running: true, Paused: true}},
+		{name: "restarting", state: &types.ContainerState{Running: true, Restarting: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &versionsAPI{state: tt.state}
+			if got := newTestClient(api).probeVersions(context.Background(), "app", []string{"node --version"}); got != nil || len(api.ran) != 0 {
+				t.Errorf("probeVersions = %v after %d execs, want nothing run", got, len(api.ran))
+			}
+		})
+	}
+}
+
+func TestProbeVersionHung(t *testing.T) {
+	api := &versionsAPI{
+		state: &types.ContainerState{Running: true},
+		execs: map[string]versionExec{"node": {hang: true}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestClient(api).probeVersion(ctx, "app", []string{"node", "--version"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("probe gave up after %s, want the hijacked stream closed on timeout", d)
+	}
+}
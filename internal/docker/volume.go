@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	VolumeBuildErr          = errors.New("failed to build volume")
+	VolumeExistsErr         = errors.New("volume already exists")
+	VolumeVerifyErr         = errors.New("failed to verify volume")
+	VolumeDigestMismatchErr = errors.New("volume content digest mismatch")
+)
+
+const (
+	// volumeHelperImage is used to create the (never started)
+	// containers the volumes are mounted in to copy content
+	volumeHelperImage = helperImage
+	volumeMountPoint  = "/data"
+)
+
+// VolumeBuildOptions holds the settings of a volume build
+type VolumeBuildOptions struct {
+	// IgnoreFile is the ignore file name, relative to the source
+	// folder (.dockerignore syntax)
+	IgnoreFile string
+	// Replace removes the volume first if it already exists
+	Replace bool
+}
+
+// BuildVolume creates the named volume populated with the source
+// folder content and returns the content digest
+func (c Client) BuildVolume(ctx context.Context, name, src string, opts VolumeBuildOptions) (digest.Digest, error) {
+	root, err := contextRoot(src, "")
+	if err != nil {
+		return "", err
+	}
+	content, err := tarDirectory(root, tarOptions{
+		IgnoreFile:    opts.IgnoreFile,
+		Deterministic: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+	dgst, err := digestTar(bytes.NewReader(b), "")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+
+	if err := c.createVolume(ctx, name, opts.Replace); err != nil {
+		return "", err
+	}
+	helper, cleanup, err := c.volumeHelper(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+	defer cleanup()
+
+	err = c.d.CopyToContainer(ctx, helper, volumeMountPoint, bytes.NewReader(b), types.CopyToContainerOptions{})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+	return dgst, nil
+}
+
+// VolumeDigest computes the content digest of the named volume, as
+// in BuildVolume
+func (c Client) VolumeDigest(ctx context.Context, name string) (digest.Digest, error) {
+	helper, cleanup, err := c.volumeHelper(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeVerifyErr, err)
+	}
+	defer cleanup()
+
+	rc, _, err := c.d.CopyFromContainer(ctx, helper, volumeMountPoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeVerifyErr, err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	dgst, err := digestTar(rc, volumeMountPoint[1:]+"/")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", VolumeVerifyErr, err)
+	}
+	return dgst, nil
+}
+
+// VerifyVolume checks the named volume content matches the expected
+// digest
+func (c Client) VerifyVolume(ctx context.Context, name string, expected digest.Digest) error {
+	if err := expected.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", VolumeVerifyErr, err)
+	}
+	actual, err := c.VolumeDigest(ctx, name)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%w: expected %s, found %s", VolumeDigestMismatchErr, expected, actual)
+	}
+	return nil
+}
+
+func (c Client) createVolume(ctx context.Context, name string, replace bool) error {
+	_, err := c.d.VolumeInspect(ctx, name)
+	switch {
+	case err == nil && !replace:
+		return fmt.Errorf("%w: %s (use replace to recreate it)", VolumeExistsErr, name)
+	case err == nil:
+		if err := c.d.VolumeRemove(ctx, name, false); err != nil {
+			return fmt.Errorf("%w: %w", VolumeBuildErr, err)
+		}
+	case !client.IsErrNotFound(err):
+		return fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+
+	_, err = c.d.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   name,
+		Labels: map[string]string{ManagedLabel: "true"},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", VolumeBuildErr, err)
+	}
+	return nil
+}
+
+// volumeHelper creates a container, never started, with the volume
+// mounted, returning its ID and a function removing it
+func (c Client) volumeHelper(ctx context.Context, name string) (string, func(), error) {
+	image := c.mirrorImage(volumeHelperImage)
+	if err := c.ensureImage(ctx, image, "", PullMissing); err != nil {
+		return "", nil, err
+	}
+	labels := map[string]string{ManagedLabel: "true"}
+	labelOriginalImage(labels, volumeHelperImage, image)
+	created, err := c.d.ContainerCreate(ctx, &container.Config{
+		Image:  image,
+		Labels: labels,
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: name,
+			Target: volumeMountPoint,
+		}},
+	}, nil, nil, "")
+	if err != nil {
+		return "", nil, err
+	}
+	return created.ID, func() {
+		_ = c.d.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	}, nil
+}
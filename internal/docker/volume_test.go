@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// volumeAPI fakes the volumes, keeping the archive copied to them
+type volumeAPI struct {
+	client.APIClient
+	exists bool
+
+	content []byte
+	created int
+	removed int
+}
+
+func (f *volumeAPI) ImageInspectWithRaw(_ context.Context, _ string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{}, nil, nil
+}
+
+func (f *volumeAPI) VolumeInspect(_ context.Context, name string) (volume.Volume, error) {
+	if !f.exists {
+		return volume.Volume{}, errdefs.NotFound(errors.New("no such volume: " + name))
+	}
+	return volume.Volume{Name: name}, nil
+}
+
+func (f *volumeAPI) VolumeCreate(_ context.Context, opts volume.CreateOptions) (volume.Volume, error) {
+	f.created++
+	f.exists = true
+	return volume.Volume{Name: opts.Name}, nil
+}
+
+func (f *volumeAPI) VolumeRemove(_ context.Context, _ string, _ bool) error {
+	f.removed++
+	f.exists = false
+	return nil
+}
+
+func (f *volumeAPI) ContainerCreate(_ context.Context, _ *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig, _ *ocispec.Platform, _ string) (container.CreateResponse, error) {
+	return container.CreateResponse{ID: testContainerID}, nil
+}
+
+func (f *volumeAPI) ContainerRemove(_ context.Context, _ string, _ container.RemoveOptions) error {
+	return nil
+}
+
+func (f *volumeAPI) CopyToContainer(_ context.Context, _, _ string, content io.Reader, _ types.CopyToContainerOptions) error {
+	b, err := io.ReadAll(content)
+	f.content = b
+	return err
+}
+
+// CopyFromContainer returns the copied archive as the daemon does, the
+// entries under the mount point folder, with other times
+func (f *volumeAPI) CopyFromContainer(_ context.Context, _, _ string) (io.ReadCloser, types.ContainerPathStat, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tr := tar.NewReader(bytes.NewReader(f.content))
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		h.Name = "data/" + h.Name
+		h.ModTime = time.Now()
+		_ = tw.WriteHeader(h)
+		_, _ = io.Copy(tw, tr)
+	}
+	_ = tw.Close()
+	return io.NopCloser(&buf), types.ContainerPathStat{}, nil
+}
+
+func TestBuildAndVerifyVolume(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"seed/users.sql": "INSERT INTO users VALUES (1);",
+		"README":         "fixtures",
+		"tmp/cache":      "ignored",
+		".volumeignore":  "tmp\n",
+	})
+	api := &volumeAPI{}
+	c := newTestClient(api)
+	ctx := context.Background()
+	built, err := c.BuildVolume(ctx, "fixtures", src, VolumeBuildOptions{IgnoreFile: ".volumeignore"})
+	if err != nil {
+		t.Fatalf("BuildVolume: %v", err)
+	}
+	if names := sortedNames(readTar(t, io.NopCloser(bytes.NewReader(api.content))).Names); !equalStrings(names, []string{".volumeignore", "README", "seed/", "seed/users.sql"}) {
+		t.Errorf("volume content = %v", names)
+	}
+
+	// the same content built again, touched, has the same digest
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "README"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.BuildVolume(ctx, "fixtures", src, VolumeBuildOptions{IgnoreFile: ".volumeignore", Replace: true})
+	if err != nil {
+		t.Fatalf("BuildVolume again: %v", err)
+	}
+	if again != built || api.removed != 1 {
+		t.Errorf("rebuilt digest = %s (%d removals), want %s after one removal", again, api.removed, built)
+	}
+
+	if err := c.VerifyVolume(ctx, "fixtures", built); err != nil {
+		t.Errorf("VerifyVolume: %v", err)
+	}
+	writeTree(t, src, map[string]string{"README": "changed"})
+	changed, err := c.BuildVolume(ctx, "fixtures", src, VolumeBuildOptions{Replace: true})
+	if err != nil {
+		t.Fatalf("BuildVolume changed: %v", err)
+	}
+	if changed == built {
+		t.Error("changed content has the same digest")
+	}
+	if err := c.VerifyVolume(ctx, "fixtures", built); !errors.Is(err, VolumeDigestMismatchErr) {
+		t.Errorf("VerifyVolume err = %v, want %v", err, VolumeDigestMismatchErr)
+	}
+}
+
+func TestBuildVolumeExists(t *testing.T) {
+	_, err := newTestClient(&volumeAPI{exists: true}).BuildVolume(context.Background(), "fixtures", t.TempDir(), VolumeBuildOptions{})
+	if !errors.Is(err, VolumeExistsErr) {
+		t.Errorf("BuildVolume err = %v, want %v", err, VolumeExistsErr)
+	}
+}
+
+func TestVerifyVolumeBadDigest(t *testing.T) {
+	err := newTestClient(&volumeAPI{}).VerifyVolume(context.Background(), "fixtures", "sha256:nope")
+	if !errors.Is(err, VolumeVerifyErr) {
+		t.Errorf("VerifyVolume err = %v, want %v", err, VolumeVerifyErr)
+	}
+}
@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+var (
+	BuildWarningsErr = errors.New("build produced warnings")
+)
+
+// buildWarning is a warning line found in the build stream
+type buildWarning struct {
+	// Step is the step header, empty before the first step
+	Step string
+	Text string
+}
+
+// warningCollector keeps the warning lines of the build stream
+type warningCollector struct {
+	patterns []*regexp.Regexp
+	warnings []buildWarning
+}
+
+func newWarningCollector(patterns []*regexp.Regexp) *warningCollector {
+	return &warningCollector{patterns: patterns}
+}
+
+// Handle records the event text if it is a warning
+func (c *warningCollector) Handle(e buildEvent) {
+	if e.Kind != eventLine && e.Kind != eventStatus {
+		return
+	}
+	if !matchesAny(c.patterns, e.Text) {
+		return
+	}
+	w := buildWarning{Text: e.Text}
+	if e.Step != nil {
+		w.Step = e.Step.Header()
+	}
+	c.warnings = append(c.warnings, w)
+}
+
+// Summary prints the collected warnings, if any
+func (c *warningCollector) Summary(out io.Writer) {
+	if len(c.warnings) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Build warnings (%d):\n", len(c.warnings))
+	for _, w := range c.warnings {
+		if w.Step != "" {
+			_, _ = fmt.Fprintf(out, "  - [%s] %s\n", w.Step, w.Text)
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "  - %s\n", w.Text)
+	}
+}
+
+// Err returns BuildWarningsErr if any warning was collected
+func (c *warningCollector) Err() error {
+	if len(c.warnings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d warning(s) found", BuildWarningsErr, len(c.warnings))
+}
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWarningCollector(t *testing.T) {
+	patterns, err := compilePatterns(DefaultWarningPatterns)
+	if err != nil {
+		t.Fatalf("compilePatterns: %v", err)
+	}
+	c := newWarningCollector(patterns)
+	step := &buildStep{Number: 2, Total: 3, Instruction: "RUN npm install"}
+	for _, e := range []buildEvent{
+		{Kind: eventLine, Text: "[WARNING]: No swap limit support"},
+		{Kind: eventLine, Step: step, Text: "npm warn deprecated glob@7.2.3"},
+		{Kind: eventStatus, Step: step, Text: "Warning: using a deprecated base"},
+		{Kind: eventLine, Step: step, Text: "added 12 packages"},
+		{Kind: eventLine, Step: step, Text: "forewarned is forearmed"},
+		{Kind: eventError, Step: step, Text: "warning: not a line"},
+	} {
+		c.Handle(e)
+	}
+	var out strings.Builder
+	c.Summary(&out)
+	want := `Build warnings (3):
+  - [WARNING]: No swap limit support
+  - [Step 2/3 : RUN npm install] npm warn deprecated glob@7.2.3
+  - [Step 2/3 : RUN npm install] Warning: using a deprecated base
+`
+	if out.String() != want {
+		t.Errorf("Summary =\n%s\nwant\n%s", out.String(), want)
+	}
+	if err := c.Err(); !errors.Is(err, BuildWarningsErr) || !strings.Contains(err.Error(), "3 warning(s)") {
+		t.Errorf("Err = %v, want %v counting the warnings", err, BuildWarningsErr)
+	}
+}
+
+func TestWarningCollectorNone(t *testing.T) {
+	c := newWarningCollector([]*regexp.Regexp{regexp.MustCompile(`SECURITY`)})
+	c.Handle(buildEvent{Kind: eventLine, Text: "WARNING: not matched"})
+	var out strings.Builder
+	c.Summary(&out)
+	if out.Len() != 0 || c.Err() != nil {
+		t.Errorf("Summary = %q, Err = %v, want nothing without warnings", out.String(), c.Err())
+	}
+}
+
+func TestBuildFailOnWarn(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"Dockerfile": "FROM alpine:3.19\n"})
+	warned := classicStream("", "FROM alpine:3.19") + streamMessage(map[string]any{"stream": "SECURITY: weak cipher\n"}) +
+		streamMessage(map[string]any{"aux": map[string]string{"ID": testImageID}})
+	tests := []struct {
+		name    string
+		stream  string
+		opts    BuildOptions
+		wantErr bool
+		wantOut string
+	}{
+		{name: "clean", stream: classicStream(testImageID, "FROM alpine:3.19"), opts: BuildOptions{FailOnWarn: true}},
+		{name: "default patterns", stream: warned, opts: BuildOptions{FailOnWarn: true}},
+		{
+			name:    "warned",
+			stream:  warned,
+			opts:    BuildOptions{FailOnWarn: true, WarningPatterns: []string{`^SECURITY:`}},
+			wantErr: true,
+			wantOut: "Build warnings (1):\n  - [Step 1/1 : FROM alpine:3.19] SECURITY: weak cipher\n",
+		},
+		{
+			name:    "summary only",
+			stream:  warned,
+			opts:    BuildOptions{WarningPatterns: []string{`^SECURITY:`}},
+			wantOut: "Build warnings (1):",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			out := captureStdout(t, func() {
+				_, err = newTestClient(&buildAPI{stream: tt.stream}).Build(context.Background(), src, tt.opts)
+			})
+			if tt.wantErr {
+				if !errors.Is(err, ImageBuildErr) || !errors.Is(err, BuildWarningsErr) {
+					t.Errorf("err = %v, want it to match %v and %v", err, ImageBuildErr, BuildWarningsErr)
+				}
+			} else if err != nil {
+				t.Errorf("err = %v, want none", err)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Errorf("output = %q, want it to contain %q", out, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestBuildBadWarningPattern(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"Dockerfile": "FROM alpine:3.19\n"})
+	api := &buildAPI{}
+	_, err := newTestClient(api).Build(context.Background(), src, BuildOptions{WarningPatterns: []string{"("}})
+	if !errors.Is(err, InvalidBuildOutputErr) || api.builds != 0 {
+		t.Errorf("err = %v after %d builds, want %v before building", err, api.builds, InvalidBuildOutputErr)
+	}
+}
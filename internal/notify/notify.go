@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	NotifyErr = errors.New("failed to send notification")
+)
+
+const (
+	// SignatureHeader holds the hex HMAC-SHA256 of the body, keyed
+	// with the notifier secret (sha256=<hex>)
+	SignatureHeader = "X-Runner-Signature"
+
+	defaultTimeout  = 5 * time.Second
+	defaultAttempts = 3
+	defaultBackoff  = 500 * time.Millisecond
+)
+
+// EventType is the kind of a run lifecycle event
+type EventType string
+
+const (
+	EventStart EventType = "start"
+	EventReady EventType = "ready"
+	EventExit  EventType = "exit"
+)
+
+// Payload is the JSON body posted for each event
+type Payload struct {
+	Event      EventType  `json:"event"`
+	RunID      string     `json:"run_id"`
+	Container  string     `json:"container"`
+	Image      string     `json:"image"`
+	Timestamp  time.Time  `json:"timestamp"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ExitCode is unset on exit events whose exit code is unknown, Error
+	// telling why
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Assertion *Assertion `json:"assertion,omitempty"`
+}
+
+// Assertion sums up the run assertion, set on the exit event if it ran
+type Assertion struct {
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// Notifier posts the event payloads to a webhook URL, in the
+// background, so a slow or dead endpoint doesn't stall the run
+type Notifier struct {
+	url    string
+	secret string
+	client *http.Client
+	// Attempts is the number of tries for 5xx answers and transport
+	// errors
+	Attempts int
+	// Backoff is the initial wait between tries, doubled on each one
+	Backoff time.Duration
+	wg      sync.WaitGroup
+}
+
+// NewNotifier builds a notifier for the url, signing the payloads with
+// secret (unsigned if empty)
+func NewNotifier(url, secret string) *Notifier {
+	return &Notifier{
+		url:      url,
+		secret:   secret,
+		client:   &http.Client{Timeout: defaultTimeout},
+		Attempts: defaultAttempts,
+		Backoff:  defaultBackoff,
+	}
+}
+
+// NewRunID returns a random run identifier
+func NewRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Notify sends the payload in the background. Failures are logged.
+func (n *Notifier) Notify(p Payload) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.send(context.Background(), p); err != nil {
+			slog.With("event", p.Event, "url", n.url, "error", err.Error()).Warn("NotificationFailed")
+		}
+	}()
+}
+
+// Wait blocks until the notifications sent so far are done
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Notifier) send(ctx context.Context, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("%w: %w", NotifyErr, err)
+	}
+
+	wait := n.Backoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= n.Attempts {
+			return err
+		}
+		slog.With("attempt", attempt, "wait", wait.String(), "error", err.Error()).Debug("RetryingNotification")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post sends the body once, telling if a failure is worth a retry
+func (n *Notifier) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", NotifyErr, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("%w: %w", NotifyErr, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("%w: %s", NotifyErr, resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%w: %s", NotifyErr, resp.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	_, _ = m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
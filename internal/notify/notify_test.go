@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifySignsPayload(t *testing.T) {
+	const secret = "s3cr3t"
+	var (
+		mu        sync.Mutex
+		signature string
+		body      []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		signature = r.Header.Get(SignatureHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, secret)
+	n.Notify(Payload{Event: EventStart, RunID: "run", Container: "c", Image: "alpine"})
+	n.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	if want := "sha256=" + hex.EncodeToString(m.Sum(nil)); signature != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, signature, want)
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("unmarshal payload: %v", err)
+	}
+	if p.Event != EventStart || p.RunID != "run" {
+		t.Errorf("payload = %+v, want the start event of run", p)
+	}
+}
+
+func TestNotifyUnsigned(t *testing.T) {
+	var signed atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed.Store(r.Header.Get(SignatureHeader) != "")
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, "")
+	n.Notify(Payload{Event: EventStart})
+	n.Wait()
+	if signed.Load() {
+		t.Errorf("%s set without secret", SignatureHeader)
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int32
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, requests: 1},
+		{name: "5xx retried", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, requests: 3},
+		{name: "5xx attempts exhausted", statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}, requests: 3},
+		{name: "4xx not retried", statuses: []int{http.StatusBadRequest, http.StatusOK}, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := requests.Add(1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			n := NewNotifier(srv.URL, "")
+			n.Backoff = time.Millisecond
+			n.Notify(Payload{Event: EventExit})
+			n.Wait()
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestWaitDrainsPendingNotifications(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []EventType
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a slow endpoint, still running when the run exits
+		time.Sleep(50 * time.Millisecond)
+		var p Payload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, p.Event)
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, "")
+	n.Notify(Payload{Event: EventStart})
+	n.Notify(Payload{Event: EventReady})
+	n.Notify(Payload{Event: EventExit})
+	n.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 3 {
+		t.Errorf("events received before Wait returned = %v, want start, ready and exit", events)
+	}
+}
+
+func TestPayloadAssertion(t *testing.T) {
+	code := 0
+	b, err := json.Marshal(Payload{Event: EventExit, ExitCode: &code, Assertion: &Assertion{Passed: false, Message: "probe failed"}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	assertion, ok := got["assertion"].(map[string]any)
+	if !ok {
+		t.Fatalf("assertion = %v, want an object", got["assertion"])
+	}
+	if assertion["passed"] != false || assertion["message"] != "probe failed" {
+		t.Errorf("assertion = %v, want failed with its message", assertion)
+	}
+	if _, ok := got["error"]; ok {
+		t.Errorf("error set on an exit with exit code: %v", got["error"])
+	}
+}
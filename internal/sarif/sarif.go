@@ -0,0 +1,135 @@
+package sarif
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	SARIFWriteErr = errors.New("failed to write SARIF file")
+)
+
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Level is the severity of a result
+type Level string
+
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelNote    Level = "note"
+)
+
+// Log is a SARIF document
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run holds the results of one tool
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+type Driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri,omitempty"`
+	Rules          []Rule `json:"rules,omitempty"`
+}
+
+// Rule describes what a result is about
+type Rule struct {
+	ID                   string        `json:"id"`
+	ShortDescription     Message       `json:"shortDescription"`
+	DefaultConfiguration Configuration `json:"defaultConfiguration"`
+}
+
+type Configuration struct {
+	Level Level `json:"level"`
+}
+
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Result is a finding of a rule
+type Result struct {
+	RuleID    string     `json:"ruleId"`
+	Level     Level      `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+// Location is a file location or a logical one (e.g. an image layer)
+type Location struct {
+	PhysicalLocation *PhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []LogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type Region struct {
+	StartLine int `json:"startLine"`
+}
+
+type LogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName,omitempty"`
+	Kind               string `json:"kind,omitempty"`
+}
+
+// NewRun returns an empty run of the named tool, with its rules
+func NewRun(tool string, rules []Rule) Run {
+	return Run{
+		Tool:    Tool{Driver: Driver{Name: tool, Rules: rules}},
+		Results: []Result{},
+	}
+}
+
+// WriteFile writes the runs to the path as a new SARIF document or,
+// with appendRuns, adds them to the runs of the existing one (a
+// missing file being created)
+func WriteFile(path string, appendRuns bool, runs ...Run) error {
+	log := Log{Version: Version, Schema: Schema}
+	if appendRuns {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %w", SARIFWriteErr, err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &log); err != nil {
+				return fmt.Errorf("%w: %s isn't a SARIF document: %w", SARIFWriteErr, path, err)
+			}
+			if log.Version != Version {
+				return fmt.Errorf("%w: %s is SARIF %q, not %s", SARIFWriteErr, path, log.Version, Version)
+			}
+		}
+	}
+	log.Runs = append(log.Runs, runs...)
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %w", SARIFWriteErr, err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("%w: %w", SARIFWriteErr, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package sarif
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readLog reads the SARIF document of path
+func readLog(t *testing.T, path string) Log {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log Log
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatalf("%s isn't JSON: %v", path, err)
+	}
+	return log
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.sarif")
+	first := NewRun("first", []Rule{{ID: "r1", DefaultConfiguration: Configuration{Level: LevelWarning}}})
+	first.Results = append(first.Results, Result{RuleID: "r1", Level: LevelWarning, Message: Message{Text: "found"}})
+	second := NewRun("second", nil)
+
+	if err := WriteFile(path, true, first); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := WriteFile(path, true, second); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	log := readLog(t, path)
+	if log.Version != Version || log.Schema != Schema || len(log.Runs) != 2 {
+		t.Fatalf("log = %+v, want both runs in a %s document", log, Version)
+	}
+	if log.Runs[0].Tool.Driver.Name != "first" || len(log.Runs[0].Results) != 1 || log.Runs[1].Tool.Driver.Name != "second" {
+		t.Errorf("runs = %+v, want first then second", log.Runs)
+	}
+
+	if err := WriteFile(path, false, second); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if log := readLog(t, path); len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Name != "second" {
+		t.Errorf("runs = %+v, want the file replaced", log.Runs)
+	}
+}
+
+func TestWriteFileEmptyResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.sarif")
+	if err := WriteFile(path, false, NewRun("tool", nil)); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	var raw struct {
+		Runs []map[string]json.RawMessage `json:"runs"`
+	}
+	b, _ := os.ReadFile(path)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(raw.Runs[0]["results"]); got != "[]" {
+		t.Errorf("results = %s, want an empty list", got)
+	}
+}
+
+func TestWriteFileAppendErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "not json", content: "not json"},
+		{name: "other version", content: `{"version":"1.0.0","runs":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".sarif")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteFile(path, true, NewRun("tool", nil)); !errors.Is(err, SARIFWriteErr) {
+				t.Errorf("err = %v, want %v", err, SARIFWriteErr)
+			}
+			if b, _ := os.ReadFile(path); string(b) != tt.content {
+				t.Errorf("content = %q, want it untouched", b)
+			}
+		})
+	}
+}